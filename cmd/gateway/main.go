@@ -1,22 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	gnmipb "github.com/openconfig/gnmi/proto/gnmi"
+	"golang.org/x/crypto/ssh"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/safabayar/gateway/internal/auth"
 	"github.com/safabayar/gateway/internal/config"
 	gnmiserver "github.com/safabayar/gateway/internal/gnmi"
 	grpcserver "github.com/safabayar/gateway/internal/grpc"
+	"github.com/safabayar/gateway/internal/health"
+	"github.com/safabayar/gateway/internal/listen"
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/parser"
+	"github.com/safabayar/gateway/internal/proxy"
+	"github.com/safabayar/gateway/internal/rest"
 	sshbastion "github.com/safabayar/gateway/internal/ssh"
+	"github.com/safabayar/gateway/internal/tracing"
+	"github.com/safabayar/gateway/internal/webterm"
 	pb "github.com/safabayar/gateway/proto"
 )
 
@@ -26,13 +43,36 @@ var (
 	grpcPort           = flag.Int("grpc-port", 50051, "gRPC server port")
 	gnmiPort           = flag.Int("gnmi-port", 57400, "gNMI server port")
 	sshPort            = flag.Int("ssh-port", 2222, "SSH bastion server port")
+	netconfPort        = flag.Int("netconf-port", 830, "NETCONF over SSH server port (0 to disable)")
+	httpPort           = flag.Int("http-port", 8080, "HTTP API server port (0 to disable)")
+	telnetPort         = flag.Int("telnet-port", 0, "Telnet server port for the bastion menu (0 to disable)")
+	muxPort            = flag.Int("mux-port", 0, "Single port serving the SSH bastion, gRPC, gNMI and HTTP API in place of their own ports (0 to disable)")
 	hostKeyPath        = flag.String("host-key", "config/ssh_host_key", "Path to SSH host key")
 	authorizedKeysPath = flag.String("authorized-keys", "config/authorized_keys", "Path to authorized keys file")
+	templatesPath      = flag.String("templates", "templates", "Path to output parsing templates directory")
+	printOpenAPI       = flag.Bool("print-openapi", false, "Print the HTTP API OpenAPI document and exit")
+	backendInterval    = flag.Duration("backend-check-interval", 0, "Interval between device reachability probes (0 to disable)")
+	traceExporter      = flag.String("trace-exporter", "none", "Trace exporter: none, otlp or file")
+	traceEndpoint      = flag.String("trace-endpoint", "", "OTLP gRPC endpoint (default from OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317)")
+	traceInsecure      = flag.Bool("trace-insecure", false, "Disable TLS to the OTLP endpoint")
+	traceFile          = flag.String("trace-file", "logs/traces.json", "File receiving spans with the file exporter")
+	backendReadyRatio  = flag.Float64("backend-ready-ratio", 0, "Minimum ratio of reachable devices for readiness (0 only reports it)")
+	drainTimeout       = flag.Duration("drain-timeout", 30*time.Second, "Time allowed for open sessions and RPCs to finish on shutdown")
 )
 
 func main() {
 	flag.Parse()
 
+	if *printOpenAPI {
+		spec, err := rest.OpenAPIJSON()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to build OpenAPI document: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(spec))
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
@@ -46,39 +86,316 @@ func main() {
 		os.Exit(1)
 	}
 
+	logger.Log.AddHook(tracing.LogHook{})
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(tracing.Options{
+		Exporter: *traceExporter,
+		Endpoint: *traceEndpoint,
+		Insecure: *traceInsecure,
+		File:     *traceFile,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize tracing: %v\n", err)
+		os.Exit(1)
+	}
+
 	logger.Log.Info("Starting Multi-Protocol Gateway")
 	logger.Log.Infof("Loaded configuration for %d devices", len(cfg.Devices))
 
+	// Load output parsing templates
+	templates, err := parser.NewRegistry(*templatesPath)
+	if err != nil {
+		logger.Log.WithError(err).Warn("Output templates not loaded, parsing disabled")
+		templates = nil
+	}
+
+	// Reuse SSH connections to devices for command execution
+	var sshPool *proxy.Pool
+	if poolSettings := cfg.Settings.SSHPool; poolSettings.Enabled {
+		sshPool = proxy.NewPool(proxy.PoolOptions{
+			MaxConnsPerDevice:  poolSettings.MaxConnsPerDevice,
+			MaxSessionsPerConn: poolSettings.MaxSessionsPerConn,
+			IdleTimeout:        time.Duration(poolSettings.IdleTimeout) * time.Second,
+			KeepaliveInterval:  time.Duration(poolSettings.KeepaliveInterval) * time.Second,
+		})
+		proxy.SetPool(sshPool)
+		logger.Log.Info("SSH connection pooling enabled")
+	}
+
+	// Keys and certificates for bastion logins to devices
+	keyring, err := proxy.NewKeyring(cfg)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to load device keys")
+		os.Exit(1)
+	}
+	proxy.SetKeyring(keyring)
+	if keyring.Len() > 0 {
+		logger.Log.Infof("Loaded device keys for %d device addresses", keyring.Len())
+	}
+
+	// Shared Gateway service implementation for gRPC and HTTP
+	gatewayServer := grpcserver.NewServer(cfg, templates)
+
+	// Device labels in metrics are limited to the inventory
+	metrics.SetInventory(cfg)
+
+	// Health state of listeners and subsystems, served on gRPC and HTTP
+	checker := health.NewChecker()
+	checker.AddCheck("inventory", func() error {
+		if len(cfg.Devices) == 0 {
+			return fmt.Errorf("no devices configured")
+		}
+		return nil
+	})
+	checker.AddListener("grpc")
+	checker.AddListener("gnmi")
+	checker.AddListener("ssh")
+	if *netconfPort > 0 {
+		checker.AddListener("netconf")
+	}
+	if *httpPort > 0 {
+		checker.AddListener("http")
+	}
+	if *telnetPort > 0 {
+		checker.AddListener("telnet")
+	}
+	if *backendInterval > 0 {
+		checker.EnableBackendProbe(cfg, *backendInterval, 3*time.Second, *backendReadyRatio)
+	}
+	checker.Watch(10 * time.Second)
+
+	// Build the servers so they can be drained on shutdown
+	grpcServer := newGRPCServer(gatewayServer, checker)
+	gnmiProxy := gnmiserver.NewServer(cfg)
+	gnmiGRPCServer := newGNMIServer(gnmiProxy, checker)
+
+	bastion, err := sshbastion.NewBastionServer(cfg, *hostKeyPath, *authorizedKeysPath)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to create SSH bastion")
+		os.Exit(1)
+	}
+	checker.AddCheck("authorized_keys", bastion.AuthorizedKeysStatus)
+	bastion.OnListening(func() { checker.SetListener("ssh", nil) })
+
+	var netconfServer *sshbastion.NetconfServer
+	if *netconfPort > 0 {
+		netconfServer, err = sshbastion.NewNetconfServer(cfg, *hostKeyPath)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to create NETCONF server")
+			os.Exit(1)
+		}
+		netconfServer.OnListening(func() { checker.SetListener("netconf", nil) })
+	}
+
+	// One TOTP store backs the second factor of every login: bastion keys
+	// and certificates, telnet and the certificate API
+	totpStore, err := auth.NewTOTPStore(cfg)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to load TOTP store")
+		os.Exit(1)
+	}
+	bastion.SetTOTPStore(totpStore)
+	if totpStore.CanEnroll() {
+		logger.Log.Infof("TOTP enrollment enabled, store %s", cfg.Settings.BastionTOTP.StoreFile)
+	}
+
+	users, err := auth.NewUsers(cfg, totpStore)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to load gateway users")
+		os.Exit(1)
+	}
+	// API callers with the token of a gateway user log in to devices with
+	// keys. API tokens, and the passwords of the certificate API and the
+	// local IdP, are throttled like bastion logins.
+	credentialLimits, err := auth.NewCredentialLimiter("Credential", cfg.Settings.BastionLimits)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to configure credential limits")
+		os.Exit(1)
+	}
+	credentialLimits.OnBan(func(scope string) { metrics.BastionBans.WithLabelValues(scope).Inc() })
+	gatewayServer.SetUsers(users, credentialLimits)
+
+	// Gateway users get bastion certificates from the API when user_ca is set
+	var localIdP *auth.LocalIdP
+	if cfg.Settings.UserCA.PrivateKeyFile != "" {
+		userCA, err := auth.NewUserCA(cfg)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to load user CA")
+			os.Exit(1)
+		}
+		gatewayServer.SetUserCA(userCA)
+		bastion.SetUserCA(userCA)
+		logger.Log.Infof("Issuing bastion user certificates, CA key %s", ssh.FingerprintSHA256(userCA.PublicKey()))
+
+		oidc := cfg.Settings.UserCA.OIDC
+		switch {
+		case oidc.DeviceAuthorizationEndpoint != "":
+			flow, err := auth.NewOIDCDeviceFlow(oidc)
+			if err != nil {
+				logger.Log.WithError(err).Error("Failed to configure OIDC")
+				os.Exit(1)
+			}
+			gatewayServer.SetDeviceAuthorizer(flow)
+			logger.Log.Infof("Verifying certificate requests with OIDC at %s", oidc.DeviceAuthorizationEndpoint)
+		case oidc.LocalIdP:
+			if oidc.Issuer == "" || *httpPort <= 0 {
+				logger.Log.Error("user_ca.oidc.local_idp needs user_ca.oidc.issuer and the HTTP port")
+				os.Exit(1)
+			}
+			localIdP = auth.NewLocalIdP(oidc.Issuer, users, credentialLimits)
+			gatewayServer.SetDeviceAuthorizer(localIdP)
+			logger.Log.Infof("Serving the local OIDC provider at %s", oidc.Issuer)
+		}
+	}
+
+	var telnetServer *sshbastion.TelnetServer
+	if *telnetPort > 0 {
+		if users.Len() == 0 {
+			logger.Log.Warn("No gateway users configured, telnet logins will be rejected")
+		}
+		telnetServer = sshbastion.NewTelnetServer(bastion, users)
+		telnetServer.OnListening(func() { checker.SetListener("telnet", nil) })
+	}
+
+	var httpServer *http.Server
+	var terminal *webterm.Server
+	if *httpPort > 0 {
+		terminal = webterm.NewServer(cfg, gatewayServer)
+		httpServer = newHTTPServer(gatewayServer, checker, terminal, localIdP, *httpPort)
+	}
+
+	// The bastion, gRPC, gNMI and HTTP API share one port with --mux-port
+	var mux *listen.Mux
+	if *muxPort > 0 {
+		listener, err := listen.TCP(fmt.Sprintf(":%d", *muxPort), cfg.Settings.ProxyProtocol.Mux)
+		if err != nil {
+			logger.Log.WithError(err).Errorf("Failed to listen on port %d", *muxPort)
+			os.Exit(1)
+		}
+		mux = listen.NewMux(listener)
+	}
+
 	// Create channels for coordinating shutdown
-	errChan := make(chan error, 3)
+	errChan := make(chan error, 7)
 	shutdownChan := make(chan os.Signal, 1)
 	signal.Notify(shutdownChan, os.Interrupt, syscall.SIGTERM)
 
+	// Start the multiplexer dispatching connections of the shared port
+	if mux != nil {
+		go func() {
+			if err := mux.Serve(); err != nil {
+				errChan <- fmt.Errorf("mux error: %w", err)
+			}
+		}()
+	}
+
 	// Start gRPC server
 	go func() {
-		if err := startGRPCServer(cfg, *grpcPort); err != nil {
+		var err error
+		if mux != nil {
+			err = serveGRPC(grpcServer, checker, mux.GRPC)
+		} else {
+			err = startGRPCServer(grpcServer, checker, *grpcPort, cfg.Settings.ProxyProtocol.GRPC)
+		}
+		if err != nil {
 			errChan <- fmt.Errorf("gRPC server error: %w", err)
 		}
 	}()
 
 	// Start gNMI proxy server
 	go func() {
-		if err := startGNMIServer(cfg, *gnmiPort); err != nil {
+		var err error
+		if mux != nil {
+			err = serveGNMI(gnmiGRPCServer, checker, mux.GNMI)
+		} else {
+			err = startGNMIServer(gnmiGRPCServer, checker, *gnmiPort, cfg.Settings.ProxyProtocol.GNMI)
+		}
+		if err != nil {
 			errChan <- fmt.Errorf("gNMI server error: %w", err)
 		}
 	}()
 
 	// Start SSH bastion server
 	go func() {
-		if err := startSSHBastion(cfg, *sshPort, *hostKeyPath, *authorizedKeysPath); err != nil {
+		var err error
+		if mux != nil {
+			err = serveSSHBastion(bastion, checker, mux.SSH)
+		} else {
+			err = startSSHBastion(bastion, checker, *sshPort)
+		}
+		if err != nil {
 			errChan <- fmt.Errorf("SSH bastion error: %w", err)
 		}
 	}()
 
+	// Start NETCONF server
+	if netconfServer != nil {
+		go func() {
+			if err := startNetconfServer(netconfServer, checker, *netconfPort); err != nil {
+				errChan <- fmt.Errorf("NETCONF server error: %w", err)
+			}
+		}()
+	}
+
+	// Start telnet server
+	if telnetServer != nil {
+		go func() {
+			if err := startTelnetServer(telnetServer, checker, *telnetPort); err != nil {
+				errChan <- fmt.Errorf("telnet server error: %w", err)
+			}
+		}()
+	}
+
+	// Start HTTP API server
+	if httpServer != nil {
+		go func() {
+			var err error
+			if mux != nil {
+				err = serveHTTP(httpServer, checker, mux.HTTP)
+			} else {
+				err = startHTTPServer(httpServer, checker, *httpPort)
+			}
+			if err != nil {
+				errChan <- fmt.Errorf("HTTP server error: %w", err)
+			}
+		}()
+	} else if mux != nil {
+		// Refuse HTTP requests on the shared port when the API is disabled
+		go func() {
+			for {
+				conn, err := mux.HTTP.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+	}
+
 	logger.Log.Info("Gateway started successfully")
-	logger.Log.Infof("gRPC server listening on port %d", *grpcPort)
-	logger.Log.Infof("gNMI proxy listening on port %d", *gnmiPort)
-	logger.Log.Infof("SSH bastion listening on port %d", *sshPort)
+	if mux != nil {
+		logger.Log.Infof("SSH bastion, gRPC, gNMI and HTTP API multiplexed on port %d", *muxPort)
+	} else {
+		logger.Log.Infof("gRPC server listening on port %d", *grpcPort)
+		logger.Log.Infof("gNMI proxy listening on port %d", *gnmiPort)
+		logger.Log.Infof("SSH bastion listening on port %d", *sshPort)
+	}
+	if *netconfPort > 0 {
+		logger.Log.Infof("NETCONF server listening on port %d", *netconfPort)
+	}
+	if *telnetPort > 0 {
+		logger.Log.Infof("Telnet server listening on port %d", *telnetPort)
+	}
+	if *httpPort > 0 {
+		port := *httpPort
+		if mux != nil {
+			port = *muxPort
+		} else {
+			logger.Log.Infof("HTTP API listening on port %d", port)
+		}
+		logger.Log.Infof("Web terminal available at http://localhost:%d/terminal/", port)
+	}
 	logger.Log.Info("Press Ctrl+C to stop")
 
 	// Wait for shutdown signal or error
@@ -87,69 +404,239 @@ func main() {
 		logger.Log.WithError(err).Error("Server error")
 		os.Exit(1)
 	case sig := <-shutdownChan:
-		logger.Log.Infof("Received signal %v, shutting down gracefully", sig)
+		logger.Log.Infof("Received signal %v, draining connections for up to %s", sig, *drainTimeout)
+	}
+
+	// Report not ready first so load balancers stop sending new clients
+	checker.Stop()
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancelDrain()
+
+	var wg sync.WaitGroup
+	drain := func(name string, stop func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := stop(drainCtx); err != nil {
+				logger.Log.WithError(err).Warnf("%s did not drain in time", name)
+				return
+			}
+			logger.Log.Infof("%s stopped", name)
+		}()
+	}
+
+	drain("gRPC server", func(ctx context.Context) error {
+		return gracefulStop(ctx, grpcServer)
+	})
+	drain("gNMI proxy", func(ctx context.Context) error {
+		gnmiProxy.Shutdown()
+		defer gnmiProxy.Close()
+		return gracefulStop(ctx, gnmiGRPCServer)
+	})
+	drain("SSH bastion", bastion.Shutdown)
+	if netconfServer != nil {
+		drain("NETCONF server", netconfServer.Shutdown)
+	}
+	if telnetServer != nil {
+		drain("Telnet server", telnetServer.Shutdown)
+	}
+	if httpServer != nil {
+		drain("HTTP server", func(ctx context.Context) error {
+			if err := httpServer.Shutdown(ctx); err != nil {
+				httpServer.Close()
+				return err
+			}
+			return nil
+		})
+		drain("Web terminal", terminal.Shutdown)
+	}
+	wg.Wait()
+
+	if sshPool != nil {
+		sshPool.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Log.WithError(err).Warn("Failed to flush traces")
 	}
 
 	logger.Log.Info("Gateway stopped")
 }
 
-func startGRPCServer(cfg *config.Config, port int) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+// gracefulStop waits for in-flight RPCs to finish, cancelling the remaining
+// ones when ctx expires
+func gracefulStop(ctx context.Context, server *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		<-done
+		return ctx.Err()
 	}
+}
 
-	grpcServer := grpc.NewServer()
-	gatewayServer := grpcserver.NewServer(cfg)
+func newGRPCServer(gatewayServer *grpcserver.Server, checker *health.Checker) *grpc.Server {
+	grpcServer := grpc.NewServer(grpc.StatsHandler(tracing.ServerHandler()))
 
 	pb.RegisterGatewayServer(grpcServer, gatewayServer)
+	registerHealth(grpcServer, checker, pb.Gateway_ServiceDesc.ServiceName)
 
 	// Enable gRPC reflection for debugging with grpcurl
 	reflection.Register(grpcServer)
 
+	return grpcServer
+}
+
+func startGRPCServer(grpcServer *grpc.Server, checker *health.Checker, port int, proxyProtocol config.ProxyProtocolSettings) error {
+	listener, err := listen.TCP(fmt.Sprintf(":%d", port), proxyProtocol)
+	if err != nil {
+		checker.SetListener("grpc", err)
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
 	logger.Log.Infof("Starting gRPC server on port %d", port)
 
+	return serveGRPC(grpcServer, checker, listener)
+}
+
+func serveGRPC(grpcServer *grpc.Server, checker *health.Checker, listener net.Listener) error {
+	checker.SetListener("grpc", nil)
 	if err := grpcServer.Serve(listener); err != nil {
+		checker.SetListener("grpc", err)
 		return fmt.Errorf("failed to serve gRPC: %w", err)
 	}
 
 	return nil
 }
 
-func startSSHBastion(cfg *config.Config, port int, hostKeyPath, authorizedKeysPath string) error {
-	bastion, err := sshbastion.NewBastionServer(cfg, hostKeyPath, authorizedKeysPath)
+func newHTTPServer(gatewayServer *grpcserver.Server, checker *health.Checker, terminal *webterm.Server, idp *auth.LocalIdP, port int) *http.Server {
+	mux := http.NewServeMux()
+	checker.Register(mux)
+	mux.Handle("GET /metrics", metrics.Handler())
+	rest.NewServer(gatewayServer).Register(mux)
+	terminal.Register(mux)
+	if idp != nil {
+		mux.Handle("/oidc/", http.StripPrefix("/oidc", idp.Handler()))
+	}
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func startHTTPServer(server *http.Server, checker *health.Checker, port int) error {
+	listener, err := net.Listen("tcp", server.Addr)
 	if err != nil {
-		return fmt.Errorf("failed to create SSH bastion: %w", err)
+		checker.SetListener("http", err)
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
 	}
 
+	logger.Log.Infof("Starting HTTP API server on port %d", port)
+
+	return serveHTTP(server, checker, listener)
+}
+
+func serveHTTP(server *http.Server, checker *health.Checker, listener net.Listener) error {
+	checker.SetListener("http", nil)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		checker.SetListener("http", err)
+		return fmt.Errorf("failed to serve HTTP: %w", err)
+	}
+
+	return nil
+}
+
+func startSSHBastion(bastion *sshbastion.BastionServer, checker *health.Checker, port int) error {
 	logger.Log.Infof("Starting SSH bastion server on port %d", port)
 
 	if err := bastion.Start(fmt.Sprintf(":%d", port)); err != nil {
+		checker.SetListener("ssh", err)
 		return fmt.Errorf("failed to start SSH bastion: %w", err)
 	}
 
 	return nil
 }
 
-func startGNMIServer(cfg *config.Config, port int) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+func serveSSHBastion(bastion *sshbastion.BastionServer, checker *health.Checker, listener net.Listener) error {
+	if err := bastion.Serve(listener); err != nil {
+		checker.SetListener("ssh", err)
+		return fmt.Errorf("failed to serve SSH bastion: %w", err)
+	}
+
+	return nil
+}
+
+func startNetconfServer(netconfServer *sshbastion.NetconfServer, checker *health.Checker, port int) error {
+	logger.Log.Infof("Starting NETCONF server on port %d", port)
+
+	if err := netconfServer.Start(fmt.Sprintf(":%d", port)); err != nil {
+		checker.SetListener("netconf", err)
+		return fmt.Errorf("failed to start NETCONF server: %w", err)
+	}
+
+	return nil
+}
+
+func startTelnetServer(telnetServer *sshbastion.TelnetServer, checker *health.Checker, port int) error {
+	logger.Log.Infof("Starting telnet server on port %d", port)
+
+	if err := telnetServer.Start(fmt.Sprintf(":%d", port)); err != nil {
+		checker.SetListener("telnet", err)
+		return fmt.Errorf("failed to start telnet server: %w", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	gnmiServer := gnmiserver.NewServer(cfg)
+	return nil
+}
+
+func newGNMIServer(gnmiProxy *gnmiserver.Server, checker *health.Checker) *grpc.Server {
+	grpcServer := grpc.NewServer(grpc.StatsHandler(tracing.ServerHandler()))
 
-	gnmipb.RegisterGNMIServer(grpcServer, gnmiServer)
+	gnmipb.RegisterGNMIServer(grpcServer, gnmiProxy)
+	registerHealth(grpcServer, checker, gnmipb.GNMI_ServiceDesc.ServiceName)
 
 	// Enable gRPC reflection for debugging with grpcurl
 	reflection.Register(grpcServer)
 
+	return grpcServer
+}
+
+func startGNMIServer(grpcServer *grpc.Server, checker *health.Checker, port int, proxyProtocol config.ProxyProtocolSettings) error {
+	listener, err := listen.TCP(fmt.Sprintf(":%d", port), proxyProtocol)
+	if err != nil {
+		checker.SetListener("gnmi", err)
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
 	logger.Log.Infof("Starting gNMI proxy server on port %d", port)
 
+	return serveGNMI(grpcServer, checker, listener)
+}
+
+func serveGNMI(grpcServer *grpc.Server, checker *health.Checker, listener net.Listener) error {
+	checker.SetListener("gnmi", nil)
 	if err := grpcServer.Serve(listener); err != nil {
+		checker.SetListener("gnmi", err)
 		return fmt.Errorf("failed to serve gNMI: %w", err)
 	}
 
 	return nil
 }
+
+// registerHealth adds the grpc.health.v1 service to a gRPC server and keeps it in sync with the checker
+func registerHealth(grpcServer *grpc.Server, checker *health.Checker, service string) {
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	checker.AddGRPCServer(healthServer, service)
+}
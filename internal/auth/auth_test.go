@@ -0,0 +1,692 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// rfcSecret is the RFC 6238 SHA-1 test key "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/auth_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B vectors, truncated to six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(rfcSecret, now)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		want   bool
+	}{
+		{"current period", rfcSecret, code, now, true},
+		{"previous period", rfcSecret, code, now.Add(30 * time.Second), true},
+		{"next period", rfcSecret, code, now.Add(-30 * time.Second), true},
+		{"expired", rfcSecret, code, now.Add(90 * time.Second), false},
+		{"lowercase secret with spaces", "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", code, now, true},
+		{"wrong code", rfcSecret, "000000", now, false},
+		{"short code", rfcSecret, code[:5], now, false},
+		{"invalid secret", "not base32!", code, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateTOTP(tt.secret, tt.code, tt.at); got != tt.want {
+				t.Errorf("ValidateTOTP = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Settings: config.Settings{Users: map[string]config.UserSettings{
+		"alice": {PasswordHash: string(hash)},
+		"bob":   {PasswordHash: string(hash), TOTPSecret: rfcSecret},
+	}}}
+	store, err := NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	now := time.Unix(1234567890, 0)
+	store.SetClock(func() time.Time { return now })
+	users, err := NewUsers(cfg, store)
+	if err != nil {
+		t.Fatalf("NewUsers failed: %v", err)
+	}
+
+	if err := users.CheckPassword("alice", "secret"); err != nil {
+		t.Errorf("CheckPassword failed: %v", err)
+	}
+	for _, login := range [][2]string{{"alice", "wrong"}, {"mallory", "secret"}} {
+		if err := users.CheckPassword(login[0], login[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("CheckPassword(%s, %s) = %v, want ErrInvalidCredentials", login[0], login[1], err)
+		}
+	}
+
+	if users.RequiresTOTP("alice") || !users.RequiresTOTP("bob") {
+		t.Error("only bob should require TOTP")
+	}
+	if users.NeedsTOTPEnrollment("alice") {
+		t.Error("alice must not enroll when TOTP is optional")
+	}
+	if _, err := users.CheckTOTP("bob", "005924"); err != nil {
+		t.Errorf("CheckTOTP failed: %v", err)
+	}
+	// The configured secret gets the replay protection of the store
+	if _, err := users.CheckTOTP("bob", "005924"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("CheckTOTP with a used code = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := users.CheckTOTP("bob", "123456"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("CheckTOTP with a wrong code = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := users.CheckTOTP("alice", "005924"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("CheckTOTP without a secret = %v, want ErrInvalidCredentials", err)
+	}
+
+	cfg.Settings.BastionTOTP.Required = true
+	users, err = NewUsers(cfg, store)
+	if err != nil {
+		t.Fatalf("NewUsers failed: %v", err)
+	}
+	if !users.NeedsTOTPEnrollment("alice") || users.NeedsTOTPEnrollment("bob") {
+		t.Error("only alice must enroll when TOTP is required")
+	}
+}
+
+func TestNewUsers_Invalid(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sha256.Sum256([]byte("token"))
+	store, _ := NewTOTPStore(&config.Config{})
+	for name, users := range map[string]map[string]config.UserSettings{
+		"plain password":   {"alice": {PasswordHash: "secret"}},
+		"short token hash": {"alice": {PasswordHash: string(hash), APITokenHashes: []string{"abcd"}}},
+		"token not hex":    {"alice": {PasswordHash: string(hash), APITokenHashes: []string{strings.Repeat("x", 64)}}},
+		"shared token": {
+			"alice": {PasswordHash: string(hash), APITokenHashes: []string{hex.EncodeToString(token[:])}},
+			"bob":   {PasswordHash: string(hash), APITokenHashes: []string{hex.EncodeToString(token[:])}},
+		},
+	} {
+		if _, err := NewUsers(&config.Config{Settings: config.Settings{Users: users}}, store); err == nil {
+			t.Errorf("%s: NewUsers should fail", name)
+		}
+	}
+}
+
+func TestUsers_KeyOnly(t *testing.T) {
+	cfg := &config.Config{Settings: config.Settings{Users: map[string]config.UserSettings{
+		"alice": {AuthorizedKeys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGb8J2sJ+8kJ3nJtxGWbDlT0Vb6Y4Zq1o4bTzv8Jx3Kq alice"}},
+		"bob":   {Principals: []string{"admin"}},
+	}}}
+	store, _ := NewTOTPStore(cfg)
+	users, err := NewUsers(cfg, store)
+	if err != nil {
+		t.Fatalf("NewUsers failed for users without a password: %v", err)
+	}
+	for _, password := range []string{"", "secret"} {
+		if err := users.CheckPassword("alice", password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("CheckPassword(alice, %q) = %v, want ErrInvalidCredentials", password, err)
+		}
+	}
+}
+
+func TestUsers_TokenUser(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sha256.Sum256([]byte("alice-token"))
+	cfg := &config.Config{Settings: config.Settings{Users: map[string]config.UserSettings{
+		"alice": {PasswordHash: string(hash), APITokenHashes: []string{strings.ToUpper(hex.EncodeToString(token[:]))}},
+		"bob":   {PasswordHash: string(hash)},
+	}}}
+	store, _ := NewTOTPStore(cfg)
+	users, err := NewUsers(cfg, store)
+	if err != nil {
+		t.Fatalf("NewUsers failed: %v", err)
+	}
+	if user, ok := users.TokenUser("alice-token"); !ok || user != "alice" {
+		t.Errorf("TokenUser = %q, %v, want alice", user, ok)
+	}
+	for _, token := range []string{"", "bob-token", hex.EncodeToString(token[:])} {
+		if user, ok := users.TokenUser(token); ok {
+			t.Errorf("TokenUser(%q) = %q, want no user", token, user)
+		}
+	}
+}
+
+func TestUserKeys(t *testing.T) {
+	alice := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newTestPublicKey(t))))
+	bob := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newTestPublicKey(t))))
+	cfg := &config.Config{Settings: config.Settings{Users: map[string]config.UserSettings{
+		"alice": {AuthorizedKeys: []string{alice + " alice@laptop"}},
+		"bob":   {AuthorizedKeys: []string{bob}},
+	}}}
+	keys, err := UserKeys(cfg)
+	if err != nil {
+		t.Fatalf("UserKeys failed: %v", err)
+	}
+	for user, line := range map[string]string{"alice": alice, "bob": bob} {
+		key, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(line))
+		if keys[string(key.Marshal())] != user {
+			t.Errorf("key of %s bound to %q", user, keys[string(key.Marshal())])
+		}
+	}
+
+	for name, users := range map[string]map[string]config.UserSettings{
+		"invalid key": {"alice": {AuthorizedKeys: []string{"ssh-ed25519 nope"}}},
+		"shared key":  {"alice": {AuthorizedKeys: []string{alice}}, "bob": {AuthorizedKeys: []string{alice}}},
+	} {
+		if _, err := UserKeys(&config.Config{Settings: config.Settings{Users: users}}); err == nil {
+			t.Errorf("%s: UserKeys should fail", name)
+		}
+	}
+}
+
+func TestUserCA(t *testing.T) {
+	cfg := &config.Config{Settings: config.Settings{
+		Users: map[string]config.UserSettings{
+			"alice": {},
+			"bob":   {Principals: []string{"admin", "netops"}},
+		},
+		UserCA: config.UserCASettings{PrivateKeyFile: writeTestKey(t, t.TempDir()), Validity: 3600},
+	}}
+	ca, err := NewUserCA(cfg)
+	if err != nil {
+		t.Fatalf("NewUserCA failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	ca.now = func() time.Time { return now }
+	key := newTestPublicKey(t)
+
+	cert, err := ca.Issue("bob", key)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if got := time.Unix(int64(cert.ValidBefore), 0).Sub(now); got != time.Hour {
+		t.Errorf("validity = %s, want 1h", got)
+	}
+	for _, principal := range []string{"admin", "netops"} {
+		if err := ca.Check(principal, cert); err != nil {
+			t.Errorf("Check(%s) failed: %v", principal, err)
+		}
+	}
+	if err := ca.Check("bob", cert); err == nil {
+		t.Error("Check should fail for a principal not in the certificate")
+	}
+	if user, ok := CertificateUser(cert); !ok || user != "bob" {
+		t.Errorf("CertificateUser = %q, %v, want bob", user, ok)
+	}
+	if alice, _ := ca.Issue("alice", key); len(alice.ValidPrincipals) != 1 || alice.ValidPrincipals[0] != "alice" {
+		t.Errorf("principals = %v, want the user name", alice.ValidPrincipals)
+	}
+	if _, err := ca.Issue("bob", cert); err == nil {
+		t.Error("Issue should refuse to sign a certificate")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := ca.Check("admin", cert); err == nil {
+		t.Error("Check should fail for an expired certificate")
+	}
+	now = now.Add(-2 * time.Hour)
+
+	// Certificates from another CA are refused
+	cfg.Settings.UserCA.PrivateKeyFile = writeTestKey(t, t.TempDir())
+	other, err := NewUserCA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.now = ca.now
+	if err := other.Check("admin", cert); err == nil {
+		t.Error("Check should fail for a certificate of another CA")
+	}
+
+	cfg.Settings.UserCA = config.UserCASettings{}
+	if _, err := NewUserCA(cfg); err == nil {
+		t.Error("NewUserCA should fail without a key")
+	}
+}
+
+// writeTestKey writes a new OpenSSH ed25519 private key and returns its path
+func writeTestKey(t *testing.T, dir string) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ca_key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestTOTPStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "totp.json")
+	cfg := &config.Config{Settings: config.Settings{BastionTOTP: config.BastionTOTPSettings{StoreFile: path}}}
+	store, err := NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	store.SetClock(func() time.Time { return now })
+
+	enrollment, err := store.NewEnrollment("alice", "Gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/Gateway:alice?") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("URI = %s", enrollment.URI)
+	}
+	if len(enrollment.RecoveryCodes) != 10 {
+		t.Errorf("got %d recovery codes, want 10", len(enrollment.RecoveryCodes))
+	}
+
+	// Nothing is stored until a code proves the app has the secret
+	if err := store.Confirm(enrollment, "000000"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Confirm with a wrong code = %v", err)
+	}
+	if store.Enrolled("alice") {
+		t.Fatal("alice enrolled without a valid code")
+	}
+	code, _ := TOTPCode(enrollment.Secret, now)
+	if err := store.Confirm(enrollment, code); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	// The code used for enrollment cannot log in, the next one can, once
+	if _, err := store.Verify("alice", code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify with the enrollment code = %v, want ErrInvalidCredentials", err)
+	}
+	now = now.Add(30 * time.Second)
+	code, _ = TOTPCode(enrollment.Secret, now)
+	if recovery, err := store.Verify("alice", code); err != nil || recovery {
+		t.Errorf("Verify = %v, %v, want a TOTP code", recovery, err)
+	}
+	if _, err := store.Verify("alice", code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify replayed code = %v, want ErrInvalidCredentials", err)
+	}
+
+	// Recovery codes work once, in any case and without the dash
+	recoveryCode := strings.ToUpper(strings.ReplaceAll(enrollment.RecoveryCodes[3], "-", ""))
+	if recovery, err := store.Verify("alice", recoveryCode); err != nil || !recovery {
+		t.Errorf("Verify = %v, %v, want a recovery code", recovery, err)
+	}
+	if _, err := store.Verify("alice", recoveryCode); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify reused recovery code = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := store.Verify("bob", code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify for a user without a secret = %v, want ErrInvalidCredentials", err)
+	}
+
+	// The store survives a restart, with the used code and step
+	reloaded, err := NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	reloaded.SetClock(store.now)
+	if !reloaded.Enrolled("alice") || reloaded.RecoveryCodesLeft("alice") != 9 {
+		t.Errorf("reloaded: enrolled %v with %d recovery codes, want 9", reloaded.Enrolled("alice"), reloaded.RecoveryCodesLeft("alice"))
+	}
+	if _, err := reloaded.Verify("alice", code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("a used code was accepted after a restart")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("store mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestTOTPStore_ConfiguredSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "totp.json")
+	cfg := &config.Config{Settings: config.Settings{
+		BastionTOTP: config.BastionTOTPSettings{StoreFile: path},
+		Users: map[string]config.UserSettings{
+			"alice": {TOTPSecret: rfcSecret},
+			"bob":   {TOTPSecret: rfcSecret},
+		},
+	}}
+	store, err := NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	now := time.Unix(1234567890, 0)
+	store.SetClock(func() time.Time { return now })
+	if !store.Enrolled("alice") || !store.Enrolled("bob") {
+		t.Fatal("users with a configured secret are not enrolled")
+	}
+	if _, err := store.Verify("alice", "005924"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// bob enrolls a new secret
+	enrollment, err := store.NewEnrollment("bob", "Gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := TOTPCode(enrollment.Secret, now)
+	if err := store.Confirm(enrollment, code); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	// After a restart alice's used code stays used and bob keeps the
+	// enrolled secret. A changed configured secret replaces the stored one.
+	cfg.Settings.Users["bob"] = config.UserSettings{TOTPSecret: "JBSWY3DPEHPK3PXP"}
+	reloaded, err := NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	reloaded.SetClock(store.now)
+	if _, err := reloaded.Verify("alice", "005924"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("a used code of a configured secret was accepted after a restart")
+	}
+	if _, err := reloaded.Verify("bob", "005924"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("the configured secret replaced bob's enrolled one")
+	}
+	cfg.Settings.Users["alice"] = config.UserSettings{TOTPSecret: "JBSWY3DPEHPK3PXP"}
+	reloaded, err = NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	reloaded.SetClock(store.now)
+	code, _ = TOTPCode("JBSWY3DPEHPK3PXP", now)
+	if _, err := reloaded.Verify("alice", code); err != nil {
+		t.Errorf("Verify with the changed configured secret failed: %v", err)
+	}
+
+	// A configured secret removed from settings.users no longer applies
+	delete(cfg.Settings.Users, "alice")
+	reloaded, err = NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	if reloaded.Enrolled("alice") || !reloaded.Enrolled("bob") {
+		t.Errorf("Enrolled alice = %v, bob = %v, want only bob", reloaded.Enrolled("alice"), reloaded.Enrolled("bob"))
+	}
+
+	cfg.Settings.Users["alice"] = config.UserSettings{TOTPSecret: "1"}
+	if _, err := NewTOTPStore(cfg); err == nil {
+		t.Error("NewTOTPStore should fail for an invalid configured secret")
+	}
+
+	// Without a store file nobody can enroll
+	memory, err := NewTOTPStore(&config.Config{})
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	if memory.CanEnroll() {
+		t.Error("a store without a file allows enrollment")
+	}
+	if _, err := memory.NewEnrollment("alice", "Gateway"); err == nil {
+		t.Error("NewEnrollment without a store file should fail")
+	}
+}
+
+func TestSSHSignature(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	message := []byte("challenge")
+
+	armored, err := SignSSHSignature(signer, "gateway-certificate", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := VerifySSHSignature(armored, "gateway-certificate", message)
+	if err != nil {
+		t.Fatalf("VerifySSHSignature failed: %v", err)
+	}
+	if string(key.Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Error("VerifySSHSignature returned another key")
+	}
+
+	if _, err := VerifySSHSignature(armored, "file", message); err == nil {
+		t.Error("signature accepted for another namespace")
+	}
+	if _, err := VerifySSHSignature(armored, "gateway-certificate", []byte("other")); err == nil {
+		t.Error("signature accepted for another message")
+	}
+	if _, err := VerifySSHSignature([]byte("signature"), "gateway-certificate", message); err == nil {
+		t.Error("VerifySSHSignature accepted garbage")
+	}
+}
+
+func TestSSHSignature_SSHKeygen(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not installed")
+	}
+	dir := t.TempDir()
+	for _, keyType := range []string{"ed25519", "rsa", "ecdsa"} {
+		keyFile := filepath.Join(dir, keyType)
+		if out, err := exec.Command("ssh-keygen", "-q", "-t", keyType, "-N", "", "-f", keyFile).CombinedOutput(); err != nil {
+			t.Fatalf("ssh-keygen: %v: %s", err, out)
+		}
+		message := filepath.Join(dir, keyType+".txt")
+		if err := os.WriteFile(message, []byte("challenge"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command("ssh-keygen", "-Y", "sign", "-n", "gateway-certificate", "-f", keyFile, message).CombinedOutput(); err != nil {
+			t.Fatalf("ssh-keygen -Y sign: %v: %s", err, out)
+		}
+		armored, err := os.ReadFile(message + ".sig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifySSHSignature(armored, "gateway-certificate", []byte("challenge")); err != nil {
+			t.Errorf("%s: VerifySSHSignature failed: %v", keyType, err)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l, err := NewLimiter("Test", config.BastionLimitSettings{MaxFailures: 2, BackoffBase: 1, Exempt: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	l.SetClock(func() time.Time { return now })
+	source := netip.MustParseAddr("192.0.2.1")
+
+	l.Record(source, "alice", false)
+	if err := l.Check(netip.MustParseAddr("192.0.2.2"), "alice"); err == nil {
+		t.Error("Check succeeded for a backed off user")
+	}
+	if err := l.Check(source, ""); err == nil {
+		t.Error("Check succeeded for a backed off source")
+	}
+	now = now.Add(time.Second)
+	if err := l.Check(source, "alice"); err != nil {
+		t.Errorf("Check after the backoff: %v", err)
+	}
+
+	// The second failure bans, a success clears the user but not the source
+	l.Record(source, "alice", false)
+	now = now.Add(10 * time.Minute)
+	if err := l.Check(source, "alice"); err == nil {
+		t.Error("Check succeeded for a banned user")
+	}
+	l.Record(source, "alice", true)
+	if err := l.Check(netip.MustParseAddr("192.0.2.2"), "alice"); err != nil {
+		t.Errorf("Check after a success: %v", err)
+	}
+	if err := l.Check(source, ""); err == nil {
+		t.Error("A success cleared the ban of its source")
+	}
+
+	exempt := netip.MustParseAddr("10.1.2.3")
+	for i := 0; i < 3; i++ {
+		l.Record(exempt, "bob", false)
+	}
+	if err := l.Check(netip.MustParseAddr("192.0.2.3"), "bob"); err != nil {
+		t.Errorf("Failures from an exempt source counted: %v", err)
+	}
+
+	if _, err := NewLimiter("Test", config.BastionLimitSettings{Exempt: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("NewLimiter accepted an invalid exempt CIDR")
+	}
+}
+
+func TestOIDCDeviceFlow_LocalIdP(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Settings: config.Settings{Users: map[string]config.UserSettings{
+		"alice": {PasswordHash: string(hash)},
+	}}}
+	store, err := NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := NewUsers(cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := NewLimiter("Test", config.BastionLimitSettings{MaxFailures: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	idp := NewLocalIdP(server.URL+"/oidc", users, limits)
+	now := time.Unix(1700000000, 0)
+	idp.SetClock(func() time.Time { return now })
+	mux.Handle("/oidc/", http.StripPrefix("/oidc", idp.Handler()))
+
+	flow, err := NewOIDCDeviceFlow(config.OIDCSettings{
+		DeviceAuthorizationEndpoint: server.URL + "/oidc/device_authorization",
+		TokenEndpoint:               server.URL + "/oidc/token",
+		UserinfoEndpoint:            server.URL + "/oidc/userinfo",
+		ClientID:                    "gateway",
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCDeviceFlow failed: %v", err)
+	}
+	ctx := context.Background()
+	device, err := flow.StartDevice(ctx)
+	if err != nil {
+		t.Fatalf("StartDevice failed: %v", err)
+	}
+	if device.VerificationURI != server.URL+"/oidc/device" || len(device.UserCode) != 9 {
+		t.Fatalf("device = %+v, want a user code for the local verification page", device)
+	}
+	if _, err := flow.PollDevice(ctx, device.DeviceCode); !errors.Is(err, ErrAuthorizationPending) {
+		t.Errorf("PollDevice before sign-in = %v, want ErrAuthorizationPending", err)
+	}
+	if _, err := flow.PollDevice(ctx, device.DeviceCode); !errors.Is(err, ErrSlowDown) {
+		t.Errorf("PollDevice right away = %v, want ErrSlowDown", err)
+	}
+
+	signIn := func(password string) int {
+		resp, err := http.PostForm(device.VerificationURI, url.Values{
+			"user_code": {strings.ToLower(device.UserCode)},
+			"username":  {"alice"},
+			"password":  {password},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := signIn("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("sign-in with a wrong password returned HTTP %d, want 401", code)
+	}
+	if code := signIn("secret"); code != http.StatusOK {
+		t.Fatalf("sign-in returned HTTP %d", code)
+	}
+
+	now = now.Add(10 * time.Second)
+	username, err := flow.PollDevice(ctx, device.DeviceCode)
+	if err != nil || username != "alice" {
+		t.Fatalf("PollDevice = %q, %v, want alice", username, err)
+	}
+	// A device code is redeemed once
+	if _, err := flow.PollDevice(ctx, device.DeviceCode); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("PollDevice after redemption = %v, want ErrExpiredToken", err)
+	}
+
+	// Failed sign-ins are throttled
+	device, err = flow.StartDevice(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signIn("wrong")
+	signIn("wrong")
+	if code := signIn("secret"); code != http.StatusTooManyRequests {
+		t.Errorf("sign-in of a banned user returned HTTP %d, want 429", code)
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/proxy"
+)
+
+// defaultUserCertValidity is the lifetime of user certificates when
+// user_ca.validity is not set
+const defaultUserCertValidity = 8 * time.Hour
+
+// userKeyIDPrefix starts the key ID of user certificates, followed by the
+// gateway user they were issued to
+const userKeyIDPrefix = "gateway-user:"
+
+// UserCA signs the certificates bastion users log in with, so that their
+// keys need not be in authorized_keys
+type UserCA struct {
+	signer   ssh.Signer
+	validity time.Duration
+	users    map[string]config.UserSettings
+	// now returns the time certificates are issued and checked at
+	now func() time.Time
+}
+
+// NewUserCA loads the CA key of settings.user_ca
+func NewUserCA(cfg *config.Config) (*UserCA, error) {
+	settings := cfg.Settings.UserCA
+	if settings.PrivateKeyFile == "" {
+		return nil, fmt.Errorf("user_ca.private_key_file is not set")
+	}
+	signer, err := proxy.LoadPrivateKey(settings.PrivateKeyFile, settings.PassphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("user CA: %w", err)
+	}
+	validity := time.Duration(settings.Validity) * time.Second
+	if validity <= 0 {
+		validity = defaultUserCertValidity
+	}
+	return &UserCA{signer: signer, validity: validity, users: cfg.Settings.Users, now: time.Now}, nil
+}
+
+// PublicKey returns the CA key that signed certificates are checked against
+func (ca *UserCA) PublicKey() ssh.PublicKey {
+	return ca.signer.PublicKey()
+}
+
+// Principals returns the login names certificates of username are valid for
+func (ca *UserCA) Principals(username string) []string {
+	if principals := ca.users[username].Principals; len(principals) > 0 {
+		return principals
+	}
+	return []string{username}
+}
+
+// Issue signs key for username. The caller authenticates the user first.
+func (ca *UserCA) Issue(username string, key ssh.PublicKey) (*ssh.Certificate, error) {
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("cannot sign a certificate, send the plain public key")
+	}
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	now := ca.now()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           userKeyIDPrefix + username,
+		ValidPrincipals: ca.Principals(username),
+		// Allow for clients whose clocks are slightly ahead
+		ValidAfter:  uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore: uint64(now.Add(ca.validity).Unix()),
+		Permissions: ssh.Permissions{Extensions: map[string]string{
+			"permit-pty":              "",
+			"permit-agent-forwarding": "",
+			"permit-port-forwarding":  "",
+		}},
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// Check verifies that cert was issued by the CA for principal and is valid
+// now
+func (ca *UserCA) Check(principal string, cert *ssh.Certificate) error {
+	if cert.CertType != ssh.UserCert {
+		return fmt.Errorf("not a user certificate")
+	}
+	// CheckCert checks the signature but not who made it
+	if !bytes.Equal(cert.SignatureKey.Marshal(), ca.signer.PublicKey().Marshal()) {
+		return fmt.Errorf("certificate signed by unrecognized authority")
+	}
+	checker := &ssh.CertChecker{Clock: ca.now}
+	return checker.CheckCert(principal, cert)
+}
+
+// CertificateUser returns the gateway user a certificate was issued to, from
+// its key ID. Only use it for certificates that passed Check.
+func CertificateUser(cert *ssh.Certificate) (string, bool) {
+	username, ok := strings.CutPrefix(cert.KeyId, userKeyIDPrefix)
+	return username, ok && username != ""
+}
@@ -0,0 +1,400 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+)
+
+const (
+	// idpDeviceLifetime is how long a device code can be authorized
+	idpDeviceLifetime = 10 * time.Minute
+	// idpPollInterval is the minimum time between polls of a device code
+	idpPollInterval = 5 * time.Second
+	// idpTokenLifetime is how long an access token reads the userinfo
+	idpTokenLifetime = 5 * time.Minute
+	// maxIdPDevices limits the device codes waiting to be authorized
+	maxIdPDevices = 1000
+	// userCodeAlphabet has no vowels, so that user codes spell no words
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+// LocalIdP is a stand-in OpenID Connect provider for the device flow, for
+// gateways without an identity provider. Gateway users authorize device
+// codes on its verification page with their password and TOTP code. It
+// serves the endpoints of a provider and is also used in-process.
+type LocalIdP struct {
+	issuer string
+	users  *Users
+	limits *Limiter
+
+	mu      sync.Mutex
+	devices map[string]*idpDevice
+	// userCodes maps user codes, without their dash, to device codes
+	userCodes map[string]string
+	tokens    map[string]idpToken
+	now       func() time.Time
+}
+
+// idpDevice is a device code waiting for or authorized by a user
+type idpDevice struct {
+	userCode string
+	user     string
+	expires  time.Time
+	lastPoll time.Time
+}
+
+// idpToken is an access token for the userinfo of user
+type idpToken struct {
+	user    string
+	expires time.Time
+}
+
+// NewLocalIdP creates a provider whose external URL is issuer, signing in
+// users with their credentials, throttled by limits
+func NewLocalIdP(issuer string, users *Users, limits *Limiter) *LocalIdP {
+	return &LocalIdP{
+		issuer:    strings.TrimSuffix(issuer, "/"),
+		users:     users,
+		limits:    limits,
+		devices:   make(map[string]*idpDevice),
+		userCodes: make(map[string]string),
+		tokens:    make(map[string]idpToken),
+		now:       time.Now,
+	}
+}
+
+// SetClock sets the function returning the current time, for tests
+func (p *LocalIdP) SetClock(now func() time.Time) {
+	p.mu.Lock()
+	p.now = now
+	p.mu.Unlock()
+}
+
+// Handler serves the provider's endpoints, to be mounted at the path of its
+// issuer URL
+func (p *LocalIdP) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.handleConfiguration)
+	mux.HandleFunc("POST /device_authorization", p.handleDeviceAuthorization)
+	mux.HandleFunc("GET /device", p.handleDevicePage)
+	mux.HandleFunc("POST /device", p.handleDeviceLogin)
+	mux.HandleFunc("POST /token", p.handleToken)
+	mux.HandleFunc("GET /userinfo", p.handleUserinfo)
+	return mux
+}
+
+// StartDevice creates a device code
+func (p *LocalIdP) StartDevice(ctx context.Context) (*DeviceAuthorization, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	p.prune(now)
+	if len(p.devices) >= maxIdPDevices {
+		return nil, errors.New("too many pending device authorizations")
+	}
+
+	deviceCode, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, err
+	}
+	p.devices[deviceCode] = &idpDevice{userCode: userCode, expires: now.Add(idpDeviceLifetime)}
+	p.userCodes[strings.ReplaceAll(userCode, "-", "")] = deviceCode
+	return &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         p.issuer + "/device",
+		VerificationURIComplete: p.issuer + "/device?user_code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int(idpDeviceLifetime / time.Second),
+		Interval:                int(idpPollInterval / time.Second),
+	}, nil
+}
+
+// PollDevice returns the user who authorized deviceCode. A device code is
+// only redeemed once.
+func (p *LocalIdP) PollDevice(ctx context.Context, deviceCode string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	device := p.devices[deviceCode]
+	if device == nil {
+		return "", ErrExpiredToken
+	}
+	if !now.Before(device.expires) {
+		p.removeDevice(deviceCode)
+		return "", ErrExpiredToken
+	}
+	if device.user == "" {
+		if now.Sub(device.lastPoll) < idpPollInterval {
+			device.lastPoll = now
+			return "", ErrSlowDown
+		}
+		device.lastPoll = now
+		return "", ErrAuthorizationPending
+	}
+	p.removeDevice(deviceCode)
+	return device.user, nil
+}
+
+// authorize marks the device code of userCode as authorized by user
+func (p *LocalIdP) authorize(userCode, user string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	deviceCode, ok := p.userCodes[normalizeUserCode(userCode)]
+	if !ok {
+		return false
+	}
+	device := p.devices[deviceCode]
+	if device.user != "" || !p.now().Before(device.expires) {
+		return false
+	}
+	device.user = user
+	return true
+}
+
+// removeDevice forgets a device code. The caller holds mu.
+func (p *LocalIdP) removeDevice(deviceCode string) {
+	if device, ok := p.devices[deviceCode]; ok {
+		delete(p.userCodes, strings.ReplaceAll(device.userCode, "-", ""))
+		delete(p.devices, deviceCode)
+	}
+}
+
+// prune drops expired device codes and tokens. The caller holds mu.
+func (p *LocalIdP) prune(now time.Time) {
+	for code, device := range p.devices {
+		if !now.Before(device.expires) {
+			p.removeDevice(code)
+		}
+	}
+	for token, t := range p.tokens {
+		if !now.Before(t.expires) {
+			delete(p.tokens, token)
+		}
+	}
+}
+
+// handleConfiguration serves the discovery document
+func (p *LocalIdP) handleConfiguration(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                        p.issuer,
+		"device_authorization_endpoint": p.issuer + "/device_authorization",
+		"token_endpoint":                p.issuer + "/token",
+		"userinfo_endpoint":             p.issuer + "/userinfo",
+		"grant_types_supported":         []string{deviceCodeGrantType},
+		"claims_supported":              []string{"sub", "preferred_username"},
+	})
+}
+
+// handleDeviceAuthorization serves POST /device_authorization. Any client ID
+// is accepted, the gateway user is only known once a user signs in.
+func (p *LocalIdP) handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	device, err := p.StartDevice(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "temporarily_unavailable", "error_description": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, device)
+}
+
+// handleToken serves POST /token for device codes
+func (p *LocalIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("grant_type") != deviceCodeGrantType {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	user, err := p.PollDevice(r.Context(), r.PostFormValue("device_code"))
+	if err != nil {
+		code := map[error]string{
+			ErrAuthorizationPending: "authorization_pending",
+			ErrSlowDown:             "slow_down",
+			ErrExpiredToken:         "expired_token",
+		}[err]
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	p.mu.Lock()
+	p.tokens[token] = idpToken{user: user, expires: p.now().Add(idpTokenLifetime)}
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(idpTokenLifetime / time.Second),
+	})
+}
+
+// handleUserinfo serves GET /userinfo for access tokens
+func (p *LocalIdP) handleUserinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	t, found := p.tokens[token]
+	valid := ok && found && p.now().Before(t.expires)
+	p.mu.Unlock()
+	if !valid {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"sub": t.user, "preferred_username": t.user})
+}
+
+// devicePage is the verification page
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Gateway sign-in</title></head>
+<body>
+<h1>Gateway sign-in</h1>
+{{if .Done}}<p>Signed in as {{.Username}}. You can return to your terminal.</p>
+{{else}}<p>Only enter a code you requested yourself: signing in lets whoever shows the code act as you.</p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+<p><label>Code <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+<p><label>Username <input name="username" value="{{.Username}}" autocomplete="username" required></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password" required></label></p>
+<p><label>Verification code <input name="totp_code" autocomplete="one-time-code"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// devicePageData fills the verification page
+type devicePageData struct {
+	UserCode string
+	Username string
+	Error    string
+	Done     bool
+}
+
+// handleDevicePage serves GET /device
+func (p *LocalIdP) handleDevicePage(w http.ResponseWriter, r *http.Request) {
+	p.renderDevicePage(w, http.StatusOK, devicePageData{UserCode: r.URL.Query().Get("user_code")})
+}
+
+// handleDeviceLogin serves POST /device, authorizing a device code once the
+// user's password and TOTP code are checked
+func (p *LocalIdP) handleDeviceLogin(w http.ResponseWriter, r *http.Request) {
+	data := devicePageData{UserCode: r.PostFormValue("user_code"), Username: r.PostFormValue("username")}
+	source := listen.RequestIP(r)
+	fields := map[string]interface{}{"username": data.Username, "remote": r.RemoteAddr}
+
+	if err := p.limits.Check(source, data.Username); err != nil {
+		metrics.AuthAttempts.WithLabelValues("oidc", "failure", "blocked").Inc()
+		data.Error = err.Error()
+		p.renderDevicePage(w, http.StatusTooManyRequests, data)
+		return
+	}
+	if reason, err := p.checkCredentials(data.Username, r.PostFormValue("password"), r.PostFormValue("totp_code")); err != nil {
+		p.limits.Record(source, data.Username, false)
+		metrics.AuthAttempts.WithLabelValues("oidc", "failure", reason).Inc()
+		logger.Log.WithError(err).WithFields(fields).Warn("Local IdP sign-in failed")
+		data.Error = err.Error()
+		p.renderDevicePage(w, http.StatusUnauthorized, data)
+		return
+	}
+	p.limits.Record(source, data.Username, true)
+	if !p.authorize(data.UserCode, data.Username) {
+		data.Error = "Unknown or expired code"
+		p.renderDevicePage(w, http.StatusBadRequest, data)
+		return
+	}
+	metrics.AuthAttempts.WithLabelValues("oidc", "success", "password").Inc()
+	logger.Log.WithFields(fields).Info("Local IdP authorized a device code")
+	data.Done = true
+	p.renderDevicePage(w, http.StatusOK, data)
+}
+
+// checkCredentials checks a password and, for users who have a second
+// factor, a TOTP or recovery code. reason labels failures in AuthAttempts.
+func (p *LocalIdP) checkCredentials(username, password, code string) (reason string, err error) {
+	if err := p.users.CheckPassword(username, password); err != nil {
+		return "password", err
+	}
+	if p.users.NeedsTOTPEnrollment(username) {
+		return "totp_enrollment", errors.New("TOTP enrollment required, enroll on the SSH bastion first")
+	}
+	if p.users.RequiresTOTP(username) {
+		if _, err := p.users.CheckTOTP(username, code); err != nil {
+			return "totp", err
+		}
+	}
+	return "", nil
+}
+
+func (p *LocalIdP) renderDevicePage(w http.ResponseWriter, status int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'")
+	w.WriteHeader(status)
+	_ = devicePage.Execute(w, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// randomToken returns an unguessable URL-safe token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newUserCode returns a user code of eight letters, e.g. WDJB-MJHT
+func newUserCode() (string, error) {
+	code := make([]byte, 0, 9)
+	b := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+		}
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		// Bytes past the last multiple of the alphabet would favor its
+		// first letters
+		if int(b[0]) >= 256/len(userCodeAlphabet)*len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode makes user codes typed in lower case or without their
+// dash match
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
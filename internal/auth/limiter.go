@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// Scopes of failure records, also logged with bans
+const (
+	LimitScopeSource = "source"
+	LimitScopeUser   = "user"
+)
+
+const (
+	defaultBanDuration = 15 * time.Minute
+	defaultBackoffMax  = time.Minute
+	// maxLimitRecords is the number of failure records kept before expired
+	// ones are dropped
+	maxLimitRecords = 10000
+)
+
+// Limiter refuses source addresses and gateway users for a while after
+// failed logins: for a backoff that doubles with each failure, then for a
+// ban once there were too many. It is configured by bastion_limits and
+// shared by the services that check gateway credentials.
+type Limiter struct {
+	// name starts the log message of bans
+	name        string
+	maxFailures int
+	banDuration time.Duration
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	exempt      []netip.Prefix
+	onBan       func(scope string)
+
+	mu      sync.Mutex
+	records map[limitKey]*failureRecord
+	// now returns the time failures are recorded and checked at
+	now func() time.Time
+}
+
+type limitKey struct {
+	scope string
+	name  string
+}
+
+// failureRecord holds the recent failed logins of a source or user
+type failureRecord struct {
+	failures int
+	last     time.Time
+	// until is the end of the current backoff or ban
+	until time.Time
+}
+
+// NewLimiter creates a limiter for settings. name starts the log message of
+// its bans, e.g. "Bastion".
+func NewLimiter(name string, settings config.BastionLimitSettings) (*Limiter, error) {
+	l := &Limiter{
+		name:        name,
+		maxFailures: settings.MaxFailures,
+		banDuration: time.Duration(settings.BanDuration) * time.Second,
+		backoffBase: time.Duration(settings.BackoffBase) * time.Second,
+		backoffMax:  time.Duration(settings.BackoffMax) * time.Second,
+		records:     make(map[limitKey]*failureRecord),
+		now:         time.Now,
+	}
+	if l.banDuration <= 0 {
+		l.banDuration = defaultBanDuration
+	}
+	if l.backoffMax <= 0 {
+		l.backoffMax = defaultBackoffMax
+	}
+	var err error
+	if l.exempt, err = listen.ParseCIDRs(settings.Exempt); err != nil {
+		return nil, fmt.Errorf("bastion_limits.exempt: %w", err)
+	}
+	return l, nil
+}
+
+// NewCredentialLimiter creates a limiter from bastion_limits for services
+// that check passwords without any other limit, such as the certificate API
+// and the NETCONF server. Their failures are always throttled: without
+// limits max_failures is 10 and backoff_base 1 second.
+func NewCredentialLimiter(name string, settings config.BastionLimitSettings) (*Limiter, error) {
+	if settings.MaxFailures <= 0 && settings.BackoffBase <= 0 {
+		settings.MaxFailures = 10
+		settings.BackoffBase = 1
+	}
+	return NewLimiter(name, settings)
+}
+
+// OnBan sets a function called with the scope of every ban
+func (l *Limiter) OnBan(fn func(scope string)) {
+	l.onBan = fn
+}
+
+// SetClock sets the function returning the time failures are recorded and
+// checked at, for tests
+func (l *Limiter) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	l.now = now
+	l.mu.Unlock()
+}
+
+// Now returns the time of the limiter's clock
+func (l *Limiter) Now() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.now()
+}
+
+// Tracking reports whether failures are counted at all
+func (l *Limiter) Tracking() bool {
+	return l.maxFailures > 0 || l.backoffBase > 0
+}
+
+// Exempted reports whether the limits do not apply to source
+func (l *Limiter) Exempted(source netip.Addr) bool {
+	return source.IsValid() && listen.Contains(l.exempt, source)
+}
+
+// Blocked reports whether name is backed off or banned in scope
+func (l *Limiter) Blocked(scope, name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record := l.records[limitKey{scope, name}]
+	return record != nil && l.now().Before(record.until)
+}
+
+// Check returns an error when source or user is backed off or banned.
+// Exempt sources are never refused, an empty user is not checked.
+func (l *Limiter) Check(source netip.Addr, user string) error {
+	if l.Exempted(source) {
+		return nil
+	}
+	if l.Blocked(LimitScopeSource, source.String()) {
+		return fmt.Errorf("too many failed logins from %s, try again later", source)
+	}
+	if user != "" && l.Blocked(LimitScopeUser, user) {
+		return fmt.Errorf("too many failed logins for %s, try again later", user)
+	}
+	return nil
+}
+
+// Record counts a failed login of user from source, or clears the failures
+// of user after a successful one. The source keeps its failures: one valid
+// login must not reset the guesses made from it for other users. Failures
+// of exempt sources and of an empty user are not counted.
+func (l *Limiter) Record(source netip.Addr, user string, success bool) {
+	if success {
+		if user != "" {
+			l.Clear(LimitScopeUser, user)
+		}
+		return
+	}
+	if l.Exempted(source) {
+		return
+	}
+	l.Failure(LimitScopeSource, source.String())
+	if user != "" {
+		l.Failure(LimitScopeUser, user)
+	}
+}
+
+// Clear forgets the failures of name in scope
+func (l *Limiter) Clear(scope, name string) {
+	l.mu.Lock()
+	delete(l.records, limitKey{scope, name})
+	l.mu.Unlock()
+}
+
+// Failure counts a failed login of name in scope and backs it off or bans
+// it
+func (l *Limiter) Failure(scope, name string) {
+	if !l.Tracking() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	key := limitKey{scope, name}
+	record := l.records[key]
+	if record == nil || l.expired(record, now) {
+		record = &failureRecord{}
+		l.records[key] = record
+	}
+	record.failures++
+	record.last = now
+	defer func() {
+		if len(l.records) > maxLimitRecords {
+			l.prune(now)
+		}
+	}()
+
+	if l.maxFailures > 0 && record.failures >= l.maxFailures {
+		record.failures = 0
+		record.until = now.Add(l.banDuration)
+		logger.Log.WithFields(map[string]interface{}{
+			"scope": scope,
+			scope:   name,
+			"until": record.until.UTC().Format(time.RFC3339),
+		}).Warnf("%s ban", l.name)
+		if l.onBan != nil {
+			l.onBan(scope)
+		}
+		return
+	}
+	if l.backoffBase > 0 {
+		backoff := l.backoffBase
+		for i := 1; i < record.failures && backoff < l.backoffMax; i++ {
+			backoff *= 2
+		}
+		if backoff > l.backoffMax {
+			backoff = l.backoffMax
+		}
+		// Failures during a ban do not shorten it
+		if until := now.Add(backoff); until.After(record.until) {
+			record.until = until
+		}
+	}
+}
+
+// expired reports whether a record is neither blocking nor recent enough to
+// count towards a ban
+func (l *Limiter) expired(record *failureRecord, now time.Time) bool {
+	return !now.Before(record.until) && now.Sub(record.last) >= l.banDuration
+}
+
+// prune drops expired records. The caller holds mu.
+func (l *Limiter) prune(now time.Time) {
+	for key, record := range l.records {
+		if l.expired(record, now) {
+			delete(l.records, key)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+// deviceCodeGrantType is the grant_type of device code token requests
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// maxOIDCResponse limits the size of identity provider responses
+const maxOIDCResponse = 1 << 20
+
+// Results of polling a device code other than success, from the error codes
+// of RFC 8628
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too fast, slow down")
+	ErrAccessDenied         = errors.New("authorization denied")
+	ErrExpiredToken         = errors.New("device code expired")
+)
+
+// DeviceAuthorization is a device code and the user code the user enters on
+// the verification page of the identity provider
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn and Interval are in seconds
+	ExpiresIn int `json:"expires_in"`
+	Interval  int `json:"interval,omitempty"`
+}
+
+// DeviceAuthorizer runs the OAuth 2.0 device authorization grant (RFC 8628)
+// to find out which gateway user is behind a request
+type DeviceAuthorizer interface {
+	// StartDevice asks for a new device code
+	StartDevice(ctx context.Context) (*DeviceAuthorization, error)
+	// PollDevice returns the gateway user who authorized deviceCode, or
+	// ErrAuthorizationPending until someone has
+	PollDevice(ctx context.Context, deviceCode string) (string, error)
+}
+
+// OIDCDeviceFlow is the device flow against an OpenID Connect provider,
+// reading the gateway user from a claim of its userinfo endpoint
+type OIDCDeviceFlow struct {
+	settings     config.OIDCSettings
+	clientSecret string
+	client       *http.Client
+}
+
+// NewOIDCDeviceFlow creates a device flow client for the endpoints of
+// settings
+func NewOIDCDeviceFlow(settings config.OIDCSettings) (*OIDCDeviceFlow, error) {
+	if settings.DeviceAuthorizationEndpoint == "" || settings.TokenEndpoint == "" || settings.UserinfoEndpoint == "" {
+		return nil, errors.New("user_ca.oidc needs device_authorization_endpoint, token_endpoint and userinfo_endpoint")
+	}
+	if settings.ClientID == "" {
+		return nil, errors.New("user_ca.oidc.client_id is required")
+	}
+	f := &OIDCDeviceFlow{settings: settings, client: &http.Client{Timeout: 10 * time.Second}}
+	if settings.ClientSecretFile != "" {
+		secret, err := os.ReadFile(settings.ClientSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OIDC client secret: %w", err)
+		}
+		f.clientSecret = strings.TrimSpace(string(secret))
+	}
+	if f.settings.UsernameClaim == "" {
+		f.settings.UsernameClaim = "preferred_username"
+	}
+	if len(f.settings.Scopes) == 0 {
+		f.settings.Scopes = []string{"openid", "profile"}
+	}
+	return f, nil
+}
+
+// StartDevice asks the provider for a device code
+func (f *OIDCDeviceFlow) StartDevice(ctx context.Context) (*DeviceAuthorization, error) {
+	var device DeviceAuthorization
+	status, err := f.post(ctx, f.settings.DeviceAuthorizationEndpoint, url.Values{
+		"scope": {strings.Join(f.settings.Scopes, " ")},
+	}, &device)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("device authorization request failed with HTTP %d", status)
+	}
+	if device.DeviceCode == "" || device.UserCode == "" || device.VerificationURI == "" {
+		return nil, errors.New("incomplete device authorization response")
+	}
+	return &device, nil
+}
+
+// PollDevice asks the provider for a token of deviceCode, and the gateway
+// user of the token from the userinfo endpoint
+func (f *OIDCDeviceFlow) PollDevice(ctx context.Context, deviceCode string) (string, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	status, err := f.post(ctx, f.settings.TokenEndpoint, url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {deviceCode},
+	}, &token)
+	if err != nil {
+		return "", err
+	}
+	switch token.Error {
+	case "":
+	case "authorization_pending":
+		return "", ErrAuthorizationPending
+	case "slow_down":
+		return "", ErrSlowDown
+	case "access_denied":
+		return "", ErrAccessDenied
+	case "expired_token":
+		return "", ErrExpiredToken
+	default:
+		return "", fmt.Errorf("token request failed: %s", token.Error)
+	}
+	if status != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("token request failed with HTTP %d", status)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.settings.UserinfoEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("userinfo request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("userinfo request failed with HTTP %d", resp.StatusCode)
+	}
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponse)).Decode(&claims); err != nil {
+		return "", fmt.Errorf("invalid userinfo response: %w", err)
+	}
+	username, _ := claims[f.settings.UsernameClaim].(string)
+	if username == "" {
+		return "", fmt.Errorf("userinfo has no %s claim", f.settings.UsernameClaim)
+	}
+	return username, nil
+}
+
+// post sends a form with the client credentials to endpoint and decodes the
+// JSON response into v, returning the HTTP status
+func (f *OIDCDeviceFlow) post(ctx context.Context, endpoint string, form url.Values, v interface{}) (int, error) {
+	form.Set("client_id", f.settings.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if f.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(f.settings.ClientID), url.QueryEscape(f.clientSecret))
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request to %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponse)).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	return resp.StatusCode, nil
+}
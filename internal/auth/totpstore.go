@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+// recoveryCodeCount is the number of recovery codes issued on enrollment
+const recoveryCodeCount = 10
+
+// TOTPStore holds the TOTP secrets and recovery codes of gateway users, for
+// every login that asks for a code. Secrets users enroll themselves are kept
+// in a JSON file the gateway rewrites on every change. The totp_secret of
+// settings.users is used for users who have not enrolled.
+type TOTPStore struct {
+	path string
+
+	mu    sync.Mutex
+	users map[string]*totpUser
+	// now returns the time codes are checked at
+	now func() time.Time
+}
+
+// totpUser is the second factor of one user. Recovery codes are stored as
+// SHA-256 hashes and removed once used.
+type totpUser struct {
+	Secret        string    `json:"secret"`
+	RecoveryCodes []string  `json:"recovery_codes"`
+	LastStep      int64     `json:"last_step"`
+	EnrolledAt    time.Time `json:"enrolled_at"`
+	// FromConfig marks the totp_secret of settings.users, which is replaced
+	// when the configured secret changes
+	FromConfig bool `json:"from_config,omitempty"`
+}
+
+// Enrollment is a new secret with its recovery codes. It replaces the user's
+// current one once confirmed with a code from the authenticator app.
+type Enrollment struct {
+	User          string
+	Secret        string
+	URI           string
+	RecoveryCodes []string
+}
+
+// NewTOTPStore loads the store of bastion_totp.store_file, which need not
+// exist yet, and adds the totp_secret of users who have not enrolled. Without
+// a store file the store is kept in memory and users cannot enroll.
+func NewTOTPStore(cfg *config.Config) (*TOTPStore, error) {
+	path := cfg.Settings.BastionTOTP.StoreFile
+	s := &TOTPStore{path: path, users: make(map[string]*totpUser), now: time.Now}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read TOTP store: %w", err)
+		default:
+			if err := json.Unmarshal(data, &s.users); err != nil {
+				return nil, fmt.Errorf("failed to parse TOTP store %s: %w", path, err)
+			}
+		}
+	}
+	for name, user := range s.users {
+		if _, err := decodeSecret(user.Secret); err != nil {
+			return nil, fmt.Errorf("TOTP store: user %s: invalid secret: %w", name, err)
+		}
+	}
+
+	for name, user := range cfg.Settings.Users {
+		if user.TOTPSecret == "" {
+			continue
+		}
+		if _, err := decodeSecret(user.TOTPSecret); err != nil {
+			return nil, fmt.Errorf("user %s: invalid TOTP secret: %w", name, err)
+		}
+		// A secret the user enrolled takes precedence, and the last used
+		// step of an unchanged configured secret is kept
+		if stored := s.users[name]; stored != nil && (!stored.FromConfig || stored.Secret == user.TOTPSecret) {
+			continue
+		}
+		s.users[name] = &totpUser{Secret: user.TOTPSecret, FromConfig: true}
+	}
+	// Configured secrets removed from settings.users no longer apply
+	for name, user := range s.users {
+		if user.FromConfig && cfg.Settings.Users[name].TOTPSecret == "" {
+			delete(s.users, name)
+		}
+	}
+	return s, nil
+}
+
+// SetClock sets the function returning the time codes are checked at, for
+// tests
+func (s *TOTPStore) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	s.now = now
+	s.mu.Unlock()
+}
+
+// CanEnroll reports whether users can enroll, which needs a store file to
+// keep their secrets
+func (s *TOTPStore) CanEnroll() bool {
+	return s.path != ""
+}
+
+// Enrolled reports whether username has a second factor
+func (s *TOTPStore) Enrolled(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[username] != nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of username
+func (s *TOTPStore) RecoveryCodesLeft(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user := s.users[username]; user != nil {
+		return len(user.RecoveryCodes)
+	}
+	return 0
+}
+
+// Verify checks a TOTP code or a recovery code of username. A TOTP code is
+// only accepted once and a recovery code is used up. recovery reports which
+// kind was accepted.
+func (s *TOTPStore) Verify(username, code string) (recovery bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.users[username]
+	if user == nil {
+		return false, ErrInvalidCredentials
+	}
+
+	if step, ok := matchTOTP(user.Secret, code, s.now()); ok {
+		if step <= user.LastStep {
+			return false, ErrInvalidCredentials
+		}
+		user.LastStep = step
+		return false, s.save()
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, ErrInvalidCredentials
+}
+
+// NewEnrollment generates a secret and recovery codes for username. issuer
+// names the gateway in authenticator apps.
+func (s *TOTPStore) NewEnrollment(username, issuer string) (*Enrollment, error) {
+	if !s.CanEnroll() {
+		return nil, fmt.Errorf("TOTP enrollment is not enabled")
+	}
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := make([]byte, 6)
+		if _, err := rand.Read(code); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(code))
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + username,
+		RawQuery: query.Encode(),
+	}
+	return &Enrollment{User: username, Secret: secret, URI: uri.String(), RecoveryCodes: codes}, nil
+}
+
+// Confirm stores the enrollment if code is valid for its secret
+func (s *TOTPStore) Confirm(e *Enrollment, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	step, ok := matchTOTP(e.Secret, code, now)
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	hashes := make([]string, len(e.RecoveryCodes))
+	for i, code := range e.RecoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+	previous := s.users[e.User]
+	s.users[e.User] = &totpUser{Secret: e.Secret, RecoveryCodes: hashes, LastStep: step, EnrolledAt: now.UTC()}
+	if err := s.save(); err != nil {
+		if previous != nil {
+			s.users[e.User] = previous
+		} else {
+			delete(s.users, e.User)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the store, replacing the file in one step. A store without a
+// file is only kept in memory.
+func (s *TOTPStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".totp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write TOTP store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write TOTP store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write TOTP store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write TOTP store: %w", err)
+	}
+	return nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, dashes and spaces
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
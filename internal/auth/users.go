@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/listen"
+)
+
+// ErrInvalidCredentials is returned for an unknown user or a wrong password
+// or code, without telling which
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// dummyHash is compared against for unknown users so that they take as long
+// to reject as a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+// Users is the gateway user store, read from settings.users. Their second
+// factor is in the TOTP store shared with the bastion.
+type Users struct {
+	users map[string]config.UserSettings
+	// tokens maps the hashes of API tokens to their user
+	tokens  map[[sha256.Size]byte]string
+	sources SourcePolicies
+	totp    *TOTPStore
+	// totpRequired refuses users without a second factor
+	totpRequired bool
+}
+
+// NewUsers creates a user store from the configuration, asking for codes from
+// totp
+func NewUsers(cfg *config.Config, totp *TOTPStore) (*Users, error) {
+	sources, err := NewSourcePolicies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[[sha256.Size]byte]string)
+	for name, user := range cfg.Settings.Users {
+		// Users without a hash only log in with keys, certificates and
+		// API tokens
+		if user.PasswordHash != "" {
+			if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+				return nil, fmt.Errorf("user %s: invalid password hash: %w", name, err)
+			}
+		}
+		for _, h := range user.APITokenHashes {
+			var hash [sha256.Size]byte
+			if len(h) != hex.EncodedLen(sha256.Size) {
+				return nil, fmt.Errorf("user %s: api_token_hashes must be hex SHA-256 hashes", name)
+			}
+			if _, err := hex.Decode(hash[:], []byte(h)); err != nil {
+				return nil, fmt.Errorf("user %s: api_token_hashes must be hex SHA-256 hashes", name)
+			}
+			if other, ok := tokens[hash]; ok && other != name {
+				return nil, fmt.Errorf("user %s: API token is also used by user %s", name, other)
+			}
+			tokens[hash] = name
+		}
+	}
+	return &Users{
+		users:        cfg.Settings.Users,
+		tokens:       tokens,
+		sources:      sources,
+		totp:         totp,
+		totpRequired: cfg.Settings.BastionTOTP.Required,
+	}, nil
+}
+
+// TokenUser returns the user an API token belongs to
+func (u *Users) TokenUser(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	username, ok := u.tokens[sha256.Sum256([]byte(token))]
+	return username, ok
+}
+
+// Len returns the number of users
+func (u *Users) Len() int {
+	return len(u.users)
+}
+
+// Has reports whether username is a gateway user
+func (u *Users) Has(username string) bool {
+	_, ok := u.users[username]
+	return ok
+}
+
+// AllowsSource reports whether username may log in from source, see
+// SourcePolicies
+func (u *Users) AllowsSource(username string, source netip.Addr) bool {
+	return u.sources.Allows(username, source)
+}
+
+// CheckPassword verifies the password of username. Users without a
+// password hash have password logins disabled.
+func (u *Users) CheckPassword(username, password string) error {
+	user, ok := u.users[username]
+	if !ok || user.PasswordHash == "" {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// RequiresTOTP reports whether username has a second factor
+func (u *Users) RequiresTOTP(username string) bool {
+	return u.totp.Enrolled(username)
+}
+
+// NeedsTOTPEnrollment reports whether username must enroll a second factor
+// on the bastion before logging in, under bastion_totp.required
+func (u *Users) NeedsTOTPEnrollment(username string) bool {
+	return u.totpRequired && !u.totp.Enrolled(username)
+}
+
+// CheckTOTP verifies a TOTP code or a recovery code of username, each
+// accepted once. recovery reports which kind was accepted.
+func (u *Users) CheckTOTP(username, code string) (recovery bool, err error) {
+	return u.totp.Verify(username, code)
+}
+
+// UserKeys returns the gateway user each public key of settings.users is
+// bound to, by its wire encoding
+func UserKeys(cfg *config.Config) (map[string]string, error) {
+	keys := make(map[string]string)
+	for name, user := range cfg.Settings.Users {
+		for _, line := range user.AuthorizedKeys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("user %s: invalid authorized key: %w", name, err)
+			}
+			if other, ok := keys[string(key.Marshal())]; ok && other != name {
+				return nil, fmt.Errorf("user %s: key %s is also bound to user %s", name, ssh.FingerprintSHA256(key), other)
+			}
+			keys[string(key.Marshal())] = name
+		}
+	}
+	return keys, nil
+}
+
+// sourcePolicy holds the CIDRs a user may and may not log in from
+type sourcePolicy struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+}
+
+// SourcePolicies holds the sources each gateway user may log in from, from
+// allowed_sources and denied_sources of settings.users
+type SourcePolicies map[string]sourcePolicy
+
+// NewSourcePolicies parses the source policies of settings.users
+func NewSourcePolicies(cfg *config.Config) (SourcePolicies, error) {
+	policies := make(SourcePolicies)
+	for name, user := range cfg.Settings.Users {
+		var policy sourcePolicy
+		var err error
+		if policy.allowed, err = listen.ParseCIDRs(user.AllowedSources); err != nil {
+			return nil, fmt.Errorf("user %s: allowed_sources: %w", name, err)
+		}
+		if policy.denied, err = listen.ParseCIDRs(user.DeniedSources); err != nil {
+			return nil, fmt.Errorf("user %s: denied_sources: %w", name, err)
+		}
+		if len(policy.allowed) > 0 || len(policy.denied) > 0 {
+			policies[name] = policy
+		}
+	}
+	return policies, nil
+}
+
+// Allows reports whether user may log in from source. Any source is allowed
+// without a policy, denied sources take precedence over allowed ones and an
+// unknown source is refused by a policy.
+func (p SourcePolicies) Allows(user string, source netip.Addr) bool {
+	policy, ok := p[user]
+	if !ok {
+		return true
+	}
+	return source.IsValid() && !listen.Contains(policy.denied, source) &&
+		(len(policy.allowed) == 0 || listen.Contains(policy.allowed, source))
+}
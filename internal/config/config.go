@@ -17,14 +17,213 @@ type DeviceConfig struct {
 	GNMIPort    int    `yaml:"gnmi_port"`
 	Description string `yaml:"description"`
 	Location    string `yaml:"location"`
+	Platform    string `yaml:"platform"`
+	// Telnet overrides the prompt patterns used for telnet sessions
+	Telnet TelnetSettings `yaml:"telnet"`
+	// Credentials names the settings.credentials profile used when the
+	// gateway logs in for the user, "default" when empty
+	Credentials string `yaml:"credentials"`
+	// PrivateKeyFile and PassphraseFile override the key of the credential
+	// profile for this device
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PassphraseFile string `yaml:"passphrase_file"`
+}
+
+// TelnetSettings holds regular expressions matched against the end of the
+// text received from a telnet device. Empty patterns use built-in defaults.
+type TelnetSettings struct {
+	LoginPrompt    string `yaml:"login_prompt"`
+	PasswordPrompt string `yaml:"password_prompt"`
+	Prompt         string `yaml:"prompt"`
+	MorePrompt     string `yaml:"more_prompt"`
 }
 
 // Settings represents global gateway settings
 type Settings struct {
-	DomainSuffix   string `yaml:"domain_suffix"`
-	DefaultTimeout int    `yaml:"default_timeout"`
-	MaxSessions    int    `yaml:"max_sessions"`
-	LogLevel       string `yaml:"log_level"`
+	DomainSuffix   string                        `yaml:"domain_suffix"`
+	DefaultTimeout int                           `yaml:"default_timeout"`
+	MaxSessions    int                           `yaml:"max_sessions"`
+	LogLevel       string                        `yaml:"log_level"`
+	SSHPool        SSHPoolSettings               `yaml:"ssh_pool"`
+	NetconfServer  NetconfServerSettings         `yaml:"netconf_server"`
+	Users          map[string]UserSettings       `yaml:"users"`
+	Credentials    map[string]CredentialSettings `yaml:"credentials"`
+	DeviceCA       DeviceCASettings              `yaml:"device_ca"`
+	UserCA         UserCASettings                `yaml:"user_ca"`
+	BastionTOTP    BastionTOTPSettings           `yaml:"bastion_totp"`
+	BastionLimits  BastionLimitSettings          `yaml:"bastion_limits"`
+	ProxyProtocol  ProxyProtocolListeners        `yaml:"proxy_protocol"`
+}
+
+// CredentialSettings are device credentials the gateway logs in with on
+// behalf of users, for example for commands run through the bastion
+type CredentialSettings struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile is read when the credentials are used, for passwords
+	// mounted from a secret. It takes precedence over Password.
+	PasswordFile string `yaml:"password_file"`
+	// PrivateKeyFile is an OpenSSH private key the gateway logs in with,
+	// decrypted with the contents of PassphraseFile when it is encrypted
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PassphraseFile string `yaml:"passphrase_file"`
+	// Certificate logs in with a short-lived certificate from device_ca
+	Certificate bool `yaml:"certificate"`
+	// PasswordFallback still tries the password when the device refuses the
+	// key or certificate. Without a key the password is always used.
+	PasswordFallback bool `yaml:"password_fallback"`
+	// DisableAgentForwarding stops bastion users from logging in to the
+	// devices of the profile with the keys of their forwarded SSH agent
+	DisableAgentForwarding bool `yaml:"disable_agent_forwarding"`
+}
+
+// DeviceCASettings is the certificate authority that signs the certificates
+// the gateway logs in to devices with. Devices trust its public key, e.g.
+// with TrustedUserCAKeys.
+type DeviceCASettings struct {
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PassphraseFile string `yaml:"passphrase_file"`
+	// Validity of issued certificates in seconds, 300 when zero
+	Validity int `yaml:"validity"`
+}
+
+// UserCASettings is the certificate authority that signs the certificates
+// bastion users log in with, issued through the API to gateway users
+type UserCASettings struct {
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PassphraseFile string `yaml:"passphrase_file"`
+	// Validity of issued certificates in seconds, 8 hours when zero
+	Validity int `yaml:"validity"`
+	// Approvers are the gateway users who may approve the certificate
+	// requests of other users
+	Approvers []string `yaml:"approvers"`
+	// OIDC lets users prove who they are with the OAuth device flow
+	OIDC OIDCSettings `yaml:"oidc"`
+}
+
+// OIDCSettings is the identity provider of the OAuth 2.0 device
+// authorization grant (RFC 8628) for user certificates. The username claim
+// of the provider's userinfo must be the gateway user.
+type OIDCSettings struct {
+	// LocalIdP serves a stand-in provider at /oidc/ on the HTTP port, which
+	// signs gateway users in with their password and TOTP code. The gateway
+	// uses it when no endpoints are set.
+	LocalIdP bool `yaml:"local_idp"`
+	// Issuer is the external URL of the local provider, e.g.
+	// https://gateway.example.net/oidc, shown to users as the verification
+	// page. Required with LocalIdP.
+	Issuer string `yaml:"issuer"`
+
+	DeviceAuthorizationEndpoint string `yaml:"device_authorization_endpoint"`
+	TokenEndpoint               string `yaml:"token_endpoint"`
+	UserinfoEndpoint            string `yaml:"userinfo_endpoint"`
+	ClientID                    string `yaml:"client_id"`
+	// ClientSecretFile holds the client secret, for confidential clients
+	ClientSecretFile string `yaml:"client_secret_file"`
+	// Scopes requested, "openid profile" when empty
+	Scopes []string `yaml:"scopes"`
+	// UsernameClaim is the userinfo claim holding the gateway user,
+	// preferred_username when empty
+	UsernameClaim string `yaml:"username_claim"`
+}
+
+// BastionTOTPSettings asks gateway users who have a TOTP secret for a code
+// after their key, certificate or password
+type BastionTOTPSettings struct {
+	// StoreFile is the JSON file of enrolled secrets, written by the gateway.
+	// Without it users cannot enroll and only the totp_secret of
+	// settings.users is used.
+	StoreFile string `yaml:"store_file"`
+	// Required makes users without a secret enroll before anything else, and
+	// refuses bastion keys that are not bound to a gateway user
+	Required bool `yaml:"required"`
+	// Issuer names the gateway in authenticator apps, "Gateway" when empty
+	Issuer string `yaml:"issuer"`
+}
+
+// BastionLimitSettings throttles connections and failed logins to the SSH
+// bastion. Each limit is off when zero. Durations are in seconds.
+type BastionLimitSettings struct {
+	// MaxFailures failed logins from a source address or for a user ban it
+	// for BanDuration
+	MaxFailures int `yaml:"max_failures"`
+	// BanDuration is also how long failures are remembered, 900 when zero
+	BanDuration int `yaml:"ban_duration"`
+	// BackoffBase is how long a source or user is refused after a failed
+	// login, doubled with each further failure up to BackoffMax (60 when
+	// zero)
+	BackoffBase int `yaml:"backoff_base"`
+	BackoffMax  int `yaml:"backoff_max"`
+	// ConnectionRate is the number of new connections accepted per second
+	// from all sources, allowing bursts of ConnectionBurst
+	ConnectionRate  float64 `yaml:"connection_rate"`
+	ConnectionBurst int     `yaml:"connection_burst"`
+	// Exempt are the CIDRs of sources the limits do not apply to
+	Exempt []string `yaml:"exempt"`
+}
+
+// ProxyProtocolListeners enables the PROXY protocol per listener
+type ProxyProtocolListeners struct {
+	SSH  ProxyProtocolSettings `yaml:"ssh"`
+	GRPC ProxyProtocolSettings `yaml:"grpc"`
+	GNMI ProxyProtocolSettings `yaml:"gnmi"`
+	// Mux is the single port of --mux-port, in place of the listeners it
+	// replaces
+	Mux ProxyProtocolSettings `yaml:"mux"`
+}
+
+// ProxyProtocolSettings makes a listener take client addresses from the
+// PROXY protocol v1 or v2 headers of the load balancers in front of it
+type ProxyProtocolSettings struct {
+	Enabled bool `yaml:"enabled"`
+	// TrustedProxies are the CIDRs of the load balancers whose headers are
+	// used. Connections from other sources that send a header are refused.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// UserSettings is a gateway user for password logins, such as the telnet
+// listener, and the person behind bastion keys and certificates. Device
+// credentials are still entered per device.
+type UserSettings struct {
+	// PasswordHash is a bcrypt hash, e.g. from htpasswd -nbBC 10 "" <password>.
+	// Users without one cannot log in with a password
+	PasswordHash string `yaml:"password_hash"`
+	// TOTPSecret is a base32 RFC 6238 secret, a code is asked when it is set.
+	// A secret the user enrolls on the bastion replaces it.
+	TOTPSecret string `yaml:"totp_secret"`
+	// AuthorizedKeys are the user's public keys in authorized_keys format.
+	// They log in to the bastion as this user whatever the login name, so
+	// that the user's second factor and source policy apply.
+	AuthorizedKeys []string `yaml:"authorized_keys"`
+	// Principals are the bastion login names the user's certificates are
+	// valid for, the user name when empty
+	Principals []string `yaml:"principals"`
+	// AllowedSources and DeniedSources are CIDRs the user's keys and
+	// certificates may and may not log in to the bastion from, whatever the
+	// login name, and the user's telnet logins. Any source is allowed when AllowedSources is empty,
+	// DeniedSources takes precedence.
+	AllowedSources []string `yaml:"allowed_sources"`
+	DeniedSources  []string `yaml:"denied_sources"`
+	// APITokenHashes are hex SHA-256 hashes of tokens that authenticate the
+	// user to the gRPC and HTTP APIs as a bearer token. Their device logins
+	// then use the device keys, like bastion logins.
+	APITokenHashes []string `yaml:"api_token_hashes"`
+}
+
+// NetconfServerSettings configures the NETCONF over SSH listener
+type NetconfServerSettings struct {
+	// Targets maps a login without an "@device" part to a device FQDN
+	Targets map[string]string `yaml:"targets"`
+}
+
+// SSHPoolSettings configures reuse of SSH connections to devices for
+// command execution. Durations are in seconds.
+type SSHPoolSettings struct {
+	Enabled            bool `yaml:"enabled"`
+	MaxConnsPerDevice  int  `yaml:"max_conns_per_device"`
+	MaxSessionsPerConn int  `yaml:"max_sessions_per_conn"`
+	IdleTimeout        int  `yaml:"idle_timeout"`
+	KeepaliveInterval  int  `yaml:"keepalive_interval"`
 }
 
 // Config represents the complete configuration
@@ -65,3 +264,44 @@ func (c *Config) GetDeviceByFQDN(fqdn string) (*DeviceConfig, string, error) {
 
 	return &device, deviceName, nil
 }
+
+// DeviceCredentials returns the username and password of the credential
+// profile of a device
+func (c *Config) DeviceCredentials(deviceName string) (string, string, error) {
+	device, exists := c.Devices[deviceName]
+	if !exists {
+		return "", "", fmt.Errorf("device not found: %s", deviceName)
+	}
+	name := device.Credentials
+	if name == "" {
+		name = "default"
+	}
+	profile, exists := c.Settings.Credentials[name]
+	if !exists {
+		return "", "", fmt.Errorf("no credentials configured for device %s", deviceName)
+	}
+
+	password := profile.Password
+	if profile.PasswordFile != "" {
+		data, err := os.ReadFile(profile.PasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read password of credentials %s: %w", name, err)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	return profile.Username, password, nil
+}
+
+// AgentForwarding reports whether bastion users may log in to the device
+// with their forwarded SSH agent, which its credential profile can disable
+func (c *Config) AgentForwarding(deviceName string) bool {
+	device, exists := c.Devices[deviceName]
+	if !exists {
+		return false
+	}
+	name := device.Credentials
+	if name == "" {
+		name = "default"
+	}
+	return !c.Settings.Credentials[name].DisableAgentForwarding
+}
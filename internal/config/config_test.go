@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -23,6 +24,10 @@ settings:
   default_timeout: 30
   max_sessions: 50
   log_level: "debug"
+  ssh_pool:
+    enabled: true
+    max_conns_per_device: 3
+    idle_timeout: 120
 `
 
 	tmpFile, err := os.CreateTemp("", "devices-*.yaml")
@@ -71,6 +76,11 @@ settings:
 	if device.GNMIPort != 57400 {
 		t.Errorf("Expected gNMI port 57400, got %d", device.GNMIPort)
 	}
+
+	pool := cfg.Settings.SSHPool
+	if !pool.Enabled || pool.MaxConnsPerDevice != 3 || pool.IdleTimeout != 120 {
+		t.Errorf("Unexpected SSH pool settings: %+v", pool)
+	}
 }
 
 func TestGetDeviceByFQDN(t *testing.T) {
@@ -154,3 +164,66 @@ func TestGetDeviceByFQDN(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceCredentials(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("from-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Devices: map[string]DeviceConfig{
+			"router1": {Hostname: "10.0.1.10"},
+			"router2": {Hostname: "10.0.1.11", Credentials: "core"},
+			"router3": {Hostname: "10.0.1.12", Credentials: "missing"},
+		},
+		Settings: Settings{
+			Credentials: map[string]CredentialSettings{
+				"default": {Username: "admin", Password: "plain"},
+				"core":    {Username: "netops", Password: "ignored", PasswordFile: passwordFile},
+			},
+		},
+	}
+
+	tests := []struct {
+		device             string
+		username, password string
+		wantErr            bool
+	}{
+		{"router1", "admin", "plain", false},
+		{"router2", "netops", "from-secret", false},
+		{"router3", "", "", true},
+		{"router9", "", "", true},
+	}
+	for _, tt := range tests {
+		username, password, err := cfg.DeviceCredentials(tt.device)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DeviceCredentials(%s) error = %v, wantErr %v", tt.device, err, tt.wantErr)
+			continue
+		}
+		if username != tt.username || password != tt.password {
+			t.Errorf("DeviceCredentials(%s) = %s/%s, want %s/%s", tt.device, username, password, tt.username, tt.password)
+		}
+	}
+}
+
+func TestAgentForwarding(t *testing.T) {
+	cfg := &Config{
+		Devices: map[string]DeviceConfig{
+			"router1": {Hostname: "10.0.1.10"},
+			"router2": {Hostname: "10.0.1.11", Credentials: "core"},
+			"router3": {Hostname: "10.0.1.12", Credentials: "lab"},
+		},
+		Settings: Settings{
+			Credentials: map[string]CredentialSettings{
+				"core": {Username: "netops", DisableAgentForwarding: true},
+			},
+		},
+	}
+
+	for device, want := range map[string]bool{"router1": true, "router2": false, "router3": true, "router9": false} {
+		if got := cfg.AgentForwarding(device); got != want {
+			t.Errorf("AgentForwarding(%s) = %v, want %v", device, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package gnmi
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+)
+
+// Backend connection cache defaults
+const (
+	backendIdleTimeout   = 5 * time.Minute
+	backendSweepInterval = 30 * time.Second
+)
+
+// connKey identifies a backend connection by device address and credentials,
+// which are sent on every RPC of the connection
+type connKey struct {
+	target     string
+	credential [sha256.Size]byte
+}
+
+// cachedConn is a backend connection and the RPCs currently using it
+type cachedConn struct {
+	conn     *grpc.ClientConn
+	refs     int
+	lastUsed time.Time
+}
+
+// connCache shares backend connections between Capabilities, Get, Set and
+// Subscribe so repeated polling does not open a new TLS session every time.
+// Unused connections are closed after idleTimeout, or as soon as they are in
+// TRANSIENT_FAILURE so the next RPC dials again instead of waiting on backoff.
+type connCache struct {
+	idleTimeout time.Duration
+	entries     map[connKey]*cachedConn
+	stop        chan struct{}
+	closed      bool
+	mu          sync.Mutex
+}
+
+func newConnCache(idleTimeout, sweepInterval time.Duration) *connCache {
+	c := &connCache{
+		idleTimeout: idleTimeout,
+		entries:     make(map[connKey]*cachedConn),
+		stop:        make(chan struct{}),
+	}
+	go c.sweep(sweepInterval)
+	return c
+}
+
+// get returns the cached connection for target and credentials, creating it
+// with dial if needed. release must be called once the RPC is done.
+func (c *connCache) get(target, username, password string, dial func() (*grpc.ClientConn, error)) (*grpc.ClientConn, func(), error) {
+	key := connKey{target: target, credential: sha256.Sum256([]byte(username + "\x00" + password))}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.closed {
+		conn, err := dial()
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.closed {
+			// Not cached, closed with the last RPC
+			return conn, func() { conn.Close() }, nil
+		}
+		entry = &cachedConn{conn: conn}
+		c.entries[key] = entry
+		metrics.GNMIBackendConnections.Inc()
+	}
+
+	entry.refs++
+	entry.lastUsed = time.Now()
+
+	var once sync.Once
+	return entry.conn, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			entry.refs--
+			entry.lastUsed = time.Now()
+			c.mu.Unlock()
+		})
+	}, nil
+}
+
+// sweep closes idle and failed connections until close
+func (c *connCache) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.evict()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// evict closes unused connections that are idle or failing
+func (c *connCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.refs > 0 {
+			continue
+		}
+		state := entry.conn.GetState()
+		idle := time.Since(entry.lastUsed) >= c.idleTimeout
+		if !idle && state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			continue
+		}
+
+		logger.Log.WithFields(map[string]interface{}{
+			"target": key.target,
+			"state":  state.String(),
+		}).Debug("Closing cached gNMI backend connection")
+		c.removeLocked(key, entry)
+	}
+}
+
+// close closes every cached connection. Connections dialed afterwards are
+// not cached.
+func (c *connCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.stop)
+	for key, entry := range c.entries {
+		c.removeLocked(key, entry)
+	}
+}
+
+// removeLocked closes entry and drops it from the cache, the caller holds c.mu
+func (c *connCache) removeLocked(key connKey, entry *cachedConn) {
+	delete(c.entries, key)
+	_ = entry.conn.Close()
+	metrics.GNMIBackendConnections.Dec()
+}
@@ -6,33 +6,56 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	gnmipb "github.com/openconfig/gnmi/proto/gnmi"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/safabayar/gateway/internal/config"
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/tracing"
 )
 
 // Server implements gNMI proxy server
 type Server struct {
 	gnmipb.UnimplementedGNMIServer
-	config *config.Config
+	config       *config.Config
+	conns        *connCache
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
 // NewServer creates a new gNMI proxy server
 func NewServer(cfg *config.Config) *Server {
 	return &Server{
-		config: cfg,
+		config:   cfg,
+		conns:    newConnCache(backendIdleTimeout, backendSweepInterval),
+		shutdown: make(chan struct{}),
 	}
 }
 
+// Shutdown ends every active Subscribe stream with codes.Unavailable so
+// clients reconnect elsewhere, allowing the gRPC server to stop gracefully
+func (s *Server) Shutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// Close closes the cached backend connections, once no RPC is running
+func (s *Server) Close() {
+	s.conns.close()
+}
+
 // getTargetFromContext extracts target device from gRPC metadata or target field
 func (s *Server) getTargetFromContext(ctx context.Context, prefix *gnmipb.Path) (string, string, string, error) {
 	// Try to get target from metadata headers
@@ -70,9 +93,12 @@ func (s *Server) parseTarget(target string) (string, string, string, error) {
 	return fqdn, username, password, nil
 }
 
-// getBackendClient creates a gNMI client connection to the backend device
-func (s *Server) getBackendClient(_ context.Context, fqdn, username, password string) (gnmipb.GNMIClient, *grpc.ClientConn, error) {
+// getBackendClient returns a gNMI client for the backend device on a cached
+// connection. release must be called once the RPC is done.
+func (s *Server) getBackendClient(ctx context.Context, fqdn, username, password string) (gnmipb.GNMIClient, func(), error) {
+	_, span := tracing.Start(ctx, "inventory.lookup", attribute.String("fqdn", fqdn))
 	device, deviceName, err := s.config.GetDeviceByFQDN(fqdn)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, nil, fmt.Errorf("device not found: %w", err)
 	}
@@ -84,10 +110,40 @@ func (s *Server) getBackendClient(_ context.Context, fqdn, username, password st
 	}
 
 	target := fmt.Sprintf("%s:%d", device.Hostname, gnmiPort)
-	logger.Log.WithFields(map[string]interface{}{
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"device": deviceName,
 		"target": target,
-	}).Debug("Connecting to backend gNMI server")
+	}).Debug("Using backend gNMI connection")
+
+	conn, release, err := s.conns.get(target, username, password, func() (*grpc.ClientConn, error) {
+		return dialBackend(target, username, password)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return gnmipb.NewGNMIClient(conn), release, nil
+}
+
+// backendConnectParams makes reconnects back off up to 30 seconds
+var backendConnectParams = grpc.ConnectParams{
+	Backoff: backoff.Config{
+		BaseDelay:  time.Second,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   30 * time.Second,
+	},
+	MinConnectTimeout: 10 * time.Second,
+}
+
+// backendKeepalive detects dead backend connections while RPCs are running
+var backendKeepalive = keepalive.ClientParameters{
+	Time:    time.Minute,
+	Timeout: 20 * time.Second,
+}
+
+// dialBackend creates a gNMI client connection to the backend device
+func dialBackend(target, username, password string) (*grpc.ClientConn, error) {
+	logger.Log.WithField("target", target).Debug("Connecting to backend gNMI server")
 
 	// Create gRPC connection with TLS (skip verify for lab)
 	tlsConfig := &tls.Config{
@@ -96,6 +152,9 @@ func (s *Server) getBackendClient(_ context.Context, fqdn, username, password st
 
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithStatsHandler(tracing.ClientHandler()),
+		grpc.WithConnectParams(backendConnectParams),
+		grpc.WithKeepaliveParams(backendKeepalive),
 		grpc.WithPerRPCCredentials(&basicAuth{
 			username: username,
 			password: password,
@@ -107,6 +166,9 @@ func (s *Server) getBackendClient(_ context.Context, fqdn, username, password st
 		// Try without TLS
 		opts = []grpc.DialOption{
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithStatsHandler(tracing.ClientHandler()),
+			grpc.WithConnectParams(backendConnectParams),
+			grpc.WithKeepaliveParams(backendKeepalive),
 			grpc.WithPerRPCCredentials(&basicAuth{
 				username: username,
 				password: password,
@@ -115,12 +177,11 @@ func (s *Server) getBackendClient(_ context.Context, fqdn, username, password st
 		}
 		conn, err = grpc.NewClient(target, opts...)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to connect to %s: %w", target, err)
+			return nil, fmt.Errorf("failed to connect to %s: %w", target, err)
 		}
 	}
 
-	client := gnmipb.NewGNMIClient(conn)
-	return client, conn, nil
+	return conn, nil
 }
 
 // basicAuth implements credentials.PerRPCCredentials
@@ -142,19 +203,22 @@ func (b *basicAuth) RequireTransportSecurity() bool {
 }
 
 // Capabilities returns the gNMI capabilities of the target
-func (s *Server) Capabilities(ctx context.Context, req *gnmipb.CapabilityRequest) (*gnmipb.CapabilityResponse, error) {
+func (s *Server) Capabilities(ctx context.Context, req *gnmipb.CapabilityRequest) (resp *gnmipb.CapabilityResponse, err error) {
+	var fqdn string
+	defer func() { observeRPC("Capabilities", fqdn, err) }()
+
 	fqdn, username, password, err := s.getTargetFromContext(ctx, nil)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	logger.Log.WithField("target", fqdn).Info("gNMI Capabilities request")
+	logger.Log.WithContext(ctx).WithField("target", fqdn).Info("gNMI Capabilities request")
 
-	client, conn, err := s.getBackendClient(ctx, fqdn, username, password)
+	client, release, err := s.getBackendClient(ctx, fqdn, username, password)
 	if err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
-	defer conn.Close()
+	defer release()
 
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -163,63 +227,76 @@ func (s *Server) Capabilities(ctx context.Context, req *gnmipb.CapabilityRequest
 }
 
 // Get retrieves data from the target
-func (s *Server) Get(ctx context.Context, req *gnmipb.GetRequest) (*gnmipb.GetResponse, error) {
+func (s *Server) Get(ctx context.Context, req *gnmipb.GetRequest) (resp *gnmipb.GetResponse, err error) {
+	var fqdn string
+	defer func() { observeRPC("Get", fqdn, err) }()
+
 	fqdn, username, password, err := s.getTargetFromContext(ctx, req.Prefix)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	logger.Log.WithFields(map[string]interface{}{
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"target": fqdn,
 		"paths":  len(req.Path),
 	}).Info("gNMI Get request")
 
-	client, conn, err := s.getBackendClient(ctx, fqdn, username, password)
+	client, release, err := s.getBackendClient(ctx, fqdn, username, password)
 	if err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
-	defer conn.Close()
+	defer release()
 
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	return client.Get(ctx, req)
+	resp, err = client.Get(ctx, req)
+	countBytes(req, resp)
+	return resp, err
 }
 
 // Set modifies data on the target
-func (s *Server) Set(ctx context.Context, req *gnmipb.SetRequest) (*gnmipb.SetResponse, error) {
+func (s *Server) Set(ctx context.Context, req *gnmipb.SetRequest) (resp *gnmipb.SetResponse, err error) {
+	var fqdn string
+	defer func() { observeRPC("Set", fqdn, err) }()
+
 	fqdn, username, password, err := s.getTargetFromContext(ctx, req.Prefix)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	logger.Log.WithFields(map[string]interface{}{
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"target":  fqdn,
 		"updates": len(req.Update),
 		"deletes": len(req.Delete),
 	}).Info("gNMI Set request")
 
-	client, conn, err := s.getBackendClient(ctx, fqdn, username, password)
+	client, release, err := s.getBackendClient(ctx, fqdn, username, password)
 	if err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
-	defer conn.Close()
+	defer release()
 
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	return client.Set(ctx, req)
+	resp, err = client.Set(ctx, req)
+	countBytes(req, resp)
+	return resp, err
 }
 
 // Subscribe creates a subscription stream to the target
-func (s *Server) Subscribe(stream gnmipb.GNMI_SubscribeServer) error {
+func (s *Server) Subscribe(stream gnmipb.GNMI_SubscribeServer) (err error) {
+	var fqdn string
+	defer func() { observeRPC("Subscribe", fqdn, err) }()
+
 	// Receive first message to get target info
 	req, err := stream.Recv()
 	if err != nil {
 		return status.Error(codes.InvalidArgument, "failed to receive subscription request")
 	}
 
-	var fqdn, username, password string
+	var username, password string
 	if sub := req.GetSubscribe(); sub != nil && sub.Prefix != nil {
 		fqdn, username, password, err = s.getTargetFromContext(stream.Context(), sub.Prefix)
 	} else {
@@ -229,13 +306,13 @@ func (s *Server) Subscribe(stream gnmipb.GNMI_SubscribeServer) error {
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	logger.Log.WithField("target", fqdn).Info("gNMI Subscribe request")
+	logger.Log.WithContext(stream.Context()).WithField("target", fqdn).Info("gNMI Subscribe request")
 
-	client, conn, err := s.getBackendClient(stream.Context(), fqdn, username, password)
+	client, release, err := s.getBackendClient(stream.Context(), fqdn, username, password)
 	if err != nil {
 		return status.Error(codes.Unavailable, err.Error())
 	}
-	defer conn.Close()
+	defer release()
 
 	// Create subscription to backend
 	backendStream, err := client.Subscribe(stream.Context())
@@ -247,6 +324,15 @@ func (s *Server) Subscribe(stream gnmipb.GNMI_SubscribeServer) error {
 	if err := backendStream.Send(req); err != nil {
 		return status.Error(codes.Internal, fmt.Sprintf("failed to send to backend: %v", err))
 	}
+	countBytes(req, nil)
+
+	metrics.ActiveSessions.WithLabelValues("gnmi").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("gnmi").Dec()
+
+	started := time.Now()
+	defer func() {
+		metrics.GNMISubscribeDuration.WithLabelValues(deviceLabel(fqdn)).Observe(time.Since(started).Seconds())
+	}()
 
 	// Bidirectional proxy
 	errChan := make(chan error, 2)
@@ -267,6 +353,7 @@ func (s *Server) Subscribe(stream gnmipb.GNMI_SubscribeServer) error {
 				errChan <- err
 				return
 			}
+			countBytes(req, nil)
 		}
 	}()
 
@@ -286,8 +373,35 @@ func (s *Server) Subscribe(stream gnmipb.GNMI_SubscribeServer) error {
 				errChan <- err
 				return
 			}
+			countBytes(nil, resp)
 		}
 	}()
 
-	return <-errChan
+	select {
+	case err := <-errChan:
+		return err
+	case <-s.shutdown:
+		logger.Log.WithContext(stream.Context()).WithField("target", fqdn).Info("Closing gNMI subscription for shutdown")
+		return status.Error(codes.Unavailable, "gateway is shutting down")
+	}
+}
+
+// deviceLabel returns the inventory-bounded device label for a target FQDN
+func deviceLabel(fqdn string) string {
+	return metrics.Device(strings.SplitN(fqdn, ".", 2)[0])
+}
+
+// observeRPC counts a gNMI RPC by method, device and status code
+func observeRPC(rpc, fqdn string, err error) {
+	metrics.GNMIRequests.WithLabelValues(rpc, deviceLabel(fqdn), status.Code(err).String()).Inc()
+}
+
+// countBytes adds the encoded size of relayed messages to the proxied bytes
+func countBytes(toDevice, fromDevice proto.Message) {
+	if toDevice != nil {
+		metrics.BytesProxied.WithLabelValues("gnmi", metrics.DirectionToDevice).Add(float64(proto.Size(toDevice)))
+	}
+	if fromDevice != nil {
+		metrics.BytesProxied.WithLabelValues("gnmi", metrics.DirectionFromDevice).Add(float64(proto.Size(fromDevice)))
+	}
 }
@@ -2,14 +2,26 @@ package gnmi
 
 import (
 	"context"
+	"os"
 	"testing"
+	"time"
 
 	gnmipb "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 
 	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
 )
 
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/gnmi_test.log", "debug")
+	os.Exit(m.Run())
+}
+
 func TestNewServer(t *testing.T) {
 	cfg := &config.Config{
 		Devices: map[string]config.DeviceConfig{
@@ -264,3 +276,72 @@ func TestSet_NoTarget(t *testing.T) {
 		t.Error("Expected error when no target specified")
 	}
 }
+
+func TestConnCache(t *testing.T) {
+	cache := newConnCache(time.Hour, time.Hour)
+	defer cache.close()
+
+	dials := 0
+	dial := func() (*grpc.ClientConn, error) {
+		dials++
+		return grpc.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	first, releaseFirst, err := cache.get("10.0.0.1:57400", "admin", "secret", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := cache.get("10.0.0.1:57400", "admin", "secret", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || dials != 1 {
+		t.Errorf("Expected one shared connection, got %d dials", dials)
+	}
+
+	other, releaseOther, err := cache.get("10.0.0.1:57400", "admin", "other", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first || dials != 2 {
+		t.Errorf("Expected separate connection for other credentials, got %d dials", dials)
+	}
+
+	releaseFirst()
+	releaseSecond()
+	releaseOther()
+	if len(cache.entries) != 2 {
+		t.Errorf("Expected released connections to stay cached, got %d", len(cache.entries))
+	}
+}
+
+func TestConnCache_EvictsIdle(t *testing.T) {
+	cache := newConnCache(0, time.Hour)
+	defer cache.close()
+
+	dial := func() (*grpc.ClientConn, error) {
+		return grpc.NewClient("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	inUse, _, err := cache.get("10.0.0.1:57400", "admin", "secret", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, release, err := cache.get("10.0.0.2:57400", "admin", "secret", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	cache.evict()
+
+	if len(cache.entries) != 1 {
+		t.Fatalf("Expected only the connection in use to remain, got %d", len(cache.entries))
+	}
+	if idle.GetState() != connectivity.Shutdown {
+		t.Error("Idle connection was not closed")
+	}
+	if inUse.GetState() == connectivity.Shutdown {
+		t.Error("Connection in use was closed")
+	}
+}
@@ -0,0 +1,469 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	pb "github.com/safabayar/gateway/proto"
+)
+
+const (
+	// CertificateSignatureNamespace is the namespace of signatures of
+	// certificate request challenges, ssh-keygen -Y sign -n
+	CertificateSignatureNamespace = "gateway-certificate"
+	// certificateRequestLifetime is how long a started request can be
+	// completed
+	certificateRequestLifetime = 10 * time.Minute
+	// certificatePollInterval is how often clients poll pending requests
+	certificatePollInterval = 5 * time.Second
+	// maxCertificateRequests limits the requests waiting to be completed
+	maxCertificateRequests = 1000
+)
+
+// certificateRequest is a certificate started with BeginUserCertificate,
+// issued by IssueUserCertificate once the user is verified
+type certificateRequest struct {
+	username string
+	key      ssh.PublicKey
+	method   pb.CertificateAuthMethod
+	expires  time.Time
+	// challenge is signed for the key method
+	challenge string
+	// deviceCode is polled for the OIDC method
+	deviceCode string
+	// approver decided an approval request
+	approver string
+	approved bool
+	denied   bool
+}
+
+// certificateRequests holds the started certificate requests by ID
+type certificateRequests struct {
+	mu       sync.Mutex
+	requests map[string]*certificateRequest
+}
+
+// add stores request under a new ID
+func (c *certificateRequests) add(request *certificateRequest, now time.Time) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.requests == nil {
+		c.requests = make(map[string]*certificateRequest)
+	}
+	for id, r := range c.requests {
+		if !now.Before(r.expires) {
+			delete(c.requests, id)
+		}
+	}
+	if len(c.requests) >= maxCertificateRequests {
+		return "", errors.New("too many pending certificate requests, try again later")
+	}
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	c.requests[id] = request
+	return id, nil
+}
+
+// get returns a copy of the request of id, unless it expired
+func (c *certificateRequests) get(id string, now time.Time) (certificateRequest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.requests[id]
+	if !ok {
+		return certificateRequest{}, false
+	}
+	if !now.Before(r.expires) {
+		delete(c.requests, id)
+		return certificateRequest{}, false
+	}
+	return *r, true
+}
+
+// decide records the decision of an approver on a pending approval request
+func (c *certificateRequests) decide(id, approver string, approve bool, now time.Time) (certificateRequest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.requests[id]
+	if !ok || !now.Before(r.expires) || r.method != pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL {
+		return certificateRequest{}, status.Error(codes.NotFound, "unknown or expired certificate request")
+	}
+	if r.approved || r.denied {
+		return certificateRequest{}, status.Errorf(codes.FailedPrecondition, "certificate request already decided by %s", r.approver)
+	}
+	r.approver = approver
+	r.approved = approve
+	r.denied = !approve
+	return *r, nil
+}
+
+// remove forgets the request of id
+func (c *certificateRequests) remove(id string) {
+	c.mu.Lock()
+	delete(c.requests, id)
+	c.mu.Unlock()
+}
+
+// BeginUserCertificate starts a certificate request for a gateway user who
+// proves who they are with a key, the OIDC device flow or an approval. It
+// does not tell whether the user exists, IssueUserCertificate checks that
+// once the user is verified.
+func (s *Server) BeginUserCertificate(ctx context.Context, req *pb.BeginUserCertificateRequest) (*pb.BeginUserCertificateResponse, error) {
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+		"username": req.Username,
+		"method":   req.Method.String(),
+	}).Info("Received user certificate request")
+
+	if s.userCA == nil {
+		return nil, status.Error(codes.FailedPrecondition, "user certificates are not configured")
+	}
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	key, err := parseCertificateKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.limits.Check(peerIP(ctx), ""); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	now := s.limits.Now()
+	request := &certificateRequest{
+		username: req.Username,
+		key:      key,
+		method:   req.Method,
+		expires:  now.Add(certificateRequestLifetime),
+	}
+	resp := &pb.BeginUserCertificateResponse{Method: req.Method}
+	switch req.Method {
+	case pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_KEY:
+		if request.challenge, err = randomID(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Challenge = request.challenge
+		resp.SignatureNamespace = CertificateSignatureNamespace
+	case pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_OIDC:
+		if s.authorizer == nil {
+			return nil, status.Error(codes.FailedPrecondition, "OIDC is not configured, set user_ca.oidc")
+		}
+		device, err := s.authorizer.StartDevice(ctx)
+		if err != nil {
+			logger.Log.WithContext(ctx).WithError(err).Error("Failed to start device authorization")
+			return nil, status.Error(codes.Unavailable, "identity provider unavailable")
+		}
+		request.deviceCode = device.DeviceCode
+		if device.ExpiresIn > 0 {
+			if expires := now.Add(time.Duration(device.ExpiresIn) * time.Second); expires.Before(request.expires) {
+				request.expires = expires
+			}
+		}
+		resp.VerificationUri = device.VerificationURI
+		resp.VerificationUriComplete = device.VerificationURIComplete
+		resp.UserCode = device.UserCode
+		resp.Interval = int32(device.Interval)
+	case pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL:
+		if len(s.approvers) == 0 {
+			return nil, status.Error(codes.FailedPrecondition, "approvals are not configured, set user_ca.approvers")
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "method must be key, oidc or approval, or send a password to IssueUserCertificate")
+	}
+	if resp.Interval <= 0 {
+		resp.Interval = int32(certificatePollInterval / time.Second)
+	}
+
+	if resp.RequestId, err = s.certRequests.add(request, now); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	resp.ExpiresAt = request.expires.Unix()
+	return resp, nil
+}
+
+// ApproveUserCertificate approves or denies a request of the approval method.
+// Approvers sign in with their password and TOTP code and cannot approve
+// their own requests.
+func (s *Server) ApproveUserCertificate(ctx context.Context, req *pb.ApproveUserCertificateRequest) (*pb.ApproveUserCertificateResponse, error) {
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+		"request_id": req.RequestId,
+		"approver":   req.Approver,
+	}).Info("Received user certificate approval")
+
+	if s.userCA == nil {
+		return nil, status.Error(codes.FailedPrecondition, "user certificates are not configured")
+	}
+	if req.RequestId == "" || req.Approver == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "request_id, approver and password are required")
+	}
+	if _, err := s.checkCredentials(ctx, "approval", req.Approver, req.Password, req.TotpCode); err != nil {
+		return nil, err
+	}
+	if !s.approvers[req.Approver] {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not an approver", req.Approver)
+	}
+	// Looked up before deciding so approvers cannot decide their own
+	request, ok := s.certRequests.get(req.RequestId, s.limits.Now())
+	if !ok || request.method != pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL {
+		return nil, status.Error(codes.NotFound, "unknown or expired certificate request")
+	}
+	if request.username == req.Approver {
+		return nil, status.Error(codes.PermissionDenied, "approvers cannot approve their own certificate requests")
+	}
+	request, err := s.certRequests.decide(req.RequestId, req.Approver, !req.Deny, s.limits.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := ssh.FingerprintSHA256(request.key)
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+		"request_id":  req.RequestId,
+		"username":    request.username,
+		"fingerprint": fingerprint,
+		"approver":    req.Approver,
+		"approved":    request.approved,
+	}).Info("Decided user certificate request")
+	return &pb.ApproveUserCertificateResponse{
+		RequestId:   req.RequestId,
+		Username:    request.username,
+		Fingerprint: fingerprint,
+		Approved:    request.approved,
+	}, nil
+}
+
+// IssueUserCertificate signs the public key of a gateway user for bastion
+// logins, after checking the user's password and TOTP code, or the request
+// of BeginUserCertificate the user was verified for
+func (s *Server) IssueUserCertificate(ctx context.Context, req *pb.UserCertificateRequest) (*pb.UserCertificateResponse, error) {
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+		"username":   req.Username,
+		"request_id": req.RequestId,
+	}).Info("Received user certificate request")
+
+	if s.userCA == nil {
+		return nil, status.Error(codes.FailedPrecondition, "user certificates are not configured")
+	}
+	if req.RequestId != "" {
+		return s.issueRequested(ctx, req)
+	}
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	if req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+	key, err := parseCertificateKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	reason, err := s.checkCredentials(ctx, "ca", req.Username, req.Password, req.TotpCode)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, req.Username, key, reason)
+}
+
+// issueRequested completes a request of BeginUserCertificate
+func (s *Server) issueRequested(ctx context.Context, req *pb.UserCertificateRequest) (*pb.UserCertificateResponse, error) {
+	source := peerIP(ctx)
+	request, ok := s.certRequests.get(req.RequestId, s.limits.Now())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown or expired certificate request")
+	}
+	if req.Username != "" && req.Username != request.username {
+		return nil, status.Error(codes.InvalidArgument, "username does not match the certificate request")
+	}
+	if req.PublicKey != "" {
+		key, err := parseCertificateKey(req.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(key.Marshal(), request.key.Marshal()) {
+			return nil, status.Error(codes.InvalidArgument, "public_key does not match the certificate request")
+		}
+	}
+	if err := s.limits.Check(source, request.username); err != nil {
+		metrics.AuthAttempts.WithLabelValues("ca", "failure", "blocked").Inc()
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	// fail counts a failed verification and drops the request
+	fail := func(reason string, code codes.Code, msg string) error {
+		s.certRequests.remove(req.RequestId)
+		s.limits.Record(source, request.username, false)
+		metrics.AuthAttempts.WithLabelValues("ca", "failure", reason).Inc()
+		logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+			"request_id": req.RequestId,
+			"username":   request.username,
+			"reason":     reason,
+		}).Warn("User certificate request failed")
+		return status.Error(code, msg)
+	}
+
+	var reason string
+	switch request.method {
+	case pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_KEY:
+		if req.Signature == "" {
+			return nil, status.Error(codes.InvalidArgument, "signature is required")
+		}
+		signer, err := auth.VerifySSHSignature([]byte(req.Signature), CertificateSignatureNamespace, []byte(request.challenge))
+		if err != nil {
+			return nil, fail("signature", codes.Unauthenticated, err.Error())
+		}
+		keys, err := auth.UserKeys(s.config)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if keys[string(signer.Marshal())] != request.username {
+			return nil, fail("unknown_key", codes.PermissionDenied, "signing key is not bound to the user")
+		}
+		reason = "key"
+	case pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_OIDC:
+		user, err := s.authorizer.PollDevice(ctx, request.deviceCode)
+		switch {
+		case errors.Is(err, auth.ErrAuthorizationPending), errors.Is(err, auth.ErrSlowDown):
+			return nil, status.Error(codes.Unavailable, err.Error())
+		case errors.Is(err, auth.ErrAccessDenied):
+			return nil, fail("oidc_denied", codes.PermissionDenied, err.Error())
+		case errors.Is(err, auth.ErrExpiredToken):
+			s.certRequests.remove(req.RequestId)
+			return nil, status.Error(codes.DeadlineExceeded, err.Error())
+		case err != nil:
+			logger.Log.WithContext(ctx).WithError(err).Error("Failed to poll device authorization")
+			return nil, status.Error(codes.Unavailable, "identity provider unavailable")
+		}
+		if user != request.username {
+			return nil, fail("oidc_user", codes.PermissionDenied, "signed in as a different user")
+		}
+		reason = "oidc"
+	case pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL:
+		if request.denied {
+			s.certRequests.remove(req.RequestId)
+			return nil, status.Errorf(codes.PermissionDenied, "certificate request denied by %s", request.approver)
+		}
+		if !request.approved {
+			return nil, status.Error(codes.Unavailable, "waiting for approval")
+		}
+		reason = "approval"
+	}
+
+	s.certRequests.remove(req.RequestId)
+	if !s.users.Has(request.username) {
+		return nil, fail("unknown_user", codes.PermissionDenied, "not a gateway user")
+	}
+	s.limits.Record(source, request.username, true)
+	metrics.AuthAttempts.WithLabelValues("ca", "success", reason).Inc()
+	return s.issue(ctx, request.username, request.key, reason)
+}
+
+// checkCredentials checks the password and, for users with a second factor,
+// the TOTP code of username, throttled by the credential limiter. service
+// labels AuthAttempts. reason names the accepted credentials.
+func (s *Server) checkCredentials(ctx context.Context, service, username, password, code string) (reason string, err error) {
+	source := peerIP(ctx)
+	if err := s.limits.Check(source, username); err != nil {
+		metrics.AuthAttempts.WithLabelValues(service, "failure", "blocked").Inc()
+		return "", status.Error(codes.ResourceExhausted, err.Error())
+	}
+	fail := func(reason string, err error) (string, error) {
+		s.limits.Record(source, username, false)
+		metrics.AuthAttempts.WithLabelValues(service, "failure", reason).Inc()
+		return "", err
+	}
+
+	if err := s.users.CheckPassword(username, password); err != nil {
+		return fail("password", status.Error(codes.Unauthenticated, err.Error()))
+	}
+	if s.users.NeedsTOTPEnrollment(username) {
+		return fail("totp_enrollment", status.Error(codes.FailedPrecondition, "TOTP enrollment required, enroll on the SSH bastion first"))
+	}
+	reason = "password"
+	if s.users.RequiresTOTP(username) {
+		recovery, err := s.users.CheckTOTP(username, code)
+		if err != nil {
+			return fail("totp", status.Error(codes.Unauthenticated, err.Error()))
+		}
+		reason = "password_totp"
+		if recovery {
+			reason = "password_recovery_code"
+		}
+	}
+	s.limits.Record(source, username, true)
+	metrics.AuthAttempts.WithLabelValues(service, "success", reason).Inc()
+	return reason, nil
+}
+
+// issue signs key for username, who was verified by reason
+func (s *Server) issue(ctx context.Context, username string, key ssh.PublicKey, reason string) (*pb.UserCertificateResponse, error) {
+	cert, err := s.userCA.Issue(username, key)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	fields := map[string]interface{}{
+		"username":     username,
+		"principals":   cert.ValidPrincipals,
+		"serial":       cert.Serial,
+		"fingerprint":  ssh.FingerprintSHA256(key),
+		"verified_by":  reason,
+		"valid_before": time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339),
+	}
+	// The client address, from the PROXY protocol header behind a load
+	// balancer
+	if p, ok := peer.FromContext(ctx); ok {
+		fields["remote"] = p.Addr.String()
+	}
+	logger.Log.WithContext(ctx).WithFields(fields).Info("Issued user certificate")
+
+	return &pb.UserCertificateResponse{
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		Principals:  cert.ValidPrincipals,
+		ValidAfter:  int64(cert.ValidAfter),
+		ValidBefore: int64(cert.ValidBefore),
+		Serial:      cert.Serial,
+		KeyId:       cert.KeyId,
+	}, nil
+}
+
+// parseCertificateKey parses the public key of a certificate request
+func parseCertificateKey(publicKey string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "public_key is not an OpenSSH public key")
+	}
+	return key, nil
+}
+
+// peerIP returns the client address of a call, which the REST API sets for
+// its requests
+func peerIP(ctx context.Context) netip.Addr {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return listen.SourceIP(p.Addr)
+	}
+	return netip.Addr{}
+}
+
+// randomID returns an unguessable request ID or challenge
+func randomID() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,211 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/netconf"
+	"github.com/safabayar/gateway/internal/proxy"
+	"github.com/safabayar/gateway/internal/tracing"
+	pb "github.com/safabayar/gateway/proto"
+)
+
+// ExecuteNetconf runs typed NETCONF operations in order over one session
+func (s *Server) ExecuteNetconf(ctx context.Context, req *pb.NetconfRequest) (*pb.NetconfResponse, error) {
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+		"fqdn":       req.Fqdn,
+		"username":   req.Username,
+		"operations": len(req.Operations),
+	}).Info("Received NETCONF request")
+
+	ctx, gatewayUser, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Fqdn == "" {
+		return nil, status.Error(codes.InvalidArgument, "FQDN is required")
+	}
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	if req.Password == "" && gatewayUser == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+	if len(req.Operations) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one operation is required")
+	}
+
+	// Build every operation before touching the device
+	operations := make([]proxy.NetconfOperation, len(req.Operations))
+	for i, op := range req.Operations {
+		operation, err := netconfOperation(op)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("operation %d: %v", i, err))
+		}
+		operations[i] = operation
+	}
+
+	device, deviceName, err := s.lookupDevice(ctx, req.Fqdn)
+	if err != nil {
+		logger.Log.WithContext(ctx).WithError(err).Error("Failed to get device config")
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if device.NetconfPort == 0 {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("device %s has no NETCONF port configured", deviceName))
+	}
+	if req.Password == "" && needsPassword(ctx, device, "netconf", req.Username) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("password is required, device %s has no key for %s", deviceName, req.Username))
+	}
+
+	ctx, span := tracing.Start(ctx, "gateway.netconf",
+		attribute.String("device", deviceName),
+		attribute.Int("operations", len(operations)),
+	)
+
+	metrics.ActiveSessions.WithLabelValues("netconf").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("netconf").Dec()
+
+	result, execErr := proxy.ExecuteNetconfOperations(ctx, device.Hostname, device.NetconfPort, req.Username, req.Password, operations)
+
+	response := &pb.NetconfResponse{
+		SessionId:         result.SessionID,
+		BackendDurationMs: result.Duration.Milliseconds(),
+	}
+	received := 0
+	for _, op := range result.Operations {
+		if op.Reply != nil {
+			received += len(op.Reply.Payload)
+		}
+		response.Results = append(response.Results, netconfResult(op))
+	}
+	sent := 0
+	for _, op := range operations {
+		sent += len(op.RPC)
+	}
+	metrics.BytesProxied.WithLabelValues("netconf", metrics.DirectionToDevice).Add(float64(sent))
+	metrics.BytesProxied.WithLabelValues("netconf", metrics.DirectionFromDevice).Add(float64(received))
+
+	category := proxy.Category(execErr)
+	metrics.ObserveCommand(deviceName, "netconf", result.Duration, string(category))
+	span.SetAttributes(attribute.String("error_category", string(category)))
+	tracing.End(span, execErr)
+
+	if execErr != nil {
+		logger.Log.WithContext(ctx).WithError(execErr).WithField("category", category).Error("NETCONF operation failed")
+		response.Error = execErr.Error()
+		response.ErrorCategory = errorCategory(execErr)
+		// The results collected before a gateway-side failure, including
+		// the rollback, are returned in the status details
+		if err := gatewayError(execErr, response); err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// netconfOperation builds the RPC for op, applying the default datastores
+func netconfOperation(op *pb.NetconfOperation) (proxy.NetconfOperation, error) {
+	var result proxy.NetconfOperation
+	var err error
+
+	switch o := op.GetOperation().(type) {
+	case *pb.NetconfOperation_Get:
+		result.Name = "get"
+		result.RPC, err = netconf.Get(netconfFilter(o.Get.GetFilter()))
+	case *pb.NetconfOperation_GetConfig:
+		result.Name = "get-config"
+		result.Datastore = datastoreOrDefault(o.GetConfig.GetSource(), netconf.Running)
+		result.RPC, err = netconf.GetConfig(result.Datastore, netconfFilter(o.GetConfig.GetFilter()))
+	case *pb.NetconfOperation_EditConfig:
+		result.Name = "edit-config"
+		result.Datastore = datastoreOrDefault(o.EditConfig.GetTarget(), netconf.Running)
+		result.RPC, err = netconf.EditConfig(netconf.EditConfigOptions{
+			Target:           result.Datastore,
+			DefaultOperation: o.EditConfig.GetDefaultOperation(),
+			TestOption:       o.EditConfig.GetTestOption(),
+			ErrorOption:      o.EditConfig.GetErrorOption(),
+			Config:           o.EditConfig.GetConfig(),
+		})
+	case *pb.NetconfOperation_Lock:
+		result.Name = "lock"
+		result.Datastore = datastoreOrDefault(o.Lock.GetTarget(), netconf.Running)
+		result.RPC, err = netconf.Lock(result.Datastore)
+	case *pb.NetconfOperation_Unlock:
+		result.Name = "unlock"
+		result.Datastore = datastoreOrDefault(o.Unlock.GetTarget(), netconf.Running)
+		result.RPC, err = netconf.Unlock(result.Datastore)
+	case *pb.NetconfOperation_Commit:
+		result.Name = "commit"
+		result.RPC, err = netconf.Commit(netconf.CommitOptions{
+			Confirmed:      o.Commit.GetConfirmed(),
+			ConfirmTimeout: o.Commit.GetConfirmTimeout(),
+			Persist:        o.Commit.GetPersist(),
+			PersistID:      o.Commit.GetPersistId(),
+		})
+	case *pb.NetconfOperation_CancelCommit:
+		result.Name = "cancel-commit"
+		result.RPC = netconf.CancelCommit(o.CancelCommit.GetPersistId())
+	case *pb.NetconfOperation_Validate:
+		result.Name = "validate"
+		result.Datastore = datastoreOrDefault(o.Validate.GetSource(), netconf.Candidate)
+		result.RPC, err = netconf.Validate(result.Datastore)
+	case *pb.NetconfOperation_DiscardChanges:
+		result.Name = "discard-changes"
+		result.RPC = netconf.DiscardChanges()
+	default:
+		err = errors.New("no operation set")
+	}
+
+	return result, err
+}
+
+// netconfResult converts the outcome of an operation
+func netconfResult(op proxy.NetconfOperationResult) *pb.NetconfResult {
+	result := &pb.NetconfResult{
+		Operation:  op.Name,
+		DurationMs: op.Duration.Milliseconds(),
+	}
+
+	var rpcErrors netconf.RPCErrors
+	errors.As(op.Err, &rpcErrors)
+	if op.Reply != nil {
+		result.Data = string(op.Reply.Data)
+		result.Ok = op.Reply.OK
+		rpcErrors = append(rpcErrors, op.Reply.Warnings...)
+	}
+	for _, e := range rpcErrors {
+		result.Errors = append(result.Errors, &pb.NetconfError{
+			Type:     e.Type,
+			Tag:      e.Tag,
+			Severity: e.Severity,
+			AppTag:   e.AppTag,
+			Path:     e.Path,
+			Message:  e.Message,
+			Info:     string(e.Info),
+		})
+	}
+	return result
+}
+
+func netconfFilter(filter *pb.NetconfFilter) netconf.Filter {
+	return netconf.Filter{
+		Subtree: filter.GetSubtree(),
+		XPath:   filter.GetXpath(),
+	}
+}
+
+func datastoreOrDefault(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
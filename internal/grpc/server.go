@@ -2,40 +2,95 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
 
+	"github.com/safabayar/gateway/internal/auth"
 	"github.com/safabayar/gateway/internal/config"
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/parser"
 	"github.com/safabayar/gateway/internal/proxy"
+	"github.com/safabayar/gateway/internal/tracing"
 	pb "github.com/safabayar/gateway/proto"
 )
 
 // Server implements the Gateway gRPC service
 type Server struct {
 	pb.UnimplementedGatewayServer
-	config *config.Config
+	config    *config.Config
+	templates *parser.Registry
+	// users authenticate API tokens. With userCA they issue bastion
+	// certificates, unset without user_ca.
+	users  *auth.Users
+	userCA *auth.UserCA
+	// limits throttles the credentials checked by the API: API tokens and
+	// their TOTP codes, and the passwords of the certificate API
+	limits *auth.Limiter
+	// authorizer runs the OIDC device flow, nil without user_ca.oidc
+	authorizer auth.DeviceAuthorizer
+	// approvers may approve certificate requests, from user_ca.approvers
+	approvers    map[string]bool
+	certRequests certificateRequests
 }
 
-// NewServer creates a new gRPC server instance
-func NewServer(cfg *config.Config) *Server {
+// NewServer creates a new gRPC server instance.
+// templates may be nil, in which case output parsing is unavailable.
+func NewServer(cfg *config.Config, templates *parser.Registry) *Server {
 	return &Server{
-		config: cfg,
+		config:    cfg,
+		templates: templates,
 	}
 }
 
+// SetUsers lets callers authenticate as the gateway users of users with
+// their API tokens. Failed token, password and code checks are throttled by
+// limits.
+func (s *Server) SetUsers(users *auth.Users, limits *auth.Limiter) {
+	s.users = users
+	s.limits = limits
+}
+
+// SetUserCA enables IssueUserCertificate, signing keys of the users set by
+// SetUsers with ca
+func (s *Server) SetUserCA(ca *auth.UserCA) {
+	s.userCA = ca
+	s.approvers = make(map[string]bool)
+	for _, approver := range s.config.Settings.UserCA.Approvers {
+		s.approvers[approver] = true
+	}
+}
+
+// SetDeviceAuthorizer enables certificate requests verified with the OIDC
+// device flow of authorizer
+func (s *Server) SetDeviceAuthorizer(authorizer auth.DeviceAuthorizer) {
+	s.authorizer = authorizer
+}
+
 // ExecuteCommand executes a single command on a device
 func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*pb.CommandResponse, error) {
-	logger.Log.WithFields(map[string]interface{}{
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"fqdn":     req.Fqdn,
 		"username": req.Username,
 		"protocol": req.Protocol,
 		"command":  req.Command,
 	}).Info("Received command execution request")
 
+	ctx, gatewayUser, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate request
 	if req.Fqdn == "" {
 		return nil, status.Error(codes.InvalidArgument, "FQDN is required")
@@ -43,7 +98,7 @@ func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*p
 	if req.Username == "" {
 		return nil, status.Error(codes.InvalidArgument, "username is required")
 	}
-	if req.Password == "" {
+	if req.Password == "" && gatewayUser == "" {
 		return nil, status.Error(codes.InvalidArgument, "password is required")
 	}
 	if req.Command == "" {
@@ -51,61 +106,39 @@ func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*p
 	}
 
 	// Get device configuration
-	device, deviceName, err := s.config.GetDeviceByFQDN(req.Fqdn)
+	device, deviceName, err := s.lookupDevice(ctx, req.Fqdn)
 	if err != nil {
-		logger.Log.WithError(err).Error("Failed to get device config")
+		logger.Log.WithContext(ctx).WithError(err).Error("Failed to get device config")
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
 
-	logger.Log.WithFields(map[string]interface{}{
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"device":   deviceName,
 		"hostname": device.Hostname,
 	}).Info("Routing to device")
 
-	// Execute command based on protocol
-	var output string
-	var execErr error
-
-	switch req.Protocol {
-	case "ssh", "":
-		output, execErr = proxy.ExecuteSSHCommand(
-			device.Hostname,
-			device.SSHPort,
-			req.Username,
-			req.Password,
-			req.Command,
-		)
-	case "telnet":
-		output, execErr = proxy.ExecuteTelnetCommand(
-			device.Hostname,
-			device.TelnetPort,
-			req.Username,
-			req.Password,
-			req.Command,
-		)
-	case "netconf":
-		output, execErr = proxy.ExecuteNetconfCommand(
-			device.Hostname,
-			device.NetconfPort,
-			req.Username,
-			req.Password,
-			req.Command,
-		)
-	default:
+	// Validate protocol before touching the device
+	if !isSupportedProtocol(req.Protocol) {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("unsupported protocol: %s", req.Protocol))
 	}
-
-	response := &pb.CommandResponse{
-		Output: output,
+	if req.Password == "" && needsPassword(ctx, device, req.Protocol, req.Username) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("password is required, device %s has no key for %s", deviceName, req.Username))
 	}
 
+	result, execErr := s.execute(ctx, deviceName, device, req.Protocol, req.Username, req.Password, req.Command)
 	if execErr != nil {
-		response.Error = execErr.Error()
-		response.ExitCode = 1
-		logger.Log.WithError(execErr).Error("Command execution failed")
+		logger.Log.WithContext(ctx).WithError(execErr).WithField("category", proxy.Category(execErr)).Error("Command execution failed")
 	} else {
-		response.ExitCode = 0
-		logger.Log.Info("Command executed successfully")
+		logger.Log.WithContext(ctx).Info("Command executed successfully")
+	}
+
+	response, err := buildResponse(result, execErr)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Parse {
+		s.parseResponse(device, req.Command, response)
 	}
 
 	return response, nil
@@ -115,6 +148,11 @@ func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*p
 func (s *Server) StreamCommand(stream pb.Gateway_StreamCommandServer) error {
 	logger.Log.Info("Starting stream command session")
 
+	ctx, gatewayUser, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+
 	var deviceName string
 	var device *config.DeviceConfig
 	var username, password string
@@ -134,7 +172,7 @@ func (s *Server) StreamCommand(stream pb.Gateway_StreamCommandServer) error {
 		// First message should contain connection details
 		if device == nil {
 			var err error
-			device, deviceName, err = s.config.GetDeviceByFQDN(req.Fqdn)
+			device, deviceName, err = s.lookupDevice(ctx, req.Fqdn)
 			if err != nil {
 				return status.Error(codes.NotFound, err.Error())
 			}
@@ -144,54 +182,31 @@ func (s *Server) StreamCommand(stream pb.Gateway_StreamCommandServer) error {
 			if protocol == "" {
 				protocol = "ssh"
 			}
+			if !isSupportedProtocol(protocol) {
+				return status.Error(codes.InvalidArgument, fmt.Sprintf("unsupported protocol: %s", protocol))
+			}
+			if gatewayUser != "" && password == "" && needsPassword(ctx, device, protocol, username) {
+				return status.Error(codes.InvalidArgument, fmt.Sprintf("password is required, device %s has no key for %s", deviceName, username))
+			}
 
 			logger.Log.WithFields(map[string]interface{}{
-				"device":   deviceName,
-				"username": username,
-				"protocol": protocol,
+				"device":       deviceName,
+				"username":     username,
+				"gateway_user": gatewayUser,
+				"protocol":     protocol,
 			}).Info("Stream session initialized")
 		}
 
-		// Execute command
-		var output string
-		var execErr error
-
-		switch protocol {
-		case "ssh":
-			output, execErr = proxy.ExecuteSSHCommand(
-				device.Hostname,
-				device.SSHPort,
-				username,
-				password,
-				req.Command,
-			)
-		case "telnet":
-			output, execErr = proxy.ExecuteTelnetCommand(
-				device.Hostname,
-				device.TelnetPort,
-				username,
-				password,
-				req.Command,
-			)
-		case "netconf":
-			output, execErr = proxy.ExecuteNetconfCommand(
-				device.Hostname,
-				device.NetconfPort,
-				username,
-				password,
-				req.Command,
-			)
-		}
-
-		response := &pb.CommandResponse{
-			Output: output,
-		}
-
-		if execErr != nil {
-			response.Error = execErr.Error()
-			response.ExitCode = 1
-		} else {
-			response.ExitCode = 0
+		// A command the gateway failed to run is reported in its response,
+		// later commands may still succeed
+		result, execErr := s.execute(ctx, deviceName, device, protocol, username, password, req.Command)
+		response, err := buildResponse(result, execErr)
+		if err != nil {
+			logger.Log.WithError(execErr).WithField("category", proxy.Category(execErr)).Error("Stream command execution failed")
+		}
+
+		if req.Parse {
+			s.parseResponse(device, req.Command, response)
 		}
 
 		if err := stream.Send(response); err != nil {
@@ -200,3 +215,362 @@ func (s *Server) StreamCommand(stream pb.Gateway_StreamCommandServer) error {
 		}
 	}
 }
+
+// ParseOutput parses sample text with a stored or inline template
+func (s *Server) ParseOutput(ctx context.Context, req *pb.ParseRequest) (*pb.ParseResponse, error) {
+	logger.Log.WithFields(map[string]interface{}{
+		"platform": req.Platform,
+		"command":  req.Command,
+		"inline":   req.Template != "",
+	}).Info("Received parse output request")
+
+	var tmpl *parser.Template
+	var err error
+
+	if req.Template != "" {
+		tmpl, err = parser.ParseTemplate("inline", req.Template)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	} else {
+		if req.Platform == "" || req.Command == "" {
+			return nil, status.Error(codes.InvalidArgument, "platform and command are required without an inline template")
+		}
+		if s.templates == nil {
+			return nil, status.Error(codes.FailedPrecondition, "output templates are not configured")
+		}
+		tmpl, err = s.templates.Lookup(req.Platform, req.Command)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+	}
+
+	result, err := tmpl.Parse(req.Text)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	parsed, err := json.Marshal(result.Maps())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &pb.ParseResponse{
+		ParsedOutput: string(parsed),
+		Header:       result.Header,
+	}
+	if req.Template == "" {
+		response.TemplateName = tmpl.Name
+	}
+
+	return response, nil
+}
+
+// ListDevices returns the devices in the inventory
+func (s *Server) ListDevices(ctx context.Context, req *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+	names := make([]string, 0, len(s.config.Devices))
+	for name := range s.config.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	response := &pb.ListDevicesResponse{}
+	for _, name := range names {
+		device := s.config.Devices[name]
+
+		fqdn := name
+		if s.config.Settings.DomainSuffix != "" {
+			fqdn = name + "." + s.config.Settings.DomainSuffix
+		}
+
+		var protocols []string
+		if device.SSHPort > 0 {
+			protocols = append(protocols, "ssh")
+		}
+		if device.TelnetPort > 0 {
+			protocols = append(protocols, "telnet")
+		}
+		if device.NetconfPort > 0 {
+			protocols = append(protocols, "netconf")
+		}
+		if device.GNMIPort > 0 {
+			protocols = append(protocols, "gnmi")
+		}
+
+		response.Devices = append(response.Devices, &pb.Device{
+			Name:        name,
+			Fqdn:        fqdn,
+			Hostname:    device.Hostname,
+			Description: device.Description,
+			Location:    device.Location,
+			Platform:    device.Platform,
+			Protocols:   protocols,
+		})
+	}
+
+	return response, nil
+}
+
+// parseResponse fills in the parsed output of a command response
+func (s *Server) parseResponse(device *config.DeviceConfig, command string, response *pb.CommandResponse) {
+	switch {
+	case response.Error != "":
+		response.ParseError = "command failed, output not parsed"
+		return
+	case s.templates == nil:
+		response.ParseError = "output templates are not configured"
+		return
+	case device.Platform == "":
+		response.ParseError = "device has no platform configured"
+		return
+	}
+
+	result, err := s.templates.Parse(device.Platform, command, response.Stdout)
+	if err != nil {
+		response.ParseError = err.Error()
+		logger.Log.WithError(err).Warn("Failed to parse command output")
+		return
+	}
+
+	parsed, err := json.Marshal(result.Maps())
+	if err != nil {
+		response.ParseError = err.Error()
+		return
+	}
+	response.ParsedOutput = string(parsed)
+}
+
+// isSupportedProtocol reports whether protocol can be used to reach a device
+func isSupportedProtocol(protocol string) bool {
+	switch protocol {
+	case "ssh", "telnet", "netconf", "":
+		return true
+	}
+	return false
+}
+
+// lookupDevice resolves an FQDN to a device in the inventory
+func (s *Server) lookupDevice(ctx context.Context, fqdn string) (*config.DeviceConfig, string, error) {
+	_, span := tracing.Start(ctx, "inventory.lookup", attribute.String("fqdn", fqdn))
+	device, deviceName, err := s.config.GetDeviceByFQDN(fqdn)
+	span.SetAttributes(attribute.String("device", deviceName))
+	tracing.End(span, err)
+	return device, deviceName, err
+}
+
+// Metadata keys of API authentication
+const (
+	authorizationMetadata = "authorization"
+	totpCodeMetadata      = "x-totp-code"
+)
+
+// authenticate checks the API token a caller sends as "authorization:
+// Bearer <token>" metadata. Callers with the token of a gateway user are
+// authenticated by the gateway, so the returned context logs in to devices
+// with the device keys. The user's source policy and second factor apply as
+// on the bastion: users with a TOTP secret send a code as x-totp-code
+// metadata, users who must enroll one are refused. Without a token, the
+// context is unchanged and the device authenticates the caller by their
+// password.
+func (s *Server) authenticate(ctx context.Context) (context.Context, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authorizationMetadata)
+	if len(values) == 0 {
+		return ctx, "", nil
+	}
+	if s.users == nil {
+		metrics.AuthAttempts.WithLabelValues("api", "failure", "token").Inc()
+		return nil, "", status.Error(codes.Unauthenticated, "invalid API token")
+	}
+
+	source := peerIP(ctx)
+	if err := s.limits.Check(source, ""); err != nil {
+		metrics.AuthAttempts.WithLabelValues("api", "failure", "blocked").Inc()
+		return nil, "", status.Error(codes.ResourceExhausted, err.Error())
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	var gatewayUser string
+	if ok {
+		gatewayUser, ok = s.users.TokenUser(strings.TrimSpace(token))
+	}
+	if !ok {
+		s.limits.Record(source, "", false)
+		metrics.AuthAttempts.WithLabelValues("api", "failure", "token").Inc()
+		return nil, "", status.Error(codes.Unauthenticated, "invalid API token")
+	}
+
+	fields := map[string]interface{}{"gateway_user": gatewayUser, "remote": source.String()}
+	if !s.users.AllowsSource(gatewayUser, source) {
+		logger.Log.WithContext(ctx).WithFields(fields).Warn("Refused API token from a source the user may not log in from")
+		metrics.AuthAttempts.WithLabelValues("api", "failure", "source_denied").Inc()
+		return nil, "", status.Errorf(codes.PermissionDenied, "%s may not log in from %s", gatewayUser, source)
+	}
+	if err := s.limits.Check(source, gatewayUser); err != nil {
+		metrics.AuthAttempts.WithLabelValues("api", "failure", "blocked").Inc()
+		return nil, "", status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if s.users.NeedsTOTPEnrollment(gatewayUser) {
+		metrics.AuthAttempts.WithLabelValues("api", "failure", "totp_enrollment").Inc()
+		return nil, "", status.Error(codes.FailedPrecondition, "TOTP enrollment required, enroll on the SSH bastion first")
+	}
+	reason := "token"
+	if s.users.RequiresTOTP(gatewayUser) {
+		var code string
+		if values := md.Get(totpCodeMetadata); len(values) > 0 {
+			code = values[0]
+		}
+		recovery, err := s.users.CheckTOTP(gatewayUser, code)
+		if err != nil {
+			s.limits.Record(source, gatewayUser, false)
+			metrics.AuthAttempts.WithLabelValues("api", "failure", "totp").Inc()
+			return nil, "", status.Error(codes.Unauthenticated, err.Error())
+		}
+		reason = "token_totp"
+		if recovery {
+			reason = "token_recovery_code"
+		}
+	}
+	s.limits.Record(source, gatewayUser, true)
+	metrics.AuthAttempts.WithLabelValues("api", "success", reason).Inc()
+	logger.Log.WithContext(ctx).WithFields(fields).Debug("Authenticated API caller")
+	return proxy.WithDeviceKeys(ctx), gatewayUser, nil
+}
+
+// Authenticate authenticates a caller by the API token of ctx like every
+// call of the gateway API, for services sharing its tokens such as the web
+// terminal
+func (s *Server) Authenticate(ctx context.Context) (context.Context, string, error) {
+	return s.authenticate(ctx)
+}
+
+// needsPassword reports whether logging in to device over protocol as
+// username with ctx needs a password: the caller was not authenticated with
+// an API token, or the device has no key for username or falls back to its
+// password. Telnet always does.
+func needsPassword(ctx context.Context, device *config.DeviceConfig, protocol, username string) bool {
+	switch protocol {
+	case "telnet":
+		return true
+	case "netconf":
+		return proxy.NeedsPassword(ctx, device.Hostname, device.NetconfPort, username)
+	}
+	return proxy.NeedsPassword(ctx, device.Hostname, device.SSHPort, username)
+}
+
+// telnetOptions returns the telnet prompt patterns and timeout for device
+func (s *Server) telnetOptions(device *config.DeviceConfig) proxy.TelnetOptions {
+	return proxy.TelnetOptions{
+		LoginPrompt:    device.Telnet.LoginPrompt,
+		PasswordPrompt: device.Telnet.PasswordPrompt,
+		Prompt:         device.Telnet.Prompt,
+		MorePrompt:     device.Telnet.MorePrompt,
+		Timeout:        time.Duration(s.config.Settings.DefaultTimeout) * time.Second,
+	}
+}
+
+// execute runs a command on the device using the requested protocol
+func (s *Server) execute(ctx context.Context, deviceName string, device *config.DeviceConfig, protocol, username, password, command string) (*proxy.Result, error) {
+	if protocol == "" {
+		protocol = "ssh"
+	}
+
+	ctx, span := tracing.Start(ctx, "gateway.execute",
+		attribute.String("device", deviceName),
+		attribute.String("protocol", protocol),
+	)
+
+	metrics.ActiveSessions.WithLabelValues(protocol).Inc()
+	defer metrics.ActiveSessions.WithLabelValues(protocol).Dec()
+
+	var result *proxy.Result
+	var err error
+	switch protocol {
+	case "telnet":
+		result, err = proxy.ExecuteTelnetCommand(ctx, device.Hostname, device.TelnetPort, username, password, command, s.telnetOptions(device))
+	case "netconf":
+		result, err = proxy.ExecuteNetconfCommand(ctx, device.Hostname, device.NetconfPort, username, password, command)
+	default:
+		result, err = proxy.ExecuteSSHCommand(ctx, device.Hostname, device.SSHPort, username, password, command)
+	}
+
+	var duration time.Duration
+	if result != nil {
+		duration = result.Duration
+		metrics.BytesProxied.WithLabelValues(protocol, metrics.DirectionFromDevice).Add(float64(len(result.Stdout) + len(result.Stderr)))
+	}
+	metrics.BytesProxied.WithLabelValues(protocol, metrics.DirectionToDevice).Add(float64(len(command)))
+	category := proxy.Category(err)
+	if err != nil && category == "" {
+		category = proxy.CategoryRemoteError
+	}
+	metrics.ObserveCommand(deviceName, protocol, duration, string(category))
+
+	span.SetAttributes(attribute.String("error_category", string(category)))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+// buildResponse converts a proxy result into a CommandResponse.
+// Failures on the gateway side of the exchange (the device could not be reached,
+// rejected the credentials or timed out) are also returned as gRPC errors that
+// carry the response as a detail, while failures reported by the device itself
+// are only returned in the response.
+func buildResponse(result *proxy.Result, execErr error) (*pb.CommandResponse, error) {
+	if result == nil {
+		result = &proxy.Result{}
+	}
+
+	response := &pb.CommandResponse{
+		Output:            result.Stdout,
+		Stdout:            result.Stdout,
+		Stderr:            result.Stderr,
+		ExitCode:          int32(result.ExitCode),
+		BackendDurationMs: result.Duration.Milliseconds(),
+	}
+
+	if execErr != nil {
+		response.Error = execErr.Error()
+		response.ErrorCategory = errorCategory(execErr)
+	}
+
+	return response, gatewayError(execErr, response)
+}
+
+// errorCategory returns the category of an execution error for responses
+func errorCategory(err error) pb.ErrorCategory {
+	switch proxy.Category(err) {
+	case proxy.CategoryConnectFailed:
+		return pb.ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED
+	case proxy.CategoryAuthFailed:
+		return pb.ErrorCategory_ERROR_CATEGORY_AUTH_FAILED
+	case proxy.CategoryTimeout:
+		return pb.ErrorCategory_ERROR_CATEGORY_TIMEOUT
+	}
+	return pb.ErrorCategory_ERROR_CATEGORY_REMOTE_ERROR
+}
+
+// gatewayError returns the gRPC status for failures on the gateway side of
+// the exchange, or nil if err is nil or was reported by the device. The
+// response is attached as a status detail, so that clients still get its
+// error category and backend timing.
+func gatewayError(err error, response protoadapt.MessageV1) error {
+	var code codes.Code
+	switch proxy.Category(err) {
+	case proxy.CategoryConnectFailed:
+		code = codes.Unavailable
+	case proxy.CategoryAuthFailed:
+		code = codes.Unauthenticated
+	case proxy.CategoryTimeout:
+		code = codes.DeadlineExceeded
+	default:
+		return nil
+	}
+	st := status.New(code, err.Error())
+	if response != nil {
+		if detailed, detailErr := st.WithDetails(response); detailErr == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
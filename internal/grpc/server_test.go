@@ -2,11 +2,35 @@ package grpc
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/auth"
 	"github.com/safabayar/gateway/internal/config"
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/proxy"
 	pb "github.com/safabayar/gateway/proto"
 )
 
@@ -29,7 +53,7 @@ func TestNewServer(t *testing.T) {
 		},
 	}
 
-	server := NewServer(cfg)
+	server := NewServer(cfg, nil)
 	if server == nil {
 		t.Fatal("NewServer returned nil")
 	}
@@ -51,7 +75,7 @@ func TestExecuteCommand_Validation(t *testing.T) {
 		},
 	}
 
-	server := NewServer(cfg)
+	server := NewServer(cfg, nil)
 	ctx := context.Background()
 
 	tests := []struct {
@@ -166,7 +190,7 @@ func TestExecuteCommand_ProtocolSelection(t *testing.T) {
 		},
 	}
 
-	server := NewServer(cfg)
+	server := NewServer(cfg, nil)
 	ctx := context.Background()
 
 	// Test that different protocols are routed correctly
@@ -197,3 +221,855 @@ func TestExecuteCommand_ProtocolSelection(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteCommand_UnreachableDeviceStatus(t *testing.T) {
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"srl1": {
+				Hostname: "127.0.0.1",
+				SSHPort:  22222, // Non-existent port
+			},
+		},
+	}
+
+	server := NewServer(cfg, nil)
+	_, err := server.ExecuteCommand(context.Background(), &pb.CommandRequest{
+		Fqdn:     "srl1.example.com",
+		Username: "admin",
+		Password: "password",
+		Command:  "show version",
+		Protocol: "ssh",
+	})
+
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("Status code: got %v, want %v", code, codes.Unavailable)
+	}
+	if resp := statusResponse(err); resp == nil || resp.ErrorCategory != pb.ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED {
+		t.Errorf("Status detail: got %v, want a CONNECT_FAILED response", resp)
+	}
+}
+
+// testCommandStream is a StreamCommand stream sending requests and
+// collecting the responses
+type testCommandStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  []*pb.CommandRequest
+	responses []*pb.CommandResponse
+}
+
+func (s *testCommandStream) Context() context.Context { return s.ctx }
+
+func (s *testCommandStream) Recv() (*pb.CommandRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *testCommandStream) Send(resp *pb.CommandResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func TestStreamCommand_GatewayErrorKeepsStream(t *testing.T) {
+	cfg := &config.Config{
+		Settings: config.Settings{DomainSuffix: "example.com", DefaultTimeout: 2},
+		Devices:  map[string]config.DeviceConfig{"srl1": {Hostname: "127.0.0.1", SSHPort: 22222}},
+	}
+	server := NewServer(cfg, nil)
+
+	stream := &testCommandStream{
+		ctx: context.Background(),
+		requests: []*pb.CommandRequest{
+			{Fqdn: "srl1.example.com", Username: "admin", Password: "password", Command: "show version"},
+			{Command: "show interfaces"},
+		},
+	}
+	if err := server.StreamCommand(stream); err != nil {
+		t.Fatalf("StreamCommand ended on a command failure: %v", err)
+	}
+	if len(stream.responses) != 2 {
+		t.Fatalf("Responses: got %d, want 2", len(stream.responses))
+	}
+	for i, resp := range stream.responses {
+		if resp.ErrorCategory != pb.ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED || resp.Error == "" {
+			t.Errorf("Response %d: got category %v error %q, want CONNECT_FAILED", i, resp.ErrorCategory, resp.Error)
+		}
+	}
+}
+
+// statusResponse returns the CommandResponse detail of a status error
+func statusResponse(err error) *pb.CommandResponse {
+	for _, detail := range status.Convert(err).Details() {
+		if resp, ok := detail.(*pb.CommandResponse); ok {
+			return resp
+		}
+	}
+	return nil
+}
+
+func TestBuildResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		result       *proxy.Result
+		err          error
+		wantCode     codes.Code
+		wantCategory pb.ErrorCategory
+		wantExitCode int32
+	}{
+		{
+			name:     "Success",
+			result:   &proxy.Result{Stdout: "ok", Duration: 5 * time.Millisecond},
+			wantCode: codes.OK,
+		},
+		{
+			name:         "Remote error",
+			result:       &proxy.Result{Stdout: "out", Stderr: "err", ExitCode: 2},
+			err:          &proxy.ExecError{Category: proxy.CategoryRemoteError, Err: errors.New("exit 2")},
+			wantCode:     codes.OK,
+			wantCategory: pb.ErrorCategory_ERROR_CATEGORY_REMOTE_ERROR,
+			wantExitCode: 2,
+		},
+		{
+			name:         "Connect failed",
+			result:       &proxy.Result{Duration: 3 * time.Millisecond},
+			err:          &proxy.ExecError{Category: proxy.CategoryConnectFailed, Err: errors.New("refused")},
+			wantCode:     codes.Unavailable,
+			wantCategory: pb.ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED,
+		},
+		{
+			name:         "Auth failed",
+			result:       &proxy.Result{Duration: 4 * time.Millisecond},
+			err:          &proxy.ExecError{Category: proxy.CategoryAuthFailed, Err: errors.New("denied")},
+			wantCode:     codes.Unauthenticated,
+			wantCategory: pb.ErrorCategory_ERROR_CATEGORY_AUTH_FAILED,
+		},
+		{
+			name:         "Timeout",
+			result:       &proxy.Result{Duration: 2 * time.Second},
+			err:          &proxy.ExecError{Category: proxy.CategoryTimeout, Err: errors.New("timeout")},
+			wantCode:     codes.DeadlineExceeded,
+			wantCategory: pb.ErrorCategory_ERROR_CATEGORY_TIMEOUT,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := buildResponse(tt.result, tt.err)
+
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Status code: got %v, want %v", code, tt.wantCode)
+			}
+			// Gateway-side failures carry the response in the status
+			if err != nil {
+				detail := statusResponse(err)
+				if detail == nil {
+					t.Fatal("Status has no CommandResponse detail")
+				}
+				if detail.ErrorCategory != resp.ErrorCategory || detail.BackendDurationMs != resp.BackendDurationMs {
+					t.Errorf("Status detail: got %v, want %v", detail, resp)
+				}
+			}
+
+			if resp.ErrorCategory != tt.wantCategory {
+				t.Errorf("ErrorCategory: got %v, want %v", resp.ErrorCategory, tt.wantCategory)
+			}
+			if resp.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode: got %d, want %d", resp.ExitCode, tt.wantExitCode)
+			}
+			if resp.Stdout != tt.result.Stdout || resp.Stderr != tt.result.Stderr {
+				t.Errorf("Output mismatch: got stdout=%q stderr=%q", resp.Stdout, resp.Stderr)
+			}
+			if resp.BackendDurationMs != tt.result.Duration.Milliseconds() {
+				t.Errorf("BackendDurationMs: got %d", resp.BackendDurationMs)
+			}
+		})
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	server := NewServer(&config.Config{}, nil)
+	ctx := context.Background()
+
+	resp, err := server.ParseOutput(ctx, &pb.ParseRequest{
+		Template: "Value Name (\\S+)\nValue State (\\S+)\n\nStart\n  ^${Name}\\s+${State} -> Record\n",
+		Text:     "eth1 up\neth2 down\n",
+	})
+	if err != nil {
+		t.Fatalf("ParseOutput failed: %v", err)
+	}
+
+	want := `[{"Name":"eth1","State":"up"},{"Name":"eth2","State":"down"}]`
+	if resp.ParsedOutput != want {
+		t.Errorf("ParsedOutput: got %s, want %s", resp.ParsedOutput, want)
+	}
+	if len(resp.Header) != 2 || resp.Header[0] != "Name" {
+		t.Errorf("Header: got %v", resp.Header)
+	}
+
+	// Invalid inline template
+	_, err = server.ParseOutput(ctx, &pb.ParseRequest{Template: "garbage", Text: "x"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("Status code: got %v, want %v", code, codes.InvalidArgument)
+	}
+
+	// Stored template without a registry
+	_, err = server.ParseOutput(ctx, &pb.ParseRequest{Platform: "nokia_srl", Command: "show version", Text: "x"})
+	if code := status.Code(err); code != codes.FailedPrecondition {
+		t.Errorf("Status code: got %v, want %v", code, codes.FailedPrecondition)
+	}
+}
+
+func TestExecuteNetconf_Validation(t *testing.T) {
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"srl1": {Hostname: "127.0.0.1", NetconfPort: 8333},
+			"ssh1": {Hostname: "127.0.0.1", SSHPort: 22},
+		},
+	}
+	server := NewServer(cfg, nil)
+
+	getConfig := &pb.NetconfOperation{Operation: &pb.NetconfOperation_GetConfig{GetConfig: &pb.NetconfGetConfig{}}}
+	tests := []struct {
+		name string
+		req  *pb.NetconfRequest
+		code codes.Code
+	}{
+		{
+			name: "no operations",
+			req:  &pb.NetconfRequest{Fqdn: "srl1.example.com", Username: "admin", Password: "secret"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing password",
+			req:  &pb.NetconfRequest{Fqdn: "srl1.example.com", Username: "admin", Operations: []*pb.NetconfOperation{getConfig}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "empty operation",
+			req: &pb.NetconfRequest{Fqdn: "srl1.example.com", Username: "admin", Password: "secret",
+				Operations: []*pb.NetconfOperation{getConfig, {}}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "invalid datastore",
+			req: &pb.NetconfRequest{Fqdn: "srl1.example.com", Username: "admin", Password: "secret",
+				Operations: []*pb.NetconfOperation{{Operation: &pb.NetconfOperation_Lock{Lock: &pb.NetconfLock{Target: "scratch"}}}}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown device",
+			req: &pb.NetconfRequest{Fqdn: "nope.example.com", Username: "admin", Password: "secret",
+				Operations: []*pb.NetconfOperation{getConfig}},
+			code: codes.NotFound,
+		},
+		{
+			name: "no NETCONF port",
+			req: &pb.NetconfRequest{Fqdn: "ssh1.example.com", Username: "admin", Password: "secret",
+				Operations: []*pb.NetconfOperation{getConfig}},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "unreachable device",
+			req: &pb.NetconfRequest{Fqdn: "srl1.example.com", Username: "admin", Password: "secret",
+				Operations: []*pb.NetconfOperation{getConfig}},
+			code: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := server.ExecuteNetconf(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("Status code: got %v, want %v (%v)", code, tt.code, err)
+			}
+		})
+	}
+
+	// Gateway-side failures carry the response with its category
+	_, err := server.ExecuteNetconf(context.Background(), &pb.NetconfRequest{
+		Fqdn: "srl1.example.com", Username: "admin", Password: "secret", Operations: []*pb.NetconfOperation{getConfig},
+	})
+	var detail *pb.NetconfResponse
+	for _, d := range status.Convert(err).Details() {
+		detail, _ = d.(*pb.NetconfResponse)
+	}
+	if detail == nil || detail.ErrorCategory != pb.ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED {
+		t.Errorf("Status detail: got %v, want a CONNECT_FAILED response", detail)
+	}
+}
+
+func TestNetconfOperation_Defaults(t *testing.T) {
+	tests := []struct {
+		op        *pb.NetconfOperation
+		name      string
+		datastore string
+	}{
+		{&pb.NetconfOperation{Operation: &pb.NetconfOperation_GetConfig{GetConfig: &pb.NetconfGetConfig{}}}, "get-config", "running"},
+		{&pb.NetconfOperation{Operation: &pb.NetconfOperation_EditConfig{EditConfig: &pb.NetconfEditConfig{Config: "<a/>"}}}, "edit-config", "running"},
+		{&pb.NetconfOperation{Operation: &pb.NetconfOperation_Lock{Lock: &pb.NetconfLock{Target: "candidate"}}}, "lock", "candidate"},
+		{&pb.NetconfOperation{Operation: &pb.NetconfOperation_Validate{Validate: &pb.NetconfValidate{}}}, "validate", "candidate"},
+		{&pb.NetconfOperation{Operation: &pb.NetconfOperation_DiscardChanges{DiscardChanges: &pb.NetconfDiscardChanges{}}}, "discard-changes", ""},
+	}
+
+	for _, tt := range tests {
+		op, err := netconfOperation(tt.op)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if op.Name != tt.name || op.Datastore != tt.datastore {
+			t.Errorf("got %s on %q, want %s on %q", op.Name, op.Datastore, tt.name, tt.datastore)
+		}
+	}
+}
+
+func TestIssueUserCertificate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, _ := ssh.MarshalPrivateKey(caKey, "")
+	caKeyFile := filepath.Join(t.TempDir(), "ca_key")
+	if err := os.WriteFile(caKeyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The TOTP secret is the RFC 6238 test key, whose codes are never
+	// guessed by the requests below
+	cfg := &config.Config{Settings: config.Settings{
+		Users: map[string]config.UserSettings{
+			"alice": {PasswordHash: string(hash), Principals: []string{"admin"}},
+			"bob":   {PasswordHash: string(hash), TOTPSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		},
+		UserCA: config.UserCASettings{PrivateKeyFile: caKeyFile},
+	}}
+	totpTime := time.Unix(1700000000, 0)
+	store, err := auth.NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetClock(func() time.Time { return totpTime })
+	users, err := auth.NewUsers(cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := auth.NewUserCA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	userKey, _ := ssh.NewPublicKey(pub)
+	publicKey := string(ssh.MarshalAuthorizedKey(userKey))
+
+	server := NewServer(cfg, nil)
+	if _, err := server.IssueUserCertificate(context.Background(), &pb.UserCertificateRequest{
+		Username: "alice", Password: "secret", PublicKey: publicKey,
+	}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("without user_ca: got %v, want FailedPrecondition", err)
+	}
+	limits, err := auth.NewLimiter("Credential", config.BastionLimitSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetUsers(users, limits)
+	server.SetUserCA(ca)
+
+	tests := []struct {
+		name string
+		req  *pb.UserCertificateRequest
+		want codes.Code
+	}{
+		{"missing password", &pb.UserCertificateRequest{Username: "alice", PublicKey: publicKey}, codes.InvalidArgument},
+		{"bad key", &pb.UserCertificateRequest{Username: "alice", Password: "secret", PublicKey: "ssh-ed25519 AAAA"}, codes.InvalidArgument},
+		{"wrong password", &pb.UserCertificateRequest{Username: "alice", Password: "wrong", PublicKey: publicKey}, codes.Unauthenticated},
+		{"unknown user", &pb.UserCertificateRequest{Username: "mallory", Password: "secret", PublicKey: publicKey}, codes.Unauthenticated},
+		{"missing TOTP code", &pb.UserCertificateRequest{Username: "bob", Password: "secret", PublicKey: publicKey}, codes.Unauthenticated},
+		{"wrong TOTP code", &pb.UserCertificateRequest{Username: "bob", Password: "secret", TotpCode: "000000", PublicKey: publicKey}, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := server.IssueUserCertificate(context.Background(), tt.req); status.Code(err) != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	resp, err := server.IssueUserCertificate(context.Background(), &pb.UserCertificateRequest{
+		Username: "alice", Password: "secret", PublicKey: publicKey,
+	})
+	if err != nil {
+		t.Fatalf("IssueUserCertificate failed: %v", err)
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(resp.Certificate))
+	if err != nil {
+		t.Fatalf("certificate does not parse: %v", err)
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok || string(cert.Key.Marshal()) != string(userKey.Marshal()) {
+		t.Fatal("certificate is not for the requested key")
+	}
+	if err := ca.Check("admin", cert); err != nil {
+		t.Errorf("certificate rejected by the CA: %v", err)
+	}
+	if len(resp.Principals) != 1 || resp.Principals[0] != "admin" || resp.Serial != cert.Serial {
+		t.Errorf("response = %+v, want the certificate's principals and serial", resp)
+	}
+
+	// bob's code works once
+	code, err := auth.TOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", totpTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobReq := &pb.UserCertificateRequest{Username: "bob", Password: "secret", TotpCode: code, PublicKey: publicKey}
+	if _, err := server.IssueUserCertificate(context.Background(), bobReq); err != nil {
+		t.Errorf("IssueUserCertificate with a TOTP code failed: %v", err)
+	}
+	if _, err := server.IssueUserCertificate(context.Background(), bobReq); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("reused TOTP code: got %v, want Unauthenticated", err)
+	}
+
+	// alice has no secret and must enroll once TOTP is required
+	cfg.Settings.BastionTOTP.Required = true
+	required, err := auth.NewUsers(cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetUsers(required, limits)
+	if _, err := server.IssueUserCertificate(context.Background(), &pb.UserCertificateRequest{
+		Username: "alice", Password: "secret", PublicKey: publicKey,
+	}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("unenrolled user with TOTP required: got %v, want FailedPrecondition", err)
+	}
+}
+
+// newCertificateTestServer returns a server issuing certificates for the
+// users of cfg, whose passwords are "secret", throttled by limits
+func newCertificateTestServer(t *testing.T, cfg *config.Config, limits config.BastionLimitSettings) (*Server, *auth.Users, *auth.Limiter) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, user := range cfg.Settings.Users {
+		user.PasswordHash = string(hash)
+		cfg.Settings.Users[name] = user
+	}
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, _ := ssh.MarshalPrivateKey(caKey, "")
+	cfg.Settings.UserCA.PrivateKeyFile = filepath.Join(t.TempDir(), "ca_key")
+	if err := os.WriteFile(cfg.Settings.UserCA.PrivateKeyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := auth.NewUsers(cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := auth.NewUserCA(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := auth.NewLimiter("Credential", limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(cfg, nil)
+	server.SetUsers(users, limiter)
+	server.SetUserCA(ca)
+	return server, users, limiter
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestUserCertificateRequests(t *testing.T) {
+	aliceKey := newTestSigner(t)
+	bobKey := newTestSigner(t)
+	cfg := &config.Config{Settings: config.Settings{
+		Users: map[string]config.UserSettings{
+			"alice": {AuthorizedKeys: []string{string(ssh.MarshalAuthorizedKey(aliceKey.PublicKey()))}},
+			"bob":   {AuthorizedKeys: []string{string(ssh.MarshalAuthorizedKey(bobKey.PublicKey()))}},
+			"carol": {},
+		},
+		UserCA: config.UserCASettings{Approvers: []string{"carol"}},
+	}}
+	server, users, limits := newCertificateTestServer(t, cfg, config.BastionLimitSettings{})
+	ctx := context.Background()
+
+	newKey := string(ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()))
+	begin := func(t *testing.T, username string, method pb.CertificateAuthMethod) *pb.BeginUserCertificateResponse {
+		t.Helper()
+		resp, err := server.BeginUserCertificate(ctx, &pb.BeginUserCertificateRequest{Username: username, PublicKey: newKey, Method: method})
+		if err != nil {
+			t.Fatalf("BeginUserCertificate failed: %v", err)
+		}
+		if resp.RequestId == "" || resp.ExpiresAt == 0 {
+			t.Fatalf("response = %+v, want a request ID and expiry", resp)
+		}
+		return resp
+	}
+	issue := func(req *pb.UserCertificateRequest) (string, error) {
+		resp, err := server.IssueUserCertificate(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		return resp.KeyId, nil
+	}
+
+	t.Run("key", func(t *testing.T) {
+		sign := func(t *testing.T, signer ssh.Signer, challenge string) string {
+			t.Helper()
+			signature, err := auth.SignSSHSignature(signer, CertificateSignatureNamespace, []byte(challenge))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(signature)
+		}
+
+		resp := begin(t, "alice", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_KEY)
+		if resp.Challenge == "" || resp.SignatureNamespace != CertificateSignatureNamespace {
+			t.Fatalf("response = %+v, want a challenge", resp)
+		}
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("without a signature: got %v, want InvalidArgument", err)
+		}
+		keyID, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId, Signature: sign(t, aliceKey, resp.Challenge)})
+		if err != nil || keyID != "gateway-user:alice" {
+			t.Fatalf("got %q, %v, want a certificate of alice", keyID, err)
+		}
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId, Signature: sign(t, aliceKey, resp.Challenge)}); status.Code(err) != codes.NotFound {
+			t.Errorf("request used twice: got %v, want NotFound", err)
+		}
+
+		// bob's key is not alice's, and a signature of another challenge
+		// does not verify
+		resp = begin(t, "alice", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_KEY)
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId, Signature: sign(t, bobKey, resp.Challenge)}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("key of another user: got %v, want PermissionDenied", err)
+		}
+		resp = begin(t, "alice", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_KEY)
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId, Signature: sign(t, aliceKey, "other")}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("signature of another challenge: got %v, want Unauthenticated", err)
+		}
+	})
+
+	t.Run("oidc", func(t *testing.T) {
+		if _, err := server.BeginUserCertificate(ctx, &pb.BeginUserCertificateRequest{
+			Username: "alice", PublicKey: newKey, Method: pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_OIDC,
+		}); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("without OIDC: got %v, want FailedPrecondition", err)
+		}
+		idp := auth.NewLocalIdP("https://gateway.example.net/oidc", users, limits)
+		server.SetDeviceAuthorizer(idp)
+		signIn := func(t *testing.T, userCode, username string) {
+			t.Helper()
+			form := url.Values{"user_code": {userCode}, "username": {username}, "password": {"secret"}}
+			req := httptest.NewRequest(http.MethodPost, "/device", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			idp.Handler().ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("sign-in returned HTTP %d: %s", rec.Code, rec.Body)
+			}
+		}
+
+		resp := begin(t, "alice", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_OIDC)
+		if resp.UserCode == "" || resp.VerificationUri != "https://gateway.example.net/oidc/device" {
+			t.Fatalf("response = %+v, want a user code and the verification page", resp)
+		}
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); status.Code(err) != codes.Unavailable {
+			t.Errorf("before sign-in: got %v, want Unavailable", err)
+		}
+		signIn(t, resp.UserCode, "alice")
+		if keyID, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); err != nil || keyID != "gateway-user:alice" {
+			t.Fatalf("got %q, %v, want a certificate of alice", keyID, err)
+		}
+
+		// Signing in as bob does not get alice a certificate
+		resp = begin(t, "alice", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_OIDC)
+		signIn(t, resp.UserCode, "bob")
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("signed in as another user: got %v, want PermissionDenied", err)
+		}
+	})
+
+	t.Run("approval", func(t *testing.T) {
+		approve := func(requestID, approver, password string, deny bool) error {
+			_, err := server.ApproveUserCertificate(ctx, &pb.ApproveUserCertificateRequest{
+				RequestId: requestID, Approver: approver, Password: password, Deny: deny,
+			})
+			return err
+		}
+
+		resp := begin(t, "alice", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL)
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); status.Code(err) != codes.Unavailable {
+			t.Errorf("before approval: got %v, want Unavailable", err)
+		}
+		if err := approve(resp.RequestId, "bob", "secret", false); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("approval by a non-approver: got %v, want PermissionDenied", err)
+		}
+		if err := approve(resp.RequestId, "carol", "wrong", false); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("approval with a wrong password: got %v, want Unauthenticated", err)
+		}
+		if err := approve(resp.RequestId, "carol", "secret", false); err != nil {
+			t.Fatalf("approval failed: %v", err)
+		}
+		if err := approve(resp.RequestId, "carol", "secret", true); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("second decision: got %v, want FailedPrecondition", err)
+		}
+		if keyID, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); err != nil || keyID != "gateway-user:alice" {
+			t.Fatalf("got %q, %v, want a certificate of alice", keyID, err)
+		}
+
+		resp = begin(t, "carol", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL)
+		if err := approve(resp.RequestId, "carol", "secret", false); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("approval of an own request: got %v, want PermissionDenied", err)
+		}
+
+		resp = begin(t, "bob", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL)
+		if err := approve(resp.RequestId, "carol", "secret", true); err != nil {
+			t.Fatalf("denial failed: %v", err)
+		}
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("denied request: got %v, want PermissionDenied", err)
+		}
+
+		// Unknown users are only refused once verified
+		resp = begin(t, "mallory", pb.CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL)
+		if err := approve(resp.RequestId, "carol", "secret", false); err != nil {
+			t.Fatalf("approval failed: %v", err)
+		}
+		if _, err := issue(&pb.UserCertificateRequest{RequestId: resp.RequestId}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("unknown user: got %v, want PermissionDenied", err)
+		}
+	})
+}
+
+func TestIssueUserCertificate_Throttled(t *testing.T) {
+	cfg := &config.Config{Settings: config.Settings{
+		Users: map[string]config.UserSettings{"alice": {}},
+	}}
+	server, _, limits := newCertificateTestServer(t, cfg, config.BastionLimitSettings{MaxFailures: 3, BackoffBase: 1})
+	now := time.Unix(1700000000, 0)
+	limits.SetClock(func() time.Time { return now })
+
+	publicKey := string(ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()))
+	from := func(addr string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 40000}})
+	}
+	issue := func(ctx context.Context, password string) error {
+		_, err := server.IssueUserCertificate(ctx, &pb.UserCertificateRequest{Username: "alice", Password: password, PublicKey: publicKey})
+		return err
+	}
+
+	if err := issue(from("192.0.2.1"), "wrong"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("wrong password: got %v, want Unauthenticated", err)
+	}
+	// The right password is refused during the backoff
+	if err := issue(from("192.0.2.1"), "secret"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("during backoff: got %v, want ResourceExhausted", err)
+	}
+
+	// Guesses from several sources ban the user
+	for _, addr := range []string{"192.0.2.2", "192.0.2.3"} {
+		now = now.Add(time.Minute)
+		if err := issue(from(addr), "wrong"); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("wrong password: got %v, want Unauthenticated", err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if err := issue(from("192.0.2.4"), "secret"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("banned user: got %v, want ResourceExhausted", err)
+	}
+	now = now.Add(time.Hour)
+	if err := issue(from("192.0.2.4"), "secret"); err != nil {
+		t.Errorf("after the ban: %v", err)
+	}
+}
+
+// startTestKeyDevice starts an SSH device that only accepts key, printing
+// "ok" for every command
+func startTestKeyDevice(t *testing.T, key ssh.PublicKey) int {
+	t.Helper()
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(offered.Marshal()) != string(key.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(newTestSigner(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						defer channel.Close()
+						for req := range requests {
+							if req.Type != "exec" {
+								_ = req.Reply(false, nil)
+								continue
+							}
+							_ = req.Reply(true, nil)
+							_, _ = channel.Write([]byte("ok\n"))
+							_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+							return
+						}
+					}()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestExecuteCommand_APIToken(t *testing.T) {
+	_, deviceKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, _ := ssh.MarshalPrivateKey(deviceKey, "")
+	keyFile := filepath.Join(t.TempDir(), "device_key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	devicePublicKey, _ := ssh.NewPublicKey(deviceKey.Public())
+	port := startTestKeyDevice(t, devicePublicKey)
+
+	tokenHash := func(token string) []string {
+		sum := sha256.Sum256([]byte(token))
+		return []string{hex.EncodeToString(sum[:])}
+	}
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: port, Credentials: "keys"},
+			"router2": {Hostname: "127.0.0.1", SSHPort: 22222},
+		},
+		Settings: config.Settings{
+			Credentials: map[string]config.CredentialSettings{"keys": {Username: "admin", PrivateKeyFile: keyFile}},
+			Users: map[string]config.UserSettings{
+				"alice": {PasswordHash: string(hash), APITokenHashes: tokenHash("alice-token")},
+				"bob":   {PasswordHash: string(hash), APITokenHashes: tokenHash("bob-token"), AllowedSources: []string{"10.0.0.0/8"}},
+				"carol": {PasswordHash: string(hash), APITokenHashes: tokenHash("carol-token"), TOTPSecret: secret},
+			},
+		},
+	}
+	keyring, err := proxy.NewKeyring(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.SetKeyring(keyring)
+	t.Cleanup(func() { proxy.SetKeyring(nil) })
+	store, err := auth.NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totpTime := time.Unix(1700000000, 0)
+	store.SetClock(func() time.Time { return totpTime })
+	users, err := auth.NewUsers(cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := auth.NewLimiter("Credential", config.BastionLimitSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(cfg, nil)
+	server.SetUsers(users, limits)
+
+	withToken := func(token string, pairs ...string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(append([]string{"authorization", "Bearer " + token}, pairs...)...))
+	}
+	request := func(fqdn, username string) *pb.CommandRequest {
+		return &pb.CommandRequest{Fqdn: fqdn, Username: username, Command: "show version"}
+	}
+
+	resp, err := server.ExecuteCommand(withToken("alice-token"), request("router1.example.com", "admin"))
+	if err != nil {
+		t.Fatalf("ExecuteCommand with an API token failed: %v", err)
+	}
+	if resp.Stdout != "ok\n" || resp.ExitCode != 0 {
+		t.Errorf("response = %+v, want the output of a key login", resp)
+	}
+
+	code, err := auth.TOTPCode(secret, totpTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ExecuteCommand(withToken("carol-token", "x-totp-code", code), request("router1.example.com", "admin")); err != nil {
+		t.Errorf("ExecuteCommand with an API token and a TOTP code failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pb.CommandRequest
+		want codes.Code
+	}{
+		{"no token", context.Background(), request("router1.example.com", "admin"), codes.InvalidArgument},
+		{"wrong token", withToken("mallory-token"), request("router1.example.com", "admin"), codes.Unauthenticated},
+		{"device without key", withToken("alice-token"), request("router2.example.com", "admin"), codes.InvalidArgument},
+		// The device keys only log in as the username of the profile
+		{"other device user", withToken("alice-token"), request("router1.example.com", "root"), codes.InvalidArgument},
+		{"source not allowed", withToken("bob-token"), request("router1.example.com", "admin"), codes.PermissionDenied},
+		{"no TOTP code", withToken("carol-token"), request("router1.example.com", "admin"), codes.Unauthenticated},
+		{"reused TOTP code", withToken("carol-token", "x-totp-code", code), request("router1.example.com", "admin"), codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := server.ExecuteCommand(tt.ctx, tt.req); status.Code(err) != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	// Users who must enroll a second factor are refused
+	cfg.Settings.BastionTOTP.Required = true
+	required, err := auth.NewUsers(cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetUsers(required, limits)
+	if _, err := server.ExecuteCommand(withToken("alice-token"), request("router1.example.com", "admin")); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("unenrolled user with TOTP required: got %v, want FailedPrecondition", err)
+	}
+}
@@ -0,0 +1,330 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// Status values reported in health responses
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Checker aggregates the state of the gateway subsystems.
+// Listeners decide liveness; listeners, registered checks and the optional
+// backend reachability ratio together decide readiness.
+type Checker struct {
+	listeners map[string]error
+	checks    map[string]func() error
+	grpc      []*grpcHealth
+	backends  *backendProbe
+	stop      chan struct{}
+	stopped   bool
+	mu        sync.RWMutex
+}
+
+// grpcHealth is a grpc.health.v1 server and the services it reports for
+type grpcHealth struct {
+	server   *health.Server
+	services []string
+}
+
+// ComponentStatus is the state of a single component
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BackendStatus is the result of the last backend reachability probe
+type BackendStatus struct {
+	Reachable   int       `json:"reachable"`
+	Total       int       `json:"total"`
+	Ratio       float64   `json:"ratio"`
+	MinRatio    float64   `json:"min_ratio"`
+	Unreachable []string  `json:"unreachable,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// Report is the JSON body of /healthz and /readyz
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+	Backends   *BackendStatus             `json:"backends,omitempty"`
+}
+
+// NewChecker creates a new health checker
+func NewChecker() *Checker {
+	return &Checker{
+		listeners: make(map[string]error),
+		checks:    make(map[string]func() error),
+		stop:      make(chan struct{}),
+	}
+}
+
+// SetListener records the state of a listener, nil meaning it is serving.
+// A listener that was never set is reported as not started.
+func (c *Checker) SetListener(name string, err error) {
+	c.mu.Lock()
+	c.listeners[name] = err
+	c.mu.Unlock()
+	c.updateGRPC()
+}
+
+// AddListener declares a listener that is expected to start
+func (c *Checker) AddListener(name string) {
+	c.SetListener(name, fmt.Errorf("not started"))
+}
+
+// AddCheck registers a readiness check evaluated on every request
+func (c *Checker) AddCheck(name string, check func() error) {
+	c.mu.Lock()
+	c.checks[name] = check
+	c.mu.Unlock()
+}
+
+// AddGRPCServer keeps a grpc.health.v1 server in sync with readiness.
+// The overall status ("") and each of services are reported.
+func (c *Checker) AddGRPCServer(server *health.Server, services ...string) {
+	c.mu.Lock()
+	c.grpc = append(c.grpc, &grpcHealth{server: server, services: services})
+	c.mu.Unlock()
+	c.updateGRPC()
+}
+
+// EnableBackendProbe periodically dials every device and makes readiness
+// require at least minRatio of them to be reachable. A minRatio of 0 only
+// reports reachability.
+func (c *Checker) EnableBackendProbe(cfg *config.Config, interval, timeout time.Duration, minRatio float64) {
+	c.mu.Lock()
+	c.backends = &backendProbe{config: cfg, timeout: timeout, minRatio: minRatio}
+	c.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.backends.run()
+			c.updateGRPC()
+			select {
+			case <-ticker.C:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the backend probe and reports the gateway as not ready, on
+// /readyz and on every gRPC service, so it is taken out of load balancing
+// while connections drain
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.stop)
+	}
+	servers := c.grpc
+	c.mu.Unlock()
+
+	for _, g := range servers {
+		g.server.Shutdown()
+	}
+}
+
+// Liveness reports the state of the listeners
+func (c *Checker) Liveness() Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentStatus)}
+	c.addListeners(&report)
+	return report
+}
+
+// Readiness reports the state of the listeners, the registered checks and the backends
+func (c *Checker) Readiness() Report {
+	c.mu.RLock()
+	checks := make(map[string]func() error, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentStatus)}
+	c.addListeners(&report)
+	if c.stopped {
+		report.add("shutdown", fmt.Errorf("gateway is shutting down"))
+	}
+	backends := c.backends
+	c.mu.RUnlock()
+
+	for name, check := range checks {
+		report.add(name, check())
+	}
+
+	if backends != nil {
+		status := backends.status()
+		report.Backends = &status
+		if status.Total > 0 && status.Ratio < status.MinRatio {
+			report.Status = StatusUnavailable
+		}
+	}
+
+	return report
+}
+
+// addListeners adds the listener states to the report, the caller holds c.mu
+func (c *Checker) addListeners(report *Report) {
+	for name, err := range c.listeners {
+		report.add(name+"_listener", err)
+	}
+}
+
+func (r *Report) add(name string, err error) {
+	if err != nil {
+		r.Status = StatusUnavailable
+		r.Components[name] = ComponentStatus{Status: StatusUnavailable, Error: err.Error()}
+		return
+	}
+	r.Components[name] = ComponentStatus{Status: StatusOK}
+}
+
+// updateGRPC pushes the current readiness to the gRPC health servers
+func (c *Checker) updateGRPC() {
+	c.mu.RLock()
+	servers := c.grpc
+	c.mu.RUnlock()
+	if len(servers) == 0 {
+		return
+	}
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if c.Readiness().Status != StatusOK {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	for _, g := range servers {
+		g.server.SetServingStatus("", status)
+		for _, service := range g.services {
+			g.server.SetServingStatus(service, status)
+		}
+	}
+}
+
+// Watch refreshes the gRPC health servers at every interval so checks that
+// are only evaluated on demand (like authorized keys) are reflected there too
+func (c *Checker) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.updateGRPC()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Register adds /healthz and /readyz to mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Liveness())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Readiness())
+	})
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// backendProbe measures which devices accept TCP connections
+type backendProbe struct {
+	config   *config.Config
+	timeout  time.Duration
+	minRatio float64
+	last     BackendStatus
+	mu       sync.RWMutex
+}
+
+// run dials every device concurrently and stores the result
+func (p *backendProbe) run() {
+	type result struct {
+		name string
+		err  error
+	}
+
+	results := make(chan result, len(p.config.Devices))
+	for name, device := range p.config.Devices {
+		go func(name string, device config.DeviceConfig) {
+			results <- result{name: name, err: p.dial(device)}
+		}(name, device)
+	}
+
+	status := BackendStatus{Total: len(p.config.Devices), MinRatio: p.minRatio, CheckedAt: time.Now()}
+	for range p.config.Devices {
+		r := <-results
+		if r.err != nil {
+			logger.Log.WithError(r.err).Debugf("Backend %s unreachable", r.name)
+			status.Unreachable = append(status.Unreachable, r.name)
+			continue
+		}
+		status.Reachable++
+	}
+	sort.Strings(status.Unreachable)
+	if status.Total > 0 {
+		status.Ratio = float64(status.Reachable) / float64(status.Total)
+	}
+
+	p.mu.Lock()
+	p.last = status
+	p.mu.Unlock()
+}
+
+// dial opens and closes a TCP connection to the first configured port of a device
+func (p *backendProbe) dial(device config.DeviceConfig) error {
+	port := device.SSHPort
+	for _, candidate := range []int{device.GNMIPort, device.NetconfPort, device.TelnetPort} {
+		if port != 0 {
+			break
+		}
+		port = candidate
+	}
+	if port == 0 {
+		return fmt.Errorf("no port configured")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(device.Hostname, strconv.Itoa(port)), p.timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *backendProbe) status() BackendStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.last
+}
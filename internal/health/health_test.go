@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/health_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+func TestListenersAndChecks(t *testing.T) {
+	checker := NewChecker()
+	checker.AddListener("grpc")
+
+	var keysErr error
+	checker.AddCheck("authorized_keys", func() error { return keysErr })
+
+	if report := checker.Liveness(); report.Status != StatusUnavailable {
+		t.Errorf("Expected liveness to fail before listener starts, got %+v", report)
+	}
+
+	checker.SetListener("grpc", nil)
+	if report := checker.Liveness(); report.Status != StatusOK {
+		t.Errorf("Expected liveness ok, got %+v", report)
+	}
+	if report := checker.Readiness(); report.Status != StatusOK {
+		t.Errorf("Expected readiness ok, got %+v", report)
+	}
+
+	keysErr = fmt.Errorf("permission denied")
+	report := checker.Readiness()
+	if report.Status != StatusUnavailable {
+		t.Errorf("Expected readiness to fail, got %+v", report)
+	}
+	if c := report.Components["authorized_keys"]; c.Status != StatusUnavailable || c.Error != "permission denied" {
+		t.Errorf("Unexpected authorized_keys status: %+v", c)
+	}
+
+	// A failing readiness check does not affect liveness
+	if report := checker.Liveness(); report.Status != StatusOK {
+		t.Errorf("Expected liveness ok, got %+v", report)
+	}
+}
+
+func TestHTTPHandlers(t *testing.T) {
+	checker := NewChecker()
+	checker.AddListener("http")
+	mux := http.NewServeMux()
+	checker.Register(mux)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before listener starts, got %d", code)
+	}
+
+	checker.SetListener("http", nil)
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected 200 from /healthz, got %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Errorf("Expected 200 from /readyz, got %d", code)
+	}
+}
+
+func TestGRPCHealth(t *testing.T) {
+	checker := NewChecker()
+	checker.AddListener("grpc")
+
+	server := health.NewServer()
+	checker.AddGRPCServer(server, "gateway.Gateway")
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		return resp.Status
+	}
+
+	if status := check("gateway.Gateway"); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Expected NOT_SERVING, got %v", status)
+	}
+
+	checker.SetListener("grpc", nil)
+	if status := check(""); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING, got %v", status)
+	}
+	if status := check("gateway.Gateway"); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING, got %v", status)
+	}
+
+	checker.Stop()
+	if status := check(""); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Expected NOT_SERVING after Stop, got %v", status)
+	}
+	if report := checker.Readiness(); report.Status != StatusUnavailable || report.Components["shutdown"].Status != StatusUnavailable {
+		t.Errorf("Expected readiness unavailable after Stop, got %+v", report)
+	}
+	if report := checker.Liveness(); report.Status != StatusOK {
+		t.Errorf("Expected liveness ok after Stop, got %+v", report)
+	}
+}
+
+func TestBackendProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"up":   {Hostname: "127.0.0.1", SSHPort: openPort},
+			"down": {Hostname: "127.0.0.1", GNMIPort: closedPort},
+		},
+	}
+
+	checker := NewChecker()
+	defer checker.Stop()
+	checker.EnableBackendProbe(cfg, time.Hour, time.Second, 0.75)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for checker.Readiness().Backends.CheckedAt.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	report := checker.Readiness()
+	if report.Backends.Reachable != 1 || report.Backends.Total != 2 || report.Backends.Ratio != 0.5 {
+		t.Errorf("Unexpected backend status: %+v", report.Backends)
+	}
+	if len(report.Backends.Unreachable) != 1 || report.Backends.Unreachable[0] != "down" {
+		t.Errorf("Unexpected unreachable devices: %v", report.Backends.Unreachable)
+	}
+	if report.Status != StatusUnavailable {
+		t.Errorf("Expected readiness to fail below the minimum ratio, got %s", report.Status)
+	}
+}
@@ -0,0 +1,113 @@
+// Package listen opens the TCP listeners of the gateway, optionally behind
+// load balancers speaking the PROXY protocol or shared by several protocols
+package listen
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+// TCP listens on address. With the PROXY protocol enabled, connections from
+// trusted proxies report the client address of their header as RemoteAddr.
+func TCP(address string, settings config.ProxyProtocolSettings) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.Enabled {
+		return listener, nil
+	}
+	wrapped, err := ProxyProtocol(listener, settings.TrustedProxies)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// ProxyProtocol wraps listener to read PROXY protocol v1 and v2 headers.
+// The header of a connection is used when it comes from a trusted proxy, a
+// connection from a trusted proxy without one keeps its own address, e.g.
+// for health checks. Connections from other sources that send a header are
+// refused, so clients cannot choose their address.
+func ProxyProtocol(listener net.Listener, trustedProxies []string) (net.Listener, error) {
+	if len(trustedProxies) == 0 {
+		return nil, fmt.Errorf("proxy protocol: trusted_proxies is empty")
+	}
+	trusted, err := ParseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: trusted_proxies: %w", err)
+	}
+	return &proxyproto.Listener{
+		Listener: listener,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if Contains(trusted, SourceIP(upstream)) {
+				return proxyproto.USE, nil
+			}
+			return proxyproto.REJECT, nil
+		},
+	}, nil
+}
+
+// ParseCIDRs parses CIDRs, taking a plain address as a single host
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Contains reports whether addr is in one of prefixes
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceIP returns the IP address of a remote address, IPv4 addresses
+// mapped to IPv6 as IPv4
+func SourceIP(addr net.Addr) netip.Addr {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, _ := netip.AddrFromSlice(tcp.IP)
+		return ip.Unmap()
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
+
+// RequestIP returns the client IP address of an HTTP request, which is the
+// address of a PROXY protocol header on listeners reading one
+func RequestIP(r *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
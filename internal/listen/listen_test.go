@@ -0,0 +1,189 @@
+package listen
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+var (
+	testClient = &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 56324}
+	testServer = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+)
+
+// accepted is a connection accepted by startTestListener with what the
+// client sent after the header
+type accepted struct {
+	remote net.Addr
+	data   string
+	err    error
+}
+
+// startTestListener accepts connections on a listener trusting trusted and
+// reports their address and first line
+func startTestListener(t *testing.T, trusted ...string) (string, <-chan accepted) {
+	t.Helper()
+
+	listener, err := TCP("127.0.0.1:0", config.ProxyProtocolSettings{Enabled: true, TrustedProxies: trusted})
+	if err != nil {
+		t.Fatalf("TCP failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	conns := make(chan accepted, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			conns <- accepted{remote: conn.RemoteAddr(), data: string(buf), err: err}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), conns
+}
+
+// send connects to address, writes header followed by "hello" and returns
+// the connection accepted from conns
+func send(t *testing.T, address string, conns <-chan accepted, header []byte) accepted {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(append(header, "hello"...)); err != nil {
+		t.Fatal(err)
+	}
+	return <-conns
+}
+
+func v2Header(t *testing.T) []byte {
+	t.Helper()
+
+	header, err := proxyproto.HeaderProxyFromAddrs(2, testClient, testServer).Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func TestProxyProtocol(t *testing.T) {
+	trusted, trustedConns := startTestListener(t, "127.0.0.0/8")
+	untrusted, untrustedConns := startTestListener(t, "10.0.0.0/8", "2001:db8::1")
+
+	v1 := []byte("PROXY TCP4 192.0.2.10 192.0.2.1 56324 2222\r\n")
+	tests := []struct {
+		name       string
+		address    string
+		conns      <-chan accepted
+		header     []byte
+		wantRemote string
+		wantErr    bool
+	}{
+		{"v1 from trusted proxy", trusted, trustedConns, v1, "192.0.2.10:56324", false},
+		{"v2 from trusted proxy", trusted, trustedConns, v2Header(t), "192.0.2.10:56324", false},
+		{"no header from trusted proxy", trusted, trustedConns, nil, "127.0.0.1", false},
+		{"header from untrusted source", untrusted, untrustedConns, v1, "", true},
+		{"no header from untrusted source", untrusted, untrustedConns, nil, "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := send(t, tt.address, tt.conns, tt.header)
+			if tt.wantErr {
+				if got.err == nil {
+					t.Errorf("Read succeeded with %q, want an error", got.data)
+				}
+				return
+			}
+			if got.err != nil || got.data != "hello" {
+				t.Fatalf("Read = %q, %v, want hello", got.data, got.err)
+			}
+			host := got.remote.String()
+			if tt.header == nil {
+				host, _, _ = net.SplitHostPort(host)
+			}
+			if host != tt.wantRemote {
+				t.Errorf("RemoteAddr = %s, want %s", got.remote, tt.wantRemote)
+			}
+		})
+	}
+}
+
+func TestTCPSettings(t *testing.T) {
+	listener, err := TCP("127.0.0.1:0", config.ProxyProtocolSettings{TrustedProxies: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	if _, ok := listener.(*proxyproto.Listener); ok {
+		t.Error("Disabled PROXY protocol wrapped the listener")
+	}
+
+	for _, trusted := range [][]string{nil, {"10.0.0.0/33"}, {"load-balancer"}} {
+		_, err := TCP("127.0.0.1:0", config.ProxyProtocolSettings{Enabled: true, TrustedProxies: trusted})
+		if err == nil {
+			t.Errorf("TCP with trusted proxies %q succeeded, want an error", trusted)
+		}
+	}
+}
+
+func TestProxyProtocolGRPCPeer(t *testing.T) {
+	listener, err := TCP("127.0.0.1:0", config.ProxyProtocolSettings{Enabled: true, TrustedProxies: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := make(chan net.Addr, 1)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if p, ok := peer.FromContext(ctx); ok {
+			peers <- p.Addr
+		}
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	header := v2Header(t)
+	client, err := grpc.NewClient("passthrough:///"+listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(header); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := healthpb.NewHealthClient(client).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if got := (<-peers).String(); got != testClient.String() {
+		t.Errorf("Peer address = %s, want %s", got, testClient)
+	}
+}
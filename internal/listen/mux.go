@@ -0,0 +1,131 @@
+package listen
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/soheilhy/cmux"
+
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// muxReadTimeout bounds how long a client may take to send the first bytes
+// its protocol is recognised by
+const muxReadTimeout = 10 * time.Second
+
+// sshSilence is how long a client may send nothing before it is taken for an
+// SSH client waiting for the server version banner, like ssh-keyscan
+const sshSilence = 2 * time.Second
+
+// gnmiPathPrefix is the :path prefix of the RPCs of the gNMI service
+const gnmiPathPrefix = "/gnmi.gNMI/"
+
+// Mux serves several protocols on one listener, dispatching each connection
+// by its first bytes:
+//   - SSH: the "SSH-" version banner, or nothing for a while
+//   - HTTP: an HTTP/1 request line
+//   - gNMI: HTTP/2 whose first request is for the gNMI service
+//   - gRPC: any other HTTP/2, e.g. the Gateway, health and reflection services
+//
+// Closing the Mux or any of its listeners closes the port for all of them.
+type Mux struct {
+	SSH  net.Listener
+	HTTP net.Listener
+	GNMI net.Listener
+	GRPC net.Listener
+
+	root      net.Listener
+	cmux      cmux.CMux
+	closeOnce sync.Once
+}
+
+// NewMux multiplexes listener. Connections are dispatched once Serve runs.
+func NewMux(listener net.Listener) *Mux {
+	m := &Mux{root: listener, cmux: cmux.New(listener)}
+	m.cmux.SetReadTimeout(muxReadTimeout)
+	m.cmux.HandleError(func(err error) bool {
+		var notMatched cmux.ErrNotMatched
+		if errors.As(err, &notMatched) {
+			logger.Log.WithError(err).Debug("Closed connection of an unknown protocol")
+		}
+		return true
+	})
+
+	// Cheap prefix matches first, HTTP/2 header matching reads until the
+	// first request
+	m.SSH = m.child(m.cmux.MatchWithWriters(matchSSH))
+	m.HTTP = m.child(m.cmux.Match(cmux.HTTP1Fast()))
+	// gRPC clients wait for the server SETTINGS before sending requests
+	m.GNMI = m.child(m.cmux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings(":path", gnmiPathPrefix)))
+	m.GRPC = m.child(m.cmux.Match(cmux.HTTP2()))
+	return m
+}
+
+// Serve dispatches connections until the Mux is closed
+func (m *Mux) Serve() error {
+	err := m.cmux.Serve()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops accepting connections on the port. Dispatched connections
+// stay open.
+func (m *Mux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		m.cmux.Close()
+		err = m.root.Close()
+	})
+	return err
+}
+
+// Addr returns the address of the port
+func (m *Mux) Addr() net.Addr {
+	return m.root.Addr()
+}
+
+// matchSSH matches the SSH version banner and clients that wait for the
+// server to send its banner first
+func matchSSH(w io.Writer, r io.Reader) bool {
+	// cmux passes the connection itself as the writer
+	if conn, ok := w.(net.Conn); ok {
+		_ = conn.SetReadDeadline(time.Now().Add(sshSilence))
+		defer func() { _ = conn.SetReadDeadline(time.Now().Add(muxReadTimeout)) }()
+	}
+
+	prefix := make([]byte, len("SSH-"))
+	n, err := io.ReadFull(r, prefix)
+	if n == 0 {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	return err == nil && string(prefix) == "SSH-"
+}
+
+func (m *Mux) child(listener net.Listener) net.Listener {
+	return &muxListener{Listener: listener, mux: m}
+}
+
+// muxListener is a listener of a Mux that reports closing as net.ErrClosed,
+// like a TCP listener, so servers stop accepting quietly
+type muxListener struct {
+	net.Listener
+	mux *Mux
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil && (errors.Is(err, cmux.ErrListenerClosed) || errors.Is(err, cmux.ErrServerClosed)) {
+		return nil, net.ErrClosed
+	}
+	return conn, err
+}
+
+func (l *muxListener) Close() error {
+	return l.mux.Close()
+}
@@ -0,0 +1,186 @@
+package listen
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gnmipb "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/listen_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+type testGNMIServer struct {
+	gnmipb.UnimplementedGNMIServer
+}
+
+func (testGNMIServer) Capabilities(context.Context, *gnmipb.CapabilityRequest) (*gnmipb.CapabilityResponse, error) {
+	return &gnmipb.CapabilityResponse{GNMIVersion: "test"}, nil
+}
+
+// startTestMux serves a Mux with a banner and line echo on SSH, an HTTP
+// handler, a gNMI server and a gRPC server with the health service
+func startTestMux(t *testing.T) *Mux {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := NewMux(listener)
+	t.Cleanup(func() { mux.Close() })
+
+	go func() {
+		for {
+			conn, err := mux.SSH.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, "banner\r\n")
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			fmt.Fprintf(conn, "echo %s", line)
+			conn.Close()
+		}
+	}()
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "http %s", r.URL.Path)
+	})}
+	go func() { _ = httpServer.Serve(mux.HTTP) }()
+	t.Cleanup(func() { httpServer.Close() })
+
+	gnmiServer := grpc.NewServer()
+	gnmipb.RegisterGNMIServer(gnmiServer, testGNMIServer{})
+	go func() { _ = gnmiServer.Serve(mux.GNMI) }()
+	t.Cleanup(gnmiServer.Stop)
+
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	go func() { _ = grpcServer.Serve(mux.GRPC) }()
+	t.Cleanup(grpcServer.Stop)
+
+	go func() { _ = mux.Serve() }()
+	return mux
+}
+
+func dialTestGRPC(t *testing.T, address string) *grpc.ClientConn {
+	t.Helper()
+
+	client, err := grpc.NewClient("passthrough:///"+address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestMux(t *testing.T) {
+	mux := startTestMux(t)
+	address := mux.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("ssh", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "SSH-2.0-test\r\n")
+		got, _ := io.ReadAll(conn)
+		if string(got) != "banner\r\necho SSH-2.0-test\r\n" {
+			t.Errorf("SSH reply = %q", got)
+		}
+	})
+
+	t.Run("ssh client waiting for the banner", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "banner\r\n" {
+			t.Errorf("ReadString = %q, %v, want the banner", line, err)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		resp, err := http.Get("http://" + address + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "http /healthz" {
+			t.Errorf("HTTP body = %q", body)
+		}
+	})
+
+	t.Run("gnmi", func(t *testing.T) {
+		resp, err := gnmipb.NewGNMIClient(dialTestGRPC(t, address)).Capabilities(ctx, &gnmipb.CapabilityRequest{})
+		if err != nil {
+			t.Fatalf("Capabilities failed: %v", err)
+		}
+		if resp.GNMIVersion != "test" {
+			t.Errorf("GNMIVersion = %q", resp.GNMIVersion)
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		// The gNMI server has no health service, so this reaches the
+		// gRPC server
+		if _, err := healthpb.NewHealthClient(dialTestGRPC(t, address)).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+	})
+
+	t.Run("unknown protocol", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, strings.Repeat("\x00", 64))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if n, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("Read %d bytes, want the connection closed", n)
+		}
+	})
+}
+
+func TestMuxClose(t *testing.T) {
+	mux := startTestMux(t)
+
+	if err := mux.HTTP.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := mux.SSH.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close = %v, want net.ErrClosed", err)
+	}
+	if _, err := net.Dial("tcp", mux.Addr().String()); err == nil {
+		t.Error("Dial after Close succeeded, want the port closed")
+	}
+	if err := mux.Close(); err != nil {
+		t.Errorf("Second Close = %v, want nil", err)
+	}
+}
@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+// OtherDevice is the device label used for names that are not in the inventory,
+// so clients cannot create unbounded label values
+const OtherDevice = "other"
+
+// Traffic directions for BytesProxied
+const (
+	DirectionToDevice   = "to_device"
+	DirectionFromDevice = "from_device"
+)
+
+// Registry holds every gateway metric
+var Registry = prometheus.NewRegistry()
+
+var (
+	// BastionConnections counts SSH bastion connections by handshake result,
+	// or by why they were refused before the handshake
+	BastionConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_bastion_connections_total",
+		Help: "SSH bastion connections by handshake result or refusal reason.",
+	}, []string{"result"})
+
+	// BastionBans counts source addresses and users banned after failed
+	// bastion logins
+	BastionBans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_bastion_bans_total",
+		Help: "Temporary bastion bans after repeated failed logins by scope.",
+	}, []string{"scope"})
+
+	// BastionForwards counts port forwards through the SSH bastion by
+	// result
+	BastionForwards = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_bastion_forwards_total",
+		Help: "SSH bastion port forwards to devices by result.",
+	}, []string{"result"})
+
+	// AuthAttempts counts client authentication attempts
+	AuthAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_auth_attempts_total",
+		Help: "Client authentication attempts by service, result and reason.",
+	}, []string{"service", "result", "reason"})
+
+	// ActiveSessions tracks open sessions to devices
+	ActiveSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_active_sessions",
+		Help: "Open sessions to devices by protocol.",
+	}, []string{"protocol"})
+
+	// CommandDuration observes command execution latency per device
+	CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_command_duration_seconds",
+		Help:    "Command execution latency by device and protocol.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"device", "protocol"})
+
+	// CommandErrors counts failed command executions per device
+	CommandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_command_errors_total",
+		Help: "Failed command executions by device, protocol and error category.",
+	}, []string{"device", "protocol", "category"})
+
+	// GNMIRequests counts gNMI RPCs
+	GNMIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_gnmi_requests_total",
+		Help: "gNMI RPCs by method, device and gRPC status code.",
+	}, []string{"rpc", "device", "code"})
+
+	// GNMISubscribeDuration observes how long Subscribe streams stay open
+	GNMISubscribeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_gnmi_subscribe_duration_seconds",
+		Help:    "gNMI Subscribe stream duration by device.",
+		Buckets: []float64{1, 10, 60, 300, 900, 3600, 14400, 86400},
+	}, []string{"device"})
+
+	// BytesProxied counts bytes relayed between clients and devices
+	BytesProxied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_bytes_proxied_total",
+		Help: "Bytes relayed between clients and devices by protocol and direction.",
+	}, []string{"protocol", "direction"})
+
+	// GNMIBackendConnections tracks cached connections to gNMI backends
+	GNMIBackendConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_gnmi_backend_connections",
+		Help: "Cached gRPC connections to gNMI backends.",
+	})
+
+	// SSHPoolConnections tracks pooled SSH connections to devices
+	SSHPoolConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_ssh_pool_connections",
+		Help: "SSH connections to devices held by the connection pool.",
+	})
+
+	// SSHPoolAcquires counts sessions served by the pool by whether the
+	// connection was reused or dialed
+	SSHPoolAcquires = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ssh_pool_acquires_total",
+		Help: "Sessions opened through the SSH connection pool by connection source.",
+	}, []string{"source"})
+)
+
+var (
+	inventory   *config.Config
+	inventoryMu sync.RWMutex
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		BastionConnections,
+		BastionBans,
+		BastionForwards,
+		AuthAttempts,
+		ActiveSessions,
+		CommandDuration,
+		CommandErrors,
+		GNMIRequests,
+		GNMISubscribeDuration,
+		BytesProxied,
+		GNMIBackendConnections,
+		SSHPoolConnections,
+		SSHPoolAcquires,
+	)
+}
+
+// SetInventory sets the device inventory that bounds the device label
+func SetInventory(cfg *config.Config) {
+	inventoryMu.Lock()
+	inventory = cfg
+	inventoryMu.Unlock()
+}
+
+// Device returns name if it is a device in the inventory and OtherDevice otherwise
+func Device(name string) string {
+	inventoryMu.RLock()
+	defer inventoryMu.RUnlock()
+
+	if inventory == nil {
+		return OtherDevice
+	}
+	if _, ok := inventory.Devices[name]; !ok {
+		return OtherDevice
+	}
+	return name
+}
+
+// ObserveCommand records the latency and outcome of a command execution.
+// An empty category means the command succeeded.
+func ObserveCommand(device, protocol string, duration time.Duration, category string) {
+	device = Device(device)
+	CommandDuration.WithLabelValues(device, protocol).Observe(duration.Seconds())
+	if category != "" {
+		CommandErrors.WithLabelValues(device, protocol, category).Inc()
+	}
+}
+
+// Handler returns the HTTP handler serving the registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// CountingReader counts bytes read from a reader into BytesProxied
+func CountingReader(r io.Reader, protocol, direction string) io.Reader {
+	return &countingReader{r: r, counter: BytesProxied.WithLabelValues(protocol, direction)}
+}
+
+// CountingWriter counts bytes written to a writer into BytesProxied
+func CountingWriter(w io.Writer, protocol, direction string) io.Writer {
+	return &countingWriter{w: w, counter: BytesProxied.WithLabelValues(protocol, direction)}
+}
+
+type countingReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w       io.Writer
+	counter prometheus.Counter
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+func TestDeviceBoundedByInventory(t *testing.T) {
+	SetInventory(&config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {}},
+	})
+	defer SetInventory(nil)
+
+	if got := Device("router1"); got != "router1" {
+		t.Errorf("Device(router1) = %s", got)
+	}
+	if got := Device("not-a-device"); got != OtherDevice {
+		t.Errorf("Device(not-a-device) = %s, want %s", got, OtherDevice)
+	}
+}
+
+func TestObserveCommand(t *testing.T) {
+	SetInventory(&config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {}},
+	})
+	defer SetInventory(nil)
+
+	ObserveCommand("router1", "ssh", 150*time.Millisecond, "")
+	ObserveCommand("router1", "ssh", time.Second, "timeout")
+	ObserveCommand("unknown", "ssh", time.Second, "connect_failed")
+
+	if got := testutil.ToFloat64(CommandErrors.WithLabelValues("router1", "ssh", "timeout")); got != 1 {
+		t.Errorf("timeout errors = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(CommandErrors.WithLabelValues(OtherDevice, "ssh", "connect_failed")); got != 1 {
+		t.Errorf("errors for unknown device = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(CommandDuration); got != 2 {
+		t.Errorf("duration series = %d, want 2", got)
+	}
+}
+
+func TestCountingReaderWriter(t *testing.T) {
+	before := testutil.ToFloat64(BytesProxied.WithLabelValues("test", DirectionToDevice))
+
+	data, err := io.ReadAll(CountingReader(strings.NewReader("hello"), "test", DirectionToDevice))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("ReadAll = %q, %v", data, err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := CountingWriter(&buf, "test", DirectionFromDevice).Write([]byte("world!")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(BytesProxied.WithLabelValues("test", DirectionToDevice)) - before; got != 5 {
+		t.Errorf("bytes to device = %v, want 5", got)
+	}
+	if got := testutil.ToFloat64(BytesProxied.WithLabelValues("test", DirectionFromDevice)); got != 6 {
+		t.Errorf("bytes from device = %v, want 6", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	AuthAttempts.WithLabelValues("bastion", "failure", "unknown_key").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gateway_auth_attempts_total{reason="unknown_key",result="failure",service="bastion"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics output missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package netconf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// endOfMessage delimits messages in base:1.0 framing (RFC 6242 section 4.3)
+const endOfMessage = "]]>]]>"
+
+// maxChunkSize is the largest chunk allowed by RFC 6242 section 4.2
+const maxChunkSize = 4294967295
+
+// maxChunkHeader is the length of the longest chunk header line after "#":
+// ten digits and the newline
+const maxChunkHeader = 11
+
+// DefaultMaxMessageSize is the largest message read before the peer is
+// assumed to be broken or hostile
+const DefaultMaxMessageSize = 64 << 20
+
+// ErrMalformedChunk is returned when a base:1.1 chunk header is invalid
+var ErrMalformedChunk = errors.New("malformed NETCONF chunk")
+
+// ErrMessageTooLarge is returned when a message exceeds the size limit of
+// the transport
+var ErrMessageTooLarge = errors.New("NETCONF message too large")
+
+// Transport reads and writes NETCONF messages. It starts with end-of-message
+// framing, used for the hello exchange, and switches to chunked framing once
+// both peers announced base:1.1.
+type Transport struct {
+	r       *bufio.Reader
+	w       io.Writer
+	chunked bool
+	writeMu sync.Mutex
+	// maxSize is the largest message ReadMessage returns
+	maxSize int
+}
+
+// NewTransport creates a transport over r and w using base:1.0 framing
+func NewTransport(r io.Reader, w io.Writer) *Transport {
+	return &Transport{r: bufio.NewReader(r), w: w, maxSize: DefaultMaxMessageSize}
+}
+
+// SetMaxMessageSize changes the largest message read, DefaultMaxMessageSize
+// by default
+func (t *Transport) SetMaxMessageSize(n int) {
+	t.maxSize = n
+}
+
+// SetChunked switches reading and writing to base:1.1 chunked framing
+func (t *Transport) SetChunked() {
+	t.writeMu.Lock()
+	t.chunked = true
+	t.writeMu.Unlock()
+}
+
+// Chunked reports whether the transport uses chunked framing
+func (t *Transport) Chunked() bool {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.chunked
+}
+
+// ReadMessage returns the next message without its framing.
+// It must not be called concurrently.
+func (t *Transport) ReadMessage() ([]byte, error) {
+	if t.Chunked() {
+		return t.readChunked()
+	}
+	return t.readEndOfMessage()
+}
+
+// WriteMessage frames msg and writes it in a single call
+func (t *Transport) WriteMessage(msg []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	var buf bytes.Buffer
+	if t.chunked {
+		if len(msg) > 0 {
+			fmt.Fprintf(&buf, "\n#%d\n", len(msg))
+			buf.Write(msg)
+		}
+		buf.WriteString("\n##\n")
+	} else {
+		buf.Write(msg)
+		buf.WriteString(endOfMessage)
+	}
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+func (t *Transport) readEndOfMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(bytes.TrimSpace(msg)) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		msg = append(msg, b)
+		if bytes.HasSuffix(msg, []byte(endOfMessage)) {
+			return msg[:len(msg)-len(endOfMessage)], nil
+		}
+		if len(msg) > t.maxSize+len(endOfMessage) {
+			return nil, ErrMessageTooLarge
+		}
+	}
+}
+
+// readChunked reads the chunks of a message into a buffer that grows with
+// the data received, not with the sizes the peer announces
+func (t *Transport) readChunked() ([]byte, error) {
+	var msg bytes.Buffer
+	for {
+		size, last, err := t.readChunkHeader(msg.Len() == 0)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return msg.Bytes(), nil
+		}
+		if int64(msg.Len())+size > int64(t.maxSize) {
+			return nil, ErrMessageTooLarge
+		}
+		if _, err := io.CopyN(&msg, t.r, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// readChunkHeader reads "\n#<size>\n" or the end-of-chunks marker "\n##\n"
+func (t *Transport) readChunkHeader(first bool) (int64, bool, error) {
+	// Some servers send whitespace between messages, skip it before the first chunk
+	b, err := t.r.ReadByte()
+	for first && err == nil && (b == ' ' || b == '\r' || b == '\t') {
+		b, err = t.r.ReadByte()
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if b != '\n' {
+		return 0, false, ErrMalformedChunk
+	}
+	if b, err = t.r.ReadByte(); err != nil {
+		return 0, false, err
+	}
+	if b != '#' {
+		return 0, false, ErrMalformedChunk
+	}
+
+	line, err := t.readHeaderLine()
+	if err != nil {
+		return 0, false, err
+	}
+	if line == "#" {
+		return 0, true, nil
+	}
+
+	size, err := strconv.ParseUint(line, 10, 32)
+	if err != nil || size == 0 || size > maxChunkSize {
+		return 0, false, ErrMalformedChunk
+	}
+	return int64(size), false, nil
+}
+
+// readHeaderLine reads the rest of a chunk header up to its newline, which
+// may not be longer than the longest valid header
+func (t *Transport) readHeaderLine() (string, error) {
+	var line []byte
+	for len(line) < maxChunkHeader {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b)
+	}
+	return "", ErrMalformedChunk
+}
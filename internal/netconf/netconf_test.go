@@ -0,0 +1,517 @@
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransport_EndOfMessage(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewTransport(&buf, &buf)
+
+	for _, msg := range []string{"<hello/>", "<rpc><get/></rpc>"} {
+		if err := tr.WriteMessage([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(buf.String(), "<hello/>]]>]]>") {
+		t.Errorf("missing end-of-message delimiter: %q", buf.String())
+	}
+
+	for _, want := range []string{"<hello/>", "<rpc><get/></rpc>"} {
+		got, err := tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadMessage() = %q, want %q", got, want)
+		}
+	}
+	if _, err := tr.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage() error = %v, want EOF", err)
+	}
+}
+
+func TestTransport_Chunked(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewTransport(&buf, &buf)
+	tr.SetChunked()
+
+	if err := tr.WriteMessage([]byte("<rpc/>")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "\n#6\n<rpc/>\n##\n" {
+		t.Errorf("chunked frame = %q", buf.String())
+	}
+
+	// A message split over several chunks
+	buf.WriteString("\n#4\n<rpc\n#9\n-reply/>\n\n##\n")
+	for _, want := range []string{"<rpc/>", "<rpc-reply/>\n"} {
+		got, err := tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("ReadMessage() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestTransport_MalformedChunk(t *testing.T) {
+	for _, frame := range []string{"#4\n<rpc", "\n#0\n\n##\n", "\n#x\n", "\n#-1\n", "\n#000000000001\n"} {
+		tr := NewTransport(strings.NewReader(frame), io.Discard)
+		tr.SetChunked()
+		if _, err := tr.ReadMessage(); !errors.Is(err, ErrMalformedChunk) {
+			t.Errorf("ReadMessage(%q) error = %v, want ErrMalformedChunk", frame, err)
+		}
+	}
+}
+
+func TestTransport_MessageTooLarge(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunked bool
+		frame   string
+	}{
+		// The announced size alone is over the limit, nothing is allocated for it
+		{"huge chunk", true, "\n#4294967295\n<rpc"},
+		{"chunks add up", true, "\n#8\n<rpc-rep\n#8\nly></rpc\n##\n"},
+		{"end of message", false, "<rpc-reply></rpc-reply>]]>]]>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransport(strings.NewReader(tt.frame), io.Discard)
+			tr.SetMaxMessageSize(10)
+			if tt.chunked {
+				tr.SetChunked()
+			}
+			if _, err := tr.ReadMessage(); !errors.Is(err, ErrMessageTooLarge) {
+				t.Errorf("ReadMessage() error = %v, want ErrMessageTooLarge", err)
+			}
+		})
+	}
+
+	// A chunk shorter than announced ends in an error, not a short message
+	tr := NewTransport(strings.NewReader("\n#100\n<rpc/>"), io.Discard)
+	tr.SetChunked()
+	if _, err := tr.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadMessage() error = %v, want ErrUnexpectedEOF", err)
+	}
+}
+
+func TestSession_Capabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		chunked      bool
+	}{
+		{name: "base:1.0", capabilities: []string{CapabilityV10}},
+		{name: "base:1.1", capabilities: []string{CapabilityV10, CapabilityV11}, chunked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, server := newTestSession(t, &testServer{capabilities: tt.capabilities, answer: answerOK})
+			if session.SessionID != 42 {
+				t.Errorf("SessionID = %d, want 42", session.SessionID)
+			}
+			if session.transport.Chunked() != tt.chunked {
+				t.Errorf("Chunked() = %v, want %v", session.transport.Chunked(), tt.chunked)
+			}
+			if !hasCapability(server.clientHello.Capabilities, CapabilityV11) {
+				t.Errorf("client hello capabilities = %v", server.clientHello.Capabilities)
+			}
+
+			reply, err := session.Exec(context.Background(), "<commit/>")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reply.OK || string(reply.Payload) != "<ok/>" {
+				t.Errorf("reply = %+v", reply)
+			}
+		})
+	}
+}
+
+func TestSession_Data(t *testing.T) {
+	session, _ := newTestSession(t, &testServer{capabilities: []string{CapabilityV11}, answer: func(string) string {
+		return "<data><interfaces><interface><name>ethernet-1/1</name></interface></interfaces></data>"
+	}})
+
+	reply, err := session.Exec(context.Background(), "<get/>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Data) != "<interfaces><interface><name>ethernet-1/1</name></interface></interfaces>" {
+		t.Errorf("Data = %q", reply.Data)
+	}
+}
+
+func TestSession_RPCError(t *testing.T) {
+	session, _ := newTestSession(t, &testServer{capabilities: []string{CapabilityV11}, answer: func(string) string {
+		return `<rpc-error>
+  <error-type>application</error-type>
+  <error-tag>invalid-value</error-tag>
+  <error-severity>error</error-severity>
+  <error-path>/interfaces/interface[name='ethernet-1/1']/mtu</error-path>
+  <error-message xml:lang="en">MTU out of range</error-message>
+  <error-info><bad-element>mtu</bad-element></error-info>
+</rpc-error>
+<rpc-error>
+  <error-type>application</error-type>
+  <error-tag>operation-not-supported</error-tag>
+  <error-severity>warning</error-severity>
+</rpc-error>`
+	}})
+
+	reply, err := session.Exec(context.Background(), "<edit-config/>")
+	var rpcErrors RPCErrors
+	if !errors.As(err, &rpcErrors) {
+		t.Fatalf("Exec() error = %v, want RPCErrors", err)
+	}
+	if len(rpcErrors) != 1 {
+		t.Fatalf("got %d errors, want 1", len(rpcErrors))
+	}
+	want := RPCError{
+		Type:     "application",
+		Tag:      "invalid-value",
+		Severity: "error",
+		Path:     "/interfaces/interface[name='ethernet-1/1']/mtu",
+		Message:  "MTU out of range",
+		Info:     []byte("<bad-element>mtu</bad-element>"),
+	}
+	if got := rpcErrors[0]; got.Error() != want.Error() || !bytes.Equal(got.Info, want.Info) {
+		t.Errorf("rpc-error = %+v, want %+v", got, want)
+	}
+	if reply == nil || len(reply.Warnings) != 1 || reply.Warnings[0].Tag != "operation-not-supported" {
+		t.Errorf("reply warnings = %+v", reply)
+	}
+}
+
+func TestSession_MessageIDCorrelation(t *testing.T) {
+	// Replies are sent in reverse order of the requests
+	const count = 5
+	session, _ := newTestSession(t, &testServer{capabilities: []string{CapabilityV11}, reverse: count})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			op := fmt.Sprintf("<get><filter>%d</filter></get>", i)
+			reply, err := session.Exec(context.Background(), op)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if want := fmt.Sprintf("<echo>%s</echo>", op); string(reply.Payload) != want {
+				errs <- fmt.Errorf("reply %q, want %q", reply.Payload, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSession_ExecContext(t *testing.T) {
+	// Never answers a single request
+	session, _ := newTestSession(t, &testServer{capabilities: []string{CapabilityV11}, reverse: 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := session.Exec(ctx, "<get/>"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Exec() error = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestSession_TransportClosed(t *testing.T) {
+	session, server := newTestSession(t, &testServer{capabilities: []string{CapabilityV11}, reverse: 2})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := session.Exec(context.Background(), "<get/>")
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	server.conn.Close()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrSessionClosed) {
+			t.Errorf("Exec() error = %v, want ErrSessionClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Exec did not return after the transport closed")
+	}
+	<-session.Done()
+	if _, err := session.Exec(context.Background(), "<get/>"); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Exec() after close error = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestSession_Close(t *testing.T) {
+	session, server := newTestSession(t, &testServer{capabilities: []string{CapabilityV11}, answer: answerOK})
+
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-server.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not see close-session")
+	}
+	if !server.closeSession {
+		t.Error("close-session was not sent")
+	}
+}
+
+func TestOperations(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() (string, error)
+		want  string
+	}{
+		{
+			name:  "get with subtree filter",
+			build: func() (string, error) { return Get(Filter{Subtree: "<interfaces/>"}) },
+			want:  `<get><filter type="subtree"><interfaces/></filter></get>`,
+		},
+		{
+			name:  "get-config with xpath filter",
+			build: func() (string, error) { return GetConfig(Running, Filter{XPath: `/system/name[.="a&b"]`}) },
+			want:  `<get-config><source><running/></source><filter type="xpath" select="/system/name[.=&#34;a&amp;b&#34;]"/></get-config>`,
+		},
+		{
+			name: "edit-config",
+			build: func() (string, error) {
+				return EditConfig(EditConfigOptions{
+					Target:           Candidate,
+					DefaultOperation: "replace",
+					ErrorOption:      "rollback-on-error",
+					Config:           "<system/>",
+				})
+			},
+			want: `<edit-config><target><candidate/></target><default-operation>replace</default-operation>` +
+				`<error-option>rollback-on-error</error-option><config><system/></config></edit-config>`,
+		},
+		{
+			name: "edit-config with config element",
+			build: func() (string, error) {
+				return EditConfig(EditConfigOptions{Target: Running, Config: "<config><system/></config>"})
+			},
+			want: `<edit-config><target><running/></target><config><system/></config></edit-config>`,
+		},
+		{
+			name: "edit-config with configuration element",
+			build: func() (string, error) {
+				return EditConfig(EditConfigOptions{Target: Running, Config: "<configuration><system/></configuration>"})
+			},
+			want: `<edit-config><target><running/></target><config><configuration><system/></configuration></config></edit-config>`,
+		},
+		{
+			name:  "lock",
+			build: func() (string, error) { return Lock(Candidate) },
+			want:  `<lock><target><candidate/></target></lock>`,
+		},
+		{
+			name: "confirmed commit",
+			build: func() (string, error) {
+				return Commit(CommitOptions{Confirmed: true, ConfirmTimeout: 120, Persist: "change-42"})
+			},
+			want: `<commit><confirmed/><confirm-timeout>120</confirm-timeout><persist>change-42</persist></commit>`,
+		},
+		{
+			name:  "confirming commit",
+			build: func() (string, error) { return Commit(CommitOptions{PersistID: "change-42"}) },
+			want:  `<commit><persist-id>change-42</persist-id></commit>`,
+		},
+		{
+			name:  "cancel-commit",
+			build: func() (string, error) { return CancelCommit("change-42"), nil },
+			want:  `<cancel-commit><persist-id>change-42</persist-id></cancel-commit>`,
+		},
+		{
+			name:  "validate",
+			build: func() (string, error) { return Validate(Candidate) },
+			want:  `<validate><source><candidate/></source></validate>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperations_Invalid(t *testing.T) {
+	tests := map[string]func() (string, error){
+		"unknown datastore":   func() (string, error) { return Lock("scratch") },
+		"both filters":        func() (string, error) { return Get(Filter{Subtree: "<a/>", XPath: "/a"}) },
+		"unbalanced subtree":  func() (string, error) { return Get(Filter{Subtree: "</filter><kill-session/>"}) },
+		"empty config":        func() (string, error) { return EditConfig(EditConfigOptions{Target: Running}) },
+		"delimiter in config": func() (string, error) { return EditConfig(EditConfigOptions{Target: Running, Config: "<a>]]>]]></a>"}) },
+		"bad default operation": func() (string, error) {
+			return EditConfig(EditConfigOptions{Target: Running, DefaultOperation: "delete", Config: "<a/>"})
+		},
+		"persist without confirmed": func() (string, error) {
+			return Commit(CommitOptions{Persist: "x"})
+		},
+	}
+
+	for name, build := range tests {
+		t.Run(name, func(t *testing.T) {
+			if rpc, err := build(); err == nil {
+				t.Errorf("expected error, got %s", rpc)
+			}
+		})
+	}
+}
+
+// testServer is the device side of a session over in-memory pipes
+type testServer struct {
+	capabilities []string
+	conn         *pipeConn
+	clientHello  Hello
+	// answer returns the rpc-reply content for an operation, nil echoes it
+	answer func(operation string) string
+	// reverse, when set, holds replies until that many requests arrived and
+	// sends them in reverse order
+	reverse      int
+	closeSession bool
+	done         chan struct{}
+}
+
+func answerOK(string) string { return "<ok/>" }
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+	closers []io.Closer
+}
+
+func (c *pipeConn) Close() error {
+	for _, closer := range c.closers {
+		closer.Close()
+	}
+	return nil
+}
+
+func newTestSession(t *testing.T, server *testServer) (*Session, *testServer) {
+	t.Helper()
+
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+	server.conn = &pipeConn{
+		Reader:      serverRead,
+		WriteCloser: serverWrite,
+		closers:     []io.Closer{serverRead, serverWrite},
+	}
+	server.done = make(chan struct{})
+
+	ready := make(chan error, 1)
+	go server.serve(ready)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	session, err := NewSession(ctx, clientRead, clientWrite)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	if err := <-ready; err != nil {
+		t.Fatalf("server hello: %v", err)
+	}
+	t.Cleanup(func() { server.conn.Close() })
+	return session, server
+}
+
+func (s *testServer) serve(ready chan<- error) {
+	defer close(s.done)
+	tr := NewTransport(s.conn, s.conn)
+
+	msg, err := tr.ReadMessage()
+	if err == nil {
+		err = xml.Unmarshal(msg, &s.clientHello)
+	}
+	if err == nil {
+		hello, _ := xml.Marshal(Hello{Xmlns: Namespace, Capabilities: s.capabilities, SessionID: 42})
+		err = tr.WriteMessage(hello)
+	}
+	ready <- err
+	if err != nil {
+		return
+	}
+	if hasCapability(s.clientHello.Capabilities, CapabilityV11) && hasCapability(s.capabilities, CapabilityV11) {
+		tr.SetChunked()
+	}
+
+	var held []string
+	for {
+		msg, err := tr.ReadMessage()
+		if err != nil {
+			return
+		}
+		var rpc struct {
+			XMLName   xml.Name `xml:"rpc"`
+			MessageID string   `xml:"message-id,attr"`
+			Inner     string   `xml:",innerxml"`
+		}
+		if err := xml.Unmarshal(msg, &rpc); err != nil {
+			return
+		}
+
+		body := "<echo>" + rpc.Inner + "</echo>"
+		if s.answer != nil {
+			body = s.answer(rpc.Inner)
+		}
+		reply := fmt.Sprintf(`<rpc-reply xmlns="%s" message-id="%s">%s</rpc-reply>`, Namespace, rpc.MessageID, body)
+
+		if rpc.Inner == "<close-session/>" {
+			s.closeSession = true
+			_ = tr.WriteMessage([]byte(reply))
+			return
+		}
+		if s.reverse == 0 {
+			if err := tr.WriteMessage([]byte(reply)); err != nil {
+				return
+			}
+			continue
+		}
+		held = append(held, reply)
+		if len(held) == s.reverse {
+			for i := len(held) - 1; i >= 0; i-- {
+				if err := tr.WriteMessage([]byte(held[i])); err != nil {
+					return
+				}
+			}
+			held = nil
+		}
+	}
+}
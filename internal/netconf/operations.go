@@ -0,0 +1,256 @@
+package netconf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Configuration datastores (RFC 6241 section 5.1)
+const (
+	Running   = "running"
+	Candidate = "candidate"
+	Startup   = "startup"
+)
+
+// Filter selects part of the data returned by Get and GetConfig.
+// At most one of Subtree and XPath may be set.
+type Filter struct {
+	// Subtree is the XML content of a subtree filter
+	Subtree string
+	// XPath is an XPath expression, it requires the :xpath capability
+	XPath string
+}
+
+// EditConfigOptions are the parameters of an edit-config operation
+type EditConfigOptions struct {
+	Target string
+	// DefaultOperation is merge, replace or none
+	DefaultOperation string
+	// TestOption is test-then-set, set or test-only
+	TestOption string
+	// ErrorOption is stop-on-error, continue-on-error or rollback-on-error
+	ErrorOption string
+	// Config is the configuration, with or without the <config> element
+	Config string
+}
+
+// CommitOptions are the parameters of a commit operation (RFC 6241 section 8.4)
+type CommitOptions struct {
+	Confirmed bool
+	// ConfirmTimeout is the confirmed commit timeout in seconds, 0 uses the device default
+	ConfirmTimeout uint32
+	// Persist makes the confirmed commit survive the end of the session
+	Persist string
+	// PersistID confirms a confirmed commit made with this persist token
+	PersistID string
+}
+
+// Get returns a <get> operation
+func Get(filter Filter) (string, error) {
+	f, err := filter.element()
+	if err != nil {
+		return "", err
+	}
+	return "<get>" + f + "</get>", nil
+}
+
+// GetConfig returns a <get-config> operation on source
+func GetConfig(source string, filter Filter) (string, error) {
+	ds, err := datastore("source", source)
+	if err != nil {
+		return "", err
+	}
+	f, err := filter.element()
+	if err != nil {
+		return "", err
+	}
+	return "<get-config>" + ds + f + "</get-config>", nil
+}
+
+// EditConfig returns an <edit-config> operation
+func EditConfig(opts EditConfigOptions) (string, error) {
+	ds, err := datastore("target", opts.Target)
+	if err != nil {
+		return "", err
+	}
+	config := strings.TrimSpace(opts.Config)
+	if config == "" {
+		return "", fmt.Errorf("edit-config requires a configuration")
+	}
+	if err := checkXML(config); err != nil {
+		return "", fmt.Errorf("invalid configuration: %w", err)
+	}
+	if rootElement(config) != "config" {
+		config = "<config>" + config + "</config>"
+	}
+
+	var b strings.Builder
+	b.WriteString("<edit-config>")
+	b.WriteString(ds)
+	for _, option := range []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"default-operation", opts.DefaultOperation, []string{"merge", "replace", "none"}},
+		{"test-option", opts.TestOption, []string{"test-then-set", "set", "test-only"}},
+		{"error-option", opts.ErrorOption, []string{"stop-on-error", "continue-on-error", "rollback-on-error"}},
+	} {
+		if option.value == "" {
+			continue
+		}
+		if !contains(option.allowed, option.value) {
+			return "", fmt.Errorf("invalid %s %q, must be one of %s", option.name, option.value, strings.Join(option.allowed, ", "))
+		}
+		fmt.Fprintf(&b, "<%s>%s</%s>", option.name, option.value, option.name)
+	}
+	b.WriteString(config)
+	b.WriteString("</edit-config>")
+	return b.String(), nil
+}
+
+// Lock returns a <lock> operation on target
+func Lock(target string) (string, error) {
+	ds, err := datastore("target", target)
+	if err != nil {
+		return "", err
+	}
+	return "<lock>" + ds + "</lock>", nil
+}
+
+// Unlock returns an <unlock> operation on target
+func Unlock(target string) (string, error) {
+	ds, err := datastore("target", target)
+	if err != nil {
+		return "", err
+	}
+	return "<unlock>" + ds + "</unlock>", nil
+}
+
+// Commit returns a <commit> operation
+func Commit(opts CommitOptions) (string, error) {
+	if !opts.Confirmed && (opts.ConfirmTimeout > 0 || opts.Persist != "") {
+		return "", fmt.Errorf("confirm-timeout and persist require a confirmed commit")
+	}
+
+	var b strings.Builder
+	b.WriteString("<commit>")
+	if opts.Confirmed {
+		b.WriteString("<confirmed/>")
+		if opts.ConfirmTimeout > 0 {
+			b.WriteString("<confirm-timeout>" + strconv.FormatUint(uint64(opts.ConfirmTimeout), 10) + "</confirm-timeout>")
+		}
+		if opts.Persist != "" {
+			b.WriteString("<persist>" + escape(opts.Persist) + "</persist>")
+		}
+	}
+	if opts.PersistID != "" {
+		b.WriteString("<persist-id>" + escape(opts.PersistID) + "</persist-id>")
+	}
+	b.WriteString("</commit>")
+	return b.String(), nil
+}
+
+// CancelCommit returns a <cancel-commit> operation. persistID is required to
+// cancel a confirmed commit made by another session.
+func CancelCommit(persistID string) string {
+	if persistID == "" {
+		return "<cancel-commit/>"
+	}
+	return "<cancel-commit><persist-id>" + escape(persistID) + "</persist-id></cancel-commit>"
+}
+
+// Validate returns a <validate> operation on source
+func Validate(source string) (string, error) {
+	ds, err := datastore("source", source)
+	if err != nil {
+		return "", err
+	}
+	return "<validate>" + ds + "</validate>", nil
+}
+
+// DiscardChanges returns a <discard-changes> operation
+func DiscardChanges() string {
+	return "<discard-changes/>"
+}
+
+// element returns the <filter> element, or nothing for an empty filter
+func (f Filter) element() (string, error) {
+	switch {
+	case f.Subtree != "" && f.XPath != "":
+		return "", fmt.Errorf("filter may be subtree or xpath, not both")
+	case f.Subtree != "":
+		if err := checkXML(f.Subtree); err != nil {
+			return "", fmt.Errorf("invalid subtree filter: %w", err)
+		}
+		return `<filter type="subtree">` + f.Subtree + "</filter>", nil
+	case f.XPath != "":
+		return `<filter type="xpath" select="` + escape(f.XPath) + `"/>`, nil
+	}
+	return "", nil
+}
+
+// datastore returns the element selecting datastore as source or target
+func datastore(element, name string) (string, error) {
+	switch name {
+	case Running, Candidate, Startup:
+		return fmt.Sprintf("<%s><%s/></%s>", element, name, element), nil
+	}
+	return "", fmt.Errorf("invalid %s datastore %q, must be running, candidate or startup", element, name)
+}
+
+// checkXML reports whether fragment is well-formed XML that stays inside
+// the element it is placed in, so a bad filter or configuration is rejected
+// instead of breaking the rpc around it
+func checkXML(fragment string) error {
+	if strings.Contains(fragment, endOfMessage) {
+		return fmt.Errorf("contains the %s delimiter", endOfMessage)
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(fragment))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := token.(xml.ProcInst); ok {
+			return fmt.Errorf("unexpected processing instruction")
+		}
+	}
+}
+
+// rootElement returns the local name of the first element of fragment
+func rootElement(fragment string) string {
+	decoder := xml.NewDecoder(strings.NewReader(fragment))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Base capabilities and namespace (RFC 6241)
+const (
+	Namespace     = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	CapabilityV10 = "urn:ietf:params:netconf:base:1.0"
+	CapabilityV11 = "urn:ietf:params:netconf:base:1.1"
+)
+
+// closeTimeout bounds the wait for the close-session reply
+const closeTimeout = 5 * time.Second
+
+// ErrSessionClosed is returned for RPCs on a session whose transport is gone
+var ErrSessionClosed = errors.New("NETCONF session closed")
+
+// Hello is the capabilities exchange message
+type Hello struct {
+	XMLName      xml.Name `xml:"hello"`
+	Xmlns        string   `xml:"xmlns,attr,omitempty"`
+	Capabilities []string `xml:"capabilities>capability"`
+	SessionID    uint64   `xml:"session-id,omitempty"`
+}
+
+// Reply is a successful rpc-reply
+type Reply struct {
+	MessageID string
+	// Payload is the content of the rpc-reply element, such as <data> or <ok/>
+	Payload []byte
+	// Data is the content of the <data> element, if any
+	Data []byte
+	// OK is set for replies carrying <ok/>
+	OK bool
+	// Warnings are the rpc-error elements with severity warning
+	Warnings RPCErrors
+}
+
+// RPCError is an rpc-error reported by the server (RFC 6241 section 4.3)
+type RPCError struct {
+	Type     string
+	Tag      string
+	Severity string
+	AppTag   string
+	Path     string
+	Message  string
+	// Info is the raw content of error-info
+	Info []byte
+}
+
+func (e RPCError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Type, e.Severity, e.Tag)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Path != "" {
+		msg += " (" + e.Path + ")"
+	}
+	return msg
+}
+
+// RPCErrors is the list of rpc-error elements of a failed rpc-reply
+type RPCErrors []RPCError
+
+func (e RPCErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// rpcReply is the wire form of an rpc-reply
+type rpcReply struct {
+	XMLName   xml.Name `xml:"rpc-reply"`
+	MessageID string   `xml:"message-id,attr"`
+	Errors    []struct {
+		Type     string `xml:"error-type"`
+		Tag      string `xml:"error-tag"`
+		Severity string `xml:"error-severity"`
+		AppTag   string `xml:"error-app-tag"`
+		Path     string `xml:"error-path"`
+		Message  string `xml:"error-message"`
+		Info     struct {
+			Inner []byte `xml:",innerxml"`
+		} `xml:"error-info"`
+	} `xml:"rpc-error"`
+	OK   *struct{} `xml:"ok"`
+	Data *struct {
+		Inner []byte `xml:",innerxml"`
+	} `xml:"data"`
+	Inner []byte `xml:",innerxml"`
+}
+
+// Session is a client NETCONF session. RPCs may be issued concurrently;
+// replies are matched to requests by message-id.
+type Session struct {
+	transport *Transport
+	closer    io.Closer
+
+	// ServerCapabilities are the capabilities announced by the server
+	ServerCapabilities []string
+	// SessionID is the session-id assigned by the server
+	SessionID uint64
+
+	nextID  uint64
+	pending map[string]chan result
+	err     error
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+type result struct {
+	reply *rpcReply
+	err   error
+}
+
+// NewSession exchanges hello messages over r and w and starts reading
+// replies. base:1.1 chunked framing is used when the server supports it.
+// w is closed by Close.
+func NewSession(ctx context.Context, r io.Reader, w io.WriteCloser) (*Session, error) {
+	s := &Session{
+		transport: NewTransport(r, w),
+		closer:    w,
+		pending:   make(map[string]chan result),
+		done:      make(chan struct{}),
+	}
+
+	hello, err := xml.Marshal(Hello{Xmlns: Namespace, Capabilities: []string{CapabilityV10, CapabilityV11}})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.transport.WriteMessage(append([]byte(xml.Header), hello...)); err != nil {
+		return nil, fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	type helloResult struct {
+		hello Hello
+		err   error
+	}
+	received := make(chan helloResult, 1)
+	go func() {
+		var res helloResult
+		msg, err := s.transport.ReadMessage()
+		if err != nil {
+			res.err = fmt.Errorf("failed to read server hello: %w", err)
+		} else if err := xml.Unmarshal(msg, &res.hello); err != nil {
+			res.err = fmt.Errorf("invalid server hello: %w", err)
+		}
+		received <- res
+	}()
+
+	var res helloResult
+	select {
+	case res = <-received:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for server hello: %w", ctx.Err())
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	s.ServerCapabilities = res.hello.Capabilities
+	s.SessionID = res.hello.SessionID
+	if s.HasCapability(CapabilityV11) {
+		s.transport.SetChunked()
+	}
+
+	go s.readReplies()
+	return s, nil
+}
+
+// HasCapability reports whether the server announced capability.
+// Parameters after "?" are ignored.
+func (s *Session) HasCapability(capability string) bool {
+	for _, c := range s.ServerCapabilities {
+		c = strings.TrimSpace(c)
+		if i := strings.IndexByte(c, '?'); i >= 0 {
+			c = c[:i]
+		}
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Exec sends operation, the XML content of an <rpc> element, and waits for
+// its reply. A reply with rpc-error elements of severity error is returned
+// as RPCErrors.
+func (s *Session) Exec(ctx context.Context, operation string) (*Reply, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.nextID++
+	id := strconv.FormatUint(s.nextID, 10)
+	replyChan := make(chan result, 1)
+	s.pending[id] = replyChan
+	s.mu.Unlock()
+
+	msg := fmt.Sprintf(`%s<rpc message-id="%s" xmlns="%s">%s</rpc>`, xml.Header, id, Namespace, operation)
+	if err := s.transport.WriteMessage([]byte(msg)); err != nil {
+		s.forget(id)
+		return nil, fmt.Errorf("failed to send rpc: %w", err)
+	}
+
+	var res result
+	select {
+	case res = <-replyChan:
+	case <-ctx.Done():
+		s.forget(id)
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	return newReply(res.reply)
+}
+
+// Close sends close-session and closes the transport
+func (s *Session) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	_, err := s.Exec(ctx, "<close-session/>")
+	if errors.Is(err, ErrSessionClosed) {
+		err = nil
+	}
+	// Servers usually close the channel after close-session
+	if cerr := s.closer.Close(); err == nil && !errors.Is(cerr, io.EOF) {
+		err = cerr
+	}
+	return err
+}
+
+// Done is closed when the transport is gone
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) forget(id string) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	s.mu.Unlock()
+}
+
+// readReplies dispatches replies to waiting RPCs until the transport fails
+func (s *Session) readReplies() {
+	var err error
+	for {
+		var msg []byte
+		if msg, err = s.transport.ReadMessage(); err != nil {
+			break
+		}
+
+		reply := &rpcReply{}
+		if uerr := xml.Unmarshal(msg, reply); uerr != nil {
+			// Notifications and other messages are not replies
+			continue
+		}
+
+		s.mu.Lock()
+		replyChan, ok := s.pending[reply.MessageID]
+		delete(s.pending, reply.MessageID)
+		s.mu.Unlock()
+		if ok {
+			replyChan <- result{reply: reply}
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = ErrSessionClosed
+	} else {
+		err = fmt.Errorf("%w: %v", ErrSessionClosed, err)
+	}
+
+	s.mu.Lock()
+	s.err = err
+	for id, replyChan := range s.pending {
+		replyChan <- result{err: err}
+		delete(s.pending, id)
+	}
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// newReply converts a wire reply, returning its rpc-error elements as errors
+func newReply(r *rpcReply) (*Reply, error) {
+	reply := &Reply{
+		MessageID: r.MessageID,
+		Payload:   bytes.TrimSpace(r.Inner),
+		OK:        r.OK != nil,
+	}
+	if r.Data != nil {
+		reply.Data = bytes.TrimSpace(r.Data.Inner)
+	}
+
+	var errs RPCErrors
+	for _, e := range r.Errors {
+		rpcErr := RPCError{
+			Type:     strings.TrimSpace(e.Type),
+			Tag:      strings.TrimSpace(e.Tag),
+			Severity: strings.TrimSpace(e.Severity),
+			AppTag:   strings.TrimSpace(e.AppTag),
+			Path:     strings.TrimSpace(e.Path),
+			Message:  strings.TrimSpace(e.Message),
+			Info:     bytes.TrimSpace(e.Info.Inner),
+		}
+		if rpcErr.Severity == "warning" {
+			reply.Warnings = append(reply.Warnings, rpcErr)
+			continue
+		}
+		errs = append(errs, rpcErr)
+	}
+	if len(errs) > 0 {
+		return reply, errs
+	}
+	return reply, nil
+}
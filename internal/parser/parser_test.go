@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/parser_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+const srlShowVersion = `--------------------------------------------------------------------
+Hostname             : srl1
+Chassis Type         : 7220 IXR-D2L
+Part Number          : Sim Part No.
+Serial Number        : Sim Serial No.
+System HW MAC Address: 1A:B0:00:FF:00:00
+OS                   : SR Linux
+Software Version     : v24.10.1
+Build Number         : 492-gf8858c5836
+Architecture         : x86_64
+Last Booted          : 2024-11-26T08:57:56.802Z
+Total Memory         : 24052875 kB
+Free Memory          : 15269231 kB
+--------------------------------------------------------------------
+`
+
+const srlInterfaceBrief = `+---------------------+------------+-------------+-------+------+
+|        Port         | Admin State| Oper State  | Speed | Type |
++=====================+============+=============+=======+======+
+| ethernet-1/1        | enable     | up          | 25G   |      |
+| ethernet-1/2        | enable     | down        | 25G   |      |
+| mgmt0               | enable     | up          | 1G    |      |
++---------------------+------------+-------------+-------+------+
+`
+
+func TestShippedTemplates(t *testing.T) {
+	registry, err := NewRegistry("../../templates")
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+	defer registry.Close()
+
+	result, err := registry.Parse("nokia_srl", "show version", srlShowVersion)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(result.Rows))
+	}
+	row := result.Maps()[0]
+	if row["Hostname"] != "srl1" || row["SoftwareVersion"] != "v24.10.1" || row["ChassisType"] != "7220 IXR-D2L" {
+		t.Errorf("Unexpected row: %v", row)
+	}
+
+	result, err = registry.Parse("nokia_srl", "show interface brief", srlInterfaceBrief)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(result.Rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d: %v", len(result.Rows), result.Rows)
+	}
+	if got := result.Maps()[1]; got["Port"] != "ethernet-1/2" || got["OperState"] != "down" {
+		t.Errorf("Unexpected row: %v", got)
+	}
+}
+
+func TestTemplateOptions(t *testing.T) {
+	tmpl := `Value Filldown Chassis (\S+)
+Value Required Slot (\d+)
+Value List Ports (\S+)
+
+Start
+  ^Chassis -> Continue.Record
+  ^Chassis ${Chassis}
+  ^Slot -> Continue.Record
+  ^Slot ${Slot}
+  ^  port ${Ports}
+`
+	text := `Chassis A
+Slot 1
+  port p1
+  port p2
+Slot 2
+Chassis B
+  port orphan
+Slot 3
+  port p3
+`
+
+	parsed, err := ParseTemplate("options", tmpl)
+	if err != nil {
+		t.Fatalf("ParseTemplate failed: %v", err)
+	}
+
+	result, err := parsed.Parse(text)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// "port orphan" is dropped because no Slot was set when the row was recorded
+	want := [][]interface{}{
+		{"A", "1", []string{"p1", "p2"}},
+		{"A", "2", []string{}},
+		{"B", "3", []string{"p3"}},
+	}
+	if !reflect.DeepEqual(result.Rows, want) {
+		t.Errorf("Rows: got %v, want %v", result.Rows, want)
+	}
+	if !reflect.DeepEqual(result.Header, []string{"Chassis", "Slot", "Ports"}) {
+		t.Errorf("Header: got %v", result.Header)
+	}
+}
+
+func TestTemplateStatesAndErrors(t *testing.T) {
+	tmpl := `Value Name (\S+)
+
+Start
+  ^BEGIN -> Body
+
+Body
+  ^item ${Name} -> Record
+  ^FAIL -> Error "unexpected failure"
+  ^END -> End
+`
+
+	parsed, err := ParseTemplate("states", tmpl)
+	if err != nil {
+		t.Fatalf("ParseTemplate failed: %v", err)
+	}
+
+	result, err := parsed.Parse("item ignored\nBEGIN\nitem a\nitem b\nEND\nitem c\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !reflect.DeepEqual(result.Rows, [][]interface{}{{"a"}, {"b"}}) {
+		t.Errorf("Rows: got %v", result.Rows)
+	}
+
+	_, err = parsed.Parse("BEGIN\nFAIL\n")
+	if err == nil || !strings.Contains(err.Error(), "unexpected failure") {
+		t.Errorf("Expected Error action to fail parsing, got %v", err)
+	}
+}
+
+func TestParseTemplate_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		tmpl string
+	}{
+		{"No values", "Start\n  ^foo\n"},
+		{"No Start state", "Value A (\\S+)\n\nOther\n  ^${A}\n"},
+		{"Undefined value", "Value A (\\S+)\n\nStart\n  ^${B}\n"},
+		{"Undefined state", "Value A (\\S+)\n\nStart\n  ^${A} -> Missing\n"},
+		{"Continue with state", "Value A (\\S+)\n\nStart\n  ^${A} -> Continue Other\n\nOther\n  ^x\n"},
+		{"Unknown option", "Value Sometimes A (\\S+)\n\nStart\n  ^${A}\n"},
+		{"Bad regex", "Value A ([)\n\nStart\n  ^${A}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTemplate(tt.name, tt.tmpl); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestRegistryLookupAndReload(t *testing.T) {
+	dir := t.TempDir()
+	platformDir := filepath.Join(dir, "acme")
+	if err := os.MkdirAll(platformDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := "Value Name (\\S+)\n\nStart\n  ^name ${Name}\n"
+	if err := os.WriteFile(filepath.Join(platformDir, "show_interface.textfsm"), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewRegistry failed: %v", err)
+	}
+	defer registry.Close()
+
+	found, err := registry.Lookup("acme", "Show  Interface ethernet-1/1")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if found.Name != filepath.Join("acme", "show_interface.textfsm") {
+		t.Errorf("Template name: got %s", found.Name)
+	}
+
+	if _, err := registry.Lookup("acme", "show version"); err == nil {
+		t.Error("Expected missing template error")
+	}
+	if _, err := registry.Lookup("other", "show interface"); err == nil {
+		t.Error("Expected missing platform error")
+	}
+
+	// Add a template and reload explicitly
+	if err := os.WriteFile(filepath.Join(platformDir, "show_version.textfsm"), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := registry.Lookup("acme", "show version"); err != nil {
+		t.Errorf("Lookup after reload failed: %v", err)
+	}
+}
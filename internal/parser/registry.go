@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// TemplateExt is the file extension of template files
+const TemplateExt = ".textfsm"
+
+// Registry holds the templates loaded from a directory laid out as
+// <dir>/<platform>/<command>.textfsm, e.g. templates/nokia_srl/show_version.textfsm
+type Registry struct {
+	dir       string
+	templates map[string]map[string]*Template // platform -> command key -> template
+	watcher   *fsnotify.Watcher
+	mu        sync.RWMutex
+}
+
+// NewRegistry loads all templates from dir and watches it for changes
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{
+		dir:       dir,
+		templates: make(map[string]map[string]*Template),
+	}
+
+	if err := r.Load(); err != nil {
+		return nil, err
+	}
+
+	if err := r.watch(); err != nil {
+		logger.Log.WithError(err).Warn("Failed to start template watcher, dynamic updates disabled")
+	}
+
+	return r, nil
+}
+
+// Load (re)loads every template in the registry directory.
+// Templates that fail to compile are skipped and logged.
+func (r *Registry) Load() error {
+	platforms, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read template directory: %w", err)
+	}
+
+	newTemplates := make(map[string]map[string]*Template)
+	count := 0
+
+	for _, platform := range platforms {
+		if !platform.IsDir() || strings.HasPrefix(platform.Name(), ".") {
+			continue
+		}
+
+		platformDir := filepath.Join(r.dir, platform.Name())
+		files, err := os.ReadDir(platformDir)
+		if err != nil {
+			logger.Log.WithError(err).Warnf("Failed to read template directory %s", platformDir)
+			continue
+		}
+
+		for _, file := range files {
+			if file.IsDir() || filepath.Ext(file.Name()) != TemplateExt {
+				continue
+			}
+
+			path := filepath.Join(platformDir, file.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Log.WithError(err).Warnf("Failed to read template %s", path)
+				continue
+			}
+
+			name := filepath.Join(platform.Name(), file.Name())
+			tmpl, err := ParseTemplate(name, string(data))
+			if err != nil {
+				logger.Log.WithError(err).Warnf("Failed to compile template %s", path)
+				continue
+			}
+
+			key := strings.TrimSuffix(file.Name(), TemplateExt)
+			if newTemplates[platform.Name()] == nil {
+				newTemplates[platform.Name()] = make(map[string]*Template)
+			}
+			newTemplates[platform.Name()][key] = tmpl
+			count++
+		}
+	}
+
+	// Thread-safe update of templates
+	r.mu.Lock()
+	r.templates = newTemplates
+	r.mu.Unlock()
+
+	logger.Log.Infof("Loaded %d output templates for %d platforms", count, len(newTemplates))
+	return nil
+}
+
+// Lookup finds the template for a platform and command.
+// The longest run of leading command words with a template wins, so
+// "show interface ethernet-1/1" falls back to show_interface.textfsm.
+func (r *Registry) Lookup(platform, command string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byCommand, ok := r.templates[platform]
+	if !ok {
+		return nil, fmt.Errorf("no templates for platform %q", platform)
+	}
+
+	words := strings.Fields(strings.ToLower(command))
+	for n := len(words); n > 0; n-- {
+		if tmpl, ok := byCommand[strings.Join(words[:n], "_")]; ok {
+			return tmpl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no template for command %q on platform %q", command, platform)
+}
+
+// Parse parses command output with the template matching platform and command
+func (r *Registry) Parse(platform, command, text string) (*Result, error) {
+	tmpl, err := r.Lookup(platform, command)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Parse(text)
+}
+
+// watch reloads the templates when files in the directory tree change
+func (r *Registry) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	r.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+					logger.Log.Info("Output templates changed, reloading...")
+					if err := r.Load(); err != nil {
+						logger.Log.WithError(err).Error("Failed to reload output templates")
+					}
+					// New platform directories need their own watch
+					r.addWatches()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Log.WithError(err).Error("Template watcher error")
+			}
+		}
+	}()
+
+	if err := watcher.Add(r.dir); err != nil {
+		return fmt.Errorf("failed to watch directory %s: %w", r.dir, err)
+	}
+	r.addWatches()
+
+	logger.Log.Infof("Watching for output template changes in: %s", r.dir)
+	return nil
+}
+
+// addWatches adds every platform directory to the watcher
+func (r *Registry) addWatches() {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			_ = r.watcher.Add(filepath.Join(r.dir, entry.Name()))
+		}
+	}
+}
+
+// Close stops watching the template directory
+func (r *Registry) Close() error {
+	if r.watcher != nil {
+		return r.watcher.Close()
+	}
+	return nil
+}
@@ -0,0 +1,464 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Value options supported in template Value lines
+const (
+	optionFilldown = "Filldown"
+	optionKey      = "Key"
+	optionRequired = "Required"
+	optionList     = "List"
+	optionFillup   = "Fillup"
+)
+
+var (
+	valueLineRe   = regexp.MustCompile(`^Value\s+(?:([A-Za-z,]+)\s+)?([A-Za-z0-9_]+)\s+(\(.*\))\s*$`)
+	stateNameRe   = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	opsRe         = regexp.MustCompile(`^(?:(Next|Continue)(?:\.(NoRecord|Record|Clear|Clearall))?|(NoRecord|Record|Clear|Clearall))$`)
+	errorActionRe = regexp.MustCompile(`^Error(?:\s+(?:"([^"]*)"|(\S+)))?$`)
+	valueRefRe    = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+)
+
+// templateValue is a Value declared at the top of a template
+type templateValue struct {
+	name     string
+	regex    string
+	filldown bool
+	required bool
+	list     bool
+	fillup   bool
+}
+
+// rule is a single line of a template state
+type rule struct {
+	regex     *regexp.Regexp
+	source    string
+	lineOp    string
+	recordOp  string
+	newState  string
+	err       bool
+	errorText string
+}
+
+// Template is a compiled TextFSM template
+type Template struct {
+	Name   string
+	values []*templateValue
+	index  map[string]int
+	states map[string][]*rule
+}
+
+// Result holds the rows extracted by a template
+type Result struct {
+	Header []string
+	Rows   [][]interface{}
+}
+
+// Maps returns the rows as a list of objects keyed by value name
+func (r *Result) Maps() []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(r.Rows))
+	for _, row := range r.Rows {
+		m := make(map[string]interface{}, len(r.Header))
+		for i, name := range r.Header {
+			m[name] = row[i]
+		}
+		rows = append(rows, m)
+	}
+	return rows
+}
+
+// ParseTemplate compiles TextFSM template text
+func ParseTemplate(name, text string) (*Template, error) {
+	t := &Template{
+		Name:   name,
+		index:  make(map[string]int),
+		states: make(map[string][]*rule),
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	lineNum := 0
+	inValues := true
+	var current string
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		if inValues {
+			if line == "" {
+				if len(t.values) > 0 {
+					inValues = false
+				}
+				continue
+			}
+			if !strings.HasPrefix(line, "Value ") {
+				return nil, fmt.Errorf("%s:%d: expected Value definition, got %q", name, lineNum, line)
+			}
+			if err := t.addValue(line); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err)
+			}
+			continue
+		}
+
+		if line == "" {
+			current = ""
+			continue
+		}
+
+		// State names start in the first column, rules are indented
+		if line[0] != ' ' && line[0] != '\t' {
+			if !stateNameRe.MatchString(line) {
+				return nil, fmt.Errorf("%s:%d: invalid state name %q", name, lineNum, line)
+			}
+			if _, exists := t.states[line]; exists {
+				return nil, fmt.Errorf("%s:%d: duplicate state %q", name, lineNum, line)
+			}
+			current = line
+			t.states[current] = nil
+			continue
+		}
+
+		if current == "" {
+			return nil, fmt.Errorf("%s:%d: rule outside of a state", name, lineNum)
+		}
+
+		r, err := t.parseRule(strings.TrimSpace(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, lineNum, err)
+		}
+		t.states[current] = append(t.states[current], r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(t.values) == 0 {
+		return nil, fmt.Errorf("%s: template defines no values", name)
+	}
+	if _, ok := t.states["Start"]; !ok {
+		return nil, fmt.Errorf("%s: template has no Start state", name)
+	}
+	for stateName, rules := range t.states {
+		for _, r := range rules {
+			if r.newState == "" || r.newState == "End" || r.newState == "EOF" {
+				continue
+			}
+			if _, ok := t.states[r.newState]; !ok {
+				return nil, fmt.Errorf("%s: state %s references undefined state %q", name, stateName, r.newState)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// addValue parses a Value line
+func (t *Template) addValue(line string) error {
+	m := valueLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return fmt.Errorf("invalid Value definition %q", line)
+	}
+
+	v := &templateValue{name: m[2], regex: m[3]}
+	if _, exists := t.index[v.name]; exists {
+		return fmt.Errorf("duplicate value %q", v.name)
+	}
+
+	if m[1] != "" {
+		for _, opt := range strings.Split(m[1], ",") {
+			switch opt {
+			case optionFilldown:
+				v.filldown = true
+			case optionKey:
+				// Key only documents which values identify a row
+			case optionRequired:
+				v.required = true
+			case optionList:
+				v.list = true
+			case optionFillup:
+				v.fillup = true
+			default:
+				return fmt.Errorf("unknown option %q for value %s", opt, v.name)
+			}
+		}
+	}
+
+	if _, err := regexp.Compile(v.regex); err != nil {
+		return fmt.Errorf("invalid regex for value %s: %w", v.name, err)
+	}
+
+	t.index[v.name] = len(t.values)
+	t.values = append(t.values, v)
+	return nil
+}
+
+// parseRule parses a rule line such as `^Name\s+${Name} -> Record Next`
+func (t *Template) parseRule(line string) (*rule, error) {
+	if !strings.HasPrefix(line, "^") {
+		return nil, fmt.Errorf("rule must start with '^': %q", line)
+	}
+
+	r := &rule{lineOp: "Next", recordOp: "NoRecord"}
+	pattern := line
+	if idx := strings.LastIndex(line, " -> "); idx >= 0 {
+		pattern = strings.TrimSpace(line[:idx])
+		action := strings.TrimSpace(line[idx+4:])
+
+		if m := errorActionRe.FindStringSubmatch(action); m != nil {
+			r.err = true
+			r.errorText = m[1] + m[2]
+		} else if err := r.parseAction(action); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing string
+	expanded := valueRefRe.ReplaceAllStringFunc(pattern, func(ref string) string {
+		name := valueRefRe.FindStringSubmatch(ref)[1]
+		i, ok := t.index[name]
+		if !ok {
+			missing = name
+			return ref
+		}
+		inner := t.values[i].regex
+		return "(?P<" + name + ">" + inner[1:len(inner)-1] + ")"
+	})
+	if missing != "" {
+		return nil, fmt.Errorf("rule references undefined value %q", missing)
+	}
+	expanded = strings.ReplaceAll(expanded, "$$", "$")
+
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule regex %q: %w", pattern, err)
+	}
+	r.regex = re
+	r.source = pattern
+	return r, nil
+}
+
+// parseAction parses the part of a rule after "->": [LineOp[.RecordOp]|RecordOp] [NewState]
+func (r *rule) parseAction(action string) error {
+	fields := strings.Fields(action)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("invalid action %q", action)
+	}
+
+	m := opsRe.FindStringSubmatch(fields[0])
+	switch {
+	case m == nil && len(fields) == 1 && stateNameRe.MatchString(fields[0]):
+		r.newState = fields[0]
+		return nil
+	case m == nil:
+		return fmt.Errorf("invalid action %q", action)
+	}
+
+	if m[1] != "" {
+		r.lineOp = m[1]
+	}
+	if m[2] != "" {
+		r.recordOp = m[2]
+	}
+	if m[3] != "" {
+		r.recordOp = m[3]
+	}
+
+	if len(fields) == 2 {
+		if !stateNameRe.MatchString(fields[1]) {
+			return fmt.Errorf("invalid state name in action %q", action)
+		}
+		if r.lineOp == "Continue" {
+			return fmt.Errorf("a Continue action cannot change state: %q", action)
+		}
+		r.newState = fields[1]
+	}
+	return nil
+}
+
+// Header returns the value names in declaration order
+func (t *Template) Header() []string {
+	header := make([]string, len(t.values))
+	for i, v := range t.values {
+		header[i] = v.name
+	}
+	return header
+}
+
+// fsm holds the state of a single parse run
+type fsm struct {
+	t       *Template
+	current []interface{}
+	rows    [][]interface{}
+}
+
+// Parse runs the template against text and returns the extracted rows
+func (t *Template) Parse(text string) (*Result, error) {
+	f := &fsm{t: t, current: make([]interface{}, len(t.values))}
+	f.clearAll()
+
+	state := "Start"
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+lines:
+	for lineNum, line := range lines {
+		for _, r := range t.states[state] {
+			idx := r.regex.FindStringSubmatchIndex(line)
+			if idx == nil {
+				continue
+			}
+
+			for i, groupName := range r.regex.SubexpNames() {
+				if groupName == "" {
+					continue
+				}
+				vi, ok := t.index[groupName]
+				if !ok {
+					continue
+				}
+				if idx[2*i] < 0 {
+					f.assign(vi, nil)
+				} else {
+					f.assign(vi, line[idx[2*i]:idx[2*i+1]])
+				}
+			}
+
+			if r.err {
+				msg := r.errorText
+				if msg == "" {
+					msg = "state error raised"
+				}
+				return nil, fmt.Errorf("%s: %s (rule %q, line %d: %q)", t.Name, msg, r.source, lineNum+1, line)
+			}
+
+			switch r.recordOp {
+			case "Record":
+				f.record()
+			case "Clear":
+				f.clear()
+			case "Clearall":
+				f.clearAll()
+			}
+
+			if r.lineOp == "Continue" {
+				continue
+			}
+
+			if r.newState != "" {
+				state = r.newState
+				if state == "End" || state == "EOF" {
+					break lines
+				}
+			}
+			break
+		}
+	}
+
+	// Implicit EOF state records the last row unless the template overrides it
+	if _, hasEOF := t.states["EOF"]; state != "End" && !hasEOF {
+		f.record()
+	}
+
+	return &Result{Header: t.Header(), Rows: f.rows}, nil
+}
+
+// assign sets the current value at index i, a nil value means the group did not match
+func (f *fsm) assign(i int, value interface{}) {
+	v := f.t.values[i]
+	if v.list {
+		if value != nil {
+			f.current[i] = append(f.current[i].([]string), value.(string))
+		}
+		return
+	}
+
+	if value == nil {
+		f.current[i] = ""
+		return
+	}
+	f.current[i] = value
+
+	if v.fillup {
+		for r := len(f.rows) - 1; r >= 0; r-- {
+			if f.rows[r][i] != "" {
+				break
+			}
+			f.rows[r][i] = value
+		}
+	}
+}
+
+// record appends the current row to the result if it satisfies the Required values
+func (f *fsm) record() {
+	empty := true
+	for i, v := range f.t.values {
+		if isEmpty(f.current[i]) {
+			if v.required {
+				f.clear()
+				return
+			}
+			continue
+		}
+		empty = false
+	}
+	if empty {
+		return
+	}
+
+	row := make([]interface{}, len(f.current))
+	for i, value := range f.current {
+		if list, ok := value.([]string); ok {
+			row[i] = append([]string{}, list...)
+		} else {
+			row[i] = value
+		}
+	}
+	f.rows = append(f.rows, row)
+	f.clear()
+}
+
+// clear resets all values except those marked Filldown
+func (f *fsm) clear() {
+	for i, v := range f.t.values {
+		if v.filldown {
+			continue
+		}
+		f.reset(i)
+	}
+}
+
+// clearAll resets every value
+func (f *fsm) clearAll() {
+	for i := range f.t.values {
+		f.reset(i)
+	}
+}
+
+func (f *fsm) reset(i int) {
+	if f.t.values[i].list {
+		f.current[i] = []string{}
+	} else {
+		f.current[i] = ""
+	}
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case []string:
+		return len(v) == 0
+	case string:
+		return v == ""
+	}
+	return value == nil
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/tracing"
+)
+
+// dialTimeout bounds name resolution and TCP connection setup to a device
+const dialTimeout = 30 * time.Second
+
+// dialTCP resolves hostname and connects to it, tracing each step separately
+// so slow DNS and slow connects can be told apart
+func dialTCP(ctx context.Context, hostname string, port int) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	hosts := []string{hostname}
+	if net.ParseIP(hostname) == nil {
+		resolveCtx, span := tracing.Start(ctx, "backend.resolve", attribute.String("net.peer.name", hostname))
+		addrs, err := net.DefaultResolver.LookupHost(resolveCtx, hostname)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		hosts = addrs
+	}
+
+	// Try each resolved address in order, as net.Dial does
+	var lastErr error
+	for _, host := range hosts {
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+		dialCtx, span := tracing.Start(ctx, "backend.dial", attribute.String("net.peer.address", address))
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(dialCtx, "tcp", address)
+		tracing.End(span, err)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// dialSSH connects to hostname and performs the SSH handshake and authentication
+func dialSSH(ctx context.Context, hostname string, port int, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dialTCP(ctx, hostname, port)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracing.Start(ctx, "ssh.handshake", attribute.String("ssh.user", config.User))
+	if config.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	tracing.End(span, err)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// newSession opens a session on client inside a "ssh.session" span
+func newSession(ctx context.Context, client *ssh.Client) (*ssh.Session, error) {
+	_, span := tracing.Start(ctx, "ssh.session")
+	session, err := client.NewSession()
+	tracing.End(span, err)
+	return session, err
+}
+
+// sessionChannel is a session channel with the requests the device sends on
+// it, such as exit-status
+type sessionChannel struct {
+	ssh.Channel
+	requests <-chan *ssh.Request
+}
+
+// newSessionChannel opens a raw session channel on client inside a
+// "ssh.session" span
+func newSessionChannel(ctx context.Context, client *ssh.Client) (*sessionChannel, error) {
+	_, span := tracing.Start(ctx, "ssh.session")
+	channel, requests, err := client.OpenChannel("session", nil)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionChannel{Channel: channel, requests: requests}, nil
+}
@@ -0,0 +1,367 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// defaultCertValidity is the lifetime of device certificates when
+// device_ca.validity is not set
+const defaultCertValidity = 5 * time.Minute
+
+// Keyring holds the private keys and the certificate authority the gateway
+// logs in to devices with. Devices are looked up by the address the gateway
+// dials, their SSH or NETCONF port. Keys and certificates only log in as the
+// username of the device's credential profile.
+type Keyring struct {
+	devices map[string]*deviceKeys
+
+	// ca signs certificates for certKey, a key generated at startup
+	ca           ssh.Signer
+	certKey      ssh.Signer
+	certValidity time.Duration
+	// certs are the issued certificates by principal, reused until they
+	// are close to expiry
+	certs map[string]ssh.Signer
+	mu    sync.Mutex
+	now   func() time.Time
+}
+
+// deviceKeys is how the gateway logs in to one device as username
+type deviceKeys struct {
+	username         string
+	signers          []ssh.Signer
+	certificate      bool
+	passwordFallback bool
+}
+
+var (
+	keyring   *Keyring
+	keyringMu sync.RWMutex
+)
+
+// SetKeyring makes device logins with a context from WithDeviceKeys use the
+// keys of k, nil logs in with passwords only
+func SetKeyring(k *Keyring) {
+	keyringMu.Lock()
+	keyring = k
+	keyringMu.Unlock()
+}
+
+func currentKeyring() *Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return keyring
+}
+
+// NewKeyring loads the device keys and the device CA of the configuration.
+// A device uses its own key, else the key of its credential profile.
+func NewKeyring(cfg *config.Config) (*Keyring, error) {
+	k := &Keyring{
+		devices: make(map[string]*deviceKeys),
+		certs:   make(map[string]ssh.Signer),
+		now:     time.Now,
+	}
+
+	if ca := cfg.Settings.DeviceCA; ca.PrivateKeyFile != "" {
+		signer, err := LoadPrivateKey(ca.PrivateKeyFile, ca.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("device CA: %w", err)
+		}
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+		}
+		certKey, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+		}
+		k.ca = signer
+		k.certKey = certKey
+		k.certValidity = time.Duration(ca.Validity) * time.Second
+		if k.certValidity <= 0 {
+			k.certValidity = defaultCertValidity
+		}
+	}
+
+	// Keys shared by several devices are only parsed once, decrypting a key
+	// is slow on purpose
+	loaded := make(map[string]ssh.Signer)
+	for name, device := range cfg.Devices {
+		profileName := device.Credentials
+		if profileName == "" {
+			profileName = "default"
+		}
+		profile := cfg.Settings.Credentials[profileName]
+
+		keyFile, passphraseFile := profile.PrivateKeyFile, profile.PassphraseFile
+		if device.PrivateKeyFile != "" {
+			keyFile, passphraseFile = device.PrivateKeyFile, device.PassphraseFile
+		}
+		if keyFile == "" && !profile.Certificate {
+			continue
+		}
+		if profile.Certificate && k.ca == nil {
+			return nil, fmt.Errorf("device %s: credentials %s use a certificate but device_ca is not set", name, profileName)
+		}
+		// The gateway's keys must not log in as any account a caller names
+		if profile.Username == "" {
+			return nil, fmt.Errorf("device %s: credentials %s log in with a key but set no username", name, profileName)
+		}
+
+		keys := &deviceKeys{
+			username:         profile.Username,
+			certificate:      profile.Certificate,
+			passwordFallback: profile.PasswordFallback,
+		}
+		if keyFile != "" {
+			signer, ok := loaded[keyFile]
+			if !ok {
+				var err error
+				if signer, err = LoadPrivateKey(keyFile, passphraseFile); err != nil {
+					return nil, fmt.Errorf("device %s: %w", name, err)
+				}
+				loaded[keyFile] = signer
+			}
+			keys.signers = []ssh.Signer{signer}
+		}
+
+		for _, port := range []int{device.SSHPort, device.NetconfPort} {
+			if port != 0 {
+				k.devices[net.JoinHostPort(device.Hostname, strconv.Itoa(port))] = keys
+			}
+		}
+	}
+	return k, nil
+}
+
+// Len returns the number of device addresses logged in to with keys
+func (k *Keyring) Len() int {
+	return len(k.devices)
+}
+
+// LoadPrivateKey reads an OpenSSH private key, decrypting it with the
+// contents of passphraseFile when it is set
+func LoadPrivateKey(keyFile, passphraseFile string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	if passphraseFile == "" {
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key %s is encrypted and no passphrase_file is set", keyFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key %s: %w", keyFile, err)
+		}
+		return signer, nil
+	}
+
+	passphrase, err := os.ReadFile(passphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte(strings.TrimRight(string(passphrase), "\r\n")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key %s: %w", keyFile, err)
+	}
+	return signer, nil
+}
+
+// deviceKeysKey marks contexts whose logins may use the keyring
+type deviceKeysKey struct{}
+
+// WithDeviceKeys returns a context whose device logins use the keyring. It
+// is only for sessions of users the gateway authenticated itself, such as
+// bastion users and API callers with the token of a gateway user. Other API
+// callers are authenticated by the device checking their password, which a
+// key login would skip.
+func WithDeviceKeys(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deviceKeysKey{}, true)
+}
+
+// lookup returns the keys for logging in to hostname:port as username if
+// ctx may use them. Other usernames log in without the device keys.
+func (k *Keyring) lookup(ctx context.Context, hostname string, port int, username string) *deviceKeys {
+	if k == nil || ctx.Value(deviceKeysKey{}) == nil {
+		return nil
+	}
+	keys := k.devices[net.JoinHostPort(hostname, strconv.Itoa(port))]
+	if keys == nil || keys.username != username {
+		return nil
+	}
+	return keys
+}
+
+// agentKey carries the signers of a forwarded SSH agent in a context
+type agentKey struct{}
+
+// WithAgent returns a context whose device logins also offer the keys of
+// the user's forwarded SSH agent, ahead of the device keys
+func WithAgent(ctx context.Context, signers func() ([]ssh.Signer, error)) context.Context {
+	return context.WithValue(ctx, agentKey{}, signers)
+}
+
+// passwordPromptKey carries the password prompt of a context
+type passwordPromptKey struct{}
+
+// WithPasswordPrompt returns a context whose device logins get the password
+// from prompt, only once the device asks for one. Interactive sessions use
+// it to skip the prompt when a key is accepted.
+func WithPasswordPrompt(ctx context.Context, prompt func() (string, error)) context.Context {
+	return context.WithValue(ctx, passwordPromptKey{}, prompt)
+}
+
+// AuthMethods returns how to log in to the device at hostname:port as
+// username: the keys of a forwarded agent, with a context from
+// WithDeviceKeys the device's key and certificate when username is the one
+// of its credential profile, then the password unless the device uses keys
+// without password fallback
+func AuthMethods(ctx context.Context, hostname string, port int, username, password string) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	agentSigners, _ := ctx.Value(agentKey{}).(func() ([]ssh.Signer, error))
+	k := currentKeyring()
+	keys := k.lookup(ctx, hostname, port, username)
+	// The client tries each method name once, so all keys are offered by a
+	// single public key method
+	if agentSigners != nil || keys != nil {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var signers []ssh.Signer
+			if agentSigners != nil {
+				forwarded, err := agentSigners()
+				if err != nil {
+					logger.Log.WithError(err).Warn("Failed to list forwarded agent keys")
+				}
+				signers = append(signers, forwarded...)
+			}
+			if keys != nil {
+				signers = append(signers, k.signers(keys, username)...)
+			}
+			return signers, nil
+		}))
+	}
+	if keys != nil && !keys.passwordFallback {
+		return methods
+	}
+
+	getPassword := func() (string, error) { return password, nil }
+	if prompt, ok := ctx.Value(passwordPromptKey{}).(func() (string, error)); ok {
+		var once sync.Once
+		var err error
+		getPassword = func() (string, error) {
+			once.Do(func() { password, err = prompt() })
+			return password, err
+		}
+	}
+	return append(methods,
+		ssh.PasswordCallback(getPassword),
+		ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			if len(questions) == 0 {
+				return nil, nil
+			}
+			password, err := getPassword()
+			if err != nil {
+				return nil, err
+			}
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answers[i] = password
+			}
+			return answers, nil
+		}),
+	)
+}
+
+// NeedsPassword reports whether a login with ctx to hostname:port as
+// username uses a password, that is the device has no key for username or
+// falls back to the password
+func NeedsPassword(ctx context.Context, hostname string, port int, username string) bool {
+	keys := currentKeyring().lookup(ctx, hostname, port, username)
+	return keys == nil || keys.passwordFallback
+}
+
+// usesKeys reports whether a login with ctx to hostname:port as username
+// offers keys
+func usesKeys(ctx context.Context, hostname string, port int, username string) bool {
+	return currentKeyring().lookup(ctx, hostname, port, username) != nil
+}
+
+// signers returns the signers to offer for username, the profile's
+// username, the certificate first
+func (k *Keyring) signers(keys *deviceKeys, username string) []ssh.Signer {
+	if !keys.certificate {
+		return keys.signers
+	}
+	cert, err := k.certificate(username)
+	if err != nil {
+		logger.Log.WithError(err).WithField("principal", username).Warn("Failed to issue device certificate")
+		return keys.signers
+	}
+	return append([]ssh.Signer{cert}, keys.signers...)
+}
+
+// certificate returns a certificate for principal, issuing a new one when
+// the last one has less than a quarter of its validity left
+func (k *Keyring) certificate(principal string) (ssh.Signer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	if signer, ok := k.certs[principal]; ok {
+		cert := signer.PublicKey().(*ssh.Certificate)
+		if now.Add(k.certValidity / 4).Before(time.Unix(int64(cert.ValidBefore), 0)) {
+			return signer, nil
+		}
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	cert := &ssh.Certificate{
+		Key:             k.certKey.PublicKey(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           "gateway:" + principal,
+		ValidPrincipals: []string{principal},
+		// Allow for devices whose clocks are slightly behind
+		ValidAfter:  uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore: uint64(now.Add(k.certValidity).Unix()),
+		Permissions: ssh.Permissions{Extensions: map[string]string{
+			"permit-pty":              "",
+			"permit-agent-forwarding": "",
+		}},
+	}
+	if err := cert.SignCert(rand.Reader, k.ca); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	signer, err := ssh.NewCertSigner(cert, k.certKey)
+	if err != nil {
+		return nil, err
+	}
+	k.certs[principal] = signer
+
+	logger.Log.WithFields(map[string]interface{}{
+		"principal": principal,
+		"serial":    cert.Serial,
+		"valid_for": k.certValidity.String(),
+	}).Info("Issued device certificate")
+	return signer, nil
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+func TestKeyring(t *testing.T) {
+	dir := t.TempDir()
+	deviceKey := writeTestPrivateKey(t, dir, "device_key", "")
+	encryptedKey := writeTestPrivateKey(t, dir, "encrypted_key", "correct horse")
+	caKey := writeTestPrivateKey(t, dir, "ca_key", "")
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deviceSigner, _ := LoadPrivateKey(deviceKey, "")
+	encryptedSigner, _ := LoadPrivateKey(encryptedKey, passphraseFile)
+	caSigner, _ := LoadPrivateKey(caKey, "")
+
+	// The device accepts the device key, certificates for admin from the CA
+	// and the password "secret"
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), caSigner.PublicKey().Marshal())
+		},
+		UserKeyFallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, signer := range []ssh.Signer{deviceSigner, encryptedSigner} {
+				if bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	// Each device is a server of its own, devices are looked up by address
+	device := func(credentials, keyFile string) config.DeviceConfig {
+		port := startTestSSHServerWithConfig(t, &ssh.ServerConfig{
+			PublicKeyCallback: checker.Authenticate,
+			PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+				if string(pass) == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("password rejected")
+			},
+		}, func(command string, channel ssh.Channel) uint32 {
+			_, _ = channel.Write([]byte("ok\n"))
+			return 0
+		})
+		return config.DeviceConfig{Hostname: "127.0.0.1", SSHPort: port, Credentials: credentials, PrivateKeyFile: keyFile}
+	}
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"plain":     device("", ""),
+			"key":       device("key", ""),
+			"encrypted": device("encrypted", ""),
+			"cert":      device("cert", ""),
+			"override":  device("wrongkey", deviceKey),
+			"wrongkey":  device("wrongkey", ""),
+			"fallback":  device("fallback", ""),
+		},
+		Settings: config.Settings{
+			Credentials: map[string]config.CredentialSettings{
+				"key":       {Username: "admin", PrivateKeyFile: deviceKey},
+				"encrypted": {Username: "admin", PrivateKeyFile: encryptedKey, PassphraseFile: passphraseFile},
+				"cert":      {Username: "admin", Certificate: true},
+				"wrongkey":  {Username: "admin", PrivateKeyFile: caKey},
+				"fallback":  {Username: "admin", PrivateKeyFile: caKey, PasswordFallback: true},
+			},
+			DeviceCA: config.DeviceCASettings{PrivateKeyFile: caKey},
+		},
+	}
+	keyring, err := NewKeyring(cfg)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	SetKeyring(keyring)
+	t.Cleanup(func() { SetKeyring(nil) })
+
+	tests := []struct {
+		device   string
+		password string
+		keys     bool
+		wantErr  bool
+	}{
+		{"plain", "secret", true, false},
+		{"key", "", true, false},
+		{"encrypted", "", true, false},
+		{"cert", "", true, false},
+		{"override", "", true, false},
+		// The password is not tried after a refused key without fallback
+		{"wrongkey", "secret", true, true},
+		{"fallback", "secret", true, false},
+		// Logins for API callers never use keys
+		{"key", "", false, true},
+		{"key", "secret", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s keys=%v", tt.device, tt.keys), func(t *testing.T) {
+			ctx := context.Background()
+			if tt.keys {
+				ctx = WithDeviceKeys(ctx)
+			}
+			device := cfg.Devices[tt.device]
+			_, err := ExecuteSSHCommand(ctx, device.Hostname, device.SSHPort, "admin", tt.password, "show version")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExecuteSSHCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && Category(err) != CategoryAuthFailed {
+				t.Errorf("category = %s, want auth_failed", Category(err))
+			}
+		})
+	}
+
+	// Keys and certificates only log in as the username of the profile
+	for _, name := range []string{"key", "cert"} {
+		device := cfg.Devices[name]
+		_, err := ExecuteSSHCommand(WithDeviceKeys(context.Background()), device.Hostname, device.SSHPort, "root", "", "show version")
+		if Category(err) != CategoryAuthFailed {
+			t.Errorf("%s: login as root with the device keys: got %v, want auth_failed", name, err)
+		}
+	}
+
+	key, fallback := cfg.Devices["key"], cfg.Devices["fallback"]
+	if NeedsPassword(WithDeviceKeys(context.Background()), key.Hostname, key.SSHPort, "admin") {
+		t.Error("a device with a key needs no password")
+	}
+	if !NeedsPassword(WithDeviceKeys(context.Background()), key.Hostname, key.SSHPort, "root") {
+		t.Error("users other than the profile's need the password")
+	}
+	if !NeedsPassword(WithDeviceKeys(context.Background()), fallback.Hostname, fallback.SSHPort, "admin") {
+		t.Error("a device with password fallback needs the password")
+	}
+	if !NeedsPassword(context.Background(), key.Hostname, key.SSHPort, "admin") {
+		t.Error("API logins always need the password")
+	}
+}
+
+func TestNewKeyring_NoUsername(t *testing.T) {
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {Hostname: "router1", SSHPort: 22}},
+		Settings: config.Settings{
+			Credentials: map[string]config.CredentialSettings{
+				"default": {PrivateKeyFile: writeTestPrivateKey(t, t.TempDir(), "device_key", "")},
+			},
+		},
+	}
+	if _, err := NewKeyring(cfg); err == nil {
+		t.Error("NewKeyring should refuse keys without a username")
+	}
+}
+
+func TestKeyring_Certificate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {Hostname: "router1", SSHPort: 22, Credentials: "cert"}},
+		Settings: config.Settings{
+			Credentials: map[string]config.CredentialSettings{"cert": {Username: "admin", Certificate: true}},
+			DeviceCA:    config.DeviceCASettings{PrivateKeyFile: writeTestPrivateKey(t, dir, "ca_key", ""), Validity: 60},
+		},
+	}
+	keyring, err := NewKeyring(cfg)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	now := time.Unix(keyring.now().Unix(), 0)
+	keyring.now = func() time.Time { return now }
+
+	first, err := keyring.certificate("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := first.PublicKey().(*ssh.Certificate)
+	if cert.CertType != ssh.UserCert || len(cert.ValidPrincipals) != 1 || cert.ValidPrincipals[0] != "admin" {
+		t.Errorf("certificate = %+v, want a user certificate for admin", cert)
+	}
+	if got := time.Unix(int64(cert.ValidBefore), 0).Sub(now); got != time.Minute {
+		t.Errorf("validity = %s, want 1m", got)
+	}
+
+	// The certificate is reused until a quarter of its validity is left
+	now = now.Add(40 * time.Second)
+	if second, _ := keyring.certificate("admin"); second != first {
+		t.Error("certificate not reused")
+	}
+	now = now.Add(10 * time.Second)
+	if third, _ := keyring.certificate("admin"); third == first {
+		t.Error("certificate close to expiry not renewed")
+	}
+
+	cfg.Settings.DeviceCA = config.DeviceCASettings{}
+	if _, err := NewKeyring(cfg); err == nil {
+		t.Error("NewKeyring should fail for certificates without device_ca")
+	}
+}
+
+func TestLoadPrivateKey_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writeTestPrivateKey(t, dir, "key", "secret")
+	if _, err := LoadPrivateKey(keyFile, ""); err == nil {
+		t.Error("LoadPrivateKey should fail for an encrypted key without passphrase")
+	}
+	wrong := filepath.Join(dir, "wrong")
+	_ = os.WriteFile(wrong, []byte("guess"), 0o600)
+	if _, err := LoadPrivateKey(keyFile, wrong); err == nil {
+		t.Error("LoadPrivateKey should fail with a wrong passphrase")
+	}
+}
+
+// writeTestPrivateKey writes a new OpenSSH ed25519 key, encrypted when
+// passphrase is set, and returns its path
+func writeTestPrivateKey(t *testing.T, dir, name, passphrase string) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startTestSSHServerWithConfig starts an SSH server on a random local port
+// authenticating with serverConfig and answering exec requests with handler
+func startTestSSHServerWithConfig(t *testing.T, serverConfig *ssh.ServerConfig, handler func(command string, channel ssh.Channel) uint32) int {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, serverConfig, handler, ssh.DiscardRequests)
+		}
+	}()
+
+	_, p, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(p)
+	return port
+}
@@ -1,108 +1,367 @@
 package proxy
 
 import (
-	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/netconf"
+	"github.com/safabayar/gateway/internal/tracing"
 )
 
-// ExecuteNetconfCommand executes a NETCONF RPC on a remote device
-func ExecuteNetconfCommand(hostname string, port int, username, password, command string) (string, error) {
-	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         30 * time.Second,
-	}
+// netconfTimeout bounds the hello exchange and each RPC
+const netconfTimeout = 30 * time.Second
 
-	address := fmt.Sprintf("%s:%d", hostname, port)
-	logger.Log.WithFields(map[string]interface{}{
+// DialNetconf opens a NETCONF session to the device over SSH, on a pooled
+// connection when a pool is set. The returned function closes the session.
+func DialNetconf(ctx context.Context, hostname string, port int, username, password string) (*netconf.Session, func(), error) {
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"address":  address,
 		"username": username,
 	}).Debug("Connecting to NETCONF server")
 
-	client, err := ssh.Dial("tcp", address, config)
+	stdin, stdout, release, err := OpenNetconfSubsystem(ctx, hostname, port, username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	helloCtx, cancel := context.WithTimeout(ctx, netconfTimeout)
+	defer cancel()
+	nc, err := netconf.NewSession(helloCtx, stdout, stdin)
+	if err != nil {
+		release()
+		return nil, nil, newExecError(classifyIOError(err), "NETCONF hello failed", err)
+	}
+
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
+		"address":    address,
+		"session_id": nc.SessionID,
+		"chunked":    nc.HasCapability(netconf.CapabilityV11),
+	}).Debug("NETCONF session established")
+
+	return nc, func() {
+		if err := nc.Close(); err != nil {
+			logger.Log.WithError(err).Debug("NETCONF close-session failed")
+		}
+		release()
+	}, nil
+}
+
+// OpenNetconfSubsystem opens the netconf subsystem of the device over SSH,
+// on a pooled connection when a pool is set, without the hello exchange of
+// DialNetconf, for relaying a client's NETCONF stream unchanged. release
+// closes the session.
+func OpenNetconfSubsystem(ctx context.Context, hostname string, port int, username, password string) (io.WriteCloser, io.Reader, func(), error) {
+	session, release, err := openSession(ctx, "NETCONF", hostname, port, username, password)
 	if err != nil {
-		return "", fmt.Errorf("failed to dial NETCONF: %w", err)
+		return nil, nil, nil, err
 	}
-	defer client.Close()
+	return requestNetconfSubsystem(session, release)
+}
 
-	session, err := client.NewSession()
+// requestNetconfSubsystem starts the netconf subsystem on session. release
+// closes the session, also on failure.
+func requestNetconfSubsystem(session *ssh.Session, release func()) (io.WriteCloser, io.Reader, func(), error) {
+	stdin, err := session.StdinPipe()
 	if err != nil {
-		return "", fmt.Errorf("failed to create NETCONF session: %w", err)
+		release()
+		return nil, nil, nil, newExecError(CategoryRemoteError, "failed to get stdin pipe", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		release()
+		return nil, nil, nil, newExecError(CategoryRemoteError, "failed to get stdout pipe", err)
 	}
-	defer session.Close()
 
 	// Request NETCONF subsystem
 	if err := session.RequestSubsystem("netconf"); err != nil {
-		return "", fmt.Errorf("failed to request NETCONF subsystem: %w", err)
+		release()
+		return nil, nil, nil, newExecError(CategoryRemoteError, "failed to request NETCONF subsystem", err)
 	}
+	return stdin, stdout, release, nil
+}
 
-	stdin, err := session.StdinPipe()
+// NetconfClient is a login to the NETCONF port of a device, on a pooled
+// connection when a pool is set, that netconf subsystems are opened on
+type NetconfClient struct {
+	client *ssh.Client
+	// done ends the login, broken reports that the connection failed
+	done   func(broken bool)
+	broken atomic.Bool
+	once   sync.Once
+}
+
+// LoginNetconf logs in to the NETCONF port of the device, to authenticate a
+// client by its device credentials and then open its sessions on the same
+// login. A pooled connection is held until Close.
+func LoginNetconf(ctx context.Context, hostname string, port int, username, password string) (*NetconfClient, error) {
+	p := currentPool()
+	// Connections logged in with a user's forwarded agent are not shared
+	if p == nil || ctx.Value(agentKey{}) != nil {
+		client, err := dialSSH(ctx, hostname, port, clientConfig(ctx, hostname, port, username, password))
+		if err != nil {
+			return nil, newExecError(classifyDialError(err), "failed to dial NETCONF", err)
+		}
+		return &NetconfClient{client: client, done: func(bool) { client.Close() }}, nil
+	}
+
+	for {
+		pc, reused, err := p.acquire(ctx, hostname, port, username, password)
+		if err != nil {
+			return nil, newExecError(classifyDialError(err), "failed to dial NETCONF", err)
+		}
+		// The device may have dropped a pooled connection, retry on a fresh one
+		if reused {
+			session, err := newSession(ctx, pc.client)
+			if err != nil {
+				p.release(pc, true)
+				continue
+			}
+			_ = session.Close()
+		}
+		return &NetconfClient{client: pc.client, done: func(broken bool) { p.release(pc, broken) }}, nil
+	}
+}
+
+// OpenSubsystem opens the netconf subsystem on the login, like
+// OpenNetconfSubsystem
+func (c *NetconfClient) OpenSubsystem(ctx context.Context) (io.WriteCloser, io.Reader, func(), error) {
+	session, err := newSession(ctx, c.client)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stdin pipe: %w", err)
+		c.broken.Store(true)
+		return nil, nil, nil, newExecError(CategoryRemoteError, "failed to create NETCONF session", err)
 	}
+	return requestNetconfSubsystem(session, func() { _ = session.Close() })
+}
 
-	var stdout bytes.Buffer
-	session.Stdout = &stdout
+// Close ends the login
+func (c *NetconfClient) Close() {
+	c.once.Do(func() { c.done(c.broken.Load()) })
+}
+
+// ExecuteNetconfCommand executes a NETCONF RPC on a remote device and returns
+// the content of the rpc-reply. command is an operation such as <get-config>,
+// or a complete <rpc> element whose content is sent.
+func ExecuteNetconfCommand(ctx context.Context, hostname string, port int, username, password, command string) (*Result, error) {
+	start := time.Now()
+	result := &Result{}
+	defer func() { result.Duration = time.Since(start) }()
 
-	// Start session
-	if err := session.Start(""); err != nil {
-		return "", fmt.Errorf("failed to start session: %w", err)
+	operation, err := rpcOperation(command)
+	if err != nil {
+		return result, newExecError(CategoryRemoteError, "invalid NETCONF rpc", err)
 	}
 
-	// Read hello message
-	time.Sleep(100 * time.Millisecond)
+	session, closeSession, err := DialNetconf(ctx, hostname, port, username, password)
+	if err != nil {
+		return result, err
+	}
+	defer closeSession()
+
+	logger.Log.WithContext(ctx).WithField("command", operation).Debug("Executing NETCONF RPC")
 
-	// Send NETCONF hello
-	hello := `<?xml version="1.0" encoding="UTF-8"?>
-<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0">
-  <capabilities>
-    <capability>urn:ietf:params:netconf:base:1.0</capability>
-  </capabilities>
-</hello>]]>]]>`
+	rpcCtx, span := tracing.Start(ctx, "netconf.rpc")
+	rpcCtx, cancel := context.WithTimeout(rpcCtx, netconfTimeout)
+	defer cancel()
+	reply, err := session.Exec(rpcCtx, operation)
+	tracing.End(span, err)
 
-	if _, err := stdin.Write([]byte(hello)); err != nil {
-		return "", fmt.Errorf("failed to send hello: %w", err)
+	if reply != nil {
+		result.Stdout = string(reply.Payload)
+	}
+	var rpcErrors netconf.RPCErrors
+	if errors.As(err, &rpcErrors) {
+		result.Stderr = rpcErrors.Error()
+		result.ExitCode = 1
+		return result, newExecError(CategoryRemoteError, "NETCONF rpc failed", err)
 	}
+	if err != nil {
+		return result, newExecError(classifyIOError(err), "NETCONF rpc failed", err)
+	}
+	return result, nil
+}
+
+// rpcOperation returns the operation to send for command, removing an XML
+// declaration, a base:1.0 delimiter and an enclosing <rpc> element
+func rpcOperation(command string) (string, error) {
+	command = strings.TrimSpace(command)
+	command = strings.TrimSpace(strings.TrimSuffix(command, "]]>]]>"))
+	if strings.HasPrefix(command, "<?xml") {
+		end := strings.Index(command, "?>")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated XML declaration")
+		}
+		command = strings.TrimSpace(command[end+2:])
+	}
+	if command == "" {
+		return "", fmt.Errorf("empty rpc")
+	}
+
+	if !strings.HasPrefix(command, "<rpc") || strings.HasPrefix(command, "<rpc-") {
+		return command, nil
+	}
+	var rpc struct {
+		XMLName xml.Name `xml:"rpc"`
+		Inner   string   `xml:",innerxml"`
+	}
+	if err := xml.Unmarshal([]byte(command), &rpc); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rpc.Inner), nil
+}
+
+// netconfRollbackTimeout bounds the cleanup after a failed operation
+const netconfRollbackTimeout = 10 * time.Second
+
+// NetconfOperation is an operation run by ExecuteNetconfOperations
+type NetconfOperation struct {
+	// Name is the operation name, such as get-config
+	Name string
+	// RPC is the operation XML, as built by the netconf package
+	RPC string
+	// Datastore is the datastore locked, unlocked or edited by the operation
+	Datastore string
+}
+
+// NetconfOperationResult is the outcome of one operation
+type NetconfOperationResult struct {
+	Name string
+	// Rollback is set for the discard-changes and unlock operations run
+	// after a failure
+	Rollback bool
+	// Reply is the rpc-reply, also set for replies carrying rpc-error
+	Reply    *netconf.Reply
+	Err      error
+	Duration time.Duration
+}
 
-	time.Sleep(200 * time.Millisecond)
+// NetconfResult is the outcome of ExecuteNetconfOperations
+type NetconfResult struct {
+	SessionID uint64
+	// Operations holds the operations that were run, up to the failed one,
+	// followed by the rollback operations run after it
+	Operations []NetconfOperationResult
+	Duration   time.Duration
+}
 
-	// Send RPC command
-	logger.Log.WithField("command", command).Debug("Executing NETCONF RPC")
+// ExecuteNetconfOperations runs operations in order over one NETCONF session
+// and stops at the first failure. After a failure, uncommitted changes made
+// to the candidate datastore are discarded and the locks taken by the
+// operations are released, so the device is left as it was found.
+func ExecuteNetconfOperations(ctx context.Context, hostname string, port int, username, password string, operations []NetconfOperation) (*NetconfResult, error) {
+	start := time.Now()
+	result := &NetconfResult{}
+	defer func() { result.Duration = time.Since(start) }()
 
-	// Wrap command in RPC tags if not already present
-	rpc := command
-	if !bytes.Contains([]byte(command), []byte("<rpc")) {
-		rpc = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<rpc message-id="1" xmlns="urn:ietf:params:xml:ns:netconf:base:1.0">
-%s
-</rpc>]]>]]>`, command)
+	session, closeSession, err := DialNetconf(ctx, hostname, port, username, password)
+	if err != nil {
+		return result, err
 	}
+	defer closeSession()
+	result.SessionID = session.SessionID
+
+	var locks []string
+	candidateChanged := false
+	for _, op := range operations {
+		if op.Name == "edit-config" && op.Datastore == netconf.Candidate {
+			// Set before running, a failed edit may still have changed the candidate
+			candidateChanged = true
+		}
 
-	if _, err := stdin.Write([]byte(rpc)); err != nil {
-		return "", fmt.Errorf("failed to send RPC: %w", err)
+		opResult := runNetconfOperation(ctx, session, op)
+		result.Operations = append(result.Operations, opResult)
+		if opResult.Err != nil {
+			result.Operations = append(result.Operations, rollbackNetconf(ctx, session, candidateChanged, locks)...)
+			return result, opResult.Err
+		}
+
+		switch op.Name {
+		case "lock":
+			locks = append(locks, op.Datastore)
+		case "unlock":
+			locks = removeString(locks, op.Datastore)
+		case "commit", "discard-changes":
+			candidateChanged = false
+		}
 	}
+	return result, nil
+}
 
-	time.Sleep(500 * time.Millisecond)
+// runNetconfOperation sends one operation and classifies its failure
+func runNetconfOperation(ctx context.Context, session *netconf.Session, op NetconfOperation) NetconfOperationResult {
+	start := time.Now()
+	logger.Log.WithContext(ctx).WithField("operation", op.Name).Debug("Executing NETCONF operation")
 
-	// Close RPC
-	closeRPC := `<?xml version="1.0" encoding="UTF-8"?>
-<rpc message-id="2" xmlns="urn:ietf:params:xml:ns:netconf:base:1.0">
-  <close-session/>
-</rpc>]]>]]>`
+	rpcCtx, span := tracing.Start(ctx, "netconf.rpc", attribute.String("operation", op.Name))
+	rpcCtx, cancel := context.WithTimeout(rpcCtx, netconfTimeout)
+	defer cancel()
+	reply, err := session.Exec(rpcCtx, op.RPC)
+	tracing.End(span, err)
 
-	_, _ = stdin.Write([]byte(closeRPC))
-	_ = stdin.Close()
+	result := NetconfOperationResult{Name: op.Name, Reply: reply, Duration: time.Since(start)}
+	var rpcErrors netconf.RPCErrors
+	switch {
+	case errors.As(err, &rpcErrors):
+		result.Err = newExecError(CategoryRemoteError, op.Name+" failed", err)
+	case err != nil:
+		result.Err = newExecError(classifyIOError(err), op.Name+" failed", err)
+	}
+	return result
+}
 
-	// Wait for session to complete
-	_ = session.Wait()
+// rollbackNetconf discards uncommitted candidate changes and releases locks
+// after a failed operation, and returns the results of the operations it
+// ran. Failures are logged, the session is closed next which also releases
+// the locks on the device.
+func rollbackNetconf(ctx context.Context, session *netconf.Session, candidateChanged bool, locks []string) []NetconfOperationResult {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), netconfRollbackTimeout)
+	defer cancel()
 
-	return stdout.String(), nil
+	var operations []NetconfOperation
+	if candidateChanged {
+		operations = append(operations, NetconfOperation{Name: "discard-changes", RPC: netconf.DiscardChanges(), Datastore: netconf.Candidate})
+	}
+	for i := len(locks) - 1; i >= 0; i-- {
+		unlock, err := netconf.Unlock(locks[i])
+		if err != nil {
+			continue
+		}
+		operations = append(operations, NetconfOperation{Name: "unlock", RPC: unlock, Datastore: locks[i]})
+	}
+
+	var results []NetconfOperationResult
+	for _, op := range operations {
+		start := time.Now()
+		reply, err := session.Exec(ctx, op.RPC)
+		results = append(results, NetconfOperationResult{Name: op.Name, Rollback: true, Reply: reply, Err: err, Duration: time.Since(start)})
+		if err != nil {
+			logger.Log.WithContext(ctx).WithError(err).WithField("operation", op.Name).Warn("NETCONF rollback failed")
+			if errors.Is(err, netconf.ErrSessionClosed) || ctx.Err() != nil {
+				return results
+			}
+		}
+	}
+	return results
+}
+
+func removeString(values []string, value string) []string {
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i] == value {
+			return append(values[:i], values[i+1:]...)
+		}
+	}
+	return values
 }
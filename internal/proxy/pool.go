@@ -0,0 +1,424 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+)
+
+// Pool defaults, used for options left at zero
+const (
+	defaultMaxConnsPerDevice  = 2
+	defaultMaxSessionsPerConn = 4
+	defaultIdleTimeout        = 5 * time.Minute
+)
+
+// errPoolClosed is returned when a session is requested after Close
+var errPoolClosed = errors.New("connection pool is closed")
+
+// PoolOptions configures a Pool
+type PoolOptions struct {
+	// MaxConnsPerDevice limits connections to one device address, across credentials
+	MaxConnsPerDevice int
+	// MaxSessionsPerConn limits the sessions multiplexed on one connection
+	MaxSessionsPerConn int
+	// IdleTimeout closes connections that had no session for that long
+	IdleTimeout time.Duration
+	// KeepaliveInterval is the interval between keepalive requests, 0 disables
+	// them. A connection whose keepalive is not answered within the interval
+	// is closed.
+	KeepaliveInterval time.Duration
+}
+
+// Pool keeps authenticated SSH connections to devices so commands open a new
+// session on an existing connection instead of dialing and logging in again.
+// Connections are keyed by device address and credentials.
+type Pool struct {
+	opts    PoolOptions
+	conns   map[string][]*pooledConn
+	dialing map[string]int
+	// released is closed and replaced whenever capacity frees up
+	released chan struct{}
+	closed   bool
+	mu       sync.Mutex
+}
+
+// pooledConn is a connection and the sessions currently open on it
+type pooledConn struct {
+	client     *ssh.Client
+	address    string
+	credential [sha256.Size]byte
+	sessions   int
+	lastUsed   time.Time
+	// broken connections get no new sessions and close once unused
+	broken  bool
+	removed bool
+	done    chan struct{}
+}
+
+var (
+	pool   *Pool
+	poolMu sync.RWMutex
+)
+
+// SetPool makes SSH and NETCONF commands use p, nil dials for every command
+func SetPool(p *Pool) {
+	poolMu.Lock()
+	pool = p
+	poolMu.Unlock()
+}
+
+func currentPool() *Pool {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+	return pool
+}
+
+// NewPool creates an empty connection pool
+func NewPool(opts PoolOptions) *Pool {
+	if opts.MaxConnsPerDevice <= 0 {
+		opts.MaxConnsPerDevice = defaultMaxConnsPerDevice
+	}
+	if opts.MaxSessionsPerConn <= 0 {
+		opts.MaxSessionsPerConn = defaultMaxSessionsPerConn
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
+	return &Pool{
+		opts:     opts,
+		conns:    make(map[string][]*pooledConn),
+		dialing:  make(map[string]int),
+		released: make(chan struct{}),
+	}
+}
+
+// Close closes every pooled connection and rejects new sessions
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, conns := range p.conns {
+		for _, pc := range append([]*pooledConn(nil), conns...) {
+			p.removeLocked(pc)
+		}
+	}
+	p.notifyLocked()
+}
+
+// acquire returns a connection to hostname:port with a session slot reserved
+// for the caller, dialing a new one when no pooled connection has room. It
+// waits for a slot when the device already has MaxConnsPerDevice connections.
+func (p *Pool) acquire(ctx context.Context, hostname string, port int, username, password string) (*pooledConn, bool, error) {
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	// Key logins are kept apart from password logins of the same user
+	login := username + "\x00" + password
+	if usesKeys(ctx, hostname, port, username) {
+		login += "\x00keys"
+	}
+	credential := sha256.Sum256([]byte(login))
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, false, errPoolClosed
+		}
+
+		for _, pc := range p.conns[address] {
+			if pc.credential == credential && !pc.broken && pc.sessions < p.opts.MaxSessionsPerConn {
+				pc.sessions++
+				pc.lastUsed = time.Now()
+				p.mu.Unlock()
+				metrics.SSHPoolAcquires.WithLabelValues("reused").Inc()
+				return pc, true, nil
+			}
+		}
+
+		// Make room by closing an idle connection held for other credentials
+		if len(p.conns[address])+p.dialing[address] >= p.opts.MaxConnsPerDevice {
+			for _, pc := range p.conns[address] {
+				if pc.sessions == 0 {
+					p.removeLocked(pc)
+					break
+				}
+			}
+		}
+
+		if len(p.conns[address])+p.dialing[address] < p.opts.MaxConnsPerDevice {
+			p.dialing[address]++
+			p.mu.Unlock()
+			return p.dial(ctx, hostname, port, address, username, password, credential)
+		}
+
+		released := p.released
+		p.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// dial opens a new pooled connection, the caller reserved a dialing slot
+func (p *Pool) dial(ctx context.Context, hostname string, port int, address, username, password string, credential [sha256.Size]byte) (*pooledConn, bool, error) {
+	client, err := dialSSH(ctx, hostname, port, clientConfig(ctx, hostname, port, username, password))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing[address]--
+	p.notifyLocked()
+
+	if err != nil {
+		// Credentials revoked on the device must not keep working through
+		// connections that authenticated earlier
+		if classifyDialError(err) == CategoryAuthFailed {
+			p.evictLocked(address, credential)
+		}
+		return nil, false, err
+	}
+	if p.closed {
+		client.Close()
+		return nil, false, errPoolClosed
+	}
+
+	pc := &pooledConn{
+		client:     client,
+		address:    address,
+		credential: credential,
+		sessions:   1,
+		lastUsed:   time.Now(),
+		done:       make(chan struct{}),
+	}
+	p.conns[address] = append(p.conns[address], pc)
+	metrics.SSHPoolConnections.Inc()
+	metrics.SSHPoolAcquires.WithLabelValues("dialed").Inc()
+	go p.maintain(pc)
+
+	logger.Log.WithContext(ctx).WithField("address", address).Debug("Added SSH connection to pool")
+	return pc, false, nil
+}
+
+// release returns the session slot of pc. A broken connection is closed
+// once its last session is released.
+func (p *Pool) release(pc *pooledConn, broken bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc.sessions--
+	pc.lastUsed = time.Now()
+	if broken {
+		pc.broken = true
+	}
+	if pc.broken && pc.sessions == 0 {
+		p.removeLocked(pc)
+	}
+	p.notifyLocked()
+}
+
+// maintain sends keepalives on pc and closes it once idle or disconnected
+func (p *Pool) maintain(pc *pooledConn) {
+	go func() {
+		_ = pc.client.Wait()
+		p.discard(pc)
+	}()
+
+	interval := p.opts.KeepaliveInterval
+	if interval <= 0 || interval > p.opts.IdleTimeout {
+		interval = p.opts.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-pc.done:
+			return
+		}
+
+		p.mu.Lock()
+		if pc.sessions == 0 && time.Since(pc.lastUsed) >= p.opts.IdleTimeout {
+			logger.Log.WithField("address", pc.address).Debug("Closing idle pooled SSH connection")
+			p.removeLocked(pc)
+			p.notifyLocked()
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		if p.opts.KeepaliveInterval > 0 && !p.keepalive(pc) {
+			return
+		}
+	}
+}
+
+// keepalive sends a keepalive request on pc and reports whether the device
+// answered it. A connection that does not answer within the keepalive
+// interval is closed and dropped with its sessions, which hang with it.
+func (p *Pool) keepalive(pc *pooledConn) bool {
+	reply := make(chan error, 1)
+	go func() {
+		// Any reply, even a failure, shows the connection is alive
+		_, _, err := pc.client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+
+	timer := time.NewTimer(p.opts.KeepaliveInterval)
+	defer timer.Stop()
+
+	select {
+	case err := <-reply:
+		if err != nil {
+			logger.Log.WithError(err).WithField("address", pc.address).Debug("Pooled SSH connection keepalive failed")
+			p.discard(pc)
+			return false
+		}
+		return true
+	case <-timer.C:
+		logger.Log.WithField("address", pc.address).Warn("Pooled SSH connection keepalive timed out, closing it")
+		p.mu.Lock()
+		p.removeLocked(pc)
+		p.notifyLocked()
+		p.mu.Unlock()
+		return false
+	case <-pc.done:
+		return false
+	}
+}
+
+// discard stops handing out pc and closes it once its sessions are released
+func (p *Pool) discard(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc.broken = true
+	if pc.sessions == 0 {
+		p.removeLocked(pc)
+	}
+	p.notifyLocked()
+}
+
+// evictLocked stops handing out connections for credential on address,
+// closing the unused ones right away. The caller holds p.mu.
+func (p *Pool) evictLocked(address string, credential [sha256.Size]byte) {
+	for _, pc := range append([]*pooledConn(nil), p.conns[address]...) {
+		if pc.credential != credential {
+			continue
+		}
+		pc.broken = true
+		if pc.sessions == 0 {
+			p.removeLocked(pc)
+		}
+	}
+}
+
+// removeLocked closes pc and drops it from the pool. The caller holds p.mu.
+func (p *Pool) removeLocked(pc *pooledConn) {
+	if pc.removed {
+		return
+	}
+	pc.removed = true
+	close(pc.done)
+	_ = pc.client.Close()
+	metrics.SSHPoolConnections.Dec()
+
+	conns := p.conns[pc.address]
+	for i, c := range conns {
+		if c == pc {
+			p.conns[pc.address] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(p.conns[pc.address]) == 0 {
+		delete(p.conns, pc.address)
+	}
+}
+
+// notifyLocked wakes callers waiting for capacity. The caller holds p.mu.
+func (p *Pool) notifyLocked() {
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// clientConfig returns the SSH client configuration for logging in to a
+// device with its keys or password
+func clientConfig(ctx context.Context, hostname string, port int, username, password string) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            AuthMethods(ctx, hostname, port, username, password),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use proper host key verification
+		Timeout:         30 * time.Second,
+	}
+}
+
+// openSession opens an SSH session to the device, on a pooled connection
+// when a pool is set. name labels errors, as in "failed to dial SSH".
+// release must be called once the session is done.
+func openSession(ctx context.Context, name, hostname string, port int, username, password string) (*ssh.Session, func(), error) {
+	return openOnClient(ctx, name, hostname, port, username, password, newSession, (*ssh.Session).Close)
+}
+
+// openChannel opens a raw session channel to the device like openSession,
+// for sessions whose exit status *ssh.Session cannot wait for
+func openChannel(ctx context.Context, name, hostname string, port int, username, password string) (*sessionChannel, func(), error) {
+	return openOnClient(ctx, name, hostname, port, username, password, newSessionChannel, (*sessionChannel).Close)
+}
+
+// openOnClient opens a session with open on a client logged in to the
+// device, pooled when a pool is set, and closes it with closeFn on release
+func openOnClient[T any](ctx context.Context, name, hostname string, port int, username, password string,
+	open func(context.Context, *ssh.Client) (T, error), closeFn func(T) error) (T, func(), error) {
+	var zero T
+	p := currentPool()
+	// Connections logged in with a user's forwarded agent are not shared
+	if p == nil || ctx.Value(agentKey{}) != nil {
+		client, err := dialSSH(ctx, hostname, port, clientConfig(ctx, hostname, port, username, password))
+		if err != nil {
+			return zero, nil, newExecError(classifyDialError(err), "failed to dial "+name, err)
+		}
+		session, err := open(ctx, client)
+		if err != nil {
+			client.Close()
+			return zero, nil, newExecError(CategoryRemoteError, "failed to create "+name+" session", err)
+		}
+		return session, func() { _ = closeFn(session); client.Close() }, nil
+	}
+
+	for {
+		pc, reused, err := p.acquire(ctx, hostname, port, username, password)
+		if err != nil {
+			return zero, nil, newExecError(classifyDialError(err), "failed to dial "+name, err)
+		}
+
+		session, err := open(ctx, pc.client)
+		if err != nil {
+			p.release(pc, true)
+			// The device may have dropped a pooled connection, retry on a fresh one
+			if reused {
+				continue
+			}
+			return zero, nil, newExecError(CategoryRemoteError, "failed to create "+name+" session", err)
+		}
+
+		var once sync.Once
+		return session, func() {
+			once.Do(func() {
+				_ = closeFn(session)
+				p.release(pc, false)
+			})
+		}, nil
+	}
+}
@@ -1,11 +1,30 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/netconf"
 )
 
 func TestMain(m *testing.M) {
@@ -19,7 +38,7 @@ func TestMain(m *testing.M) {
 
 func TestExecuteSSHCommand_ConnectionError(t *testing.T) {
 	// Test with non-existent host - should fail to connect
-	output, err := ExecuteSSHCommand("127.0.0.1", 22222, "admin", "password", "show version")
+	output, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", 22222, "admin", "password", "show version")
 
 	if err == nil {
 		t.Error("Expected connection error but got none")
@@ -31,7 +50,7 @@ func TestExecuteSSHCommand_ConnectionError(t *testing.T) {
 
 func TestExecuteTelnetCommand_ConnectionError(t *testing.T) {
 	// Test with non-existent host - should fail to connect
-	output, err := ExecuteTelnetCommand("127.0.0.1", 23333, "admin", "password", "show version")
+	output, err := ExecuteTelnetCommand(context.Background(), "127.0.0.1", 23333, "admin", "password", "show version", TelnetOptions{})
 
 	if err == nil {
 		t.Error("Expected connection error but got none")
@@ -42,7 +61,7 @@ func TestExecuteTelnetCommand_ConnectionError(t *testing.T) {
 
 func TestExecuteNetconfCommand_ConnectionError(t *testing.T) {
 	// Test with non-existent host - should fail to connect
-	output, err := ExecuteNetconfCommand("127.0.0.1", 8333, "admin", "password", "<get-config/>")
+	output, err := ExecuteNetconfCommand(context.Background(), "127.0.0.1", 8333, "admin", "password", "<get-config/>")
 
 	if err == nil {
 		t.Error("Expected connection error but got none")
@@ -53,7 +72,7 @@ func TestExecuteNetconfCommand_ConnectionError(t *testing.T) {
 
 func TestExecuteSSHCommand_InvalidPort(t *testing.T) {
 	// Test with invalid port
-	_, err := ExecuteSSHCommand("127.0.0.1", 0, "admin", "password", "show version")
+	_, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", 0, "admin", "password", "show version")
 
 	if err == nil {
 		t.Error("Expected error for invalid port")
@@ -62,7 +81,7 @@ func TestExecuteSSHCommand_InvalidPort(t *testing.T) {
 
 func TestExecuteTelnetCommand_InvalidPort(t *testing.T) {
 	// Test with invalid port
-	_, err := ExecuteTelnetCommand("127.0.0.1", 0, "admin", "password", "show version")
+	_, err := ExecuteTelnetCommand(context.Background(), "127.0.0.1", 0, "admin", "password", "show version", TelnetOptions{})
 
 	if err == nil {
 		t.Error("Expected error for invalid port")
@@ -71,38 +90,772 @@ func TestExecuteTelnetCommand_InvalidPort(t *testing.T) {
 
 func TestExecuteNetconfCommand_InvalidPort(t *testing.T) {
 	// Test with invalid port
-	_, err := ExecuteNetconfCommand("127.0.0.1", 0, "admin", "password", "<get-config/>")
+	_, err := ExecuteNetconfCommand(context.Background(), "127.0.0.1", 0, "admin", "password", "<get-config/>")
 
 	if err == nil {
 		t.Error("Expected error for invalid port")
 	}
 }
 
-// TestNetconfRPCWrapping tests that NETCONF commands are properly wrapped
-func TestNetconfRPCWrapping(t *testing.T) {
+func TestRPCOperation(t *testing.T) {
 	tests := []struct {
 		name    string
 		command string
-		hasRPC  bool
+		want    string
 	}{
 		{
-			name:    "Command without RPC tag",
+			name:    "operation",
 			command: "<get-config><source><running/></source></get-config>",
-			hasRPC:  false,
+			want:    "<get-config><source><running/></source></get-config>",
+		},
+		{
+			name:    "rpc element",
+			command: `<rpc message-id="1" xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><get-config/></rpc>`,
+			want:    "<get-config/>",
 		},
 		{
-			name:    "Command with RPC tag",
-			command: `<rpc message-id="1"><get-config/></rpc>`,
-			hasRPC:  true,
+			name:    "declaration and delimiter",
+			command: "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<rpc message-id=\"7\">\n  <get/>\n</rpc>\n]]>]]>",
+			want:    "<get/>",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hasRPC := strings.Contains(tt.command, "<rpc")
-			if hasRPC != tt.hasRPC {
-				t.Errorf("RPC detection: got %v, want %v", hasRPC, tt.hasRPC)
+			got, err := rpcOperation(tt.command)
+			if err != nil {
+				t.Fatalf("rpcOperation() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rpcOperation() = %q, want %q", got, tt.want)
 			}
 		})
 	}
+
+	if _, err := rpcOperation("  ]]>]]>"); err == nil {
+		t.Error("expected error for empty rpc")
+	}
+}
+
+func TestExecuteNetconfCommand_Success(t *testing.T) {
+	port := startTestSSHServer(t, "secret", (&testNetconfDevice{}).serve)
+
+	result, err := ExecuteNetconfCommand(context.Background(), "127.0.0.1", port, "admin", "secret",
+		`<rpc message-id="101"><get-config><source><running/></source></get-config></rpc>`)
+	if err != nil {
+		t.Fatalf("ExecuteNetconfCommand() error = %v", err)
+	}
+	if result.Stdout != "<data><system><hostname>srl1</hostname></system></data>" {
+		t.Errorf("Stdout = %q", result.Stdout)
+	}
+}
+
+func TestExecuteNetconfCommand_RPCError(t *testing.T) {
+	port := startTestSSHServer(t, "secret", (&testNetconfDevice{fail: "lock"}).serve)
+
+	result, err := ExecuteNetconfCommand(context.Background(), "127.0.0.1", port, "admin", "secret",
+		"<lock><target><running/></target></lock>")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got := Category(err); got != CategoryRemoteError {
+		t.Errorf("Category() = %q, want %q", got, CategoryRemoteError)
+	}
+	var rpcErrors netconf.RPCErrors
+	if !errors.As(err, &rpcErrors) || rpcErrors[0].Tag != "lock-denied" {
+		t.Errorf("error = %v, want lock-denied rpc-error", err)
+	}
+	if !strings.Contains(result.Stderr, "lock-denied") {
+		t.Errorf("Stderr = %q", result.Stderr)
+	}
+}
+
+func TestExecuteNetconfOperations(t *testing.T) {
+	device := &testNetconfDevice{}
+	port := startTestSSHServer(t, "secret", device.serve)
+
+	result, err := ExecuteNetconfOperations(context.Background(), "127.0.0.1", port, "admin", "secret",
+		netconfTransaction(t))
+	if err != nil {
+		t.Fatalf("ExecuteNetconfOperations() error = %v", err)
+	}
+	if result.SessionID != 4 {
+		t.Errorf("SessionID = %d, want 4", result.SessionID)
+	}
+	if len(result.Operations) != 5 {
+		t.Fatalf("got %d results, want 5", len(result.Operations))
+	}
+	if data := string(result.Operations[4].Reply.Data); data != "<system><hostname>srl1</hostname></system>" {
+		t.Errorf("get-config data = %q", data)
+	}
+	want := "lock edit-config validate commit get-config close-session"
+	if got := strings.Join(device.received(), " "); got != want {
+		t.Errorf("device received %q, want %q", got, want)
+	}
+}
+
+func TestExecuteNetconfOperations_Rollback(t *testing.T) {
+	device := &testNetconfDevice{fail: "validate"}
+	port := startTestSSHServer(t, "secret", device.serve)
+
+	result, err := ExecuteNetconfOperations(context.Background(), "127.0.0.1", port, "admin", "secret",
+		netconfTransaction(t))
+	if got := Category(err); got != CategoryRemoteError {
+		t.Fatalf("Category() = %q, want %q (error %v)", got, CategoryRemoteError, err)
+	}
+	if len(result.Operations) != 5 || result.Operations[2].Err == nil {
+		t.Fatalf("results = %+v, want validate to fail before the rollback", result.Operations)
+	}
+	for i, name := range []string{"discard-changes", "unlock"} {
+		if op := result.Operations[3+i]; op.Name != name || !op.Rollback || op.Err != nil {
+			t.Errorf("rollback result %d = %+v, want a successful %s", i, op, name)
+		}
+	}
+
+	// Candidate changes are discarded and the lock released before closing
+	want := "lock edit-config validate discard-changes unlock close-session"
+	if got := strings.Join(device.received(), " "); got != want {
+		t.Errorf("device received %q, want %q", got, want)
+	}
+}
+
+// netconfTransaction returns lock, edit, validate, commit and read back
+// operations on the candidate datastore
+func netconfTransaction(t *testing.T) []NetconfOperation {
+	t.Helper()
+
+	lock, err := netconf.Lock(netconf.Candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit, err := netconf.EditConfig(netconf.EditConfigOptions{
+		Target: netconf.Candidate,
+		Config: "<system><hostname>srl1</hostname></system>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate, err := netconf.Validate(netconf.Candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := netconf.Commit(netconf.CommitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getConfig, err := netconf.GetConfig(netconf.Running, netconf.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []NetconfOperation{
+		{Name: "lock", RPC: lock, Datastore: netconf.Candidate},
+		{Name: "edit-config", RPC: edit, Datastore: netconf.Candidate},
+		{Name: "validate", RPC: validate, Datastore: netconf.Candidate},
+		{Name: "commit", RPC: commit},
+		{Name: "get-config", RPC: getConfig, Datastore: netconf.Running},
+	}
+}
+
+func TestExecuteSSHCommand_ConnectionErrorCategory(t *testing.T) {
+	_, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", 22222, "admin", "password", "show version")
+	if err == nil {
+		t.Fatal("Expected connection error but got none")
+	}
+
+	if got := Category(err); got != CategoryConnectFailed {
+		t.Errorf("Category: got %q, want %q", got, CategoryConnectFailed)
+	}
+}
+
+func TestExecuteSSHCommand_ExitStatus(t *testing.T) {
+	port := startTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		_, _ = channel.Write([]byte("partial output\n"))
+		_, _ = channel.Stderr().Write([]byte("error: " + command + "\n"))
+		return 3
+	})
+
+	result, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", port, "admin", "secret", "show bogus")
+	if err == nil {
+		t.Fatal("Expected remote error but got none")
+	}
+
+	if got := Category(err); got != CategoryRemoteError {
+		t.Errorf("Category: got %q, want %q", got, CategoryRemoteError)
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("ExitCode: got %d, want 3", result.ExitCode)
+	}
+	if result.Stdout != "partial output\n" {
+		t.Errorf("Stdout: got %q", result.Stdout)
+	}
+	if result.Stderr != "error: show bogus\n" {
+		t.Errorf("Stderr: got %q", result.Stderr)
+	}
+	if result.Duration <= 0 {
+		t.Error("Duration should be recorded")
+	}
+}
+
+func TestExecuteSSHCommand_Success(t *testing.T) {
+	port := startTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		_, _ = channel.Write([]byte("version 1.0\n"))
+		return 0
+	})
+
+	result, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", port, "admin", "secret", "show version")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.ExitCode != 0 || result.Stdout != "version 1.0\n" || result.Stderr != "" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestExecuteSSHCommand_AuthFailed(t *testing.T) {
+	port := startTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		return 0
+	})
+
+	_, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", port, "admin", "wrong", "show version")
+	if err == nil {
+		t.Fatal("Expected authentication error but got none")
+	}
+
+	if got := Category(err); got != CategoryAuthFailed {
+		t.Errorf("Category: got %q, want %q", got, CategoryAuthFailed)
+	}
+}
+
+func TestExecuteSSHCommand_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	port := startTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		return 0
+	})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	if _, err := ExecuteSSHCommand(ctx, "localhost", port, "admin", "secret", "show version"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	parent.End()
+
+	names := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.Name() != "parent" && span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Span %s is not a child of the caller's span", span.Name())
+		}
+		names[span.Name()] = true
+	}
+	for _, want := range []string{"backend.resolve", "backend.dial", "ssh.handshake", "ssh.session", "ssh.exec"} {
+		if !names[want] {
+			t.Errorf("Missing span %s, got %v", want, names)
+		}
+	}
+}
+
+func TestPool_ReusesConnection(t *testing.T) {
+	server := newTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		_, _ = channel.Write([]byte(command))
+		return 0
+	})
+	usePool(t, PoolOptions{})
+
+	for i := 0; i < 3; i++ {
+		result, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", server.port, "admin", "secret", fmt.Sprintf("show %d", i))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Stdout != fmt.Sprintf("show %d", i) {
+			t.Errorf("Unexpected output: %q", result.Stdout)
+		}
+	}
+
+	if got := server.logins.Load(); got != 1 {
+		t.Errorf("Expected 1 login, got %d", got)
+	}
+}
+
+func TestPool_MaxConnsPerDevice(t *testing.T) {
+	server := newTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		time.Sleep(50 * time.Millisecond)
+		return 0
+	})
+	usePool(t, PoolOptions{MaxConnsPerDevice: 1, MaxSessionsPerConn: 2})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", server.port, "admin", "secret", "show version")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if got := server.logins.Load(); got != 1 {
+		t.Errorf("Expected 1 login, got %d", got)
+	}
+}
+
+func TestPool_EvictsOnAuthFailure(t *testing.T) {
+	server := newTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		return 0
+	})
+	pool := usePool(t, PoolOptions{MaxConnsPerDevice: 2, MaxSessionsPerConn: 1})
+
+	// Hold the only session of the first connection so the next one dials
+	first, reused, err := pool.acquire(context.Background(), "127.0.0.1", server.port, "admin", "secret")
+	if err != nil || reused {
+		t.Fatalf("acquire: reused=%v err=%v", reused, err)
+	}
+
+	// The password is changed on the device
+	server.password.Store("changed")
+	_, err = ExecuteSSHCommand(context.Background(), "127.0.0.1", server.port, "admin", "secret", "show version")
+	if got := Category(err); got != CategoryAuthFailed {
+		t.Fatalf("Category: got %q, want %q", got, CategoryAuthFailed)
+	}
+
+	pool.release(first, false)
+	pool.mu.Lock()
+	remaining := len(pool.conns)
+	pool.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected connection with revoked credentials to be closed, %d addresses left", remaining)
+	}
+}
+
+func TestPool_KeepaliveTimeout(t *testing.T) {
+	server := newTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		return 0
+	})
+	pool := usePool(t, PoolOptions{KeepaliveInterval: 20 * time.Millisecond})
+
+	pc, _, err := pool.acquire(context.Background(), "127.0.0.1", server.port, "admin", "secret")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer pool.release(pc, false)
+
+	// The device hangs, its connection is dropped although a session is open
+	server.silent.Store(true)
+	select {
+	case <-pc.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection with unanswered keepalives was not closed")
+	}
+	pool.mu.Lock()
+	remaining := len(pool.conns)
+	pool.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected the hung connection to be dropped, %d addresses left", remaining)
+	}
+}
+
+func TestPool_IdleTimeout(t *testing.T) {
+	server := newTestSSHServer(t, "secret", func(command string, channel ssh.Channel) uint32 {
+		return 0
+	})
+	pool := usePool(t, PoolOptions{IdleTimeout: 50 * time.Millisecond})
+
+	if _, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", server.port, "admin", "secret", "show version"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pool.mu.Lock()
+		remaining := len(pool.conns)
+		pool.mu.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Idle connection was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := ExecuteSSHCommand(context.Background(), "127.0.0.1", server.port, "admin", "secret", "show version"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := server.logins.Load(); got != 2 {
+		t.Errorf("Expected 2 logins, got %d", got)
+	}
+}
+
+func TestExecuteTelnetCommand_Script(t *testing.T) {
+	server := startTestTelnetServer(t, "secret")
+
+	result, err := ExecuteTelnetCommand(context.Background(), "127.0.0.1", server.port, "admin", "secret", "show version\nshow interfaces\n", TelnetOptions{Width: 300, Height: 40})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := "version 1.0\neth0 up\neth1 up\neth2 down\n"
+	if result.Stdout != want {
+		t.Errorf("Stdout = %q, want %q", result.Stdout, want)
+	}
+
+	negotiated := <-server.negotiated
+	for _, want := range [][]byte{
+		{telnetIAC, telnetWILL, telnetOptTType},
+		{telnetIAC, telnetWILL, telnetOptNAWS},
+		{telnetIAC, telnetSB, telnetOptNAWS, 1, 44, 0, 40, telnetIAC, telnetSE},
+		{telnetIAC, telnetDO, telnetOptEcho},
+		{telnetIAC, telnetDO, telnetOptSGA},
+		{telnetIAC, telnetWONT, 39},
+		append(append([]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeIs}, "vt100"...), telnetIAC, telnetSE),
+	} {
+		if !bytes.Contains(negotiated, want) {
+			t.Errorf("Missing negotiation reply %v in %v", want, negotiated)
+		}
+	}
+}
+
+func TestExecuteTelnetCommand_AuthFailed(t *testing.T) {
+	server := startTestTelnetServer(t, "secret")
+
+	_, err := ExecuteTelnetCommand(context.Background(), "127.0.0.1", server.port, "admin", "wrong", "show version", TelnetOptions{Timeout: 2 * time.Second})
+	if got := Category(err); got != CategoryAuthFailed {
+		t.Errorf("Category: got %q, want %q (%v)", got, CategoryAuthFailed, err)
+	}
+}
+
+func TestCleanTelnetOutput(t *testing.T) {
+	output := "show clock\r\n12:00\r\n \r          \rnext\r\nabc\b\bX\r\n\x1b[7mbold\x1b[0m\r\n"
+	want := "12:00\nnext\naX\nbold\n"
+	if got := cleanTelnetOutput(output, "show clock"); got != want {
+		t.Errorf("cleanTelnetOutput = %q, want %q", got, want)
+	}
+}
+
+// testTelnetServer is a telnet device that negotiates options, asks for a
+// login and pages "show interfaces" with a --More-- prompt
+type testTelnetServer struct {
+	port int
+	// negotiated receives the option replies sent by the client
+	negotiated chan []byte
+}
+
+func startTestTelnetServer(t *testing.T, password string) *testTelnetServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testTelnetServer{
+		port:       listener.Addr().(*net.TCPAddr).Port,
+		negotiated: make(chan []byte, 1),
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		server.serve(conn, password)
+	}()
+
+	return server
+}
+
+func (s *testTelnetServer) serve(conn net.Conn, password string) {
+	var negotiated []byte
+	defer func() { s.negotiated <- negotiated }()
+
+	reader := bufio.NewReader(conn)
+	// readLine returns the next input line, collecting IAC sequences apart
+	readLine := func() (string, error) {
+		var line []byte
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case b == telnetIAC:
+				command, _ := reader.ReadByte()
+				negotiated = append(negotiated, telnetIAC, command)
+				if command == telnetSB {
+					for {
+						b, _ := reader.ReadByte()
+						negotiated = append(negotiated, b)
+						if b == telnetSE && negotiated[len(negotiated)-2] == telnetIAC {
+							break
+						}
+					}
+				} else {
+					option, _ := reader.ReadByte()
+					negotiated = append(negotiated, option)
+				}
+			case b == '\n':
+				return strings.TrimRight(string(line), "\r"), nil
+			default:
+				line = append(line, b)
+			}
+		}
+	}
+
+	write := func(data ...[]byte) {
+		for _, d := range data {
+			_, _ = conn.Write(d)
+		}
+	}
+
+	write(
+		[]byte{telnetIAC, telnetDO, telnetOptTType},
+		[]byte{telnetIAC, telnetDO, telnetOptNAWS},
+		[]byte{telnetIAC, telnetWILL, telnetOptEcho},
+		[]byte{telnetIAC, telnetWILL, telnetOptSGA},
+		[]byte{telnetIAC, telnetDO, 39},
+		[]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE},
+		[]byte("\r\nWelcome\r\n\r\nUsername: "),
+	)
+	if _, err := readLine(); err != nil {
+		return
+	}
+	write([]byte("Password: "))
+	pass, err := readLine()
+	if err != nil {
+		return
+	}
+	if pass != password {
+		write([]byte("\r\n% Login incorrect\r\n\r\nUsername: "))
+		return
+	}
+	write([]byte("\r\nrouter1# "))
+
+	for {
+		command, err := readLine()
+		if err != nil {
+			return
+		}
+		write([]byte(command + "\r\n"))
+		switch command {
+		case "show version":
+			write([]byte("version 1.0\r\n"))
+		case "show interfaces":
+			write([]byte("eth0 up\r\neth1 up\r\n --More-- "))
+			if b, err := reader.ReadByte(); err != nil || b != ' ' {
+				return
+			}
+			write([]byte("\r          \reth2 down\r\n"))
+		case "exit":
+			return
+		}
+		write([]byte("router1# "))
+	}
+}
+
+// usePool installs a pool for the duration of the test
+func usePool(t *testing.T, opts PoolOptions) *Pool {
+	t.Helper()
+
+	pool := NewPool(opts)
+	SetPool(pool)
+	t.Cleanup(func() {
+		SetPool(nil)
+		pool.Close()
+	})
+	return pool
+}
+
+// testSSHServer is a local SSH server with a password that can be changed
+// and a count of successful logins
+type testSSHServer struct {
+	port     int
+	password atomic.Value
+	logins   atomic.Int32
+	// silent stops answering global requests such as keepalives
+	silent atomic.Bool
+}
+
+// startTestSSHServer starts an SSH server on a random local port that accepts
+// the given password and answers exec requests with handler
+func startTestSSHServer(t *testing.T, password string, handler func(command string, channel ssh.Channel) uint32) int {
+	t.Helper()
+	return newTestSSHServer(t, password, handler).port
+}
+
+func newTestSSHServer(t *testing.T, password string, handler func(command string, channel ssh.Channel) uint32) *testSSHServer {
+	t.Helper()
+
+	server := &testSSHServer{}
+	server.password.Store(password)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == server.password.Load().(string) {
+				server.logins.Add(1)
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConn(conn, serverConfig, handler, server.globalRequests)
+		}
+	}()
+
+	server.port = listener.Addr().(*net.TCPAddr).Port
+	return server
+}
+
+// globalRequests answers the global requests of a connection, or leaves
+// them unanswered like a hung device while silent is set
+func (s *testSSHServer) globalRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if !s.silent.Load() && req.WantReply {
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+func serveTestSSHConn(conn net.Conn, serverConfig *ssh.ServerConfig, handler func(command string, channel ssh.Channel) uint32, requests func(<-chan *ssh.Request)) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go requests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" && req.Type != "subsystem" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+				if req.Type == "subsystem" {
+					// Subsystems are passed to handler as "subsystem:<name>"
+					payload.Command = "subsystem:" + payload.Command
+				}
+
+				code := handler(payload.Command, channel)
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{code}))
+				return
+			}
+		}()
+	}
+}
+
+// testNetconfDevice is a NETCONF server speaking base:1.1. It answers
+// get-config with a fixed configuration and the operation named by fail with
+// an rpc-error, and records the operations it receives.
+type testNetconfDevice struct {
+	fail       string
+	operations []string
+	mu         sync.Mutex
+}
+
+func (d *testNetconfDevice) received() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.operations...)
+}
+
+func (d *testNetconfDevice) serve(command string, channel ssh.Channel) uint32 {
+	if command != "subsystem:netconf" {
+		return 1
+	}
+
+	transport := netconf.NewTransport(channel, channel)
+	if _, err := transport.ReadMessage(); err != nil {
+		return 1
+	}
+	hello := `<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><capabilities>` +
+		`<capability>urn:ietf:params:netconf:base:1.1</capability></capabilities><session-id>4</session-id></hello>`
+	if err := transport.WriteMessage([]byte(hello)); err != nil {
+		return 1
+	}
+	transport.SetChunked()
+
+	for {
+		msg, err := transport.ReadMessage()
+		if err != nil {
+			return 0
+		}
+		var rpc struct {
+			MessageID string `xml:"message-id,attr"`
+			Operation struct {
+				XMLName xml.Name
+			} `xml:",any"`
+		}
+		if err := xml.Unmarshal(msg, &rpc); err != nil {
+			return 1
+		}
+		operation := rpc.Operation.XMLName.Local
+		d.mu.Lock()
+		d.operations = append(d.operations, operation)
+		d.mu.Unlock()
+
+		var body string
+		switch operation {
+		case d.fail:
+			tag := "operation-failed"
+			if operation == "lock" {
+				tag = "lock-denied"
+			}
+			body = "<rpc-error><error-type>protocol</error-type><error-tag>" + tag + "</error-tag>" +
+				"<error-severity>error</error-severity><error-info><session-id>9</session-id></error-info></rpc-error>"
+		case "get-config":
+			body = "<data><system><hostname>srl1</hostname></system></data>"
+		default:
+			body = "<ok/>"
+		}
+		reply := fmt.Sprintf(`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="%s">%s</rpc-reply>`, rpc.MessageID, body)
+		if err := transport.WriteMessage([]byte(reply)); err != nil {
+			return 1
+		}
+		if operation == "close-session" {
+			return 0
+		}
+	}
 }
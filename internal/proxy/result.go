@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrorCategory classifies why a command did not complete successfully
+type ErrorCategory string
+
+const (
+	// CategoryConnectFailed means the device could not be reached
+	CategoryConnectFailed ErrorCategory = "connect_failed"
+	// CategoryAuthFailed means the device rejected the supplied credentials
+	CategoryAuthFailed ErrorCategory = "auth_failed"
+	// CategoryTimeout means the device did not answer in time
+	CategoryTimeout ErrorCategory = "timeout"
+	// CategoryRemoteError means the command ran but the device reported a failure
+	CategoryRemoteError ErrorCategory = "remote_error"
+)
+
+// Result holds the outcome of a command executed on a device
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// ExecError is returned by the Execute* functions and carries the failure category
+type ExecError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *ExecError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// newExecError wraps err with a category and a message prefix
+func newExecError(category ErrorCategory, format string, err error) *ExecError {
+	return &ExecError{
+		Category: category,
+		Err:      fmt.Errorf(format+": %w", err),
+	}
+}
+
+// Category returns the category of err, or an empty string if err is not an ExecError
+func Category(err error) ErrorCategory {
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		return execErr.Category
+	}
+	return ""
+}
+
+// classifyDialError maps an error from dialing and authenticating to a device
+func classifyDialError(err error) ErrorCategory {
+	if isTimeout(err) {
+		return CategoryTimeout
+	}
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return CategoryAuthFailed
+	}
+	return CategoryConnectFailed
+}
+
+// classifyIOError maps an error from reading or writing an established session
+func classifyIOError(err error) ErrorCategory {
+	if isTimeout(err) {
+		return CategoryTimeout
+	}
+	return CategoryRemoteError
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -2,53 +2,188 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/tracing"
 )
 
 // ExecuteSSHCommand executes a command on a remote device via SSH
-func ExecuteSSHCommand(hostname string, port int, username, password, command string) (string, error) {
-	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use proper host key verification
-		Timeout:         30 * time.Second,
-	}
-
-	address := fmt.Sprintf("%s:%d", hostname, port)
-	logger.Log.WithFields(map[string]interface{}{
+func ExecuteSSHCommand(ctx context.Context, hostname string, port int, username, password, command string) (*Result, error) {
+	start := time.Now()
+	result := &Result{}
+	defer func() { result.Duration = time.Since(start) }()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	exitCode, err := StreamSSHCommand(ctx, hostname, port, username, password, command, nil, &stdout, &stderr)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.ExitCode = exitCode
+	return result, err
+}
+
+// StreamSSHCommand executes a command on a remote device via SSH, passing
+// stdin and the output through as they flow. It returns the exit status of
+// the command, -1 if the session ended without one. A non-zero exit status
+// is also returned as a remote_error. Cancelling ctx ends the session.
+func StreamSSHCommand(ctx context.Context, hostname string, port int, username, password, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	logger.Log.WithContext(ctx).WithField("command", command).Debug("Executing SSH command")
+	return streamSSHSession(ctx, hostname, port, username, password, "ssh.exec", stdin, stdout, stderr, func(session *ssh.Session) error {
+		return session.Run(command)
+	})
+}
+
+// StreamSSHSubsystem runs an SSH subsystem such as sftp on a device, relaying
+// stdin, stdout and stderr until the device closes the session. Like
+// StreamSSHCommand it returns the exit status the device reports, -1 if it
+// reports none, and a non-zero exit status also as a remote_error.
+func StreamSSHSubsystem(ctx context.Context, hostname string, port int, username, password, subsystem string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	logger.Log.WithContext(ctx).WithField("subsystem", subsystem).Debug("Starting SSH subsystem")
+
+	// *ssh.Session only waits for the exit status of sessions it started,
+	// which a subsystem is not, so it runs on a raw channel
+	channel, release, err := openChannel(ctx, "SSH", hostname, port, username, password)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	stop := context.AfterFunc(ctx, func() { channel.Close() })
+	defer stop()
+
+	_, span := tracing.Start(ctx, "ssh.subsystem")
+	exitCode := 0
+	defer func() { span.SetAttributes(attribute.Int("exit_code", exitCode)); span.End() }()
+
+	exitCode, err = relaySubsystem(channel, subsystem, stdin, stdout, stderr)
+	if err == nil {
+		return 0, nil
+	}
+	span.RecordError(err)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return exitCode, newExecError(classifyIOError(ctxErr), "command execution interrupted", ctxErr)
+	}
+	return exitCode, newExecError(CategoryRemoteError, "command execution failed", err)
+}
+
+// relaySubsystem starts subsystem on channel and relays it until the device
+// closes the channel. It returns the exit status and an error when it is
+// not zero or missing.
+func relaySubsystem(channel *sessionChannel, subsystem string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	// The exit status arrives as a request on the channel, which must be
+	// read throughout so the channel does not stall
+	exitCode := -1
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for req := range channel.requests {
+			var status struct{ Status uint32 }
+			if req.Type == "exit-status" && ssh.Unmarshal(req.Payload, &status) == nil {
+				exitCode = int(status.Status)
+			}
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}()
+
+	ok, err := channel.SendRequest("subsystem", true, ssh.Marshal(struct{ Name string }{subsystem}))
+	if err == nil && !ok {
+		err = fmt.Errorf("subsystem %s refused", subsystem)
+	}
+	if err != nil {
+		channel.Close()
+		<-exited
+		return -1, err
+	}
+
+	go func() {
+		if stdin != nil {
+			_, _ = io.Copy(channel, stdin)
+		}
+		_ = channel.CloseWrite()
+	}()
+	errOutput := make(chan struct{})
+	go func() {
+		defer close(errOutput)
+		if stderr == nil {
+			stderr = io.Discard
+		}
+		_, _ = io.Copy(stderr, channel.Stderr())
+	}()
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	_, copyErr := io.Copy(stdout, channel)
+	if copyErr != nil {
+		channel.Close()
+	}
+	<-errOutput
+	// The device sends the exit status after its output, before it closes
+	// the channel
+	<-exited
+
+	switch {
+	case copyErr != nil:
+		return exitCode, copyErr
+	case exitCode < 0:
+		return exitCode, fmt.Errorf("%s exited without an exit status", subsystem)
+	case exitCode > 0:
+		return exitCode, fmt.Errorf("%s exited with status %d", subsystem, exitCode)
+	}
+	return 0, nil
+}
+
+// streamSSHSession opens a session on a device and runs it with run
+func streamSSHSession(ctx context.Context, hostname string, port int, username, password, spanName string, stdin io.Reader, stdout, stderr io.Writer, run func(*ssh.Session) error) (int, error) {
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"address":  address,
 		"username": username,
 	}).Debug("Connecting to SSH server")
 
-	client, err := ssh.Dial("tcp", address, config)
+	session, release, err := openSession(ctx, "SSH", hostname, port, username, password)
 	if err != nil {
-		return "", fmt.Errorf("failed to dial SSH: %w", err)
+		return 0, err
 	}
-	defer client.Close()
+	defer release()
 
-	session, err := client.NewSession()
-	if err != nil {
-		return "", fmt.Errorf("failed to create SSH session: %w", err)
-	}
-	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
 
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	session.Stdout = &stdout
-	session.Stderr = &stderr
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
 
-	logger.Log.WithField("command", command).Debug("Executing SSH command")
+	_, span := tracing.Start(ctx, spanName)
+	exitCode := 0
+	defer func() { span.SetAttributes(attribute.Int("exit_code", exitCode)); span.End() }()
 
-	if err := session.Run(command); err != nil {
-		return stdout.String() + stderr.String(), fmt.Errorf("command execution failed: %w", err)
+	err = run(session)
+	if err == nil {
+		return 0, nil
 	}
+	span.RecordError(err)
 
-	return stdout.String(), nil
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitStatus()
+	} else {
+		// The session ended without reporting an exit status
+		exitCode = -1
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return exitCode, newExecError(classifyIOError(ctxErr), "command execution interrupted", ctxErr)
+	}
+	return exitCode, newExecError(CategoryRemoteError, "command execution failed", err)
 }
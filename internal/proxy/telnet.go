@@ -1,86 +1,67 @@
 package proxy
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/tracing"
 )
 
-// ExecuteTelnetCommand executes a command on a remote device via Telnet
-func ExecuteTelnetCommand(hostname string, port int, username, password, command string) (string, error) {
-	address := fmt.Sprintf("%s:%d", hostname, port)
-	logger.Log.WithFields(map[string]interface{}{
+// ExecuteTelnetCommand executes a command on a remote device via Telnet.
+// A command spanning several lines is run as a script, one line at a time.
+func ExecuteTelnetCommand(ctx context.Context, hostname string, port int, username, password, command string, opts TelnetOptions) (*Result, error) {
+	start := time.Now()
+	result := &Result{}
+	defer func() { result.Duration = time.Since(start) }()
+
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	logger.Log.WithContext(ctx).WithFields(map[string]interface{}{
 		"address":  address,
 		"username": username,
 	}).Debug("Connecting to Telnet server")
 
-	conn, err := net.DialTimeout("tcp", address, 30*time.Second)
+	client, err := DialTelnet(ctx, hostname, port, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to telnet: %w", err)
+		return result, newExecError(classifyDialError(err), "failed to connect to telnet", err)
 	}
-	defer conn.Close()
+	defer client.Close()
 
-	// Set read/write deadlines
-	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
-		return "", fmt.Errorf("failed to set deadline: %w", err)
+	_, loginSpan := tracing.Start(ctx, "telnet.login")
+	err = client.Login(username, password)
+	tracing.End(loginSpan, err)
+	if errors.Is(err, ErrTelnetAuth) {
+		return result, newExecError(CategoryAuthFailed, "telnet login failed", err)
 	}
-
-	// Read initial prompt
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
 	if err != nil {
-		return "", fmt.Errorf("failed to read initial prompt: %w", err)
-	}
-
-	output := string(buf[:n])
-
-	// Send username
-	if _, err := conn.Write([]byte(username + "\r\n")); err != nil {
-		return "", fmt.Errorf("failed to send username: %w", err)
+		return result, newExecError(classifyIOError(err), "telnet login failed", err)
 	}
 
-	// Read password prompt
-	n, err = conn.Read(buf)
-	if err != nil {
-		return "", fmt.Errorf("failed to read password prompt: %w", err)
-	}
-	output += string(buf[:n])
+	logger.Log.WithContext(ctx).WithField("command", command).Debug("Executing Telnet command")
 
-	// Send password
-	if _, err := conn.Write([]byte(password + "\r\n")); err != nil {
-		return "", fmt.Errorf("failed to send password: %w", err)
-	}
+	_, span := tracing.Start(ctx, "telnet.exec")
+	output, err := client.RunScript(scriptLines(command))
+	tracing.End(span, err)
 
-	// Read login response
-	n, err = conn.Read(buf)
+	// Telnet has no notion of exit status, a completed exchange is reported as 0
+	result.Stdout = output
 	if err != nil {
-		return "", fmt.Errorf("failed to read login response: %w", err)
-	}
-	output += string(buf[:n])
-
-	// Send command
-	logger.Log.WithField("command", command).Debug("Executing Telnet command")
-	if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
-		return "", fmt.Errorf("failed to send command: %w", err)
-	}
-
-	// Read command output
-	time.Sleep(100 * time.Millisecond) // Brief delay for command execution
-
-	n, err = conn.Read(buf)
-	if err != nil && !strings.Contains(err.Error(), "i/o timeout") {
-		return output, fmt.Errorf("failed to read command output: %w", err)
+		return result, newExecError(classifyIOError(err), "failed to read command output", err)
 	}
+	return result, nil
+}
 
-	if n > 0 {
-		output += string(buf[:n])
+// scriptLines splits a command into its non-empty lines
+func scriptLines(command string) []string {
+	var lines []string
+	for _, line := range strings.Split(command, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
 	}
-
-	// Send exit command
-	_, _ = conn.Write([]byte("exit\r\n"))
-
-	return output, nil
+	return lines
 }
@@ -0,0 +1,512 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options (RFC 854, 857, 858, 1073, 1091)
+const (
+	telnetSE   byte = 240
+	telnetSB   byte = 250
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255
+
+	telnetOptEcho  byte = 1
+	telnetOptSGA   byte = 3
+	telnetOptTType byte = 24
+	telnetOptNAWS  byte = 31
+
+	telnetTTypeIs   byte = 0
+	telnetTTypeSend byte = 1
+)
+
+// Default prompt patterns, matched against the end of the received text
+const (
+	defaultLoginPrompt    = `(?i)(login|username|user name)\s*:\s*$`
+	defaultPasswordPrompt = `(?i)password\s*:\s*$`
+	defaultPrompt         = `[\w.\-@()/:~\[\]]+\s?[>#$%]\s*$`
+	defaultMorePrompt     = `(?i)(-+\s*\(?more\b[^\r\n]*?\)?\s*-*|<--- more --->)\s*$`
+	defaultLoginFailed    = `(?i)(login incorrect|login invalid|authentication failed|access denied|bad password)`
+)
+
+// ErrTelnetAuth is returned when the device rejects the telnet login
+var ErrTelnetAuth = errors.New("telnet login rejected")
+
+// terminalEscapes matches ANSI escape sequences that devices use to redraw
+// the line, for example to erase a --More-- prompt
+var terminalEscapes = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// TelnetOptions configures a telnet session. Empty patterns use defaults
+// that match the usual Cisco, Juniper, Nokia and Linux prompts.
+type TelnetOptions struct {
+	LoginPrompt    string
+	PasswordPrompt string
+	Prompt         string
+	MorePrompt     string
+	// Timeout bounds each wait for a prompt
+	Timeout time.Duration
+	// TerminalType is sent when the device asks for it (TTYPE)
+	TerminalType string
+	// Width and Height are sent as the window size (NAWS)
+	Width, Height int
+}
+
+// TelnetClient drives a line-oriented telnet session: it negotiates options,
+// logs in by matching prompts and runs commands until the prompt returns
+type TelnetClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	opts   TelnetOptions
+
+	loginPrompt    *regexp.Regexp
+	passwordPrompt *regexp.Regexp
+	prompt         *regexp.Regexp
+	morePrompt     *regexp.Regexp
+	loginFailed    *regexp.Regexp
+
+	// Options enabled on our side and on the device side. mu guards them
+	// and the window size, which Resize changes during Interact.
+	local  map[byte]bool
+	remote map[byte]bool
+	mu     sync.Mutex
+
+	// received holds decoded text not consumed by a prompt match yet
+	received strings.Builder
+}
+
+// DialTelnet connects to a telnet server
+func DialTelnet(ctx context.Context, hostname string, port int, opts TelnetOptions) (*TelnetClient, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.TerminalType == "" {
+		opts.TerminalType = "vt100"
+	}
+	if opts.Width <= 0 {
+		opts.Width = 200
+	}
+	if opts.Height <= 0 {
+		opts.Height = 24
+	}
+
+	c := &TelnetClient{
+		opts:   opts,
+		local:  make(map[byte]bool),
+		remote: make(map[byte]bool),
+	}
+
+	patterns := []struct {
+		target  **regexp.Regexp
+		pattern string
+		def     string
+	}{
+		{&c.loginPrompt, opts.LoginPrompt, defaultLoginPrompt},
+		{&c.passwordPrompt, opts.PasswordPrompt, defaultPasswordPrompt},
+		{&c.prompt, opts.Prompt, defaultPrompt},
+		{&c.morePrompt, opts.MorePrompt, defaultMorePrompt},
+		{&c.loginFailed, "", defaultLoginFailed},
+	}
+	for _, p := range patterns {
+		pattern := p.pattern
+		if pattern == "" {
+			pattern = p.def
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt pattern %q: %w", pattern, err)
+		}
+		*p.target = re
+	}
+
+	conn, err := dialTCP(ctx, hostname, port)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return c, nil
+}
+
+// Close ends the session
+func (c *TelnetClient) Close() error {
+	_, _ = c.conn.Write([]byte("exit\r\n"))
+	return c.conn.Close()
+}
+
+// Login answers the login and password prompts and waits for the command
+// prompt. Devices that go straight to a password or command prompt are
+// handled too.
+func (c *TelnetClient) Login(username, password string) error {
+	matched, _, err := c.readUntil(c.loginPrompt, c.passwordPrompt, c.prompt)
+	if err != nil {
+		return err
+	}
+
+	if matched == c.loginPrompt {
+		if err := c.writeLine(username); err != nil {
+			return err
+		}
+		if matched, _, err = c.readUntil(c.passwordPrompt, c.prompt, c.loginFailed); err != nil {
+			return err
+		}
+	}
+
+	if matched == c.passwordPrompt {
+		if err := c.writeLine(password); err != nil {
+			return err
+		}
+		if matched, _, err = c.readUntil(c.prompt, c.loginFailed, c.loginPrompt); err != nil {
+			return err
+		}
+	}
+
+	if matched != c.prompt {
+		return ErrTelnetAuth
+	}
+	return nil
+}
+
+// Run sends command and returns its output once the prompt returns, paging
+// through --More-- prompts. The echoed command and the prompt are removed.
+func (c *TelnetClient) Run(command string) (string, error) {
+	if err := c.writeLine(command); err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	for {
+		matched, text, err := c.readUntil(c.prompt, c.morePrompt)
+		output.WriteString(text)
+		if err != nil {
+			return cleanTelnetOutput(output.String(), command), err
+		}
+		if matched == c.prompt {
+			return cleanTelnetOutput(output.String(), command), nil
+		}
+		if _, err := c.conn.Write([]byte(" ")); err != nil {
+			return cleanTelnetOutput(output.String(), command), err
+		}
+	}
+}
+
+// RunScript runs each command in turn and returns the combined output.
+// It stops at the first command that fails.
+func (c *TelnetClient) RunScript(commands []string) (string, error) {
+	var output strings.Builder
+	for _, command := range commands {
+		out, err := c.Run(command)
+		output.WriteString(out)
+		if err != nil {
+			return output.String(), err
+		}
+	}
+	return output.String(), nil
+}
+
+// Resize changes the window size sent to the device (NAWS)
+func (c *TelnetClient) Resize(width, height int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts.Width, c.opts.Height = width, height
+	if !c.local[telnetOptNAWS] {
+		return nil
+	}
+	return c.sendWindowSize()
+}
+
+// Interact relays an interactive session between client and the device
+// until either side closes it. Text from the device is passed on as it
+// arrives, and option negotiation is answered as during Login.
+func (c *TelnetClient) Interact(client io.ReadWriter) error {
+	_ = c.conn.SetReadDeadline(time.Time{})
+	errc := make(chan error, 2)
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := client.Read(buf)
+			if n > 0 {
+				if werr := c.writeData(buf[:n]); werr != nil {
+					errc <- werr
+					return
+				}
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			err := c.readData()
+			if c.received.Len() > 0 {
+				if _, werr := io.WriteString(client, c.received.String()); werr != nil {
+					errc <- werr
+					return
+				}
+				c.received.Reset()
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	err := <-errc
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// writeData sends terminal input, escaping IAC and sending CR as CR NUL
+func (c *TelnetClient) writeData(data []byte) error {
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch b {
+		case telnetIAC:
+			out = append(out, telnetIAC, telnetIAC)
+		case '\r':
+			out = append(out, '\r', 0)
+		default:
+			out = append(out, b)
+		}
+	}
+	_, err := c.conn.Write(out)
+	return err
+}
+
+// writeLine sends a line terminated by CR LF
+func (c *TelnetClient) writeLine(line string) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// readUntil reads until the received text matches one of patterns and
+// returns the pattern and the text before the match. The matched text is
+// consumed.
+func (c *TelnetClient) readUntil(patterns ...*regexp.Regexp) (*regexp.Regexp, string, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.opts.Timeout))
+
+	for {
+		text := c.received.String()
+		for _, re := range patterns {
+			if loc := re.FindStringIndex(text); loc != nil {
+				c.received.Reset()
+				c.received.WriteString(text[loc[1]:])
+				return re, text[:loc[0]], nil
+			}
+		}
+
+		if err := c.readData(); err != nil {
+			return nil, text, err
+		}
+	}
+}
+
+// readData reads from the connection, answering option negotiation and
+// appending the data bytes to received. It returns after at least one byte.
+func (c *TelnetClient) readData() error {
+	b, err := c.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	for {
+		if b == telnetIAC {
+			if err := c.handleCommand(); err != nil {
+				return err
+			}
+		} else if b != 0 {
+			// NUL follows a bare CR and carries nothing
+			c.received.WriteByte(b)
+		}
+
+		if c.reader.Buffered() == 0 {
+			return nil
+		}
+		if b, err = c.reader.ReadByte(); err != nil {
+			return err
+		}
+	}
+}
+
+// handleCommand processes the bytes following an IAC
+func (c *TelnetClient) handleCommand() error {
+	command, err := c.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case telnetIAC:
+		// Escaped 255 data byte
+		c.received.WriteByte(telnetIAC)
+		return nil
+	case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+		option, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		return c.negotiate(command, option)
+	case telnetSB:
+		return c.handleSubnegotiation()
+	}
+	// NOP, GA and the other commands carry no option
+	return nil
+}
+
+// negotiate answers an option request. We offer TTYPE, NAWS and SGA, and
+// accept ECHO and SGA from the device; everything else is refused.
+func (c *TelnetClient) negotiate(command, option byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch command {
+	case telnetDO:
+		switch option {
+		case telnetOptTType, telnetOptNAWS, telnetOptSGA:
+			if c.local[option] {
+				return nil
+			}
+			c.local[option] = true
+			if err := c.send(telnetIAC, telnetWILL, option); err != nil {
+				return err
+			}
+			if option == telnetOptNAWS {
+				return c.sendWindowSize()
+			}
+			return nil
+		}
+		return c.send(telnetIAC, telnetWONT, option)
+
+	case telnetDONT:
+		if !c.local[option] {
+			return nil
+		}
+		c.local[option] = false
+		return c.send(telnetIAC, telnetWONT, option)
+
+	case telnetWILL:
+		switch option {
+		case telnetOptEcho, telnetOptSGA:
+			if c.remote[option] {
+				return nil
+			}
+			c.remote[option] = true
+			return c.send(telnetIAC, telnetDO, option)
+		}
+		return c.send(telnetIAC, telnetDONT, option)
+
+	case telnetWONT:
+		if !c.remote[option] {
+			return nil
+		}
+		c.remote[option] = false
+		return c.send(telnetIAC, telnetDONT, option)
+	}
+	return nil
+}
+
+// handleSubnegotiation reads IAC SB <option> ... IAC SE and answers TTYPE SEND
+func (c *TelnetClient) handleSubnegotiation() error {
+	var data []byte
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != telnetIAC {
+			data = append(data, b)
+			continue
+		}
+		next, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if next == telnetSE {
+			break
+		}
+		data = append(data, next)
+	}
+
+	if len(data) >= 2 && data[0] == telnetOptTType && data[1] == telnetTTypeSend {
+		reply := []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeIs}
+		reply = append(reply, c.opts.TerminalType...)
+		reply = append(reply, telnetIAC, telnetSE)
+		return c.send(reply...)
+	}
+	return nil
+}
+
+// sendWindowSize sends the NAWS subnegotiation, doubling 255 bytes as
+// required. mu must be held.
+func (c *TelnetClient) sendWindowSize() error {
+	reply := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, v := range []int{c.opts.Width, c.opts.Height} {
+		for _, b := range []byte{byte(v >> 8), byte(v)} {
+			reply = append(reply, b)
+			if b == telnetIAC {
+				reply = append(reply, telnetIAC)
+			}
+		}
+	}
+	reply = append(reply, telnetIAC, telnetSE)
+	return c.send(reply...)
+}
+
+func (c *TelnetClient) send(data ...byte) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// cleanTelnetOutput normalizes line endings, removes terminal redraw
+// sequences and drops the echoed command from the first line
+func cleanTelnetOutput(output, command string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	output = terminalEscapes.ReplaceAllString(output, "")
+
+	// Apply backspaces within a line
+	if strings.Contains(output, "\b") {
+		var b []byte
+		for i := 0; i < len(output); i++ {
+			if output[i] != '\b' {
+				b = append(b, output[i])
+			} else if len(b) > 0 && b[len(b)-1] != '\n' {
+				b = b[:len(b)-1]
+			}
+		}
+		output = string(b)
+	}
+
+	// Lines redrawn with a bare CR keep only the text after it
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if idx := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); idx >= 0 {
+			line = line[idx+1:]
+		}
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == strings.TrimSpace(command) {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "\n")
+}
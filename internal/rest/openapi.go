@@ -0,0 +1,319 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb "github.com/safabayar/gateway/proto"
+)
+
+// handleOpenAPI serves GET /v1/openapi.json
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPISpec())
+}
+
+// OpenAPISpec builds the OpenAPI 3 document for the REST API.
+// Schemas are generated from the protobuf descriptors so they follow the
+// gRPC messages as the proto file evolves.
+func OpenAPISpec() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	for _, msg := range []proto.Message{
+		&pb.CommandResponse{},
+		&pb.ParseRequest{},
+		&pb.ParseResponse{},
+		&pb.ListDevicesResponse{},
+		&pb.NetconfRequest{},
+		&pb.NetconfResponse{},
+		&pb.UserCertificateRequest{},
+		&pb.UserCertificateResponse{},
+		&pb.BeginUserCertificateRequest{},
+		&pb.BeginUserCertificateResponse{},
+		&pb.ApproveUserCertificateRequest{},
+		&pb.ApproveUserCertificateResponse{},
+	} {
+		addSchema(schemas, proto.MessageV2(msg).ProtoReflect().Descriptor())
+	}
+
+	// The FQDN of a NETCONF request comes from the path, and exactly one
+	// operation of the oneof must be set
+	delete(schemas["NetconfRequest"].(map[string]interface{})["properties"].(map[string]interface{}), "fqdn")
+	schemas["NetconfOperation"].(map[string]interface{})["description"] = "Exactly one operation must be set"
+
+	// The request ID of an approval comes from the path
+	delete(schemas["ApproveUserCertificateRequest"].(map[string]interface{})["properties"].(map[string]interface{}), "request_id")
+
+	// The exec body is CommandRequest without the FQDN, which comes from the path
+	execRequest := messageSchema(proto.MessageV2(&pb.CommandRequest{}).ProtoReflect().Descriptor())
+	properties := execRequest["properties"].(map[string]interface{})
+	delete(properties, "fqdn")
+	properties["commands"] = map[string]interface{}{
+		"type":        "array",
+		"items":       map[string]interface{}{"type": "string"},
+		"description": "Commands executed in order after command",
+	}
+	schemas["ExecRequest"] = execRequest
+
+	schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"code":    map[string]interface{}{"type": "integer", "description": "gRPC status code"},
+			"status":  map[string]interface{}{"type": "string", "description": "gRPC status code name"},
+			"message": map[string]interface{}{"type": "string"},
+			"error_category": map[string]interface{}{
+				"type":        "string",
+				"description": "Why a command failed on the gateway side: CONNECT_FAILED, AUTH_FAILED or TIMEOUT",
+			},
+			"backend_duration_ms": map[string]interface{}{"type": "integer", "description": "Time spent on the device"},
+			"results": map[string]interface{}{
+				"type":        "array",
+				"items":       ref("NetconfResult"),
+				"description": "NETCONF operations run before the failure, with the rollback",
+			},
+		},
+	}
+
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref("Error")},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Gateway API",
+			"description": "HTTP/JSON mirror of the gateway.Gateway gRPC service",
+			"version":     "v1",
+		},
+		"paths": map[string]interface{}{
+			"/v1/devices": map[string]interface{}{
+				"get": map[string]interface{}{
+					"operationId": "ListDevices",
+					"summary":     "List devices reachable through the gateway",
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("ListDevicesResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+			"/v1/devices/{fqdn}/exec": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "ExecuteCommand",
+					"summary":     "Execute one or more commands on a device",
+					"description": "Send Accept: text/event-stream (or ?stream=sse) to receive each result as a Server-Sent Event, " +
+						"or Accept: application/x-ndjson (or ?stream=true) for newline-delimited JSON. " +
+						"Device credentials may be given in the body or with HTTP basic auth.",
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "fqdn",
+							"in":       "path",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "string"},
+						},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("ExecRequest")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("CommandResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+			"/v1/devices/{fqdn}/netconf": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "ExecuteNetconf",
+					"summary":     "Run NETCONF operations in order over one session",
+					"description": "Execution stops at the first failed operation. Uncommitted candidate changes made by the " +
+						"request are then discarded and the locks it took are released. " +
+						"Device credentials may be given in the body or with HTTP basic auth.",
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "fqdn",
+							"in":       "path",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "string"},
+						},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("NetconfRequest")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("NetconfResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+			"/v1/certificates": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "IssueUserCertificate",
+					"summary":     "Sign an SSH public key of a gateway user for logins to the bastion",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("UserCertificateRequest")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("UserCertificateResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+			"/v1/certificates/requests": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "BeginUserCertificate",
+					"summary":     "Start a certificate request verified by key, OIDC device flow or approval",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("BeginUserCertificateRequest")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("BeginUserCertificateResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+			"/v1/certificates/requests/{request_id}/approve": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "ApproveUserCertificate",
+					"summary":     "Approve or deny a certificate request as an approver",
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "request_id",
+							"in":       "path",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "string"},
+						},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("ApproveUserCertificateRequest")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("ApproveUserCertificateResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+			"/v1/parse": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "ParseOutput",
+					"summary":     "Parse sample output with a stored or inline template",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("ParseRequest")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonResponse("ParseResponse"),
+						"default": errorResponse,
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+// addSchema adds the schema of a message and of every message it references
+func addSchema(schemas map[string]interface{}, md protoreflect.MessageDescriptor) {
+	name := string(md.Name())
+	if _, exists := schemas[name]; exists {
+		return
+	}
+	schemas[name] = messageSchema(md)
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if fd := fields.Get(i); fd.Kind() == protoreflect.MessageKind {
+			addSchema(schemas, fd.Message())
+		}
+	}
+}
+
+// messageSchema builds the JSON schema of a message using the proto field names
+func messageSchema(md protoreflect.MessageDescriptor) map[string]interface{} {
+	properties := map[string]interface{}{}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		schema := fieldSchema(fd)
+		if fd.IsList() {
+			schema = map[string]interface{}{"type": "array", "items": schema}
+		}
+		properties[string(fd.Name())] = schema
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// fieldSchema maps a field kind to its proto3 JSON representation
+func fieldSchema(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "uint32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	case protoreflect.MessageKind:
+		return ref(string(fd.Message().Name()))
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonResponse(schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": "OK",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref(schema)},
+		},
+	}
+}
+
+// OpenAPIJSON returns the OpenAPI document as indented JSON
+func OpenAPIJSON() ([]byte, error) {
+	return json.MarshalIndent(OpenAPISpec(), "", "  ")
+}
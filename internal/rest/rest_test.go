@@ -0,0 +1,424 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/config"
+	grpcserver "github.com/safabayar/gateway/internal/grpc"
+	"github.com/safabayar/gateway/internal/logger"
+	pb "github.com/safabayar/gateway/proto"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/rest_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+// fakeGateway answers ExecuteCommand with the command echoed back and fails on "fail"
+type fakeGateway struct {
+	pb.UnimplementedGatewayServer
+	requests        []*pb.CommandRequest
+	netconfRequests []*pb.NetconfRequest
+	authorization   []string
+	totpCodes       []string
+}
+
+func (f *fakeGateway) ExecuteNetconf(ctx context.Context, req *pb.NetconfRequest) (*pb.NetconfResponse, error) {
+	f.netconfRequests = append(f.netconfRequests, req)
+	if req.Fqdn == "lost" {
+		st, err := status.New(codes.Unavailable, "session lost").WithDetails(&pb.NetconfResponse{
+			Results: []*pb.NetconfResult{
+				{Operation: "edit-config", Ok: true},
+				{Operation: "discard-changes", Ok: true},
+			},
+			Error:         "session lost",
+			ErrorCategory: pb.ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+	return &pb.NetconfResponse{
+		SessionId: 7,
+		Results:   []*pb.NetconfResult{{Operation: "get-config", Data: "<system/>"}},
+	}, nil
+}
+
+func (f *fakeGateway) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*pb.CommandResponse, error) {
+	f.requests = append(f.requests, req)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		f.authorization = append(f.authorization, md.Get("authorization")...)
+		f.totpCodes = append(f.totpCodes, md.Get("x-totp-code")...)
+	}
+	switch req.Command {
+	case "fail":
+		return nil, status.Error(codes.Unavailable, "device unreachable")
+	case "timeout":
+		st, err := status.New(codes.DeadlineExceeded, "device timed out").WithDetails(&pb.CommandResponse{
+			Error:             "device timed out",
+			ErrorCategory:     pb.ErrorCategory_ERROR_CATEGORY_TIMEOUT,
+			BackendDurationMs: 5000,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+	return &pb.CommandResponse{Output: req.Command, Stdout: req.Command}, nil
+}
+
+func newTestServer(gateway pb.GatewayServer) *httptest.Server {
+	mux := http.NewServeMux()
+	NewServer(gateway).Register(mux)
+	return httptest.NewServer(mux)
+}
+
+func TestExec_Single(t *testing.T) {
+	gateway := &fakeGateway{}
+	ts := newTestServer(gateway)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/devices/srl1.example.com/exec",
+		strings.NewReader(`{"command": "show version", "protocol": "ssh"}`))
+	req.SetBasicAuth("admin", "secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: got %d, want 200", resp.StatusCode)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["stdout"] != "show version" {
+		t.Errorf("stdout: got %v", body["stdout"])
+	}
+	if _, ok := body["exit_code"]; !ok {
+		t.Error("Response should use proto field names and include defaults")
+	}
+
+	got := gateway.requests[0]
+	if got.Fqdn != "srl1.example.com" || got.Username != "admin" || got.Password != "secret" {
+		t.Errorf("Unexpected request forwarded: %+v", got)
+	}
+}
+
+func TestExec_BearerToken(t *testing.T) {
+	gateway := &fakeGateway{}
+	ts := newTestServer(gateway)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/devices/srl1.example.com/exec",
+		strings.NewReader(`{"command": "show version", "username": "admin"}`))
+	req.Header.Set("Authorization", "Bearer s3cret-token")
+	req.Header.Set("X-TOTP-Code", "123456")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: got %d, want 200", resp.StatusCode)
+	}
+	if len(gateway.authorization) != 1 || gateway.authorization[0] != "Bearer s3cret-token" {
+		t.Errorf("Authorization metadata: got %v", gateway.authorization)
+	}
+	if len(gateway.totpCodes) != 1 || gateway.totpCodes[0] != "123456" {
+		t.Errorf("TOTP code metadata: got %v", gateway.totpCodes)
+	}
+}
+
+func TestExec_ErrorMapping(t *testing.T) {
+	ts := newTestServer(&fakeGateway{})
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/v1/devices/srl1/exec", "application/json", strings.NewReader(`{"command": "fail"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Status: got %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	var body errorBody
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != int(codes.Unavailable) || body.Message != "device unreachable" {
+		t.Errorf("Unexpected error body: %+v", body)
+	}
+
+	// Commands the gateway failed to run report their category and timing
+	resp, err = http.Post(ts.URL+"/v1/devices/srl1/exec", "application/json", strings.NewReader(`{"command": "timeout"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body = errorBody{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout || body.ErrorCategory != "ERROR_CATEGORY_TIMEOUT" || body.BackendDurationMs != 5000 {
+		t.Errorf("Unexpected timeout response %d: %+v", resp.StatusCode, body)
+	}
+
+	// Missing command and unknown fields are rejected
+	for _, payload := range []string{`{}`, `{"command": "x", "bogus": 1}`, `not json`} {
+		resp, err := http.Post(ts.URL+"/v1/devices/srl1/exec", "application/json", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Payload %s: got status %d, want 400", payload, resp.StatusCode)
+		}
+	}
+}
+
+func TestExec_ServerSentEvents(t *testing.T) {
+	ts := newTestServer(&fakeGateway{})
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/devices/srl1/exec",
+		strings.NewReader(`{"commands": ["one", "timeout", "two", "fail", "never"]}`))
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type: got %s", ct)
+	}
+
+	data := readAll(t, resp)
+	// A command the gateway failed to run does not end the stream
+	if strings.Count(data, "event: result") != 2 || !strings.Contains(data, "ERROR_CATEGORY_TIMEOUT") {
+		t.Errorf("Expected 2 result events around the timeout, got: %s", data)
+	}
+	if strings.Count(data, "event: error") != 2 || strings.Contains(data, "never") {
+		t.Errorf("Stream should stop at the first other error, got: %s", data)
+	}
+}
+
+func TestExec_NDJSON(t *testing.T) {
+	ts := newTestServer(&fakeGateway{})
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/v1/devices/srl1/exec?stream=true", "application/json",
+		strings.NewReader(`{"command": "one", "commands": ["two"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := strings.Split(strings.TrimSpace(readAll(t, resp)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %v", len(lines), lines)
+	}
+	for i, want := range []string{"one", "two"} {
+		var line struct {
+			Result map[string]interface{} `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &line); err != nil {
+			t.Fatalf("Line %d is not JSON: %v", i, err)
+		}
+		if line.Result["stdout"] != want {
+			t.Errorf("Line %d: got %v, want %s", i, line.Result["stdout"], want)
+		}
+	}
+}
+
+func TestListDevicesAndParse(t *testing.T) {
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"srl2": {Hostname: "10.0.0.2", SSHPort: 22, GNMIPort: 57400, Platform: "nokia_srl"},
+			"srl1": {Hostname: "10.0.0.1", SSHPort: 22},
+		},
+		Settings: config.Settings{DomainSuffix: "example.com"},
+	}
+	ts := newTestServer(grpcserver.NewServer(cfg, nil))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Devices []struct {
+			Name      string   `json:"name"`
+			Fqdn      string   `json:"fqdn"`
+			Protocols []string `json:"protocols"`
+		} `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Devices) != 2 || list.Devices[0].Name != "srl1" || list.Devices[1].Fqdn != "srl2.example.com" {
+		t.Errorf("Unexpected devices: %+v", list.Devices)
+	}
+	if len(list.Devices[1].Protocols) != 2 {
+		t.Errorf("Unexpected protocols: %v", list.Devices[1].Protocols)
+	}
+
+	resp, err = http.Post(ts.URL+"/v1/parse", "application/json", strings.NewReader(
+		`{"template": "Value A (\\S+)\n\nStart\n  ^${A} -> Record\n", "text": "x\ny\n"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var parsed pb.ParseResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ParsedOutput != `[{"A":"x"},{"A":"y"}]` {
+		t.Errorf("ParsedOutput: got %s", parsed.ParsedOutput)
+	}
+}
+
+func TestNetconf(t *testing.T) {
+	gateway := &fakeGateway{}
+	ts := newTestServer(gateway)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/devices/srl1.example.com/netconf",
+		strings.NewReader(`{"operations": [{"lock": {"target": "candidate"}}, {"get_config": {"filter": {"xpath": "/system"}}}]}`))
+	req.SetBasicAuth("admin", "secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: got %d, want 200: %s", resp.StatusCode, readAll(t, resp))
+	}
+
+	if len(gateway.netconfRequests) != 1 {
+		t.Fatalf("Expected 1 NETCONF request, got %d", len(gateway.netconfRequests))
+	}
+	got := gateway.netconfRequests[0]
+	if got.Fqdn != "srl1.example.com" || got.Username != "admin" || got.Password != "secret" {
+		t.Errorf("Request: got %+v", got)
+	}
+	if len(got.Operations) != 2 || got.Operations[0].GetLock().GetTarget() != "candidate" ||
+		got.Operations[1].GetGetConfig().GetFilter().GetXpath() != "/system" {
+		t.Errorf("Operations: got %v", got.Operations)
+	}
+
+	var body struct {
+		SessionID string `json:"session_id"`
+		Results   []struct {
+			Operation string `json:"operation"`
+			Data      string `json:"data"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.SessionID != "7" || len(body.Results) != 1 || body.Results[0].Data != "<system/>" {
+		t.Errorf("Body: got %+v", body)
+	}
+
+	// A gateway-side failure keeps the results of the operations that ran
+	resp, err = http.Post(ts.URL+"/v1/devices/lost/netconf", "application/json",
+		strings.NewReader(`{"username": "admin", "operations": [{"edit_config": {"config": "<a/>"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var failure struct {
+		ErrorCategory string `json:"error_category"`
+		Results       []struct {
+			Operation string `json:"operation"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || failure.ErrorCategory != "ERROR_CATEGORY_CONNECT_FAILED" ||
+		len(failure.Results) != 2 || failure.Results[1].Operation != "discard-changes" {
+		t.Errorf("Failure %d: got %+v", resp.StatusCode, failure)
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	ts := newTestServer(&fakeGateway{})
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var spec struct {
+		Paths      map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/v1/devices", "/v1/devices/{fqdn}/exec", "/v1/devices/{fqdn}/netconf", "/v1/parse", "/v1/certificates", "/v1/certificates/requests", "/v1/certificates/requests/{request_id}/approve"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("Missing path %s", path)
+		}
+	}
+	if _, ok := spec.Components.Schemas["CommandResponse"].Properties["exit_code"]; !ok {
+		t.Error("CommandResponse schema should be generated from the proto descriptor")
+	}
+	if _, ok := spec.Components.Schemas["Device"]; !ok {
+		t.Error("Nested Device schema should be included")
+	}
+	if _, ok := spec.Components.Schemas["ExecRequest"].Properties["fqdn"]; ok {
+		t.Error("ExecRequest should not contain fqdn")
+	}
+	if _, ok := spec.Components.Schemas["NetconfRequest"].Properties["fqdn"]; ok {
+		t.Error("NetconfRequest should not contain fqdn")
+	}
+	if _, ok := spec.Components.Schemas["NetconfEditConfig"]; !ok {
+		t.Error("Nested NETCONF operation schemas should be included")
+	}
+}
+
+func readAll(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
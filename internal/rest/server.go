@@ -0,0 +1,490 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/logger"
+	pb "github.com/safabayar/gateway/proto"
+)
+
+// maxBodyBytes limits the size of request bodies
+const maxBodyBytes = 1 << 20
+
+// Stream modes for command execution
+const (
+	streamNone   = ""
+	streamSSE    = "sse"
+	streamNDJSON = "ndjson"
+)
+
+// Server exposes the Gateway service as an HTTP/JSON API.
+// Every handler calls the gRPC service implementation, so validation,
+// device credentials and error semantics are identical on both endpoints.
+type Server struct {
+	gateway   pb.GatewayServer
+	marshaler *jsonpb.Marshaler
+}
+
+// NewServer creates a new REST API server on top of a Gateway service implementation
+func NewServer(gateway pb.GatewayServer) *Server {
+	return &Server{
+		gateway: gateway,
+		marshaler: &jsonpb.Marshaler{
+			OrigName:     true,
+			EmitDefaults: true,
+		},
+	}
+}
+
+// Register adds the API routes to mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/devices", s.handleListDevices)
+	mux.HandleFunc("POST /v1/devices/{fqdn}/exec", s.handleExec)
+	mux.HandleFunc("POST /v1/devices/{fqdn}/netconf", s.handleNetconf)
+	mux.HandleFunc("POST /v1/parse", s.handleParse)
+	mux.HandleFunc("POST /v1/certificates", s.handleCertificate)
+	mux.HandleFunc("POST /v1/certificates/requests", s.handleCertificateRequest)
+	mux.HandleFunc("POST /v1/certificates/requests/{request_id}/approve", s.handleCertificateApproval)
+	mux.HandleFunc("GET /v1/openapi.json", s.handleOpenAPI)
+}
+
+// execRequest is the body of POST /v1/devices/{fqdn}/exec.
+// It mirrors CommandRequest with the FQDN taken from the path and an
+// optional list of commands that are executed in order.
+type execRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Protocol string   `json:"protocol"`
+	Command  string   `json:"command"`
+	Commands []string `json:"commands"`
+	Parse    bool     `json:"parse"`
+}
+
+// errorBody is the JSON representation of a failed call. Commands and
+// NETCONF operations the gateway failed to run also report their error
+// category and timing, and NETCONF the results of the operations that ran.
+type errorBody struct {
+	Code              int               `json:"code"`
+	Status            string            `json:"status"`
+	Message           string            `json:"message"`
+	ErrorCategory     string            `json:"error_category,omitempty"`
+	BackendDurationMs int64             `json:"backend_duration_ms,omitempty"`
+	Results           []json.RawMessage `json:"results,omitempty"`
+}
+
+// handleListDevices serves GET /v1/devices
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.gateway.ListDevices(callContext(r), &pb.ListDevicesRequest{})
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeMessage(w, resp)
+}
+
+// handleParse serves POST /v1/parse
+func (s *Server) handleParse(w http.ResponseWriter, r *http.Request) {
+	req := &pb.ParseRequest{}
+	if err := s.decodeMessage(w, r, req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	resp, err := s.gateway.ParseOutput(callContext(r), req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeMessage(w, resp)
+}
+
+// handleCertificate serves POST /v1/certificates
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	req := &pb.UserCertificateRequest{}
+	if err := s.decodeMessage(w, r, req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	resp, err := s.gateway.IssueUserCertificate(callContext(r), req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeMessage(w, resp)
+}
+
+// handleCertificateRequest serves POST /v1/certificates/requests
+func (s *Server) handleCertificateRequest(w http.ResponseWriter, r *http.Request) {
+	req := &pb.BeginUserCertificateRequest{}
+	if err := s.decodeMessage(w, r, req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	resp, err := s.gateway.BeginUserCertificate(callContext(r), req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeMessage(w, resp)
+}
+
+// handleCertificateApproval serves
+// POST /v1/certificates/requests/{request_id}/approve
+func (s *Server) handleCertificateApproval(w http.ResponseWriter, r *http.Request) {
+	req := &pb.ApproveUserCertificateRequest{}
+	if err := s.decodeMessage(w, r, req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	req.RequestId = r.PathValue("request_id")
+
+	resp, err := s.gateway.ApproveUserCertificate(callContext(r), req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeMessage(w, resp)
+}
+
+// callContext returns the context of r as the gateway sees gRPC calls: the
+// client address is the peer, which the certificate API throttles failed
+// logins by, and a bearer token in the Authorization header is the
+// authorization metadata, which authenticates gateway users, with the TOTP
+// code of the X-TOTP-Code header
+func callContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addrPort)})
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		md := metadata.Pairs("authorization", auth)
+		if code := r.Header.Get("X-TOTP-Code"); code != "" {
+			md.Set("x-totp-code", code)
+		}
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return ctx
+}
+
+// handleExec serves POST /v1/devices/{fqdn}/exec
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request) {
+	var body execRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		s.writeError(w, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid request body: %v", err)))
+		return
+	}
+
+	// Device credentials may also be supplied with HTTP basic auth
+	if username, password, ok := r.BasicAuth(); ok && body.Username == "" && body.Password == "" {
+		body.Username = username
+		body.Password = password
+	}
+
+	commands := body.Commands
+	if body.Command != "" {
+		commands = append([]string{body.Command}, commands...)
+	}
+	if len(commands) == 0 {
+		s.writeError(w, status.Error(codes.InvalidArgument, "command is required"))
+		return
+	}
+
+	requests := make([]*pb.CommandRequest, 0, len(commands))
+	for _, command := range commands {
+		requests = append(requests, &pb.CommandRequest{
+			Fqdn:     r.PathValue("fqdn"),
+			Username: body.Username,
+			Password: body.Password,
+			Command:  command,
+			Protocol: body.Protocol,
+			Parse:    body.Parse,
+		})
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"fqdn":     r.PathValue("fqdn"),
+		"commands": len(requests),
+		"remote":   r.RemoteAddr,
+	}).Info("Received REST exec request")
+
+	if mode := streamMode(r); mode != streamNone {
+		s.streamExec(w, r, mode, requests)
+		return
+	}
+
+	ctx := callContext(r)
+	if len(requests) == 1 {
+		resp, err := s.gateway.ExecuteCommand(ctx, requests[0])
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeMessage(w, resp)
+		return
+	}
+
+	results := make([]json.RawMessage, 0, len(requests))
+	for _, req := range requests {
+		resp, err := s.gateway.ExecuteCommand(ctx, req)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		data, err := s.marshal(resp)
+		if err != nil {
+			s.writeError(w, status.Error(codes.Internal, err.Error()))
+			return
+		}
+		results = append(results, data)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// handleNetconf serves POST /v1/devices/{fqdn}/netconf
+func (s *Server) handleNetconf(w http.ResponseWriter, r *http.Request) {
+	req := &pb.NetconfRequest{}
+	if err := s.decodeMessage(w, r, req); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	req.Fqdn = r.PathValue("fqdn")
+
+	// Device credentials may also be supplied with HTTP basic auth
+	if username, password, ok := r.BasicAuth(); ok && req.Username == "" && req.Password == "" {
+		req.Username = username
+		req.Password = password
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"fqdn":       req.Fqdn,
+		"operations": len(req.Operations),
+		"remote":     r.RemoteAddr,
+	}).Info("Received REST NETCONF request")
+
+	resp, err := s.gateway.ExecuteNetconf(callContext(r), req)
+	if err != nil {
+		s.writeNetconfError(w, err)
+		return
+	}
+	s.writeMessage(w, resp)
+}
+
+// streamExec executes commands one by one and writes each response as soon
+// as it is available, either as Server-Sent Events or as newline-delimited
+// JSON over a chunked response. A command the gateway failed to run is
+// reported as an error event and the next command still runs, as with the
+// StreamCommand RPC; any other error ends the stream.
+func (s *Server) streamExec(w http.ResponseWriter, r *http.Request, mode string, requests []*pb.CommandRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, status.Error(codes.Unimplemented, "streaming is not supported by this connection"))
+		return
+	}
+
+	if mode == streamSSE {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := callContext(r)
+	for _, req := range requests {
+		if ctx.Err() != nil {
+			return
+		}
+
+		resp, err := s.gateway.ExecuteCommand(ctx, req)
+		if err != nil {
+			data, _ := json.Marshal(statusBody(err))
+			writeStreamEvent(w, mode, "error", data)
+			flusher.Flush()
+			if commandFailure(err) == nil {
+				return
+			}
+			continue
+		}
+
+		data, err := s.marshal(resp)
+		if err != nil {
+			logger.Log.WithError(err).Error("Failed to encode stream response")
+			return
+		}
+		writeStreamEvent(w, mode, "result", data)
+		flusher.Flush()
+	}
+
+	if mode == streamSSE {
+		writeStreamEvent(w, mode, "done", []byte("{}"))
+		flusher.Flush()
+	}
+}
+
+// streamMode selects the streaming format from the request
+func streamMode(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/event-stream"):
+		return streamSSE
+	case strings.Contains(accept, "application/x-ndjson"):
+		return streamNDJSON
+	case r.URL.Query().Get("stream") == "sse":
+		return streamSSE
+	case r.URL.Query().Get("stream") == "true" || r.URL.Query().Get("stream") == "ndjson":
+		return streamNDJSON
+	}
+	return streamNone
+}
+
+// writeStreamEvent writes one event in the given stream format
+func writeStreamEvent(w http.ResponseWriter, mode, event string, data []byte) {
+	if mode == streamSSE {
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		return
+	}
+	if event == "done" {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "{%q:%s}\n", event, data)
+}
+
+// decodeMessage decodes a JSON request body into a protobuf message
+func (s *Server) decodeMessage(w http.ResponseWriter, r *http.Request, msg proto.Message) error {
+	unmarshaler := &jsonpb.Unmarshaler{}
+	if err := unmarshaler.Unmarshal(http.MaxBytesReader(w, r.Body, maxBodyBytes), msg); err != nil {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("invalid request body: %v", err))
+	}
+	return nil
+}
+
+// marshal encodes a protobuf message using the proto field names
+func (s *Server) marshal(msg proto.Message) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := s.marshaler.Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeMessage writes a protobuf message as a JSON response
+func (s *Server) writeMessage(w http.ResponseWriter, msg proto.Message) {
+	data, err := s.marshal(msg)
+	if err != nil {
+		s.writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// writeError writes a gRPC status error as a JSON response with the matching HTTP status
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	body := statusBody(err)
+	writeJSON(w, httpStatusFromCode(codes.Code(body.Code)), body)
+}
+
+// writeNetconfError writes a failed NETCONF call, with the results of the
+// operations that ran before a gateway-side failure
+func (s *Server) writeNetconfError(w http.ResponseWriter, err error) {
+	body := statusBody(err)
+	for _, detail := range status.Convert(err).Details() {
+		resp, ok := detail.(*pb.NetconfResponse)
+		if !ok {
+			continue
+		}
+		body.ErrorCategory = resp.ErrorCategory.String()
+		body.BackendDurationMs = resp.BackendDurationMs
+		for _, result := range resp.Results {
+			data, err := s.marshal(result)
+			if err != nil {
+				continue
+			}
+			body.Results = append(body.Results, data)
+		}
+	}
+	writeJSON(w, httpStatusFromCode(codes.Code(body.Code)), body)
+}
+
+// statusBody converts an error to its JSON representation
+func statusBody(err error) errorBody {
+	st := status.Convert(err)
+	body := errorBody{
+		Code:    int(st.Code()),
+		Status:  st.Code().String(),
+		Message: st.Message(),
+	}
+	if resp := commandFailure(err); resp != nil {
+		body.ErrorCategory = resp.ErrorCategory.String()
+		body.BackendDurationMs = resp.BackendDurationMs
+	}
+	return body
+}
+
+// commandFailure returns the response a gateway-side command failure
+// carries in its status, or nil for other errors
+func commandFailure(err error) *pb.CommandResponse {
+	for _, detail := range status.Convert(err).Details() {
+		if resp, ok := detail.(*pb.CommandResponse); ok {
+			return resp
+		}
+	}
+	return nil
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// httpStatusFromCode maps gRPC status codes to HTTP status codes
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
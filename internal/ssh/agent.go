@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/safabayar/gateway/internal/proxy"
+)
+
+// agentChannelType is the channel the bastion opens back to the client to
+// reach the agent it forwarded
+const agentChannelType = "auth-agent@openssh.com"
+
+var errAgentClosed = errors.New("agent forwarding ended with the session")
+
+// agentForwarder is the SSH agent a bastion user forwarded. Its channel is
+// opened when a device login first needs the keys and stays open for the
+// other logins of the session. The keys never leave the client, the agent
+// signs each login.
+type agentForwarder struct {
+	conn ssh.Conn
+
+	mu      sync.Mutex
+	channel ssh.Channel
+	client  agent.ExtendedAgent
+	closed  bool
+}
+
+// agentForwarderKey carries the agentForwarder of a session in its context
+type agentForwarderKey struct{}
+
+func newAgentForwarder(conn ssh.Conn) *agentForwarder {
+	return &agentForwarder{conn: conn}
+}
+
+// signers returns the keys of the forwarded agent
+func (f *agentForwarder) signers() ([]ssh.Signer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, errAgentClosed
+	}
+	if f.client == nil {
+		channel, requests, err := f.conn.OpenChannel(agentChannelType, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open agent channel: %w", err)
+		}
+		go ssh.DiscardRequests(requests)
+		f.channel = channel
+		f.client = agent.NewClient(channel)
+	}
+	return f.client.Signers()
+}
+
+// Close closes the agent channel at the end of the session
+func (f *agentForwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.channel != nil {
+		f.channel.Close()
+	}
+}
+
+// agentContext returns ctx with the session's forwarded agent for logins to
+// the device, if the user forwarded one and the device's credential profile
+// allows it
+func (bs *BastionServer) agentContext(ctx context.Context, deviceName string) (context.Context, bool) {
+	fwd, ok := ctx.Value(agentForwarderKey{}).(*agentForwarder)
+	if !ok || !bs.config.AgentForwarding(deviceName) {
+		return ctx, false
+	}
+	return proxy.WithAgent(ctx, fwd.signers), true
+}
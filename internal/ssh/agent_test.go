@@ -0,0 +1,114 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+func TestAgentForwarding(t *testing.T) {
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userSigner, _ := ssh.NewSignerFromKey(userKey)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The devices accept the user's personal key and the password "secret"
+	serverConfig := func() *ssh.ServerConfig {
+		return &ssh.ServerConfig{
+			PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				if string(key.Marshal()) == string(userSigner.PublicKey().Marshal()) {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("unknown key")
+			},
+			PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+				if string(pass) == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("password rejected")
+			},
+		}
+	}
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: startTestExecDeviceWithConfig(t, serverConfig())},
+			"router2": {Hostname: "127.0.0.1", SSHPort: startTestExecDeviceWithConfig(t, serverConfig()), Credentials: "core"},
+		},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			Credentials: map[string]config.CredentialSettings{
+				"core": {DisableAgentForwarding: true},
+			},
+		},
+	}
+	_, address := startTestBastionWithConfig(t, cfg)
+
+	t.Run("agent key", func(t *testing.T) {
+		stdin, stdout := startAgentShell(t, address, "admin%router1.test.local", userKey)
+		stdin.Close()
+		output, _ := io.ReadAll(stdout)
+		if !strings.Contains(string(output), "Welcome admin") || strings.Contains(string(output), "Password") {
+			t.Errorf("output = %q, want a device shell without password prompt", output)
+		}
+	})
+
+	t.Run("refused agent key", func(t *testing.T) {
+		// The password is only asked for once the device refuses the key
+		stdin, stdout := startAgentShell(t, address, "admin%router1.test.local", otherKey)
+		readUntil(t, stdout, "Password for admin@router1: ")
+		writeString(t, stdin, "secret\r")
+		readUntil(t, stdout, "Welcome admin")
+	})
+
+	t.Run("disabled by profile", func(t *testing.T) {
+		stdin, stdout := startAgentShell(t, address, "admin%router2.test.local", userKey)
+		readUntil(t, stdout, "Password for admin@router2: ")
+		writeString(t, stdin, "secret\r")
+		readUntil(t, stdout, "Welcome admin")
+	})
+}
+
+// startAgentShell opens a shell on the bastion as user, forwarding an agent
+// holding key
+func startAgentShell(t *testing.T, address, user string, key ed25519.PrivateKey) (io.WriteCloser, io.Reader) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	client := dialTestBastionAs(t, address, user)
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatalf("RequestAgentForwarding failed: %v", err)
+	}
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	return stdin, stdout
+}
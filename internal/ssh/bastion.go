@@ -1,19 +1,27 @@
 package ssh
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/safabayar/gateway/internal/auth"
 	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/listen"
 	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/proxy"
 )
 
 // BastionServer implements SSH bastion/jump server functionality
@@ -22,9 +30,22 @@ type BastionServer struct {
 	sshConfig          *ssh.ServerConfig
 	authorizedKeys     map[string]ssh.PublicKey
 	authorizedKeysPath string
-	listener           net.Listener
-	watcher            *fsnotify.Watcher
-	mu                 sync.RWMutex
+	authorizedKeysErr  error
+	// userKeys holds the gateway user each key of settings.users is bound
+	// to, by its wire encoding
+	userKeys    map[string]string
+	userCA      *auth.UserCA
+	totp        *auth.TOTPStore
+	limits      *loginLimiter
+	listener    net.Listener
+	watcher     *fsnotify.Watcher
+	onListening func()
+	ctx         context.Context
+	cancel      context.CancelFunc
+	conns       map[*ssh.ServerConn]struct{}
+	sessions    map[ssh.Channel]struct{}
+	connWG      sync.WaitGroup
+	mu          sync.RWMutex
 }
 
 // NewBastionServer creates a new SSH bastion server
@@ -33,7 +54,21 @@ func NewBastionServer(cfg *config.Config, hostKeyPath string, authorizedKeysPath
 		config:             cfg,
 		authorizedKeys:     make(map[string]ssh.PublicKey),
 		authorizedKeysPath: authorizedKeysPath,
+		conns:              make(map[*ssh.ServerConn]struct{}),
+		sessions:           make(map[ssh.Channel]struct{}),
 	}
+	limits, err := newLoginLimiter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bastion limits: %w", err)
+	}
+	bs.limits = limits
+	if bs.userKeys, err = auth.UserKeys(cfg); err != nil {
+		return nil, err
+	}
+
+	// Device logins of connections that proved a trusted key or
+	// certificate use the device keys, see connContext
+	bs.ctx, bs.cancel = context.WithCancel(context.Background())
 
 	// Load authorized keys for client authentication
 	if err := bs.loadAuthorizedKeys(authorizedKeysPath); err != nil {
@@ -48,6 +83,7 @@ func NewBastionServer(cfg *config.Config, hostKeyPath string, authorizedKeysPath
 	// Configure SSH server
 	sshConfig := &ssh.ServerConfig{
 		PublicKeyCallback: bs.publicKeyCallback,
+		AuthLogCallback:   bs.limits.logAttempt,
 	}
 
 	// Load host key
@@ -68,8 +104,10 @@ func (bs *BastionServer) loadAuthorizedKeys(path string) error {
 		// If file doesn't exist, create empty map
 		if os.IsNotExist(err) {
 			logger.Log.Warn("Authorized keys file not found, will accept all keys (INSECURE)")
+			bs.setAuthorizedKeysErr(nil)
 			return nil
 		}
+		bs.setAuthorizedKeysErr(err)
 		return err
 	}
 
@@ -96,12 +134,26 @@ func (bs *BastionServer) loadAuthorizedKeys(path string) error {
 	// Thread-safe update of authorized keys
 	bs.mu.Lock()
 	bs.authorizedKeys = newKeys
+	bs.authorizedKeysErr = nil
 	bs.mu.Unlock()
 
 	logger.Log.Infof("Loaded %d authorized keys", len(newKeys))
 	return nil
 }
 
+func (bs *BastionServer) setAuthorizedKeysErr(err error) {
+	bs.mu.Lock()
+	bs.authorizedKeysErr = err
+	bs.mu.Unlock()
+}
+
+// AuthorizedKeysStatus returns the error of the last authorized keys load, if any
+func (bs *BastionServer) AuthorizedKeysStatus() error {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.authorizedKeysErr
+}
+
 // watchAuthorizedKeys starts watching the authorized keys file for changes
 func (bs *BastionServer) watchAuthorizedKeys() error {
 	if bs.authorizedKeysPath == "" {
@@ -178,8 +230,24 @@ func generateHostKey(path string) (ssh.Signer, error) {
 	return nil, fmt.Errorf("host key file not found: %s", path)
 }
 
-// publicKeyCallback validates client public keys
+// publicKeyCallback validates client public keys, followed by a TOTP code
+// for users who enrolled one
 func (bs *BastionServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	perms, err := bs.checkPublicKey(conn, key)
+	if err != nil {
+		return nil, err
+	}
+	// The source policy and failures belong to the gateway user of the key
+	if err := bs.limits.checkLogin(conn, perms.Extensions[gatewayUserExtension]); err != nil {
+		return nil, err
+	}
+	return bs.secondFactor(conn, perms)
+}
+
+// checkPublicKey validates client public keys. Keys bound to a gateway user
+// in settings.users are accepted for that user, whose name is kept in the
+// gateway-user extension of the permissions.
+func (bs *BastionServer) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	logger.Log.WithFields(map[string]interface{}{
 		"user":     conn.User(),
 		"remote":   conn.RemoteAddr().String(),
@@ -190,11 +258,34 @@ func (bs *BastionServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.Public
 	bs.mu.RLock()
 	keyCount := len(bs.authorizedKeys)
 	_, exists := bs.authorizedKeys[string(key.Marshal())]
+	userCA := bs.userCA
 	bs.mu.RUnlock()
 
-	// If no authorized keys loaded, accept all (INSECURE - for development only)
-	if keyCount == 0 {
+	if cert, ok := key.(*ssh.Certificate); ok && userCA != nil {
+		return bs.certificateCallback(conn, cert, userCA)
+	}
+
+	if gatewayUser, ok := bs.userKeys[string(key.Marshal())]; ok {
+		logger.Log.WithFields(map[string]interface{}{
+			"user":         conn.User(),
+			"gateway_user": gatewayUser,
+		}).Info("Accepted public key of gateway user")
+		metrics.AuthAttempts.WithLabelValues("bastion", "success", "publickey").Inc()
+		return &ssh.Permissions{
+			Extensions: map[string]string{
+				"pubkey-fp":          ssh.FingerprintSHA256(key),
+				gatewayUserExtension: gatewayUser,
+				deviceKeysExtension:  "",
+			},
+		}, nil
+	}
+
+	// If no authorized keys loaded and no user CA is set, accept all
+	// (INSECURE - for development only). These clients are not trusted with
+	// the device keys.
+	if keyCount == 0 && len(bs.userKeys) == 0 && userCA == nil {
 		logger.Log.Warn("No authorized keys configured, accepting all connections (INSECURE)")
+		metrics.AuthAttempts.WithLabelValues("bastion", "success", "no_authorized_keys").Inc()
 		return &ssh.Permissions{
 			Extensions: map[string]string{
 				"pubkey-fp": ssh.FingerprintSHA256(key),
@@ -205,50 +296,156 @@ func (bs *BastionServer) publicKeyCallback(conn ssh.ConnMetadata, key ssh.Public
 	// Check if key is authorized
 	if exists {
 		logger.Log.Infof("Accepted public key for user %s", conn.User())
+		metrics.AuthAttempts.WithLabelValues("bastion", "success", "publickey").Inc()
 		return &ssh.Permissions{
 			Extensions: map[string]string{
-				"pubkey-fp": ssh.FingerprintSHA256(key),
+				"pubkey-fp":         ssh.FingerprintSHA256(key),
+				deviceKeysExtension: "",
 			},
 		}, nil
 	}
 
 	logger.Log.Warnf("Rejected public key for user %s (fingerprint: %s)", conn.User(), ssh.FingerprintSHA256(key))
+	metrics.AuthAttempts.WithLabelValues("bastion", "failure", "unknown_key").Inc()
 	return nil, fmt.Errorf("unknown public key for %s", conn.User())
 }
 
+// certificateCallback validates a user certificate. Its principals are the
+// login names it is valid for, the device user for logins naming a device.
+// The gateway user it was issued to is kept in the permissions.
+func (bs *BastionServer) certificateCallback(conn ssh.ConnMetadata, cert *ssh.Certificate, userCA *auth.UserCA) (*ssh.Permissions, error) {
+	if err := userCA.Check(bastionUser(conn.User()), cert); err != nil {
+		logger.Log.WithError(err).WithFields(map[string]interface{}{
+			"user":   conn.User(),
+			"key_id": cert.KeyId,
+			"serial": cert.Serial,
+		}).Warn("Rejected user certificate")
+		metrics.AuthAttempts.WithLabelValues("bastion", "failure", "certificate").Inc()
+		return nil, fmt.Errorf("invalid certificate for %s: %w", conn.User(), err)
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"user":   conn.User(),
+		"key_id": cert.KeyId,
+		"serial": cert.Serial,
+	}).Info("Accepted user certificate")
+	metrics.AuthAttempts.WithLabelValues("bastion", "success", "certificate").Inc()
+	perms := &ssh.Permissions{
+		Extensions: map[string]string{
+			"pubkey-fp":         ssh.FingerprintSHA256(cert.Key),
+			"cert-key-id":       cert.KeyId,
+			deviceKeysExtension: "",
+		},
+	}
+	if gatewayUser, ok := auth.CertificateUser(cert); ok {
+		perms.Extensions[gatewayUserExtension] = gatewayUser
+	}
+	return perms, nil
+}
+
+// deviceKeysExtension marks the permissions of connections that proved a
+// key or certificate the gateway trusts
+const deviceKeysExtension = "device-keys"
+
+// connContext returns the context of the device logins of conn. Only
+// connections that proved a trusted key or certificate log in to devices
+// with the device keys.
+func (bs *BastionServer) connContext(conn *ssh.ServerConn) context.Context {
+	if conn.Permissions != nil {
+		if _, ok := conn.Permissions.Extensions[deviceKeysExtension]; ok {
+			return proxy.WithDeviceKeys(bs.ctx)
+		}
+	}
+	return bs.ctx
+}
+
 // Start starts the SSH bastion server
 func (bs *BastionServer) Start(address string) error {
-	listener, err := net.Listen("tcp", address)
+	listener, err := listen.TCP(address, bs.config.Settings.ProxyProtocol.SSH)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", address, err)
 	}
+	return bs.Serve(listener)
+}
 
+// Serve accepts SSH connections on listener until it is closed
+func (bs *BastionServer) Serve(listener net.Listener) error {
+	bs.mu.Lock()
 	bs.listener = listener
-	logger.Log.Infof("SSH bastion server listening on %s", address)
+	bs.mu.Unlock()
+	logger.Log.Infof("SSH bastion server listening on %s", listener.Addr())
+	if bs.onListening != nil {
+		bs.onListening()
+	}
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				logger.Log.Info("SSH bastion server stopped accepting connections")
+				return nil
+			}
 			logger.Log.WithError(err).Error("Failed to accept connection")
 			continue
 		}
 
-		go bs.handleConnection(conn)
+		bs.connWG.Add(1)
+		go func() {
+			defer bs.connWG.Done()
+			// The client address of a PROXY protocol header is read here,
+			// not in the accept loop
+			if reason, ok := bs.limits.admit(conn.RemoteAddr()); !ok {
+				logger.Log.WithField("remote", conn.RemoteAddr().String()).Debugf("Refused connection: %s", reason)
+				metrics.BastionConnections.WithLabelValues(reason).Inc()
+				conn.Close()
+				return
+			}
+			bs.handleConnection(conn)
+		}()
 	}
 }
 
+// SetUserCA makes the bastion accept user certificates signed by ca, in
+// addition to the authorized keys
+func (bs *BastionServer) SetUserCA(ca *auth.UserCA) {
+	bs.mu.Lock()
+	bs.userCA = ca
+	bs.mu.Unlock()
+}
+
+// OnListening sets a function called once the server accepts connections
+func (bs *BastionServer) OnListening(fn func()) {
+	bs.onListening = fn
+}
+
 // handleConnection handles an incoming SSH connection
 func (bs *BastionServer) handleConnection(netConn net.Conn) {
 	logger.Log.Infof("New connection from %s", netConn.RemoteAddr())
 
 	// Perform SSH handshake
 	sshConn, chans, reqs, err := ssh.NewServerConn(netConn, bs.sshConfig)
+	user := ""
+	if sshConn != nil {
+		user = connGatewayUser(sshConn)
+	}
+	bs.limits.finish(netConn.RemoteAddr(), user, err)
 	if err != nil {
 		logger.Log.WithError(err).Error("Failed to handshake")
+		metrics.BastionConnections.WithLabelValues("rejected").Inc()
 		netConn.Close()
 		return
 	}
 	defer sshConn.Close()
+	metrics.BastionConnections.WithLabelValues("accepted").Inc()
+
+	bs.mu.Lock()
+	bs.conns[sshConn] = struct{}{}
+	bs.mu.Unlock()
+	defer func() {
+		bs.mu.Lock()
+		delete(bs.conns, sshConn)
+		bs.mu.Unlock()
+	}()
 
 	logger.Log.Infof("SSH connection established for user %s from %s", sshConn.User(), sshConn.RemoteAddr())
 
@@ -261,10 +458,21 @@ func (bs *BastionServer) handleConnection(netConn net.Conn) {
 	}
 }
 
-// handleChannel handles an SSH channel (session, direct-tcpip, etc.)
+// handleChannel handles an SSH channel (session, direct-tcpip, etc.). Users
+// who must enroll a second factor only get sessions, which enroll them.
 func (bs *BastionServer) handleChannel(sshConn *ssh.ServerConn, newChannel ssh.NewChannel) {
 	logger.Log.Debugf("New channel type: %s", newChannel.ChannelType())
 
+	if newChannel.ChannelType() != "session" && bs.needsTOTPEnrollment(connGatewayUser(sshConn)) {
+		logger.Log.WithFields(map[string]interface{}{
+			"user":         sshConn.User(),
+			"gateway_user": connGatewayUser(sshConn),
+			"channel":      newChannel.ChannelType(),
+		}).Warn("Refused channel of a user who must enroll TOTP")
+		_ = newChannel.Reject(ssh.Prohibited, "TOTP enrollment required, open a shell to enroll")
+		return
+	}
+
 	switch newChannel.ChannelType() {
 	case "session":
 		bs.handleSession(sshConn, newChannel)
@@ -302,7 +510,24 @@ func (bs *BastionServer) handleSession(sshConn *ssh.ServerConn, newChannel ssh.N
 	}
 	defer channel.Close()
 
+	bs.mu.Lock()
+	bs.sessions[channel] = struct{}{}
+	bs.mu.Unlock()
+	defer func() {
+		bs.mu.Lock()
+		delete(bs.sessions, channel)
+		bs.mu.Unlock()
+	}()
+
 	username := sshConn.User()
+	gatewayUser := connGatewayUser(sshConn)
+	if bs.needsTOTPEnrollment(gatewayUser) {
+		bs.handleEnrollmentSession(channel, requests, gatewayUser)
+		return
+	}
+	deviceUser, target, routed := parseTargetUser(username)
+	// ctx carries the forwarded agent of the session, if any
+	ctx := bs.connContext(sshConn)
 
 	// Terminal info from client
 	var termInfo ptyRequestMsg
@@ -329,21 +554,69 @@ func (bs *BastionServer) handleSession(sshConn *ssh.ServerConn, newChannel ssh.N
 			logger.Log.Infof("PTY size (after defaults): cols=%d, rows=%d", termInfo.Columns, termInfo.Rows)
 			_ = req.Reply(true, nil)
 
+		case "auth-agent-req@openssh.com":
+			if _, ok := ctx.Value(agentForwarderKey{}).(*agentForwarder); !ok {
+				logger.Log.Infof("Agent forwarding requested by %s", username)
+				fwd := newAgentForwarder(sshConn)
+				defer fwd.Close()
+				ctx = context.WithValue(ctx, agentForwarderKey{}, fwd)
+			}
+			_ = req.Reply(true, nil)
+
 		case "shell":
 			_ = req.Reply(true, nil)
+			resize := make(chan WindowSize, 1)
+			go windowChanges(requests, resize)
+			if routed {
+				bs.connectTarget(ctx, channel, deviceUser, target, &termInfo, resize)
+				return
+			}
 			// Run interactive shell with terminal info
-			bs.runInteractiveShellWithPty(channel, username, &termInfo, requests)
+			bs.runInteractiveShellWithPty(ctx, channel, username, gatewayUser, &termInfo, resize)
 			return
 
 		case "exec":
-			// Parse command - expected format: "ssh router1.myCustomer.safabayar.net"
-			command := string(req.Payload[4:]) // Skip length prefix
+			// Either "ssh <device-fqdn>" for a shell or "<device-fqdn> <command>"
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			command := payload.Command
 
 			logger.Log.Infof("Exec request from %s: %s", username, command)
 
-			// Handle the command with terminal info
-			bs.handleCommandWithPty(channel, username, command, &termInfo, requests)
+			if routed {
+				_ = req.Reply(true, nil)
+				status := bs.handleTargetExec(ctx, channel, requests, username, deviceUser, target, command)
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+
+			if strings.HasPrefix(command, "ssh ") {
+				// Handle the command with terminal info
+				resize := make(chan WindowSize, 1)
+				go windowChanges(requests, resize)
+				bs.handleCommandWithPty(ctx, channel, username, command, &termInfo, resize)
+				_ = req.Reply(true, nil)
+				return
+			}
+
 			_ = req.Reply(true, nil)
+			status := bs.handleExec(ctx, channel, requests, username, command)
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+
+		case "subsystem":
+			// sftp is relayed to the device named in the login name
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" || !routed {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			status := bs.handleSFTP(ctx, channel, requests, username, deviceUser, target)
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
 			return
 
 		default:
@@ -352,15 +625,108 @@ func (bs *BastionServer) handleSession(sshConn *ssh.ServerConn, newChannel ssh.N
 	}
 }
 
+// bastionUser is the user a login name logs in to devices as: the device
+// user for logins naming a device, else the login name. It is not who the
+// client is, see connGatewayUser.
+func bastionUser(login string) string {
+	if deviceUser, _, routed := parseTargetUser(login); routed {
+		return deviceUser
+	}
+	return login
+}
+
+// parseTargetUser splits a login name that names the device to connect to,
+// either "<device-user>%<device-fqdn>" or "<device>+<device-user>"
+func parseTargetUser(user string) (deviceUser, target string, ok bool) {
+	if deviceUser, target, found := strings.Cut(user, "%"); found {
+		return deviceUser, target, deviceUser != "" && target != ""
+	}
+	if target, deviceUser, found := strings.Cut(user, "+"); found {
+		return deviceUser, target, deviceUser != "" && target != ""
+	}
+	return "", "", false
+}
+
+// targetPassword returns the password of the device's credential profile
+// when the profile is for deviceUser. No password is needed when the gateway
+// logs in to the device as deviceUser with a key alone.
+func (bs *BastionServer) targetPassword(ctx context.Context, deviceName, deviceUser string) (string, bool) {
+	username, password, err := bs.config.DeviceCredentials(deviceName)
+	if err == nil && username == deviceUser {
+		return password, true
+	}
+	device := bs.config.Devices[deviceName]
+	return "", !proxy.NeedsPassword(ctx, device.Hostname, device.SSHPort, deviceUser)
+}
+
+// connectTarget opens a shell on the device named in the login name instead
+// of the menu. The device password is asked for unless the device's
+// credential profile is for the same user, or only once the device refuses
+// the keys of a forwarded agent.
+func (bs *BastionServer) connectTarget(ctx context.Context, channel io.ReadWriter, deviceUser, target string, termInfo *ptyRequestMsg, resize <-chan WindowSize) {
+	device, deviceName, err := bs.config.GetDeviceByFQDN(target)
+	if err != nil {
+		_, _ = channel.Write([]byte(fmt.Sprintf("Error: %s\r\n", err)))
+		return
+	}
+
+	ctx, forwarded := bs.agentContext(ctx, deviceName)
+	password, ok := bs.targetPassword(ctx, deviceName, deviceUser)
+	switch {
+	case ok:
+	case forwarded:
+		ctx = bs.passwordPrompt(ctx, channel, fmt.Sprintf("Password for %s@%s: ", deviceUser, deviceName))
+	default:
+		_, _ = channel.Write([]byte(fmt.Sprintf("Password for %s@%s: ", deviceUser, deviceName)))
+		password, err = bs.readPassword(channel)
+		if err != nil {
+			_, _ = channel.Write([]byte(fmt.Sprintf("\r\nError reading password: %s\r\n", err)))
+			return
+		}
+		_, _ = channel.Write([]byte("\r\n"))
+	}
+
+	logger.Log.Infof("Routing session directly to %s as %s", deviceName, deviceUser)
+	bs.proxyToDeviceWithPty(ctx, channel, device, deviceUser, password, termInfo, resize)
+}
+
+// windowChanges passes the sizes of window-change requests on to resize,
+// keeping only the latest one until a device session reads it
+func windowChanges(requests <-chan *ssh.Request, resize chan WindowSize) {
+	defer close(resize)
+	for req := range requests {
+		if req.Type != "window-change" {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+			continue
+		}
+		var winChange windowChangeMsg
+		if err := ssh.Unmarshal(req.Payload, &winChange); err == nil {
+			select {
+			case <-resize:
+			default:
+			}
+			resize <- WindowSize{Columns: int(winChange.Columns), Rows: int(winChange.Rows)}
+		}
+		if req.WantReply {
+			_ = req.Reply(true, nil)
+		}
+	}
+}
+
 // runInteractiveShellWithPty provides an interactive shell with PTY support
-func (bs *BastionServer) runInteractiveShellWithPty(channel ssh.Channel, username string, termInfo *ptyRequestMsg, requests <-chan *ssh.Request) {
-	// Pass termInfo and requests to runInteractiveShell so PTY info is available
+func (bs *BastionServer) runInteractiveShellWithPty(ctx context.Context, channel ssh.Channel, username, gatewayUser string, termInfo *ptyRequestMsg, resize <-chan WindowSize) {
+	// Pass termInfo and resizes to runInteractiveShell so PTY info is available
 	// when user types 'ssh <device>'
-	bs.runInteractiveShellWithTermInfo(channel, username, termInfo, requests)
+	bs.runInteractiveShellWithTermInfo(ctx, channel, username, gatewayUser, termInfo, resize)
 }
 
-// runInteractiveShellWithTermInfo provides an interactive shell with optional PTY info
-func (bs *BastionServer) runInteractiveShellWithTermInfo(channel ssh.Channel, username string, termInfo *ptyRequestMsg, requests <-chan *ssh.Request) {
+// runInteractiveShellWithTermInfo provides an interactive shell with optional
+// PTY info. channel is an SSH session channel or a telnet connection.
+// username is the login name, gatewayUser the gateway user it was
+// authenticated as, if any.
+func (bs *BastionServer) runInteractiveShellWithTermInfo(ctx context.Context, channel io.ReadWriter, username, gatewayUser string, termInfo *ptyRequestMsg, resize <-chan WindowSize) {
 	// Send welcome banner
 	_, _ = channel.Write([]byte("\r\n"))
 	_, _ = channel.Write([]byte("╔══════════════════════════════════════════════════════════════╗\r\n"))
@@ -375,9 +741,18 @@ func (bs *BastionServer) runInteractiveShellWithTermInfo(channel ssh.Channel, us
 
 	_, _ = channel.Write([]byte("\r\n"))
 	_, _ = channel.Write([]byte("Commands:\r\n"))
-	_, _ = channel.Write([]byte("  ssh <device-fqdn>  - Connect to a device\r\n"))
-	_, _ = channel.Write([]byte("  list               - Show available devices\r\n"))
-	_, _ = channel.Write([]byte("  exit               - Close connection\r\n"))
+	_, _ = channel.Write([]byte("  ssh <device-fqdn>     - Connect to a device\r\n"))
+	_, _ = channel.Write([]byte("  telnet <device-fqdn>  - Connect to a device over telnet\r\n"))
+	_, _ = channel.Write([]byte("  list                  - Show available devices\r\n"))
+	// Enrollment is offered over SSH only, where the secret is not sent in
+	// clear text, to users whose key or certificate names them
+	_, sshSession := channel.(ssh.Channel)
+	store := bs.totpStore()
+	canEnroll := sshSession && gatewayUser != "" && store != nil && store.CanEnroll()
+	if canEnroll {
+		_, _ = channel.Write([]byte("  totp enroll           - Set up a TOTP second factor\r\n"))
+	}
+	_, _ = channel.Write([]byte("  exit                  - Close connection\r\n"))
 	_, _ = channel.Write([]byte("\r\n"))
 
 	// Interactive command loop
@@ -413,22 +788,30 @@ func (bs *BastionServer) runInteractiveShellWithTermInfo(channel ssh.Channel, us
 		case strings.HasPrefix(command, "ssh "):
 			// Use PTY-aware handler if we have termInfo
 			if termInfo != nil {
-				bs.handleCommandWithPty(channel, username, command, termInfo, requests)
+				bs.handleCommandWithPty(ctx, channel, username, command, termInfo, resize)
 			} else {
-				bs.handleCommand(channel, username, command)
+				bs.handleCommand(ctx, channel, username, command)
 			}
 			// After device session ends, show prompt again
 			_, _ = channel.Write([]byte("\r\n"))
 
+		case strings.HasPrefix(command, "telnet "):
+			bs.handleTelnetCommand(channel, command, termInfo, resize)
+			_, _ = channel.Write([]byte("\r\n"))
+
+		case command == "totp enroll" && canEnroll:
+			bs.enrollTOTP(channel, gatewayUser)
+			_, _ = channel.Write([]byte("\r\n"))
+
 		default:
 			_, _ = channel.Write([]byte(fmt.Sprintf("Unknown command: %s\r\n", command)))
-			_, _ = channel.Write([]byte("Use 'ssh <device-fqdn>' to connect or 'exit' to quit\r\n"))
+			_, _ = channel.Write([]byte("Use 'ssh <device-fqdn>' or 'telnet <device-fqdn>' to connect or 'exit' to quit\r\n"))
 		}
 	}
 }
 
 // readLine reads a line from the channel with basic line editing
-func (bs *BastionServer) readLine(channel ssh.Channel) (string, error) {
+func (bs *BastionServer) readLine(channel io.ReadWriter) (string, error) {
 	var line []byte
 	buf := make([]byte, 1)
 
@@ -473,7 +856,7 @@ func (bs *BastionServer) readLine(channel ssh.Channel) (string, error) {
 }
 
 // handleCommandWithPty processes ssh commands with PTY info
-func (bs *BastionServer) handleCommandWithPty(channel ssh.Channel, defaultUsername, command string, termInfo *ptyRequestMsg, requests <-chan *ssh.Request) {
+func (bs *BastionServer) handleCommandWithPty(ctx context.Context, channel io.ReadWriter, defaultUsername, command string, termInfo *ptyRequestMsg, resize <-chan WindowSize) {
 	parts := strings.Fields(command)
 	if len(parts) < 2 || parts[0] != "ssh" {
 		_, _ = channel.Write([]byte("Error: Invalid command format. Use: ssh <device-fqdn>\r\n"))
@@ -505,22 +888,161 @@ func (bs *BastionServer) handleCommandWithPty(channel ssh.Channel, defaultUserna
 		username = defaultUsername
 	}
 
-	// Prompt for password
-	_, _ = channel.Write([]byte("Password: "))
-	password, err := bs.readPassword(channel)
-	if err != nil {
+	// Prompt for password, unless the gateway logs in with a key. With a
+	// forwarded agent it is only asked for if the device refuses its keys.
+	ctx, forwarded := bs.agentContext(ctx, deviceName)
+	var password string
+	if forwarded {
+		ctx = bs.passwordPrompt(ctx, channel, "Password: ")
+	} else if password, err = bs.devicePassword(ctx, channel, device, username); err != nil {
 		_, _ = channel.Write([]byte(fmt.Sprintf("\r\nError reading password: %s\r\n", err)))
 		return
 	}
-	_, _ = channel.Write([]byte("\r\n"))
 
 	// Connect to target device with PTY info
 	logger.Log.Infof("Proxying to device with PTY: cols=%d, rows=%d, term=%s", termInfo.Columns, termInfo.Rows, termInfo.Term)
-	bs.proxyToDeviceWithPty(channel, device, username, password, termInfo, requests)
+	bs.proxyToDeviceWithPty(ctx, channel, device, username, password, termInfo, resize)
+}
+
+// execFailedStatus is the exit status of a command that could not be run on
+// the device, as the OpenSSH client uses for its own errors
+const execFailedStatus = 255
+
+// handleExec runs "<device-fqdn> <command>" on the device without a PTY,
+// logging in with the credentials configured for the device. The output and
+// the exit status of the command are passed back to the client.
+func (bs *BastionServer) handleExec(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request, username, command string) uint32 {
+	target, deviceCommand, _ := strings.Cut(strings.TrimSpace(command), " ")
+	deviceCommand = strings.TrimSpace(deviceCommand)
+	if target == "" || deviceCommand == "" {
+		_, _ = fmt.Fprintf(channel.Stderr(), "Usage: <device-fqdn> <command>, or ssh <device-fqdn> for a shell\n")
+		return execFailedStatus
+	}
+
+	device, deviceName, err := bs.config.GetDeviceByFQDN(target)
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "Error: %s\n", err)
+		return execFailedStatus
+	}
+	deviceUsername, password, err := bs.config.DeviceCredentials(deviceName)
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "Error: %s\n", err)
+		return execFailedStatus
+	}
+	return bs.runExec(ctx, channel, requests, username, deviceName, device, deviceUsername, password, deviceCommand)
+}
+
+// handleTargetExec runs a command on the device named in the login name
+func (bs *BastionServer) handleTargetExec(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request, username, deviceUser, target, command string) uint32 {
+	device, deviceName, password, ok := bs.resolveTarget(ctx, channel, deviceUser, target)
+	if !ok {
+		return execFailedStatus
+	}
+	return bs.runExec(ctx, channel, requests, username, deviceName, device, deviceUser, password, command)
+}
+
+// handleSFTP relays the sftp subsystem to the device named in the login name
+func (bs *BastionServer) handleSFTP(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request, username, deviceUser, target string) uint32 {
+	device, deviceName, password, ok := bs.resolveTarget(ctx, channel, deviceUser, target)
+	if !ok {
+		return execFailedStatus
+	}
+
+	audit := newSFTPAudit(&transferLog{user: username, device: deviceName})
+	defer audit.finish()
+	return bs.runDeviceSession(ctx, channel, requests, username, deviceName, deviceUser, "sftp", func(ctx context.Context, stdin io.Reader, stdout io.Writer) (int, error) {
+		stdin, stdout = audit.tap(stdin, stdout)
+		return proxy.StreamSSHSubsystem(ctx, device.Hostname, device.SSHPort, deviceUser, password, "sftp", stdin, stdout,
+			metrics.CountingWriter(channel.Stderr(), "ssh", metrics.DirectionFromDevice))
+	})
+}
+
+// resolveTarget looks up the device named in the login name for a session
+// without a terminal to ask for a password on, so the device's credential
+// profile must be for the same user. Errors are written to stderr.
+func (bs *BastionServer) resolveTarget(ctx context.Context, channel ssh.Channel, deviceUser, target string) (*config.DeviceConfig, string, string, bool) {
+	device, deviceName, err := bs.config.GetDeviceByFQDN(target)
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "Error: %s\n", err)
+		return nil, "", "", false
+	}
+	password, ok := bs.targetPassword(ctx, deviceName, deviceUser)
+	if !ok {
+		_, _ = fmt.Fprintf(channel.Stderr(), "Error: no credentials configured for %s on device %s\n", deviceUser, deviceName)
+		return nil, "", "", false
+	}
+	return device, deviceName, password, true
+}
+
+// runExec runs command on a device and returns the exit status for the
+// client. The files of scp commands are logged.
+func (bs *BastionServer) runExec(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request, username, deviceName string, device *config.DeviceConfig, deviceUsername, password, command string) uint32 {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		_, _ = fmt.Fprintf(channel.Stderr(), "Error: no command given\n")
+		return execFailedStatus
+	}
+
+	scp := newSCPAudit(&transferLog{user: username, device: deviceName}, command)
+	return bs.runDeviceSession(ctx, channel, requests, username, deviceName, deviceUsername, command, func(ctx context.Context, stdin io.Reader, stdout io.Writer) (int, error) {
+		if scp != nil {
+			stdin, stdout = scp.tap(stdin, stdout)
+			defer scp.finish()
+		}
+		return proxy.StreamSSHCommand(ctx, device.Hostname, device.SSHPort, deviceUsername, password, command, stdin, stdout,
+			metrics.CountingWriter(channel.Stderr(), "ssh", metrics.DirectionFromDevice))
+	})
+}
+
+// runDeviceSession runs a session on a device without a PTY through run,
+// which relays stdin and stdout of the channel, and returns the exit status
+// for the client. ctx is the context of the connection.
+func (bs *BastionServer) runDeviceSession(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request, username, deviceName, deviceUsername, command string, run func(ctx context.Context, stdin io.Reader, stdout io.Writer) (int, error)) uint32 {
+	// The session ends when the client closes the channel or on shutdown
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for req := range requests {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+		cancel()
+	}()
+
+	metrics.ActiveSessions.WithLabelValues("ssh").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("ssh").Dec()
+
+	start := time.Now()
+	exitCode, err := run(ctx,
+		metrics.CountingReader(channel, "ssh", metrics.DirectionToDevice),
+		metrics.CountingWriter(channel, "ssh", metrics.DirectionFromDevice),
+	)
+	category := proxy.Category(err)
+	metrics.ObserveCommand(deviceName, "ssh", time.Since(start), string(category))
+
+	logger.Log.WithFields(map[string]interface{}{
+		"user":        username,
+		"device":      deviceName,
+		"device_user": deviceUsername,
+		"command":     command,
+		"exit_status": exitCode,
+		"duration":    time.Since(start).String(),
+	}).Info("Bastion exec finished")
+
+	switch {
+	case err == nil:
+		return 0
+	case exitCode > 0:
+		return uint32(exitCode)
+	}
+	logger.Log.WithError(err).WithField("category", category).Warn("Bastion exec failed")
+	_, _ = fmt.Fprintf(channel.Stderr(), "Error: %s\n", err)
+	return execFailedStatus
 }
 
 // handleCommand processes ssh commands (legacy without PTY)
-func (bs *BastionServer) handleCommand(channel ssh.Channel, defaultUsername, command string) {
+func (bs *BastionServer) handleCommand(ctx context.Context, channel io.ReadWriter, defaultUsername, command string) {
 	parts := strings.Fields(command)
 	if len(parts) < 2 || parts[0] != "ssh" {
 		_, _ = channel.Write([]byte("Error: Invalid command format. Use: ssh <device-fqdn>\r\n"))
@@ -552,21 +1074,45 @@ func (bs *BastionServer) handleCommand(channel ssh.Channel, defaultUsername, com
 		username = defaultUsername
 	}
 
-	// Prompt for password
-	_, _ = channel.Write([]byte("Password: "))
-	password, err := bs.readPassword(channel)
+	// Prompt for password, unless the gateway logs in with a key
+	password, err := bs.devicePassword(ctx, channel, device, username)
 	if err != nil {
 		_, _ = channel.Write([]byte(fmt.Sprintf("\r\nError reading password: %s\r\n", err)))
 		return
 	}
-	_, _ = channel.Write([]byte("\r\n"))
 
 	// Connect to target device
-	bs.proxyToDevice(channel, device, username, password)
+	bs.proxyToDevice(ctx, channel, device, username, password)
+}
+
+// devicePassword asks for the password of a device the gateway does not log
+// in to as username with a key alone
+func (bs *BastionServer) devicePassword(ctx context.Context, channel io.ReadWriter, device *config.DeviceConfig, username string) (string, error) {
+	if !proxy.NeedsPassword(ctx, device.Hostname, device.SSHPort, username) {
+		return "", nil
+	}
+	_, _ = channel.Write([]byte("Password: "))
+	password, err := bs.readPassword(channel)
+	if err != nil {
+		return "", err
+	}
+	_, _ = channel.Write([]byte("\r\n"))
+	return password, nil
+}
+
+// passwordPrompt returns a context whose device logins ask for the password
+// on channel when the device wants one
+func (bs *BastionServer) passwordPrompt(ctx context.Context, channel io.ReadWriter, prompt string) context.Context {
+	return proxy.WithPasswordPrompt(ctx, func() (string, error) {
+		_, _ = channel.Write([]byte(prompt))
+		password, err := bs.readPassword(channel)
+		_, _ = channel.Write([]byte("\r\n"))
+		return password, err
+	})
 }
 
 // readPassword reads password without echoing
-func (bs *BastionServer) readPassword(channel ssh.Channel) (string, error) {
+func (bs *BastionServer) readPassword(channel io.ReadWriter) (string, error) {
 	var password []byte
 	buf := make([]byte, 1)
 	var lastChar byte
@@ -618,8 +1164,12 @@ func (bs *BastionServer) readPassword(channel ssh.Channel) (string, error) {
 	}
 }
 
-// handleDirectTCPIP handles direct TCP/IP forwarding
-func (bs *BastionServer) handleDirectTCPIP(_ *ssh.ServerConn, newChannel ssh.NewChannel) {
+// handleDirectTCPIP forwards a port to a device, for ssh -J. Only the SSH and
+// NETCONF ports of inventory devices can be reached, and each forward is
+// logged with its user, device and byte counts. The gateway cannot see into
+// the client's own SSH session to the device, so the files of scp -J are
+// not logged.
+func (bs *BastionServer) handleDirectTCPIP(sshConn *ssh.ServerConn, newChannel ssh.NewChannel) {
 	// Parse direct-tcpip payload to get target address
 	var payload struct {
 		TargetAddr string
@@ -633,7 +1183,30 @@ func (bs *BastionServer) handleDirectTCPIP(_ *ssh.ServerConn, newChannel ssh.New
 		return
 	}
 
-	logger.Log.Infof("Direct TCP/IP forward request to %s:%d", payload.TargetAddr, payload.TargetPort)
+	fields := map[string]interface{}{
+		"user":   sshConn.User(),
+		"remote": sshConn.RemoteAddr().String(),
+		"target": net.JoinHostPort(payload.TargetAddr, strconv.Itoa(int(payload.TargetPort))),
+	}
+	device, deviceName, err := bs.forwardTarget(payload.TargetAddr, payload.TargetPort)
+	if err != nil {
+		logger.Log.WithFields(fields).WithError(err).Warn("Refused bastion forward")
+		metrics.BastionForwards.WithLabelValues("refused").Inc()
+		_ = newChannel.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+	fields["device"] = deviceName
+
+	// Connect to target
+	address := net.JoinHostPort(device.Hostname, strconv.Itoa(int(payload.TargetPort)))
+	targetConn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		logger.Log.WithFields(fields).WithError(err).Warn("Failed to connect bastion forward")
+		metrics.BastionForwards.WithLabelValues("failed").Inc()
+		_ = newChannel.Reject(ssh.ConnectionFailed, fmt.Sprintf("failed to connect to %s", deviceName))
+		return
+	}
+	defer targetConn.Close()
 
 	channel, requests, err := newChannel.Accept()
 	if err != nil {
@@ -644,47 +1217,72 @@ func (bs *BastionServer) handleDirectTCPIP(_ *ssh.ServerConn, newChannel ssh.New
 
 	go ssh.DiscardRequests(requests)
 
-	// Connect to target
-	targetConn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", payload.TargetAddr, payload.TargetPort))
-	if err != nil {
-		logger.Log.WithError(err).Error("Failed to connect to target")
-		return
-	}
-	defer targetConn.Close()
+	logger.Log.WithFields(fields).Info("Bastion forward opened")
+	metrics.BastionForwards.WithLabelValues("opened").Inc()
+	metrics.ActiveSessions.WithLabelValues("direct-tcpip").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("direct-tcpip").Dec()
 
-	// Bidirectional copy
+	// Bidirectional copy. Each side is closed once the other ends, so that a
+	// client or device that stops sending ends the forward.
+	start := time.Now()
+	var toDevice, fromDevice int64
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
-		_, _ = io.Copy(channel, targetConn)
-		wg.Done()
+		defer wg.Done()
+		fromDevice, _ = io.Copy(channel, targetConn)
+		metrics.BytesProxied.WithLabelValues("direct-tcpip", metrics.DirectionFromDevice).Add(float64(fromDevice))
+		_ = channel.CloseWrite()
 	}()
 
 	go func() {
-		_, _ = io.Copy(targetConn, channel)
-		wg.Done()
+		defer wg.Done()
+		toDevice, _ = io.Copy(targetConn, channel)
+		metrics.BytesProxied.WithLabelValues("direct-tcpip", metrics.DirectionToDevice).Add(float64(toDevice))
+		if tcp, ok := targetConn.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
 	}()
 
 	wg.Wait()
+
+	fields["bytes_to_device"] = toDevice
+	fields["bytes_from_device"] = fromDevice
+	fields["duration"] = time.Since(start).String()
+	logger.Log.WithFields(fields).Info("Bastion forward closed")
+}
+
+// forwardTarget returns the inventory device a forward may connect to. The
+// device is named by its name, FQDN or hostname, and port must be its SSH or
+// NETCONF port.
+func (bs *BastionServer) forwardTarget(host string, port uint32) (*config.DeviceConfig, string, error) {
+	device, deviceName, err := bs.config.GetDeviceByFQDN(host)
+	if err != nil {
+		device, deviceName = nil, ""
+		for name, candidate := range bs.config.Devices {
+			if strings.EqualFold(candidate.Hostname, host) {
+				candidate := candidate
+				device, deviceName = &candidate, name
+				break
+			}
+		}
+		if device == nil {
+			return nil, "", fmt.Errorf("%s is not a known device", host)
+		}
+	}
+	if port == 0 || (int(port) != device.SSHPort && int(port) != device.NetconfPort) {
+		return nil, "", fmt.Errorf("port %d of device %s may not be forwarded", port, deviceName)
+	}
+	return device, deviceName, nil
 }
 
 // proxyToDevice establishes connection to target device and proxies traffic
-func (bs *BastionServer) proxyToDevice(clientChannel ssh.Channel, device *config.DeviceConfig, username, password string) {
+func (bs *BastionServer) proxyToDevice(ctx context.Context, clientChannel io.ReadWriter, device *config.DeviceConfig, username, password string) {
 	// Configure SSH client for target device
-	// Support both password and keyboard-interactive authentication
 	targetConfig := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-				answers := make([]string, len(questions))
-				for i := range questions {
-					answers[i] = password
-				}
-				return answers, nil
-			}),
-		},
+		User:            username,
+		Auth:            proxy.AuthMethods(ctx, device.Hostname, device.SSHPort, username, password),
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
@@ -697,6 +1295,10 @@ func (bs *BastionServer) proxyToDevice(clientChannel ssh.Channel, device *config
 	}
 	defer targetConn.Close()
 
+	// Closing the connection ends the session on shutdown
+	stop := context.AfterFunc(ctx, func() { targetConn.Close() })
+	defer stop()
+
 	// Create session on target
 	targetSession, err := targetConn.NewSession()
 	if err != nil {
@@ -705,10 +1307,14 @@ func (bs *BastionServer) proxyToDevice(clientChannel ssh.Channel, device *config
 	}
 	defer targetSession.Close()
 
+	metrics.ActiveSessions.WithLabelValues("ssh").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("ssh").Dec()
+
 	// Setup I/O
-	targetSession.Stdout = clientChannel
-	targetSession.Stderr = clientChannel
-	targetSession.Stdin = clientChannel
+	output := metrics.CountingWriter(clientChannel, "ssh", metrics.DirectionFromDevice)
+	targetSession.Stdout = output
+	targetSession.Stderr = output
+	targetSession.Stdin = metrics.CountingReader(clientChannel, "ssh", metrics.DirectionToDevice)
 
 	// Request PTY
 	modes := ssh.TerminalModes{
@@ -734,109 +1340,132 @@ func (bs *BastionServer) proxyToDevice(clientChannel ssh.Channel, device *config
 }
 
 // proxyToDeviceWithPty establishes connection with proper PTY handling
-func (bs *BastionServer) proxyToDeviceWithPty(clientChannel ssh.Channel, device *config.DeviceConfig, username, password string, termInfo *ptyRequestMsg, requests <-chan *ssh.Request) {
-	// Configure SSH client for target device
-	targetConfig := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-				answers := make([]string, len(questions))
-				for i := range questions {
-					answers[i] = password
-				}
-				return answers, nil
-			}),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+func (bs *BastionServer) proxyToDeviceWithPty(ctx context.Context, clientChannel io.ReadWriter, device *config.DeviceConfig, username, password string, termInfo *ptyRequestMsg, resize <-chan WindowSize) {
+	size := WindowSize{Columns: int(termInfo.Columns), Rows: int(termInfo.Rows)}
+	if err := ProxyShell(ctx, clientChannel, device, username, password, termInfo.Term, size, resize); err != nil {
+		_, _ = clientChannel.Write([]byte(fmt.Sprintf("\nError: %s\n", err)))
+		return
 	}
+	_, _ = clientChannel.Write([]byte("\n\nConnection closed.\n"))
+}
 
-	// Connect to target device
-	targetAddr := fmt.Sprintf("%s:%d", device.Hostname, device.SSHPort)
-	targetConn, err := ssh.Dial("tcp", targetAddr, targetConfig)
-	if err != nil {
-		_, _ = clientChannel.Write([]byte(fmt.Sprintf("\nError: Failed to connect to device: %s\n", err)))
+// handleTelnetCommand connects to a device over telnet. The user logs in at
+// the device's own prompts.
+func (bs *BastionServer) handleTelnetCommand(channel io.ReadWriter, command string, termInfo *ptyRequestMsg, resize <-chan WindowSize) {
+	parts := strings.Fields(command)
+	if len(parts) < 2 || parts[0] != "telnet" {
+		_, _ = channel.Write([]byte("Error: Invalid command format. Use: telnet <device-fqdn>\r\n"))
 		return
 	}
-	defer targetConn.Close()
 
-	// Create session on target
-	targetSession, err := targetConn.NewSession()
+	device, deviceName, err := bs.config.GetDeviceByFQDN(parts[1])
 	if err != nil {
-		_, _ = clientChannel.Write([]byte(fmt.Sprintf("\nError: Failed to create session: %s\n", err)))
+		_, _ = channel.Write([]byte(fmt.Sprintf("Error: %s\r\n", err)))
+		return
+	}
+	if device.TelnetPort == 0 {
+		_, _ = channel.Write([]byte(fmt.Sprintf("Error: device %s has no telnet port configured\r\n", deviceName)))
 		return
 	}
-	defer targetSession.Close()
 
-	// Setup I/O
-	targetSession.Stdout = clientChannel
-	targetSession.Stderr = clientChannel
-	targetSession.Stdin = clientChannel
+	_, _ = channel.Write([]byte(fmt.Sprintf("Connecting to %s (%s) over telnet...\r\n", deviceName, device.Hostname)))
 
-	// Request PTY with client's terminal size
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
+	var size WindowSize
+	var term string
+	if termInfo != nil {
+		size = WindowSize{Columns: int(termInfo.Columns), Rows: int(termInfo.Rows)}
+		term = termInfo.Term
 	}
-
-	// Use client's terminal info
-	term := termInfo.Term
-	if term == "" {
-		term = "xterm-256color"
+	timeout := time.Duration(bs.config.Settings.DefaultTimeout) * time.Second
+	if err := ProxyTelnet(bs.ctx, channel, device, term, size, timeout, resize); err != nil {
+		_, _ = channel.Write([]byte(fmt.Sprintf("\r\nError: %s\r\n", err)))
+		return
 	}
-	cols := int(termInfo.Columns)
-	if cols == 0 {
-		cols = 80
+	_, _ = channel.Write([]byte("\r\n\r\nConnection closed.\r\n"))
+}
+
+// Stop stops the SSH bastion server
+func (bs *BastionServer) Stop() error {
+	if bs.watcher != nil {
+		bs.watcher.Close()
 	}
-	rows := int(termInfo.Rows)
-	if rows == 0 {
-		rows = 24
+	bs.mu.RLock()
+	listener := bs.listener
+	bs.mu.RUnlock()
+	if listener != nil {
+		return listener.Close()
 	}
+	return nil
+}
 
-	if err := targetSession.RequestPty(term, rows, cols, modes); err != nil {
-		_, _ = clientChannel.Write([]byte(fmt.Sprintf("\nError: Failed to request PTY: %s\n", err)))
-		return
+// Shutdown stops accepting connections and lets connected users finish until
+// ctx expires, sending a countdown notice to every open session. Connections
+// still open when ctx expires are closed.
+func (bs *BastionServer) Shutdown(ctx context.Context) error {
+	if err := bs.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Log.WithError(err).Warn("Failed to close SSH bastion listener")
 	}
 
-	// Handle window-change requests from client
+	done := make(chan struct{})
 	go func() {
-		for req := range requests {
-			if req.Type == "window-change" {
-				var winChange windowChangeMsg
-				if err := ssh.Unmarshal(req.Payload, &winChange); err == nil {
-					// Send window-change to target session
-					_ = targetSession.WindowChange(int(winChange.Rows), int(winChange.Columns))
-				}
-				if req.WantReply {
-					_ = req.Reply(true, nil)
-				}
-			} else {
-				if req.WantReply {
-					_ = req.Reply(false, nil)
-				}
-			}
-		}
+		bs.connWG.Wait()
+		close(done)
 	}()
 
-	// Start shell
-	if err := targetSession.Shell(); err != nil {
-		_, _ = clientChannel.Write([]byte(fmt.Sprintf("\nError: Failed to start shell: %s\n", err)))
-		return
+	deadline, hasDeadline := ctx.Deadline()
+	notifyAt := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if hasDeadline && !time.Now().Before(notifyAt) {
+			remaining := time.Until(deadline).Round(time.Second)
+			bs.broadcast(fmt.Sprintf("\r\n*** The gateway is shutting down, this session will be closed in %s ***\r\n", remaining))
+			notifyAt = time.Now().Add(noticeInterval(remaining))
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			// End device sessions, then the client connections
+			bs.cancel()
+			bs.mu.RLock()
+			remaining := len(bs.conns)
+			for conn := range bs.conns {
+				_ = conn.Close()
+			}
+			bs.mu.RUnlock()
+			logger.Log.Warnf("Closed %d SSH bastion connections after the drain timeout", remaining)
+			<-done
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
+}
 
-	// Wait for session to end
-	_ = targetSession.Wait()
-	_, _ = clientChannel.Write([]byte("\n\nConnection closed.\n"))
+// noticeInterval returns the delay until the next shutdown notice: every ten
+// seconds, then a last one five seconds before the connections are closed
+func noticeInterval(remaining time.Duration) time.Duration {
+	switch {
+	case remaining > 15*time.Second:
+		return 10 * time.Second
+	case remaining > 5*time.Second:
+		return remaining - 5*time.Second
+	}
+	return remaining + time.Second
 }
 
-// Stop stops the SSH bastion server
-func (bs *BastionServer) Stop() error {
-	if bs.watcher != nil {
-		bs.watcher.Close()
+// broadcast writes a message to every open session
+func (bs *BastionServer) broadcast(message string) {
+	bs.mu.RLock()
+	sessions := make([]ssh.Channel, 0, len(bs.sessions))
+	for channel := range bs.sessions {
+		sessions = append(sessions, channel)
 	}
-	if bs.listener != nil {
-		return bs.listener.Close()
+	bs.mu.RUnlock()
+
+	for _, channel := range sessions {
+		_, _ = channel.Write([]byte(message))
 	}
-	return nil
 }
@@ -0,0 +1,721 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/proxy"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/ssh_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+func TestNoticeInterval(t *testing.T) {
+	tests := []struct {
+		remaining time.Duration
+		expected  time.Duration
+	}{
+		{30 * time.Second, 10 * time.Second},
+		{16 * time.Second, 10 * time.Second},
+		{12 * time.Second, 7 * time.Second},
+		{5 * time.Second, 6 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := noticeInterval(tt.remaining); got != tt.expected {
+			t.Errorf("noticeInterval(%s) = %s, want %s", tt.remaining, got, tt.expected)
+		}
+	}
+}
+
+func TestShutdown_Idle(t *testing.T) {
+	bs, _ := startTestBastion(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := bs.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown of idle bastion failed: %v", err)
+	}
+}
+
+func TestShutdown_ClosesSessionsAtDeadline(t *testing.T) {
+	bs, address := startTestBastion(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "admin",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep stdin open, a nil Stdin sends EOF and ends the shell
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	output, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the prompt so the session is registered
+	readUntil(t, output, "bastion> ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- bs.Shutdown(ctx) }()
+
+	readUntil(t, output, "this session will be closed in 2s")
+
+	select {
+	case err := <-shutdownErr:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the deadline")
+	}
+
+	if err := session.Wait(); err == nil {
+		t.Error("Expected session to be closed by shutdown")
+	}
+}
+
+func TestServe_Mux(t *testing.T) {
+	dir := t.TempDir()
+	bs, err := NewBastionServer(&config.Config{
+		Devices:  map[string]config.DeviceConfig{},
+		Settings: config.Settings{DomainSuffix: "test.local"},
+	}, writeTestHostKey(t, dir), filepath.Join(dir, "authorized_keys"))
+	if err != nil {
+		t.Fatalf("NewBastionServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := listen.NewMux(listener)
+	defer mux.Close()
+	served := make(chan error, 1)
+	go func() { served <- bs.Serve(mux.SSH) }()
+	go func() { _ = mux.Serve() }()
+
+	session, err := dialTestBastionAs(t, mux.Addr().String(), "admin").NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	output, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, output, "bastion> ")
+	session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = bs.Shutdown(ctx)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+func TestExec(t *testing.T) {
+	devicePort := startTestExecDevice(t, "secret")
+	_, address := startTestBastionWithConfig(t, &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: devicePort},
+			"router2": {Hostname: "127.0.0.1", SSHPort: devicePort, Credentials: "wrong"},
+			"router3": {Hostname: "127.0.0.1", SSHPort: devicePort, Credentials: "missing"},
+		},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			Credentials: map[string]config.CredentialSettings{
+				"default": {Username: "admin", Password: "secret"},
+				"wrong":   {Username: "admin", Password: "guess"},
+			},
+		},
+	})
+	client := dialTestBastion(t, address)
+
+	tests := []struct {
+		name    string
+		command string
+		status  int
+		stdout  string
+		stderr  string
+	}{
+		{"success", "router1.test.local show version", 0, "Version 1.0\n", ""},
+		{"exit status", "router1.test.local show bogus", 3, "", "invalid command\n"},
+		{"stdin", "router1 cat", 0, "input\n", ""},
+		{"unknown device", "router9.test.local show version", 255, "", "device not found"},
+		{"no credentials", "router3.test.local show version", 255, "", "no credentials configured"},
+		{"device rejects login", "router2.test.local show version", 255, "", "unable to authenticate"},
+		{"no command", "router1.test.local", 255, "", "Usage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+
+			var stdout, stderr strings.Builder
+			session.Stdin = strings.NewReader("input\n")
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			err = session.Run(tt.command)
+
+			status := 0
+			var exitErr *ssh.ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.ExitStatus()
+			} else if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if status != tt.status {
+				t.Errorf("exit status = %d, want %d (stderr %q)", status, tt.status, stderr.String())
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.stdout)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.stderr)
+			}
+		})
+	}
+}
+
+func TestParseTargetUser(t *testing.T) {
+	tests := []struct {
+		user       string
+		deviceUser string
+		target     string
+		ok         bool
+	}{
+		{"admin%srl1.safabayar.net", "admin", "srl1.safabayar.net", true},
+		{"srl1+admin", "admin", "srl1", true},
+		{"admin", "", "", false},
+		{"admin%", "", "", false},
+		{"+admin", "", "", false},
+	}
+	for _, tt := range tests {
+		deviceUser, target, ok := parseTargetUser(tt.user)
+		if ok != tt.ok || (ok && (deviceUser != tt.deviceUser || target != tt.target)) {
+			t.Errorf("parseTargetUser(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.user, deviceUser, target, ok, tt.deviceUser, tt.target, tt.ok)
+		}
+	}
+}
+
+func TestTargetUser(t *testing.T) {
+	devicePort := startTestExecDevice(t, "secret")
+	_, address := startTestBastionWithConfig(t, &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: devicePort},
+		},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			Credentials: map[string]config.CredentialSettings{
+				"default": {Username: "admin", Password: "secret"},
+			},
+		},
+	})
+
+	t.Run("exec", func(t *testing.T) {
+		session, err := dialTestBastionAs(t, address, "admin%router1.test.local").NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		output, err := session.Output("show version")
+		if err != nil {
+			t.Fatalf("Output failed: %v", err)
+		}
+		if string(output) != "Version 1.0\n" {
+			t.Errorf("output = %q, want the device output", output)
+		}
+	})
+
+	t.Run("exec without credentials", func(t *testing.T) {
+		session, err := dialTestBastionAs(t, address, "operator%router1.test.local").NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		var exitErr *ssh.ExitError
+		if _, err := session.Output("show version"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 255 {
+			t.Errorf("Output = %v, want exit status 255", err)
+		}
+	})
+
+	t.Run("shell", func(t *testing.T) {
+		session, err := dialTestBastionAs(t, address, "router1+admin").NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		var stdout strings.Builder
+		session.Stdout = &stdout
+		if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		}
+		_ = session.Wait()
+		if !strings.Contains(stdout.String(), "Welcome admin") {
+			t.Errorf("shell output = %q, want the device greeting", stdout.String())
+		}
+	})
+
+	t.Run("shell with password prompt", func(t *testing.T) {
+		session, err := dialTestBastionAs(t, address, "operator%router1.test.local").NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := session.Shell(); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, stdout, "Password for operator@router1: ")
+		writeString(t, stdin, "secret\r")
+		readUntil(t, stdout, "Welcome operator")
+	})
+}
+
+func TestTargetUser_DeviceKey(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "device_key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	// The device only accepts the gateway's key
+	devicePort := startTestExecDeviceWithConfig(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(signer.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	})
+	operator := newTestSigner(t)
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: devicePort, PrivateKeyFile: keyFile},
+		},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			// The device key only logs in as the user of the profile
+			Credentials: map[string]config.CredentialSettings{"default": {Username: "operator"}},
+			Users:       map[string]config.UserSettings{"operator": {AuthorizedKeys: []string{authorizedKey(operator)}}},
+		},
+	}
+	keyring, err := proxy.NewKeyring(cfg)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	proxy.SetKeyring(keyring)
+	t.Cleanup(func() { proxy.SetKeyring(nil) })
+	_, address := startTestBastionWithConfig(t, cfg)
+
+	run := func(address, login string) ([]byte, error) {
+		client, err := dialTestBastionTOTP(address, operator, login, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		return session.Output("show version")
+	}
+
+	output, err := run(address, "operator%router1.test.local")
+	if err != nil {
+		t.Fatalf("Output failed: %v", err)
+	}
+	if string(output) != "Version 1.0\n" {
+		t.Errorf("output = %q, want the device output", output)
+	}
+
+	// Other device users need their own credentials
+	if _, err := run(address, "root%router1.test.local"); err == nil {
+		t.Error("login as another device user with the device key succeeded")
+	}
+
+	// Clients accepted without any authorized keys are not trusted with the
+	// device keys
+	_, insecure := startTestBastionWithConfig(t, &config.Config{
+		Devices:  cfg.Devices,
+		Settings: config.Settings{DomainSuffix: "test.local", Credentials: cfg.Settings.Credentials},
+	})
+	if _, err := run(insecure, "operator%router1.test.local"); err == nil {
+		t.Error("login through the accept-all fallback with the device key succeeded")
+	}
+}
+
+func TestUserCertificate(t *testing.T) {
+	newCA := func(dir string) *auth.UserCA {
+		ca, err := auth.NewUserCA(&config.Config{Settings: config.Settings{
+			Users:  map[string]config.UserSettings{"alice": {Principals: []string{"admin"}}},
+			UserCA: config.UserCASettings{PrivateKeyFile: writeTestHostKey(t, dir)},
+		}})
+		if err != nil {
+			t.Fatalf("NewUserCA failed: %v", err)
+		}
+		return ca
+	}
+	ca, otherCA := newCA(t.TempDir()), newCA(t.TempDir())
+
+	// No authorized keys are configured, only certificates are accepted
+	bs, address := startTestBastionWithConfig(t, &config.Config{Settings: config.Settings{DomainSuffix: "test.local"}})
+	bs.SetUserCA(ca)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, _ := ssh.NewSignerFromKey(priv)
+	certSigner := func(ca *auth.UserCA) ssh.Signer {
+		cert, err := ca.Issue("alice", signer.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certSigner
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		signer  ssh.Signer
+		wantErr bool
+	}{
+		{"principal", "admin", certSigner(ca), false},
+		{"device user principal", "admin%router1.test.local", certSigner(ca), false},
+		{"other login name", "alice", certSigner(ca), true},
+		{"other device user", "root%router1.test.local", certSigner(ca), true},
+		{"other CA", "admin", certSigner(otherCA), true},
+		{"plain key", "admin", signer, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+				User:            tt.user,
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(tt.signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         5 * time.Second,
+			})
+			if err == nil {
+				client.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Dial error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// dialTestBastion connects to a bastion with a new key
+func dialTestBastion(t *testing.T, address string) *ssh.Client {
+	t.Helper()
+	return dialTestBastionAs(t, address, "admin")
+}
+
+// dialTestBastionAs connects to a bastion as user with a new key
+func dialTestBastionAs(t *testing.T, address, user string) *ssh.Client {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// startTestExecDevice starts a device running "show version", "cat",
+// "scp -t <dir>" and sftp, failing any other command with exit status 3 and
+// sftp for the user nosftp with 127.
+// Any user may log in with password, and a shell greets the user and exits.
+func startTestExecDevice(t *testing.T, password string) int {
+	t.Helper()
+	return startTestExecDeviceWithConfig(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	})
+}
+
+// startTestExecDeviceWithConfig starts the device of startTestExecDevice
+// authenticating with serverConfig
+func startTestExecDeviceWithConfig(t *testing.T, serverConfig *ssh.ServerConfig) int {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestExecDevice(conn, serverConfig)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serveTestExecDevice(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	user := sshConn.User()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				switch req.Type {
+				case "pty-req", "window-change":
+					_ = req.Reply(true, nil)
+					continue
+				case "subsystem":
+					_ = req.Reply(true, nil)
+					if user == "nosftp" {
+						_, _ = io.WriteString(channel.Stderr(), "sftp-server: not found\n")
+						_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{127}))
+						return
+					}
+					if server, err := sftp.NewServer(channel); err == nil {
+						_ = server.Serve()
+					}
+					_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					return
+				case "shell":
+					_ = req.Reply(true, nil)
+					_, _ = io.WriteString(channel, "Welcome "+user+"\r\n")
+					_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					return
+				}
+				var payload struct{ Command string }
+				if req.Type != "exec" || ssh.Unmarshal(req.Payload, &payload) != nil {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+
+				var status uint32
+				switch {
+				case strings.HasPrefix(payload.Command, "scp -t "):
+					status = receiveTestSCP(channel, strings.TrimPrefix(payload.Command, "scp -t "))
+				case payload.Command == "show version":
+					_, _ = io.WriteString(channel, "Version 1.0\n")
+				case payload.Command == "cat":
+					_, _ = io.Copy(channel, channel)
+				default:
+					_, _ = io.WriteString(channel.Stderr(), "invalid command\n")
+					status = 3
+				}
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// startTestBastion starts a bastion accepting any key on a random port
+func startTestBastion(t *testing.T) (*BastionServer, string) {
+	t.Helper()
+
+	return startTestBastionWithConfig(t, &config.Config{
+		Devices:  map[string]config.DeviceConfig{"router1": {Hostname: "127.0.0.1", SSHPort: 22}},
+		Settings: config.Settings{DomainSuffix: "test.local"},
+	})
+}
+
+// startTestBastionWithConfig starts a bastion for cfg accepting any key on a
+// random port
+func startTestBastionWithConfig(t *testing.T, cfg *config.Config) (*BastionServer, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	hostKeyPath := writeTestHostKey(t, dir)
+
+	bs, err := NewBastionServer(cfg, hostKeyPath, filepath.Join(dir, "authorized_keys"))
+	if err != nil {
+		t.Fatalf("NewBastionServer failed: %v", err)
+	}
+
+	listening := make(chan struct{})
+	bs.OnListening(func() { close(listening) })
+	go func() { _ = bs.Start("127.0.0.1:0") }()
+
+	select {
+	case <-listening:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Bastion did not start listening")
+	}
+	t.Cleanup(func() { _ = bs.Stop() })
+
+	bs.mu.RLock()
+	address := bs.listener.Addr().String()
+	bs.mu.RUnlock()
+	return bs, address
+}
+
+// writeTestHostKey writes a new ed25519 host key to dir and returns its path
+func writeTestHostKey(t *testing.T, dir string) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeyPath := filepath.Join(dir, "host_key")
+	if err := os.WriteFile(hostKeyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return hostKeyPath
+}
+
+// readUntil reads from r until the output contains want
+func readUntil(t *testing.T, r interface{ Read([]byte) (int, error) }, want string) {
+	t.Helper()
+
+	var output strings.Builder
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(output.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q, got %q", want, output.String())
+		}
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatalf("Waiting for %q, got %q: %v", want, output.String(), err)
+		}
+		output.Write(buf[:n])
+	}
+}
@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/time/rate"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+)
+
+// Reasons connections are refused before the handshake, in
+// BastionConnections
+const (
+	refusedBlocked     = "blocked"
+	refusedRateLimited = "rate_limited"
+)
+
+// loginLimiter throttles connections to the bastion and refuses source
+// addresses and gateway users for a while after failed logins, with the
+// backoffs and bans of failures
+type loginLimiter struct {
+	failures *auth.Limiter
+	rate     *rate.Limiter
+	policies auth.SourcePolicies
+
+	mu sync.Mutex
+	// attempts holds the gateway user each connection that tried to log in
+	// proved to be, empty when none, by remote address until its handshake
+	// ends
+	attempts map[string]string
+}
+
+func newLoginLimiter(cfg *config.Config) (*loginLimiter, error) {
+	settings := cfg.Settings.BastionLimits
+	failures, err := auth.NewLimiter("Bastion", settings)
+	if err != nil {
+		return nil, err
+	}
+	failures.OnBan(func(scope string) { metrics.BastionBans.WithLabelValues(scope).Inc() })
+	policies, err := auth.NewSourcePolicies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l := &loginLimiter{
+		failures: failures,
+		policies: policies,
+		attempts: make(map[string]string),
+	}
+	if settings.ConnectionRate > 0 {
+		burst := settings.ConnectionBurst
+		if burst <= 0 {
+			burst = int(math.Ceil(settings.ConnectionRate))
+		}
+		l.rate = rate.NewLimiter(rate.Limit(settings.ConnectionRate), burst)
+	}
+	return l, nil
+}
+
+// admit decides whether a new connection is served. reason is why it is
+// refused.
+func (l *loginLimiter) admit(remote net.Addr) (reason string, ok bool) {
+	source := listen.SourceIP(remote)
+	if l.failures.Exempted(source) {
+		return "", true
+	}
+	if l.failures.Blocked(auth.LimitScopeSource, source.String()) {
+		return refusedBlocked, false
+	}
+	if l.rate != nil && !l.rate.AllowN(l.failures.Now(), 1) {
+		return refusedRateLimited, false
+	}
+	return "", true
+}
+
+// checkLogin refuses a login of the gateway user a key or certificate
+// belongs to from a source the user may not log in from, or while the user's
+// failed logins are being backed off or banned. The login name plays no part
+// in it, keys without a gateway user have no policy.
+func (l *loginLimiter) checkLogin(conn ssh.ConnMetadata, user string) error {
+	if user == "" {
+		return nil
+	}
+	source := listen.SourceIP(conn.RemoteAddr())
+	fields := map[string]interface{}{
+		"user":         conn.User(),
+		"gateway_user": user,
+		"remote":       conn.RemoteAddr().String(),
+	}
+
+	if !l.policies.Allows(user, source) {
+		logger.Log.WithFields(fields).Warn("Refused bastion login from a source the user may not log in from")
+		metrics.AuthAttempts.WithLabelValues("bastion", "failure", "source_denied").Inc()
+		return fmt.Errorf("%s may not log in from %s", user, source)
+	}
+
+	if l.failures.Exempted(source) {
+		return nil
+	}
+	if l.failures.Blocked(auth.LimitScopeUser, user) {
+		logger.Log.WithFields(fields).Debug("Refused bastion login of a blocked user")
+		metrics.AuthAttempts.WithLabelValues("bastion", "failure", "blocked").Inc()
+		return fmt.Errorf("too many failed logins for %s, try again later", user)
+	}
+	return nil
+}
+
+// logAttempt remembers that a connection tried to log in, for
+// AuthLogCallback
+func (l *loginLimiter) logAttempt(conn ssh.ConnMetadata, method string, err error) {
+	if method == "none" || !l.failures.Tracking() {
+		return
+	}
+	l.mu.Lock()
+	if _, ok := l.attempts[conn.RemoteAddr().String()]; !ok {
+		l.attempts[conn.RemoteAddr().String()] = ""
+	}
+	l.mu.Unlock()
+}
+
+// identify remembers the gateway user whose key or certificate a connection
+// proved it holds, so that its failed second factor counts against the user.
+// Keys are only proven once their signature is checked, a public key alone
+// must not lock its user out.
+func (l *loginLimiter) identify(conn ssh.ConnMetadata, user string) {
+	if !l.failures.Tracking() {
+		return
+	}
+	l.mu.Lock()
+	l.attempts[conn.RemoteAddr().String()] = user
+	l.mu.Unlock()
+}
+
+// finish records the result of a handshake. A successful login of a gateway
+// user clears the failures of the user, a failed one counts
+// against the source and the gateway user the connection proved to be, if
+// any. Connections that never tried to log in are not counted.
+func (l *loginLimiter) finish(remote net.Addr, user string, err error) {
+	if !l.failures.Tracking() {
+		return
+	}
+	l.mu.Lock()
+	attempted, ok := l.attempts[remote.String()]
+	delete(l.attempts, remote.String())
+	l.mu.Unlock()
+
+	source := listen.SourceIP(remote)
+	if err == nil {
+		l.failures.Record(source, user, true)
+		return
+	}
+	var authErr *ssh.ServerAuthError
+	if ok && errors.As(err, &authErr) {
+		l.failures.Record(source, attempted, false)
+	}
+}
@@ -0,0 +1,399 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/config"
+)
+
+// testConnMetadata is the ssh.ConnMetadata of a login from remote
+type testConnMetadata struct {
+	user   string
+	remote net.Addr
+}
+
+func (m testConnMetadata) User() string          { return m.user }
+func (m testConnMetadata) SessionID() []byte     { return nil }
+func (m testConnMetadata) ClientVersion() []byte { return nil }
+func (m testConnMetadata) ServerVersion() []byte { return nil }
+func (m testConnMetadata) RemoteAddr() net.Addr  { return m.remote }
+func (m testConnMetadata) LocalAddr() net.Addr   { return nil }
+
+func newTestLimiter(t *testing.T, settings config.BastionLimitSettings, users map[string]config.UserSettings) (*loginLimiter, *time.Time) {
+	t.Helper()
+
+	l, err := newLoginLimiter(&config.Config{Settings: config.Settings{BastionLimits: settings, Users: users}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.failures.SetClock(func() time.Time { return now })
+	return l, &now
+}
+
+func testRemote(ip string, port int) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+// failLogin records a failed login from remote of the gateway user, or of a
+// key without one when user is empty
+func failLogin(l *loginLimiter, remote net.Addr, user string) {
+	conn := testConnMetadata{user: "admin", remote: remote}
+	l.logAttempt(conn, "publickey", fmt.Errorf("unknown public key"))
+	if user != "" {
+		l.identify(conn, user)
+		l.logAttempt(conn, "keyboard-interactive", fmt.Errorf("invalid verification code"))
+	}
+	l.finish(remote, "", &ssh.ServerAuthError{})
+}
+
+func TestLoginLimiterBackoffAndBan(t *testing.T) {
+	hook := captureLogs(t)
+	l, now := newTestLimiter(t, config.BastionLimitSettings{
+		MaxFailures: 4,
+		BanDuration: 600,
+		BackoffBase: 1,
+		BackoffMax:  2,
+	}, nil)
+	remote := testRemote("192.0.2.1", 40000)
+	conn := testConnMetadata{user: "admin", remote: testRemote("198.51.100.1", 40000)}
+
+	// Backoffs of 1s, 2s and 2s (capped) after the first three failures
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second, 2 * time.Second} {
+		failLogin(l, remote, "alice")
+		if reason, ok := l.admit(remote); ok || reason != refusedBlocked {
+			t.Fatalf("Failure %d: admit = %q, %v, want blocked", i+1, reason, ok)
+		}
+		if err := l.checkLogin(conn, "alice"); err == nil {
+			t.Fatalf("Failure %d: checkLogin succeeded during backoff", i+1)
+		}
+		*now = now.Add(backoff - time.Millisecond)
+		if _, ok := l.admit(remote); ok {
+			t.Fatalf("Failure %d: admitted before the backoff ended", i+1)
+		}
+		*now = now.Add(time.Millisecond)
+		if _, ok := l.admit(remote); !ok {
+			t.Fatalf("Failure %d: refused after the backoff ended", i+1)
+		}
+	}
+
+	// The fourth failure bans the source and the user
+	failLogin(l, remote, "alice")
+	*now = now.Add(599 * time.Second)
+	if _, ok := l.admit(remote); ok {
+		t.Error("Banned source admitted")
+	}
+	if err := l.checkLogin(conn, "alice"); err == nil || !strings.Contains(err.Error(), "too many failed logins") {
+		t.Errorf("checkLogin = %v, want too many failed logins", err)
+	}
+	bans := map[string]bool{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Bastion ban" {
+			bans[fmt.Sprint(entry.Data["scope"], " ", entry.Data[entry.Data["scope"].(string)])] = true
+		}
+	}
+	if !bans["source 192.0.2.1"] || !bans["user alice"] || len(bans) != 2 {
+		t.Errorf("Ban events = %v, want source 192.0.2.1 and user alice", bans)
+	}
+
+	*now = now.Add(time.Second)
+	if _, ok := l.admit(remote); !ok {
+		t.Error("Source still refused after the ban")
+	}
+	if err := l.checkLogin(conn, "alice"); err != nil {
+		t.Errorf("checkLogin after the ban: %v", err)
+	}
+}
+
+func TestLoginLimiterResets(t *testing.T) {
+	l, now := newTestLimiter(t, config.BastionLimitSettings{MaxFailures: 2, BanDuration: 60}, nil)
+	remote := testRemote("192.0.2.1", 40000)
+
+	t.Run("success", func(t *testing.T) {
+		failLogin(l, remote, "alice")
+		l.finish(remote, "alice", nil)
+		failLogin(l, remote, "alice")
+		conn := testConnMetadata{user: "admin", remote: testRemote("192.0.2.9", 40000)}
+		if err := l.checkLogin(conn, "alice"); err != nil {
+			t.Errorf("Failure of the user before a successful login counted: %v", err)
+		}
+		// Guesses from the source for other users are not forgotten
+		if _, ok := l.admit(remote); ok {
+			t.Error("A successful login cleared the failures of its source")
+		}
+		*now = now.Add(time.Minute)
+		l.finish(remote, "alice", nil)
+	})
+
+	t.Run("old failures", func(t *testing.T) {
+		failLogin(l, remote, "alice")
+		*now = now.Add(time.Minute)
+		failLogin(l, remote, "alice")
+		if _, ok := l.admit(remote); !ok {
+			t.Error("Failure older than the ban duration counted")
+		}
+		l.finish(remote, "alice", nil)
+	})
+
+	t.Run("no login attempt", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			l.finish(remote, "", &ssh.ServerAuthError{})
+			l.finish(remote, "", fmt.Errorf("connection reset"))
+		}
+		if _, ok := l.admit(remote); !ok {
+			t.Error("Connections that did not log in counted as failures")
+		}
+	})
+
+	t.Run("gateway user", func(t *testing.T) {
+		// Failures of keys without a gateway user, whatever the login name,
+		// do not count against a user
+		conn := testConnMetadata{user: "alice", remote: testRemote("192.0.2.3", 40000)}
+		failLogin(l, testRemote("192.0.2.4", 40000), "")
+		failLogin(l, testRemote("192.0.2.5", 40000), "")
+		if err := l.checkLogin(conn, "alice"); err != nil {
+			t.Errorf("Failures without a gateway user counted for alice: %v", err)
+		}
+
+		failLogin(l, testRemote("192.0.2.4", 40000), "alice")
+		failLogin(l, testRemote("192.0.2.5", 40000), "alice")
+		if err := l.checkLogin(conn, "alice"); err == nil {
+			t.Error("Failures of alice's key were not counted for the user")
+		}
+		if err := l.checkLogin(conn, "bob"); err != nil {
+			t.Errorf("Failures of alice's key counted for bob logging in as alice: %v", err)
+		}
+	})
+}
+
+func TestLoginLimiterExempt(t *testing.T) {
+	l, _ := newTestLimiter(t, config.BastionLimitSettings{
+		MaxFailures:     1,
+		ConnectionRate:  1,
+		ConnectionBurst: 1,
+		Exempt:          []string{"10.0.0.0/8", "2001:db8::1"},
+	}, nil)
+
+	for _, ip := range []string{"10.1.2.3", "::ffff:10.1.2.3", "2001:db8::1"} {
+		remote := testRemote(ip, 40000)
+		for i := 0; i < 3; i++ {
+			failLogin(l, remote, "alice")
+			if _, ok := l.admit(remote); !ok {
+				t.Fatalf("Exempt source %s refused", ip)
+			}
+			if err := l.checkLogin(testConnMetadata{user: "admin", remote: remote}, "alice"); err != nil {
+				t.Fatalf("Exempt source %s: checkLogin: %v", ip, err)
+			}
+		}
+	}
+	if _, ok := l.admit(testRemote("192.0.2.1", 40000)); !ok {
+		t.Error("First connection of another source refused")
+	}
+	if _, ok := l.admit(testRemote("192.0.2.1", 40001)); ok {
+		t.Error("Connection over the rate admitted")
+	}
+}
+
+func TestLoginLimiterConnectionRate(t *testing.T) {
+	l, now := newTestLimiter(t, config.BastionLimitSettings{ConnectionRate: 2, ConnectionBurst: 3}, nil)
+
+	for i := 0; i < 3; i++ {
+		if _, ok := l.admit(testRemote("192.0.2.1", 40000+i)); !ok {
+			t.Fatalf("Connection %d of the burst refused", i+1)
+		}
+	}
+	if reason, ok := l.admit(testRemote("192.0.2.2", 40000)); ok || reason != refusedRateLimited {
+		t.Errorf("admit = %q, %v, want rate_limited", reason, ok)
+	}
+	*now = now.Add(500 * time.Millisecond)
+	if _, ok := l.admit(testRemote("192.0.2.2", 40000)); !ok {
+		t.Error("Connection refused after the rate allowed another")
+	}
+}
+
+func TestLoginLimiterSourcePolicy(t *testing.T) {
+	l, _ := newTestLimiter(t, config.BastionLimitSettings{}, map[string]config.UserSettings{
+		"alice": {AllowedSources: []string{"10.0.0.0/8"}, DeniedSources: []string{"10.1.0.0/16"}},
+		"bob":   {DeniedSources: []string{"192.0.2.1"}},
+	})
+
+	// The policy of the gateway user applies whatever the login name
+	tests := []struct {
+		login string
+		user  string
+		ip    string
+		ok    bool
+	}{
+		{"alice", "alice", "10.2.3.4", true},
+		{"alice", "alice", "192.0.2.1", false},
+		{"alice", "alice", "10.1.2.3", false},
+		{"admin%router1.test.local", "alice", "192.0.2.1", false},
+		{"bob", "alice", "192.0.2.1", false},
+		{"alice", "bob", "192.0.2.1", false},
+		{"alice", "bob", "192.0.2.2", true},
+		{"alice", "carol", "192.0.2.1", true},
+		{"alice", "", "192.0.2.1", true},
+	}
+	for _, tt := range tests {
+		err := l.checkLogin(testConnMetadata{user: tt.login, remote: testRemote(tt.ip, 40000)}, tt.user)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkLogin(%s as %s from %s) = %v, want allowed %v", tt.user, tt.login, tt.ip, err, tt.ok)
+		}
+	}
+
+	for _, settings := range []config.Settings{
+		{BastionLimits: config.BastionLimitSettings{Exempt: []string{"10.0.0.0/33"}}},
+		{Users: map[string]config.UserSettings{"alice": {AllowedSources: []string{"office"}}}},
+	} {
+		if _, err := newLoginLimiter(&config.Config{Settings: settings}); err == nil {
+			t.Errorf("newLoginLimiter(%+v) succeeded, want an error", settings)
+		}
+	}
+}
+
+func TestBastionBansSource(t *testing.T) {
+	mallory := newTestSigner(t)
+	bs, address := startTestBastionWithConfig(t, &config.Config{
+		Devices: map[string]config.DeviceConfig{},
+		Settings: config.Settings{
+			DomainSuffix:  "test.local",
+			BastionLimits: config.BastionLimitSettings{MaxFailures: 2},
+			Users: map[string]config.UserSettings{
+				"mallory": {AuthorizedKeys: []string{authorizedKey(mallory)}, DeniedSources: []string{"127.0.0.1"}},
+			},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := dialTestBastionTOTP(address, mallory, "admin", nil); err == nil {
+			t.Fatal("Login from a denied source succeeded")
+		}
+	}
+	// The bastion records the failure once the client has gone
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if bs.limits.failures.Blocked(auth.LimitScopeSource, "127.0.0.1") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Source was not banned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Connections of the banned source are closed before the handshake,
+	// whatever user they log in as
+	if _, err := dialTestBastionTOTP(address, newTestSigner(t), "alice", nil); err == nil {
+		t.Error("Login from a banned source succeeded")
+	}
+}
+
+func TestBastionBansUser(t *testing.T) {
+	alice := newTestSigner(t)
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{},
+		Settings: config.Settings{
+			DomainSuffix:  "test.local",
+			BastionLimits: config.BastionLimitSettings{MaxFailures: 2},
+			Users: map[string]config.UserSettings{
+				"alice": {AuthorizedKeys: []string{authorizedKey(alice)}, TOTPSecret: testTOTPSecret},
+			},
+		},
+	}
+	store := writeTestTOTPStore(t, cfg, "")
+	bs, address := startTestBastionWithConfig(t, cfg)
+	bs.SetTOTPStore(store)
+
+	// Wrong codes count against alice whatever name the key logs in as
+	for _, login := range []string{"alice", "admin%router1.test.local"} {
+		if _, err := dialTestBastionTOTP(address, alice, login, []string{"000000"}); err == nil {
+			t.Fatal("Login with a wrong code succeeded")
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if bs.limits.failures.Blocked(auth.LimitScopeUser, "alice") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("User was not banned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// With the source ban lifted, the user ban still refuses alice's key
+	bs.limits.failures.Clear(auth.LimitScopeSource, "127.0.0.1")
+
+	code, _ := auth.TOTPCode(testTOTPSecret, testTOTPTime)
+	if _, err := dialTestBastionTOTP(address, alice, "bob", []string{code}); err == nil {
+		t.Error("Login of a banned user succeeded")
+	}
+}
+
+func TestBastionProxyProtocol(t *testing.T) {
+	hook := captureLogs(t)
+	mallory := newTestSigner(t)
+	_, address := startTestBastionWithConfig(t, &config.Config{
+		Devices: map[string]config.DeviceConfig{},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			ProxyProtocol: config.ProxyProtocolListeners{
+				SSH: config.ProxyProtocolSettings{Enabled: true, TrustedProxies: []string{"127.0.0.1"}},
+			},
+			Users: map[string]config.UserSettings{
+				"mallory": {AuthorizedKeys: []string{authorizedKey(mallory)}, DeniedSources: []string{"192.0.2.10"}},
+			},
+		},
+	})
+
+	// dial logs in with mallory's key through a load balancer forwarding client
+	dial := func(client string) error {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return err
+		}
+		header := proxyproto.HeaderProxyFromAddrs(2,
+			&net.TCPAddr{IP: net.ParseIP(client), Port: 56324}, conn.RemoteAddr())
+		if _, err := header.WriteTo(conn); err != nil {
+			conn.Close()
+			return err
+		}
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, &ssh.ClientConfig{
+			User:            "admin",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(mallory)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		ssh.NewClient(sshConn, chans, reqs).Close()
+		return nil
+	}
+
+	if err := dial("192.0.2.10"); err == nil {
+		t.Error("Login from the denied client address succeeded")
+	}
+	if err := dial("192.0.2.11"); err != nil {
+		t.Errorf("Login from another client address failed: %v", err)
+	}
+
+	// The bastion logs the client address, not the load balancer's
+	found := false
+	for _, entry := range hook.AllEntries() {
+		if strings.Contains(entry.Message, "from 192.0.2.11:56324") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Client address was not logged")
+	}
+}
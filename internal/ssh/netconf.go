@@ -0,0 +1,365 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/proxy"
+)
+
+// netconfDialTimeout bounds the login to the device when default_timeout is not set
+const netconfDialTimeout = 30 * time.Second
+
+// NetconfServer accepts NETCONF over SSH clients such as ncclient and
+// netconf-console and relays their netconf subsystem to a device. The device
+// is named in the login, "admin@srl1.safabayar.net", or found in the
+// netconf_server.targets mapping. Clients log in with their device
+// credentials, which are checked by logging in to the device, and their
+// sessions are opened on that login. The NETCONF stream is relayed unchanged
+// so both ends negotiate capabilities and framing with each other.
+type NetconfServer struct {
+	config      *config.Config
+	sshConfig   *ssh.ServerConfig
+	listener    net.Listener
+	onListening func()
+	ctx         context.Context
+	cancel      context.CancelFunc
+	// limits throttles failed logins, which would otherwise lock out device
+	// accounts
+	limits *auth.Limiter
+	// logins holds the device login of a client by its address until its
+	// handshake completes, so a failed handshake does not leak it
+	logins map[string]*proxy.NetconfClient
+	conns  map[*ssh.ServerConn]struct{}
+	connWG sync.WaitGroup
+	mu     sync.Mutex
+}
+
+// netconfDevice is the device a client logged in to and its login there,
+// carried in the ExtraData of its permissions
+type netconfDevice struct {
+	name   string
+	client *proxy.NetconfClient
+}
+
+// netconfDeviceKey is the ExtraData key of a client's netconfDevice
+type netconfDeviceKey struct{}
+
+// NewNetconfServer creates a NETCONF server using the bastion host key
+func NewNetconfServer(cfg *config.Config, hostKeyPath string) (*NetconfServer, error) {
+	limits, err := auth.NewCredentialLimiter("NETCONF", cfg.Settings.BastionLimits)
+	if err != nil {
+		return nil, err
+	}
+	limits.OnBan(func(scope string) { metrics.BastionBans.WithLabelValues(scope).Inc() })
+	ns := &NetconfServer{
+		config: cfg,
+		limits: limits,
+		logins: make(map[string]*proxy.NetconfClient),
+		conns:  make(map[*ssh.ServerConn]struct{}),
+	}
+	ns.ctx, ns.cancel = context.WithCancel(context.Background())
+
+	hostKey, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host key: %w", err)
+	}
+
+	ns.sshConfig = &ssh.ServerConfig{
+		PasswordCallback: ns.passwordCallback,
+	}
+	ns.sshConfig.AddHostKey(hostKey)
+	return ns, nil
+}
+
+// netconfTarget splits a login into the device username and target FQDN
+func (ns *NetconfServer) netconfTarget(login string) (string, string, error) {
+	if i := strings.LastIndex(login, "@"); i > 0 && i < len(login)-1 {
+		return login[:i], login[i+1:], nil
+	}
+	if target, ok := ns.config.Settings.NetconfServer.Targets[login]; ok {
+		return login, target, nil
+	}
+	return "", "", fmt.Errorf("no target device for %s, log in as user@device", login)
+}
+
+// passwordCallback logs in to the target device with the client credentials.
+// Failed logins back off the client address and the device account.
+func (ns *NetconfServer) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	source := listen.SourceIP(conn.RemoteAddr())
+	if err := ns.limits.Check(source, ""); err != nil {
+		metrics.AuthAttempts.WithLabelValues("netconf", "failure", "blocked").Inc()
+		return nil, err
+	}
+
+	username, target, err := ns.netconfTarget(conn.User())
+	if err != nil {
+		ns.limits.Record(source, "", false)
+		metrics.AuthAttempts.WithLabelValues("netconf", "failure", "no_target").Inc()
+		return nil, err
+	}
+
+	device, deviceName, err := ns.config.GetDeviceByFQDN(target)
+	if err != nil {
+		ns.limits.Record(source, "", false)
+		metrics.AuthAttempts.WithLabelValues("netconf", "failure", "unknown_device").Inc()
+		return nil, err
+	}
+	if device.NetconfPort == 0 {
+		ns.limits.Record(source, "", false)
+		metrics.AuthAttempts.WithLabelValues("netconf", "failure", "unknown_device").Inc()
+		return nil, fmt.Errorf("device %s has no NETCONF port configured", deviceName)
+	}
+	account := username + "@" + deviceName
+	if err := ns.limits.Check(source, account); err != nil {
+		metrics.AuthAttempts.WithLabelValues("netconf", "failure", "blocked").Inc()
+		return nil, err
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"user":   username,
+		"device": deviceName,
+		"remote": conn.RemoteAddr().String(),
+	}).Debug("NETCONF login, authenticating against device")
+
+	timeout := time.Duration(ns.config.Settings.DefaultTimeout) * time.Second
+	if timeout == 0 {
+		timeout = netconfDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ns.ctx, timeout)
+	defer cancel()
+	client, err := proxy.LoginNetconf(ctx, device.Hostname, device.NetconfPort, username, string(password))
+	if err != nil {
+		reason := "device_unreachable"
+		var execErr *proxy.ExecError
+		if errors.As(err, &execErr) && execErr.Category == proxy.CategoryAuthFailed {
+			reason = "device_rejected"
+			ns.limits.Record(source, account, false)
+		}
+		logger.Log.WithError(err).WithField("device", deviceName).Warn("NETCONF login failed")
+		metrics.AuthAttempts.WithLabelValues("netconf", "failure", reason).Inc()
+		return nil, fmt.Errorf("login to %s failed: %w", deviceName, err)
+	}
+	ns.limits.Record(source, account, true)
+	metrics.AuthAttempts.WithLabelValues("netconf", "success", "device_password").Inc()
+
+	ns.mu.Lock()
+	ns.logins[conn.RemoteAddr().String()] = client
+	ns.mu.Unlock()
+	return &ssh.Permissions{
+		Extensions: map[string]string{"device": deviceName},
+		ExtraData: map[any]any{netconfDeviceKey{}: &netconfDevice{
+			name:   deviceName,
+			client: client,
+		}},
+	}, nil
+}
+
+// Start starts accepting NETCONF clients on address
+func (ns *NetconfServer) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	ns.mu.Lock()
+	ns.listener = listener
+	ns.mu.Unlock()
+	logger.Log.Infof("NETCONF server listening on %s", address)
+	if ns.onListening != nil {
+		ns.onListening()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				logger.Log.Info("NETCONF server stopped accepting connections")
+				return nil
+			}
+			logger.Log.WithError(err).Error("Failed to accept connection")
+			continue
+		}
+
+		ns.connWG.Add(1)
+		go func() {
+			defer ns.connWG.Done()
+			ns.handleConnection(conn)
+		}()
+	}
+}
+
+// OnListening sets a function called once the server accepts connections
+func (ns *NetconfServer) OnListening(fn func()) {
+	ns.onListening = fn
+}
+
+// handleConnection authenticates a client and serves its session channels
+func (ns *NetconfServer) handleConnection(netConn net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(netConn, ns.sshConfig)
+	ns.mu.Lock()
+	client := ns.logins[netConn.RemoteAddr().String()]
+	delete(ns.logins, netConn.RemoteAddr().String())
+	ns.mu.Unlock()
+	// The device login lasts as long as the client connection
+	if client != nil {
+		defer client.Close()
+	}
+	if err != nil {
+		logger.Log.WithError(err).Debug("NETCONF handshake failed")
+		netConn.Close()
+		return
+	}
+	defer sshConn.Close()
+	device, _ := sshConn.Permissions.ExtraData[netconfDeviceKey{}].(*netconfDevice)
+	if device == nil {
+		logger.Log.Error("NETCONF client authenticated without a device")
+		return
+	}
+
+	ns.mu.Lock()
+	ns.conns[sshConn] = struct{}{}
+	ns.mu.Unlock()
+	defer func() {
+		ns.mu.Lock()
+		delete(ns.conns, sshConn)
+		ns.mu.Unlock()
+	}()
+
+	logger.Log.Infof("NETCONF session for %s to %s from %s", sshConn.User(), device.name, sshConn.RemoteAddr())
+
+	go ssh.DiscardRequests(reqs)
+
+	// Device sessions end when the client disconnects or on shutdown
+	ctx, cancel := context.WithCancel(ns.ctx)
+	defer cancel()
+	go func() {
+		_ = sshConn.Wait()
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", newChannel.ChannelType()))
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ns.handleSession(ctx, device, newChannel)
+		}()
+	}
+	wg.Wait()
+}
+
+// handleSession waits for the netconf subsystem request and relays it
+func (ns *NetconfServer) handleSession(ctx context.Context, device *netconfDevice, newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to accept channel")
+		return
+	}
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "subsystem" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Name string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "netconf" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+		status := ns.relay(ctx, channel, req, device)
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+// relay opens the netconf subsystem on the client's device login and copies
+// the NETCONF stream in both directions until either side closes it
+func (ns *NetconfServer) relay(ctx context.Context, channel ssh.Channel, req *ssh.Request, device *netconfDevice) uint32 {
+	stdin, stdout, release, err := device.client.OpenSubsystem(ctx)
+	if err != nil {
+		logger.Log.WithError(err).WithField("device", device.name).Error("Failed to open the device netconf subsystem")
+		_ = req.Reply(false, nil)
+		return 1
+	}
+	release = sync.OnceFunc(release)
+	defer release()
+	// Closing the device session ends the relay when ctx is done
+	stop := context.AfterFunc(ctx, release)
+	defer stop()
+	_ = req.Reply(true, nil)
+
+	metrics.ActiveSessions.WithLabelValues("netconf").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("netconf").Dec()
+
+	go func() {
+		_, _ = io.Copy(stdin, metrics.CountingReader(channel, "netconf", metrics.DirectionToDevice))
+		stdin.Close()
+	}()
+	_, _ = io.Copy(metrics.CountingWriter(channel, "netconf", metrics.DirectionFromDevice), stdout)
+	_ = channel.CloseWrite()
+
+	logger.Log.WithField("device", device.name).Debug("NETCONF session closed")
+	return 0
+}
+
+// Stop stops accepting connections
+func (ns *NetconfServer) Stop() error {
+	ns.mu.Lock()
+	listener := ns.listener
+	ns.mu.Unlock()
+	if listener != nil {
+		return listener.Close()
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and lets open sessions finish until
+// ctx expires. Connections still open when ctx expires are closed.
+func (ns *NetconfServer) Shutdown(ctx context.Context) error {
+	if err := ns.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Log.WithError(err).Warn("Failed to close NETCONF listener")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ns.connWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		ns.cancel()
+		ns.mu.Lock()
+		remaining := len(ns.conns)
+		for conn := range ns.conns {
+			_ = conn.Close()
+		}
+		ns.mu.Unlock()
+		logger.Log.Warnf("Closed %d NETCONF connections after the drain timeout", remaining)
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,281 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+)
+
+const testDeviceHello = `<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><capabilities>` +
+	`<capability>urn:ietf:params:netconf:base:1.1</capability></capabilities><session-id>7</session-id></hello>]]>]]>`
+
+func TestNetconfServer_Relay(t *testing.T) {
+	devicePort := startTestNetconfDevice(t, "secret")
+	address := startTestNetconfServer(t, devicePort, map[string]string{"ops": "router1.test.local"})
+
+	for _, login := range []string{"admin@router1.test.local", "ops"} {
+		t.Run(login, func(t *testing.T) {
+			client, err := ssh.Dial("tcp", address, testNetconfClientConfig(login, "secret"))
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			stdin, _ := session.StdinPipe()
+			stdout, _ := session.StdoutPipe()
+			if err := session.RequestSubsystem("netconf"); err != nil {
+				t.Fatalf("RequestSubsystem failed: %v", err)
+			}
+
+			// The device hello and the chunked frames pass through unchanged
+			readUntil(t, stdout, testDeviceHello)
+			frame := "\n#19\n<rpc message-id=\"1\">\n##\n"
+			if _, err := io.WriteString(stdin, frame); err != nil {
+				t.Fatal(err)
+			}
+			readUntil(t, stdout, frame)
+		})
+	}
+}
+
+func TestNetconfServer_SingleDeviceLogin(t *testing.T) {
+	devicePort, logins := startCountingNetconfDevice(t, "secret")
+	address := startTestNetconfServer(t, devicePort, nil)
+
+	client, err := ssh.Dial("tcp", address, testNetconfClientConfig("admin@router1.test.local", "secret"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+
+	// Sessions are opened on the login that authenticated the client
+	for i := 0; i < 2; i++ {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout, _ := session.StdoutPipe()
+		if err := session.RequestSubsystem("netconf"); err != nil {
+			t.Fatalf("RequestSubsystem failed: %v", err)
+		}
+		readUntil(t, stdout, testDeviceHello)
+		session.Close()
+	}
+	if got := logins.Load(); got != 1 {
+		t.Errorf("Device logins = %d, want 1", got)
+	}
+}
+
+func TestNetconfServer_Authentication(t *testing.T) {
+	devicePort := startTestNetconfDevice(t, "secret")
+	address := startTestNetconfServer(t, devicePort, nil)
+
+	tests := []struct {
+		login, password string
+	}{
+		{"admin@router1.test.local", "wrong"},
+		{"admin@unknown.test.local", "secret"},
+		{"admin", "secret"},
+	}
+	for _, tt := range tests {
+		client, err := ssh.Dial("tcp", address, testNetconfClientConfig(tt.login, tt.password))
+		if err == nil {
+			client.Close()
+			t.Errorf("Login %s/%s should be rejected", tt.login, tt.password)
+		}
+	}
+}
+
+func TestNetconfServer_Throttled(t *testing.T) {
+	devicePort := startTestNetconfDevice(t, "secret")
+	cfg := testNetconfConfig(devicePort, nil)
+	cfg.Settings.BastionLimits = config.BastionLimitSettings{MaxFailures: 2, BanDuration: 60}
+	address := startTestNetconfServerWithConfig(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		if client, err := ssh.Dial("tcp", address, testNetconfClientConfig("admin@router1.test.local", "wrong")); err == nil {
+			client.Close()
+			t.Fatal("Login with a wrong password should be rejected")
+		}
+	}
+	// The device account is banned, the right password is not tried
+	if client, err := ssh.Dial("tcp", address, testNetconfClientConfig("admin@router1.test.local", "secret")); err == nil {
+		client.Close()
+		t.Error("Login of a banned device account should be rejected")
+	}
+}
+
+func TestNetconfServer_RejectsOtherRequests(t *testing.T) {
+	devicePort := startTestNetconfDevice(t, "secret")
+	address := startTestNetconfServer(t, devicePort, nil)
+
+	client, err := ssh.Dial("tcp", address, testNetconfClientConfig("admin@router1.test.local", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.Start("show version"); err == nil {
+		t.Error("exec should be rejected")
+	}
+	if err := session.RequestSubsystem("sftp"); err == nil {
+		t.Error("sftp subsystem should be rejected")
+	}
+}
+
+func testNetconfClientConfig(login, password string) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            login,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+}
+
+// testNetconfConfig returns the configuration of router1 on devicePort
+func testNetconfConfig(devicePort int, targets map[string]string) *config.Config {
+	return &config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {Hostname: "127.0.0.1", NetconfPort: devicePort}},
+		Settings: config.Settings{
+			DomainSuffix:  "test.local",
+			NetconfServer: config.NetconfServerSettings{Targets: targets},
+		},
+	}
+}
+
+// startTestNetconfServer starts a NETCONF server for router1 on devicePort
+func startTestNetconfServer(t *testing.T, devicePort int, targets map[string]string) string {
+	t.Helper()
+	return startTestNetconfServerWithConfig(t, testNetconfConfig(devicePort, targets))
+}
+
+// startTestNetconfServerWithConfig starts a NETCONF server for cfg
+func startTestNetconfServerWithConfig(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+
+	ns, err := NewNetconfServer(cfg, writeTestHostKey(t, t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewNetconfServer failed: %v", err)
+	}
+
+	listening := make(chan struct{})
+	ns.OnListening(func() { close(listening) })
+	go func() { _ = ns.Start("127.0.0.1:0") }()
+
+	select {
+	case <-listening:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NETCONF server did not start listening")
+	}
+	t.Cleanup(func() { _ = ns.Stop() })
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.listener.Addr().String()
+}
+
+// startTestNetconfDevice starts a device whose netconf subsystem sends a
+// hello and then echoes everything it receives
+func startTestNetconfDevice(t *testing.T, password string) int {
+	t.Helper()
+	port, _ := startCountingNetconfDevice(t, password)
+	return port
+}
+
+// startCountingNetconfDevice starts a test NETCONF device and counts the
+// logins it accepts
+func startCountingNetconfDevice(t *testing.T, password string) (int, *atomic.Int32) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logins := &atomic.Int32{}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if conn.User() == "admin" || conn.User() == "ops" {
+				if string(pass) == password {
+					logins.Add(1)
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestNetconfDevice(conn, serverConfig)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p, logins
+}
+
+func serveTestNetconfDevice(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				var payload struct{ Name string }
+				if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "netconf" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+				_, _ = io.WriteString(channel, testDeviceHello)
+				_, _ = io.Copy(channel, channel)
+				return
+			}
+		}()
+	}
+}
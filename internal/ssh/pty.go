@@ -0,0 +1,166 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/proxy"
+)
+
+// WindowSize is the size of a client terminal in characters
+type WindowSize struct {
+	Columns int
+	Rows    int
+}
+
+// ProxyShell opens an interactive shell with a PTY on device and bridges it
+// to client until the remote shell exits or ctx is cancelled. Sizes received
+// on resize are forwarded to the device as window changes. It is shared by
+// the SSH bastion and the web terminal so both behave the same way. The
+// device keys are only used when ctx comes from proxy.WithDeviceKeys.
+func ProxyShell(ctx context.Context, client io.ReadWriter, device *config.DeviceConfig, username, password, term string, size WindowSize, resize <-chan WindowSize) error {
+	// Configure SSH client for target device
+	targetConfig := &ssh.ClientConfig{
+		User:            username,
+		Auth:            proxy.AuthMethods(ctx, device.Hostname, device.SSHPort, username, password),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	// Connect to target device
+	targetAddr := net.JoinHostPort(device.Hostname, strconv.Itoa(device.SSHPort))
+	targetConn, err := ssh.Dial("tcp", targetAddr, targetConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect to device: %w", err)
+	}
+	defer targetConn.Close()
+
+	// Closing the connection ends the session when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { targetConn.Close() })
+	defer stop()
+
+	// Create session on target
+	targetSession, err := targetConn.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer targetSession.Close()
+
+	metrics.ActiveSessions.WithLabelValues("ssh").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("ssh").Dec()
+
+	// Setup I/O
+	output := metrics.CountingWriter(client, "ssh", metrics.DirectionFromDevice)
+	targetSession.Stdout = output
+	targetSession.Stderr = output
+	targetSession.Stdin = metrics.CountingReader(client, "ssh", metrics.DirectionToDevice)
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+
+	if term == "" {
+		term = "xterm-256color"
+	}
+	if size.Columns == 0 {
+		size.Columns = 80
+	}
+	if size.Rows == 0 {
+		size.Rows = 24
+	}
+
+	if err := targetSession.RequestPty(term, size.Rows, size.Columns, modes); err != nil {
+		return fmt.Errorf("failed to request PTY: %w", err)
+	}
+
+	// Forward terminal resizes until the session ends
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case ws, ok := <-resize:
+				if !ok {
+					return
+				}
+				_ = targetSession.WindowChange(ws.Rows, ws.Columns)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Start shell
+	if err := targetSession.Shell(); err != nil {
+		return fmt.Errorf("failed to start shell: %w", err)
+	}
+
+	// Wait for session to end
+	_ = targetSession.Wait()
+	return nil
+}
+
+// ProxyTelnet opens a telnet session to device and bridges it to client
+// until either side closes it or ctx is cancelled. The user logs in at the
+// device prompts. Sizes received on resize are sent to the device as NAWS
+// updates. timeout bounds the connection and each write, 0 uses the default.
+func ProxyTelnet(ctx context.Context, client io.ReadWriter, device *config.DeviceConfig, term string, size WindowSize, timeout time.Duration, resize <-chan WindowSize) error {
+	dialCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	target, err := proxy.DialTelnet(dialCtx, device.Hostname, device.TelnetPort, proxy.TelnetOptions{
+		Timeout:      timeout,
+		TerminalType: term,
+		Width:        size.Columns,
+		Height:       size.Rows,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to device: %w", err)
+	}
+	defer target.Close()
+
+	// Closing the connection ends the session when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { target.Close() })
+	defer stop()
+
+	metrics.ActiveSessions.WithLabelValues("telnet").Inc()
+	defer metrics.ActiveSessions.WithLabelValues("telnet").Dec()
+
+	// Forward terminal resizes until the session ends
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case ws, ok := <-resize:
+				if !ok {
+					return
+				}
+				_ = target.Resize(ws.Columns, ws.Rows)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return target.Interact(struct {
+		io.Reader
+		io.Writer
+	}{
+		metrics.CountingReader(client, "telnet", metrics.DirectionToDevice),
+		metrics.CountingWriter(client, "telnet", metrics.DirectionFromDevice),
+	})
+}
@@ -0,0 +1,522 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/listen"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+	"github.com/safabayar/gateway/internal/proxy"
+)
+
+// Telnet commands and options (RFC 854, 857, 858, 1073, 1091)
+const (
+	telnetSE   byte = 240
+	telnetIP   byte = 244
+	telnetSB   byte = 250
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255
+
+	telnetOptEcho  byte = 1
+	telnetOptSGA   byte = 3
+	telnetOptTType byte = 24
+	telnetOptNAWS  byte = 31
+
+	telnetTTypeIs   byte = 0
+	telnetTTypeSend byte = 1
+)
+
+const (
+	// telnetLoginAttempts is the number of logins allowed per connection
+	telnetLoginAttempts = 3
+	// telnetLoginDelay slows down password guessing after a failed login
+	telnetLoginDelay = 2 * time.Second
+	// telnetLoginTimeout bounds the time to complete a login
+	telnetLoginTimeout = 60 * time.Second
+)
+
+// TelnetServer serves the bastion menu to telnet clients, for console
+// tooling that does not speak SSH. Users log in with a gateway user from
+// settings.users, with a TOTP code when the user has a secret, and then
+// connect to devices over SSH or telnet like bastion users. Failed logins
+// count towards the bastion limits, and users only log in from their
+// allowed sources.
+type TelnetServer struct {
+	bastion     *BastionServer
+	users       *auth.Users
+	listener    net.Listener
+	onListening func()
+	loginDelay  time.Duration
+	conns       map[net.Conn]struct{}
+	sessions    map[*telnetConn]struct{}
+	connWG      sync.WaitGroup
+	mu          sync.Mutex
+}
+
+// NewTelnetServer creates a telnet server presenting the menu of bastion
+func NewTelnetServer(bastion *BastionServer, users *auth.Users) *TelnetServer {
+	return &TelnetServer{
+		bastion:    bastion,
+		users:      users,
+		loginDelay: telnetLoginDelay,
+		conns:      make(map[net.Conn]struct{}),
+		sessions:   make(map[*telnetConn]struct{}),
+	}
+}
+
+// Start starts accepting telnet clients on address
+func (ts *TelnetServer) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	ts.mu.Lock()
+	ts.listener = listener
+	ts.mu.Unlock()
+	logger.Log.Infof("Telnet server listening on %s", address)
+	if ts.onListening != nil {
+		ts.onListening()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				logger.Log.Info("Telnet server stopped accepting connections")
+				return nil
+			}
+			logger.Log.WithError(err).Error("Failed to accept connection")
+			continue
+		}
+
+		ts.connWG.Add(1)
+		go func() {
+			defer ts.connWG.Done()
+			ts.handleConnection(conn)
+		}()
+	}
+}
+
+// OnListening sets a function called once the server accepts connections
+func (ts *TelnetServer) OnListening(fn func()) {
+	ts.onListening = fn
+}
+
+// handleConnection logs the client in and runs the bastion menu
+func (ts *TelnetServer) handleConnection(netConn net.Conn) {
+	defer netConn.Close()
+	logger.Log.Infof("New telnet connection from %s", netConn.RemoteAddr())
+
+	ts.mu.Lock()
+	ts.conns[netConn] = struct{}{}
+	ts.mu.Unlock()
+	defer func() {
+		ts.mu.Lock()
+		delete(ts.conns, netConn)
+		ts.mu.Unlock()
+	}()
+
+	conn := newTelnetConn(netConn)
+	if err := conn.negotiate(); err != nil {
+		logger.Log.WithError(err).Debug("Telnet negotiation failed")
+		return
+	}
+
+	_ = netConn.SetDeadline(time.Now().Add(telnetLoginTimeout))
+	username, ok := ts.login(conn, listen.SourceIP(netConn.RemoteAddr()))
+	if !ok {
+		return
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	logger.Log.Infof("Telnet login for user %s from %s", username, netConn.RemoteAddr())
+
+	ts.mu.Lock()
+	ts.sessions[conn] = struct{}{}
+	ts.mu.Unlock()
+	defer func() {
+		ts.mu.Lock()
+		delete(ts.sessions, conn)
+		ts.mu.Unlock()
+	}()
+
+	term, size := conn.terminal()
+	termInfo := &ptyRequestMsg{Term: term, Columns: uint32(size.Columns), Rows: uint32(size.Rows)}
+	// Telnet users are authenticated by the gateway, so their device logins
+	// use the device keys
+	ts.bastion.runInteractiveShellWithTermInfo(proxy.WithDeviceKeys(ts.bastion.ctx), conn, username, username, termInfo, conn.resize)
+}
+
+// login asks for a gateway username, password and TOTP code, and returns
+// the username once they are accepted from source
+func (ts *TelnetServer) login(conn *telnetConn, source netip.Addr) (string, bool) {
+	limits := ts.bastion.limits.failures
+	for attempt := 0; attempt < telnetLoginAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ts.loginDelay)
+			_, _ = conn.Write([]byte("Login incorrect\r\n\r\n"))
+		}
+
+		_, _ = conn.Write([]byte("Username: "))
+		username, err := ts.bastion.readLine(conn)
+		if err != nil {
+			return "", false
+		}
+		_, _ = conn.Write([]byte("Password: "))
+		password, err := ts.bastion.readPassword(conn)
+		if err != nil {
+			return "", false
+		}
+		_, _ = conn.Write([]byte("\r\n"))
+
+		if err := limits.Check(source, username); err != nil {
+			metrics.AuthAttempts.WithLabelValues("telnet", "failure", "blocked").Inc()
+			logger.Log.WithFields(map[string]interface{}{"user": username, "remote": source.String()}).Debug("Refused telnet login of a blocked source or user")
+			_, _ = conn.Write([]byte(err.Error() + "\r\n"))
+			return "", false
+		}
+
+		if err := ts.users.CheckPassword(username, password); err != nil {
+			limits.Record(source, username, false)
+			metrics.AuthAttempts.WithLabelValues("telnet", "failure", "password").Inc()
+			logger.Log.WithField("user", username).Warn("Telnet login failed")
+			continue
+		}
+
+		if !ts.users.AllowsSource(username, source) {
+			metrics.AuthAttempts.WithLabelValues("telnet", "failure", "source_denied").Inc()
+			logger.Log.WithFields(map[string]interface{}{"user": username, "remote": source.String()}).Warn("Refused telnet login from a source the user may not log in from")
+			_, _ = conn.Write([]byte(fmt.Sprintf("%s may not log in from %s\r\n", username, source)))
+			return "", false
+		}
+
+		// The secret cannot be shown over telnet, users enroll on the SSH
+		// bastion
+		if ts.users.NeedsTOTPEnrollment(username) {
+			metrics.AuthAttempts.WithLabelValues("telnet", "failure", "totp_enrollment").Inc()
+			logger.Log.WithField("user", username).Warn("Telnet login refused, TOTP enrollment required")
+			_, _ = conn.Write([]byte("TOTP enrollment required, log in to the SSH bastion to enroll\r\n"))
+			return "", false
+		}
+
+		if ts.users.RequiresTOTP(username) {
+			_, _ = conn.Write([]byte("Verification code: "))
+			code, err := ts.bastion.readLine(conn)
+			if err != nil {
+				return "", false
+			}
+			recovery, err := ts.users.CheckTOTP(username, code)
+			if err != nil {
+				limits.Record(source, username, false)
+				metrics.AuthAttempts.WithLabelValues("telnet", "failure", "totp").Inc()
+				logger.Log.WithField("user", username).Warn("Telnet login failed, invalid verification code")
+				continue
+			}
+			limits.Record(source, username, true)
+			if recovery {
+				logger.Log.WithField("user", username).Warn("Accepted TOTP recovery code")
+				metrics.AuthAttempts.WithLabelValues("telnet", "success", "recovery_code").Inc()
+				return username, true
+			}
+			metrics.AuthAttempts.WithLabelValues("telnet", "success", "password_totp").Inc()
+			return username, true
+		}
+
+		limits.Record(source, username, true)
+		metrics.AuthAttempts.WithLabelValues("telnet", "success", "password").Inc()
+		return username, true
+	}
+
+	_, _ = conn.Write([]byte("Login incorrect\r\n"))
+	return "", false
+}
+
+// Stop stops accepting connections
+func (ts *TelnetServer) Stop() error {
+	ts.mu.Lock()
+	listener := ts.listener
+	ts.mu.Unlock()
+	if listener != nil {
+		return listener.Close()
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and lets connected users finish until
+// ctx expires, sending the bastion countdown notice to logged in users.
+// Connections still open when ctx expires are closed.
+func (ts *TelnetServer) Shutdown(ctx context.Context) error {
+	if err := ts.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Log.WithError(err).Warn("Failed to close telnet listener")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ts.connWG.Wait()
+		close(done)
+	}()
+
+	deadline, hasDeadline := ctx.Deadline()
+	notifyAt := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if hasDeadline && !time.Now().Before(notifyAt) {
+			remaining := time.Until(deadline).Round(time.Second)
+			ts.broadcast(fmt.Sprintf("\r\n*** The gateway is shutting down, this session will be closed in %s ***\r\n", remaining))
+			notifyAt = time.Now().Add(noticeInterval(remaining))
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			ts.mu.Lock()
+			remaining := len(ts.conns)
+			for conn := range ts.conns {
+				_ = conn.Close()
+			}
+			ts.mu.Unlock()
+			logger.Log.Warnf("Closed %d telnet connections after the drain timeout", remaining)
+			<-done
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// broadcast writes a message to every logged in user
+func (ts *TelnetServer) broadcast(message string) {
+	ts.mu.Lock()
+	sessions := make([]*telnetConn, 0, len(ts.sessions))
+	for conn := range ts.sessions {
+		sessions = append(sessions, conn)
+	}
+	ts.mu.Unlock()
+
+	for _, conn := range sessions {
+		_, _ = conn.Write([]byte(message))
+	}
+}
+
+// telnetConn is the terminal of a telnet client. Reads return the typed
+// characters with telnet commands removed, an Enter key as a single CR and
+// an interrupt as Ctrl+C. Writes escape IAC bytes.
+type telnetConn struct {
+	conn net.Conn
+	// readMu serializes reads, a device relay may still be reading when the
+	// menu resumes, as with SSH channels
+	readMu sync.Mutex
+	reader *bufio.Reader
+	// resize receives the window sizes sent by the client (NAWS), keeping
+	// only the latest one until it is read
+	resize chan WindowSize
+	lastCR bool
+
+	mu   sync.Mutex
+	term string
+	size WindowSize
+
+	writeMu sync.Mutex
+}
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	return &telnetConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		resize: make(chan WindowSize, 1),
+		term:   "vt100",
+		size:   WindowSize{Columns: 80, Rows: 24},
+	}
+}
+
+// negotiate puts the client in character mode with the server echoing, and
+// asks for its window size and terminal type
+func (c *telnetConn) negotiate() error {
+	return c.send(
+		telnetIAC, telnetWILL, telnetOptEcho,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptNAWS,
+		telnetIAC, telnetDO, telnetOptTType,
+	)
+}
+
+// terminal returns the terminal type and window size sent by the client
+func (c *telnetConn) terminal() (string, WindowSize) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.term, c.size
+}
+
+// Read returns the data bytes received, processing telnet commands
+func (c *telnetConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if n > 0 && c.reader.Buffered() == 0 {
+			break
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+
+		if b == telnetIAC {
+			data, ok, err := c.command()
+			if err != nil {
+				return n, err
+			}
+			if ok {
+				p[n] = data
+				n++
+			}
+			continue
+		}
+
+		// Enter is sent as CR LF or CR NUL
+		if c.lastCR && (b == '\n' || b == 0) {
+			c.lastCR = false
+			continue
+		}
+		c.lastCR = b == '\r'
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// command processes the bytes following an IAC and returns a data byte
+// when the command stands for one
+func (c *telnetConn) command() (byte, bool, error) {
+	command, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch command {
+	case telnetIAC:
+		return telnetIAC, true, nil
+	case telnetIP:
+		return 3, true, nil
+	case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+		option, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, false, c.answer(command, option)
+	case telnetSB:
+		return 0, false, c.subnegotiation()
+	}
+	// NOP, GA and the other commands carry no data
+	return 0, false, nil
+}
+
+// answer replies to option requests. The options we offer or ask for in
+// negotiate are accepted, others are refused. DONT and WONT need no reply.
+func (c *telnetConn) answer(command, option byte) error {
+	switch command {
+	case telnetDO:
+		if option == telnetOptEcho || option == telnetOptSGA {
+			return nil
+		}
+		return c.send(telnetIAC, telnetWONT, option)
+	case telnetWILL:
+		switch option {
+		case telnetOptSGA, telnetOptNAWS:
+			return nil
+		case telnetOptTType:
+			return c.send(telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE)
+		}
+		return c.send(telnetIAC, telnetDONT, option)
+	}
+	return nil
+}
+
+// subnegotiation reads IAC SB <option> ... IAC SE and records the window
+// size and terminal type
+func (c *telnetConn) subnegotiation() error {
+	var data []byte
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != telnetIAC {
+			data = append(data, b)
+			continue
+		}
+		next, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if next == telnetSE {
+			break
+		}
+		data = append(data, next)
+	}
+
+	switch {
+	case len(data) == 5 && data[0] == telnetOptNAWS:
+		size := WindowSize{
+			Columns: int(data[1])<<8 | int(data[2]),
+			Rows:    int(data[3])<<8 | int(data[4]),
+		}
+		if size.Columns == 0 || size.Rows == 0 {
+			return nil
+		}
+		c.mu.Lock()
+		c.size = size
+		c.mu.Unlock()
+		select {
+		case <-c.resize:
+		default:
+		}
+		c.resize <- size
+	case len(data) > 2 && data[0] == telnetOptTType && data[1] == telnetTTypeIs:
+		c.mu.Lock()
+		c.term = string(data[2:])
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// Write sends p, doubling IAC bytes
+func (c *telnetConn) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		out = append(out, b)
+		if b == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+	}
+	if err := c.send(out...); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *telnetConn) send(data ...byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
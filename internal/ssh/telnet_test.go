@@ -0,0 +1,374 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/config"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// testTOTPTime is the fixed time test TOTP stores check codes at
+var testTOTPTime = time.Unix(1700000000, 0)
+
+func TestTelnetConn_Read(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := newTelnetConn(server)
+
+	go func() {
+		_, _ = client.Write([]byte{
+			'l', 's', '\r', '\n',
+			telnetIAC, telnetSB, telnetOptNAWS, 0, 132, 0, 43, telnetIAC, telnetSE,
+			'a', telnetIAC, telnetIAC, '\r', 0,
+			telnetIAC, telnetSB, telnetOptTType, telnetTTypeIs, 'x', 't', 'e', 'r', 'm', telnetIAC, telnetSE,
+			telnetIAC, telnetIP,
+		})
+	}()
+
+	want := []byte{'l', 's', '\r', 'a', telnetIAC, '\r', 3}
+	got := make([]byte, 0, len(want))
+	buf := make([]byte, 64)
+	for len(got) < len(want) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Read = %q, want %q", got, want)
+	}
+
+	term, size := conn.terminal()
+	if term != "xterm" || size != (WindowSize{Columns: 132, Rows: 43}) {
+		t.Errorf("terminal() = %s %+v, want xterm 132x43", term, size)
+	}
+	select {
+	case ws := <-conn.resize:
+		if ws != size {
+			t.Errorf("resize = %+v, want %+v", ws, size)
+		}
+	default:
+		t.Error("window size not sent on resize")
+	}
+}
+
+func TestTelnetServer_Login(t *testing.T) {
+	address, _ := startTestTelnetServer(t, 0)
+
+	tests := []struct {
+		name  string
+		login func(t *testing.T, conn net.Conn)
+	}{
+		{"password", func(t *testing.T, conn net.Conn) {
+			telnetLogin(t, conn, "alice", "secret")
+		}},
+		{"totp", func(t *testing.T, conn net.Conn) {
+			telnetLogin(t, conn, "bob", "secret")
+			readUntil(t, conn, "Verification code: ")
+			code, err := auth.TOTPCode(testTOTPSecret, testTOTPTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeString(t, conn, code+"\r\n")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dialTelnet(t, address)
+			tt.login(t, conn)
+
+			readUntil(t, conn, "router1.test.local")
+			readUntil(t, conn, "bastion> ")
+			writeString(t, conn, "exit\r\n")
+			readUntil(t, conn, "Goodbye!")
+		})
+	}
+}
+
+func TestTelnetServer_LoginRejected(t *testing.T) {
+	address, _ := startTestTelnetServer(t, 0)
+
+	conn := dialTelnet(t, address)
+	for _, password := range []string{"wrong", "secret", "guess"} {
+		telnetLogin(t, conn, "mallory", password)
+	}
+	readUntil(t, conn, "Login incorrect")
+
+	// The connection is closed after the last attempt
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed after failed logins: %v", err)
+	}
+
+	conn = dialTelnet(t, address)
+	telnetLogin(t, conn, "bob", "secret")
+	readUntil(t, conn, "Verification code: ")
+	writeString(t, conn, "000000\r\n")
+	readUntil(t, conn, "Login incorrect\r\n\r\nUsername: ")
+}
+
+func TestTelnetServer_LoginLimits(t *testing.T) {
+	cfg := testTelnetConfig(t, 0)
+	cfg.Settings.BastionLimits = config.BastionLimitSettings{MaxFailures: 2}
+	carol := cfg.Settings.Users["alice"]
+	carol.AllowedSources = []string{"10.0.0.0/8"}
+	cfg.Settings.Users["carol"] = carol
+	address, ts := startTestTelnetServerWithConfig(t, cfg)
+
+	// carol may only log in from 10.0.0.0/8
+	conn := dialTelnet(t, address)
+	telnetLogin(t, conn, "carol", "secret")
+	readUntil(t, conn, "carol may not log in from 127.0.0.1")
+
+	// Two failures ban alice, on telnet and on the SSH bastion
+	conn = dialTelnet(t, address)
+	telnetLogin(t, conn, "alice", "wrong")
+	telnetLogin(t, conn, "alice", "guess")
+	telnetLogin(t, conn, "alice", "secret")
+	readUntil(t, conn, "too many failed logins")
+	if !ts.bastion.limits.failures.Blocked(auth.LimitScopeUser, "alice") {
+		t.Error("Failed telnet logins did not count against the bastion user")
+	}
+}
+
+func TestTelnetServer_BastionTOTP(t *testing.T) {
+	address, ts := startTestTelnetServer(t, 0)
+	store := ts.bastion.totpStore()
+
+	// alice enrolls on the SSH bastion, and telnet asks for the same code
+	enrollment, err := store.NewEnrollment("alice", "Gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := auth.TOTPCode(enrollment.Secret, testTOTPTime)
+	if err := store.Confirm(enrollment, code); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+
+	conn := dialTelnet(t, address)
+	telnetLogin(t, conn, "alice", "secret")
+	readUntil(t, conn, "Verification code: ")
+	// The code used to enroll is spent
+	writeString(t, conn, code+"\r\n")
+	telnetLogin(t, conn, "alice", "secret")
+	readUntil(t, conn, "Verification code: ")
+	writeString(t, conn, enrollment.RecoveryCodes[0]+"\r\n")
+	readUntil(t, conn, "bastion> ")
+	if left := store.RecoveryCodesLeft("alice"); left != 9 {
+		t.Errorf("RecoveryCodesLeft = %d, want 9", left)
+	}
+}
+
+func TestTelnetServer_TOTPRequired(t *testing.T) {
+	cfg := testTelnetConfig(t, 0)
+	cfg.Settings.BastionTOTP.Required = true
+	address, _ := startTestTelnetServerWithConfig(t, cfg)
+
+	// alice has no secret and cannot enroll over telnet
+	conn := dialTelnet(t, address)
+	telnetLogin(t, conn, "alice", "secret")
+	readUntil(t, conn, "TOTP enrollment required")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed: %v", err)
+	}
+}
+
+func TestTelnetServer_TelnetToDevice(t *testing.T) {
+	devicePort := startTestTelnetDevice(t)
+	address, _ := startTestTelnetServer(t, devicePort)
+
+	conn := dialTelnet(t, address)
+	telnetLogin(t, conn, "alice", "secret")
+	readUntil(t, conn, "bastion> ")
+
+	writeString(t, conn, "telnet router1.test.local\r\n")
+	readUntil(t, conn, "device> ")
+	writeString(t, conn, "show version\r\n")
+	readUntil(t, conn, "echo: show version")
+
+	// The device closes the session and the menu returns
+	writeString(t, conn, "quit\r\n")
+	readUntil(t, conn, "Connection closed.\r\n\r\nbastion> ")
+}
+
+func TestTelnetServer_Shutdown(t *testing.T) {
+	address, ts := startTestTelnetServer(t, 0)
+
+	conn := dialTelnet(t, address)
+	telnetLogin(t, conn, "alice", "secret")
+	readUntil(t, conn, "bastion> ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- ts.Shutdown(ctx) }()
+
+	readUntil(t, conn, "The gateway is shutting down")
+	if err := <-errc; err == nil {
+		t.Error("Shutdown with a logged in user should report the drain timeout")
+	}
+}
+
+// startTestTelnetServer starts a telnet server with the users alice and bob,
+// bob having a TOTP secret, and router1 on telnetPort
+func startTestTelnetServer(t *testing.T, telnetPort int) (string, *TelnetServer) {
+	t.Helper()
+	return startTestTelnetServerWithConfig(t, testTelnetConfig(t, telnetPort))
+}
+
+// testTelnetConfig is the configuration of startTestTelnetServer, with a
+// TOTP store file users can enroll in
+func testTelnetConfig(t *testing.T, telnetPort int) *config.Config {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {Hostname: "127.0.0.1", SSHPort: 22, TelnetPort: telnetPort}},
+		Settings: config.Settings{
+			DomainSuffix:   "test.local",
+			DefaultTimeout: 5,
+			Users: map[string]config.UserSettings{
+				"alice": {PasswordHash: string(hash)},
+				"bob":   {PasswordHash: string(hash), TOTPSecret: testTOTPSecret},
+			},
+			BastionTOTP: config.BastionTOTPSettings{StoreFile: filepath.Join(t.TempDir(), "totp.json")},
+		},
+	}
+}
+
+// startTestTelnetServerWithConfig starts a telnet server for cfg whose TOTP
+// store checks codes at testTOTPTime
+func startTestTelnetServerWithConfig(t *testing.T, cfg *config.Config) (string, *TelnetServer) {
+	t.Helper()
+
+	dir := t.TempDir()
+	bs, err := NewBastionServer(cfg, writeTestHostKey(t, dir), filepath.Join(dir, "authorized_keys"))
+	if err != nil {
+		t.Fatalf("NewBastionServer failed: %v", err)
+	}
+	store, err := auth.NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatalf("NewTOTPStore failed: %v", err)
+	}
+	store.SetClock(func() time.Time { return testTOTPTime })
+	bs.SetTOTPStore(store)
+	users, err := auth.NewUsers(cfg, store)
+	if err != nil {
+		t.Fatalf("NewUsers failed: %v", err)
+	}
+
+	ts := NewTelnetServer(bs, users)
+	ts.loginDelay = 0
+	listening := make(chan struct{})
+	ts.OnListening(func() { close(listening) })
+	go func() { _ = ts.Start("127.0.0.1:0") }()
+
+	select {
+	case <-listening:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Telnet server did not start listening")
+	}
+	t.Cleanup(func() {
+		_ = ts.Stop()
+		_ = bs.Stop()
+	})
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.listener.Addr().String(), ts
+}
+
+func dialTelnet(t *testing.T, address string) net.Conn {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func telnetLogin(t *testing.T, conn net.Conn, username, password string) {
+	t.Helper()
+
+	readUntil(t, conn, "Username: ")
+	writeString(t, conn, username+"\r\n")
+	readUntil(t, conn, "Password: ")
+	writeString(t, conn, password+"\r\x00")
+}
+
+func writeString(t *testing.T, w io.Writer, s string) {
+	t.Helper()
+
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTestTelnetDevice starts a device that echoes each line after a
+// "device> " prompt and closes the connection on "quit"
+func startTestTelnetDevice(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestTelnetDevice(conn)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func serveTestTelnetDevice(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	var line []byte
+	_, _ = io.WriteString(conn, "device> ")
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0, '\n':
+		case '\r':
+			if string(line) == "quit" {
+				return
+			}
+			_, _ = io.WriteString(conn, "\r\necho: "+string(line)+"\r\ndevice> ")
+			line = line[:0]
+		default:
+			line = append(line, b)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/logger"
+	"github.com/safabayar/gateway/internal/metrics"
+)
+
+// defaultTOTPIssuer names the gateway in authenticator apps
+const defaultTOTPIssuer = "Gateway"
+
+// gatewayUserExtension holds the gateway user a bastion client authenticated
+// as in the permissions of its connection, from its bound key or certificate
+const gatewayUserExtension = "gateway-user"
+
+// SetTOTPStore asks gateway users with a secret in store for a TOTP code
+// after their key and lets users enroll from the menu
+func (bs *BastionServer) SetTOTPStore(store *auth.TOTPStore) {
+	bs.mu.Lock()
+	bs.totp = store
+	bs.mu.Unlock()
+}
+
+func (bs *BastionServer) totpStore() *auth.TOTPStore {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.totp
+}
+
+// connGatewayUser returns the gateway user a connection authenticated as,
+// empty for keys not bound to one. The login name plays no part in it.
+func connGatewayUser(conn *ssh.ServerConn) string {
+	if conn.Permissions == nil {
+		return ""
+	}
+	return conn.Permissions.Extensions[gatewayUserExtension]
+}
+
+// secondFactor completes a key login, or asks for a TOTP code with
+// keyboard-interactive when the gateway user of the key has a secret. Keys
+// without a gateway user are refused when TOTP is required.
+func (bs *BastionServer) secondFactor(conn ssh.ConnMetadata, perms *ssh.Permissions) (*ssh.Permissions, error) {
+	store := bs.totpStore()
+	if store == nil {
+		return perms, nil
+	}
+	user := perms.Extensions[gatewayUserExtension]
+	if user == "" {
+		if bs.config.Settings.BastionTOTP.Required {
+			logger.Log.WithField("user", conn.User()).Warn("Refused key without a gateway user, TOTP is required")
+			metrics.AuthAttempts.WithLabelValues("bastion", "failure", "unbound_key").Inc()
+			return nil, fmt.Errorf("key of %s is not bound to a gateway user", conn.User())
+		}
+		return perms, nil
+	}
+	if !store.Enrolled(user) {
+		return perms, nil
+	}
+
+	return nil, &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			// The key's signature was checked, a wrong code counts against
+			// its user
+			bs.limits.identify(conn, user)
+			answers, err := challenge("", "", []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 {
+				return nil, fmt.Errorf("expected one answer, got %d", len(answers))
+			}
+
+			fields := map[string]interface{}{"user": conn.User(), "gateway_user": user}
+			recovery, err := store.Verify(user, answers[0])
+			if err != nil {
+				logger.Log.WithError(err).WithFields(fields).Warn("Rejected TOTP code")
+				metrics.AuthAttempts.WithLabelValues("bastion", "failure", "totp").Inc()
+				return nil, fmt.Errorf("invalid verification code for %s", user)
+			}
+			if recovery {
+				fields["remaining"] = store.RecoveryCodesLeft(user)
+				logger.Log.WithFields(fields).Warn("Accepted TOTP recovery code")
+				metrics.AuthAttempts.WithLabelValues("bastion", "success", "recovery_code").Inc()
+				return perms, nil
+			}
+			metrics.AuthAttempts.WithLabelValues("bastion", "success", "totp").Inc()
+			return perms, nil
+		},
+	}}
+}
+
+// needsTOTPEnrollment reports whether the gateway user must enroll before
+// using the bastion. Keys without a gateway user were refused at login.
+func (bs *BastionServer) needsTOTPEnrollment(user string) bool {
+	store := bs.totpStore()
+	return store != nil && user != "" && bs.config.Settings.BastionTOTP.Required && !store.Enrolled(user)
+}
+
+// handleEnrollmentSession serves a session of a user who must enroll first:
+// a shell runs the enrollment, commands and subsystems are refused
+func (bs *BastionServer) handleEnrollmentSession(channel ssh.Channel, requests <-chan *ssh.Request, user string) {
+	for req := range requests {
+		switch req.Type {
+		case "pty-req", "env":
+			_ = req.Reply(true, nil)
+
+		case "shell":
+			_ = req.Reply(true, nil)
+			var status uint32
+			if !bs.enrollTOTP(channel, user) {
+				status = 1
+			}
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+
+		case "exec", "subsystem":
+			_ = req.Reply(true, nil)
+			_, _ = fmt.Fprintf(channel.Stderr(), "Error: TOTP enrollment required, log in with an interactive shell to enroll\n")
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+			return
+
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// enrollTOTP shows a new secret as a QR code with its recovery codes and
+// stores it once the user enters a valid code
+func (bs *BastionServer) enrollTOTP(channel io.ReadWriter, user string) bool {
+	store := bs.totpStore()
+	if !store.CanEnroll() {
+		_, _ = channel.Write([]byte("Error: TOTP enrollment is not enabled on this gateway\r\n"))
+		return false
+	}
+	issuer := bs.config.Settings.BastionTOTP.Issuer
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+
+	enrollment, err := store.NewEnrollment(user, issuer)
+	if err != nil {
+		_, _ = channel.Write([]byte(fmt.Sprintf("Error: %s\r\n", err)))
+		return false
+	}
+	qr, err := qrcode.New(enrollment.URI, qrcode.Low)
+	if err != nil {
+		_, _ = channel.Write([]byte(fmt.Sprintf("Error: %s\r\n", err)))
+		return false
+	}
+
+	_, _ = channel.Write([]byte(fmt.Sprintf("\r\nTOTP enrollment for %s\r\n\r\n", user)))
+	_, _ = channel.Write([]byte("Scan this QR code with your authenticator app:\r\n\r\n"))
+	_, _ = channel.Write([]byte(strings.ReplaceAll(qr.ToSmallString(false), "\n", "\r\n")))
+	_, _ = channel.Write([]byte(fmt.Sprintf("\r\nOr enter the secret manually: %s\r\n\r\n", enrollment.Secret)))
+	_, _ = channel.Write([]byte("Recovery codes, each works once in place of a code. Keep them safe:\r\n"))
+	for _, code := range enrollment.RecoveryCodes {
+		_, _ = channel.Write([]byte(fmt.Sprintf("  %s\r\n", code)))
+	}
+	_, _ = channel.Write([]byte("\r\n"))
+
+	for attempt := 0; attempt < 3; attempt++ {
+		_, _ = channel.Write([]byte("Verification code: "))
+		code, err := bs.readLine(channel)
+		if err != nil {
+			return false
+		}
+		if err := store.Confirm(enrollment, strings.TrimSpace(code)); err != nil {
+			_, _ = channel.Write([]byte(fmt.Sprintf("Error: %s\r\n", err)))
+			continue
+		}
+		logger.Log.WithField("user", user).Info("Enrolled bastion TOTP secret")
+		_, _ = channel.Write([]byte("TOTP enrolled, your next logins ask for a code.\r\n"))
+		return true
+	}
+	_, _ = channel.Write([]byte("Enrollment cancelled.\r\n"))
+	return false
+}
@@ -0,0 +1,327 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/auth"
+	"github.com/safabayar/gateway/internal/config"
+)
+
+func TestBastionTOTP(t *testing.T) {
+	alice, bob, unbound := newTestSigner(t), newTestSigner(t), newTestSigner(t)
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			Users: map[string]config.UserSettings{
+				"alice": {AuthorizedKeys: []string{authorizedKey(alice)}},
+				"bob":   {AuthorizedKeys: []string{authorizedKey(bob)}},
+			},
+		},
+	}
+	recoveryHash := sha256.Sum256([]byte("abcde12345"))
+	store := writeTestTOTPStore(t, cfg, fmt.Sprintf(
+		`{"alice": {"secret": %q, "recovery_codes": [%q]}}`, testTOTPSecret, hex.EncodeToString(recoveryHash[:])))
+
+	bs, address := startTestBastionWithConfig(t, cfg)
+	bs.SetTOTPStore(store)
+	// Unbound keys are accepted from authorized_keys, without a gateway user
+	bs.mu.Lock()
+	bs.authorizedKeys = map[string]ssh.PublicKey{string(unbound.PublicKey().Marshal()): unbound.PublicKey()}
+	bs.mu.Unlock()
+
+	code, err := auth.TOTPCode(testTOTPSecret, testTOTPTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("key alone", func(t *testing.T) {
+		if _, err := dialTestBastionTOTP(address, alice, "alice", nil); err == nil {
+			t.Error("Login without a code succeeded")
+		}
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		if _, err := dialTestBastionTOTP(address, alice, "alice", []string{"000000"}); err == nil {
+			t.Error("Login with a wrong code succeeded")
+		}
+	})
+
+	t.Run("code", func(t *testing.T) {
+		client, err := dialTestBastionTOTP(address, alice, "alice", []string{code})
+		if err != nil {
+			t.Fatalf("Login with a valid code failed: %v", err)
+		}
+		client.Close()
+
+		if _, err := dialTestBastionTOTP(address, alice, "alice", []string{code}); err == nil {
+			t.Error("Login reusing a code succeeded")
+		}
+	})
+
+	t.Run("login name", func(t *testing.T) {
+		// The login name picks the device and its user, the key picks the
+		// gateway user whose code is asked
+		for _, user := range []string{"admin%router1.test.local", "bob"} {
+			if _, err := dialTestBastionTOTP(address, alice, user, nil); err == nil {
+				t.Errorf("Login of alice's key as %s without a code succeeded", user)
+			}
+		}
+		if _, err := dialTestBastionTOTP(address, bob, "alice", nil); err != nil {
+			t.Errorf("Login of bob's key as alice failed: %v", err)
+		}
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		client, err := dialTestBastionTOTP(address, alice, "admin%router1.test.local", []string{"ABCDE-12345"})
+		if err != nil {
+			t.Fatalf("Login with a recovery code failed: %v", err)
+		}
+		client.Close()
+		if left := store.RecoveryCodesLeft("alice"); left != 0 {
+			t.Errorf("RecoveryCodesLeft = %d, want 0", left)
+		}
+
+		if _, err := dialTestBastionTOTP(address, alice, "alice", []string{"abcde-12345"}); err == nil {
+			t.Error("Login reusing a recovery code succeeded")
+		}
+	})
+
+	t.Run("not enrolled", func(t *testing.T) {
+		client, err := dialTestBastionTOTP(address, bob, "bob", nil)
+		if err != nil {
+			t.Fatalf("Login of a user without TOTP failed: %v", err)
+		}
+		client.Close()
+	})
+
+	t.Run("unbound key", func(t *testing.T) {
+		client, err := dialTestBastionTOTP(address, unbound, "alice", nil)
+		if err != nil {
+			t.Fatalf("Login with an unbound key failed: %v", err)
+		}
+		client.Close()
+
+		bs.config.Settings.BastionTOTP.Required = true
+		defer func() { bs.config.Settings.BastionTOTP.Required = false }()
+		if _, err := dialTestBastionTOTP(address, unbound, "alice", nil); err == nil {
+			t.Error("Login with an unbound key succeeded while TOTP is required")
+		}
+	})
+}
+
+func TestBastionTOTPEnrollment(t *testing.T) {
+	carol, dave := newTestSigner(t), newTestSigner(t)
+	devicePort := startTestExecDevice(t, "secret")
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{"router1": {Hostname: "127.0.0.1", SSHPort: devicePort}},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			Users: map[string]config.UserSettings{
+				"carol": {AuthorizedKeys: []string{authorizedKey(carol)}},
+				"dave":  {AuthorizedKeys: []string{authorizedKey(dave)}},
+			},
+			BastionTOTP: config.BastionTOTPSettings{Required: true, Issuer: "Test Gateway"},
+		},
+	}
+	store := writeTestTOTPStore(t, cfg, "")
+	bs, address := startTestBastionWithConfig(t, cfg)
+	bs.SetTOTPStore(store)
+
+	t.Run("commands refused", func(t *testing.T) {
+		client, err := dialTestBastionTOTP(address, carol, "carol", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+		output, err := session.CombinedOutput("list")
+		if err == nil || !strings.Contains(string(output), "TOTP enrollment required") {
+			t.Errorf("output = %q, err = %v, want enrollment required", output, err)
+		}
+	})
+
+	t.Run("forwards refused", func(t *testing.T) {
+		client, err := dialTestBastionTOTP(address, carol, "carol", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		conn, err := client.Dial("tcp", net.JoinHostPort("router1.test.local", strconv.Itoa(devicePort)))
+		if err == nil {
+			conn.Close()
+			t.Fatal("forward of a user who must enroll was opened")
+		}
+		if !strings.Contains(err.Error(), "TOTP enrollment required") {
+			t.Errorf("Dial error = %v, want enrollment required", err)
+		}
+	})
+
+	t.Run("enroll", func(t *testing.T) {
+		// The login name does not choose who enrolls
+		client, err := dialTestBastionTOTP(address, carol, "mallory", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		stdin, stdout := startTestShell(t, client)
+		secret := enrollTestUser(t, stdin, stdout)
+		if !store.Enrolled("carol") || store.Enrolled("mallory") {
+			t.Fatalf("Enrolled carol = %v, mallory = %v, want only carol", store.Enrolled("carol"), store.Enrolled("mallory"))
+		}
+		if left := store.RecoveryCodesLeft("carol"); left != 10 {
+			t.Errorf("RecoveryCodesLeft = %d, want 10", left)
+		}
+
+		// The code used to enroll cannot log in again
+		code, _ := auth.TOTPCode(secret, testTOTPTime)
+		if _, err := dialTestBastionTOTP(address, carol, "carol", []string{code}); err == nil {
+			t.Error("Login with the enrollment code succeeded")
+		}
+	})
+
+	t.Run("menu", func(t *testing.T) {
+		// Without required enrollment users get the menu and enroll from it
+		bs.config.Settings.BastionTOTP.Required = false
+		client, err := dialTestBastionTOTP(address, dave, "dave", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		stdin, stdout := startTestShell(t, client)
+		readUntil(t, stdout, "totp enroll           - Set up a TOTP second factor")
+		writeString(t, stdin, "totp enroll\r")
+		enrollTestUser(t, stdin, stdout)
+		if !store.Enrolled("dave") {
+			t.Error("dave is not enrolled")
+		}
+	})
+}
+
+// enrollTestUser completes an enrollment shown on stdout and returns its
+// secret
+func enrollTestUser(t *testing.T, stdin io.Writer, stdout io.Reader) string {
+	t.Helper()
+
+	var output strings.Builder
+	readUntil(t, io.TeeReader(stdout, &output), "Verification code: ")
+	if !strings.Contains(output.String(), "█") && !strings.Contains(output.String(), "▀") {
+		t.Errorf("Enrollment shows no QR code: %q", output.String())
+	}
+	match := regexp.MustCompile(`secret manually: ([A-Z2-7]+)`).FindStringSubmatch(output.String())
+	if match == nil {
+		t.Fatalf("Enrollment shows no secret: %q", output.String())
+	}
+	if n := len(regexp.MustCompile(`  [a-z2-7]{5}-[a-z2-7]{5}\r\n`).FindAllString(output.String(), -1)); n != 10 {
+		t.Errorf("Enrollment shows %d recovery codes, want 10", n)
+	}
+
+	writeString(t, stdin, "000000\r")
+	readUntil(t, stdout, "Verification code: ")
+	code, err := auth.TOTPCode(match[1], testTOTPTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeString(t, stdin, code+"\r")
+	readUntil(t, stdout, "TOTP enrolled")
+	return match[1]
+}
+
+// startTestShell opens an interactive shell with a pty on client
+func startTestShell(t *testing.T, client *ssh.Client) (io.WriteCloser, io.Reader) {
+	t.Helper()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	return stdin, stdout
+}
+
+// dialTestBastionTOTP logs in as user with signer, answering the
+// keyboard-interactive questions with answers
+func dialTestBastionTOTP(address string, signer ssh.Signer, user string, answers []string) (*ssh.Client, error) {
+	methods := []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	if answers != nil {
+		methods = append(methods, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			if len(questions) != len(answers) {
+				return nil, fmt.Errorf("got %d questions, want %d", len(questions), len(answers))
+			}
+			return answers, nil
+		}))
+	}
+	return ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            user,
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+// newTestSigner returns a new ed25519 client key
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// authorizedKey returns the public key of signer in authorized_keys format
+func authorizedKey(signer ssh.Signer) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+}
+
+// writeTestTOTPStore loads the TOTP store of cfg from a file holding
+// content, or an empty file, checking codes at testTOTPTime
+func writeTestTOTPStore(t *testing.T, cfg *config.Config, content string) *auth.TOTPStore {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "totp.json")
+	if content != "" {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Settings.BastionTOTP.StoreFile = path
+	store, err := auth.NewTOTPStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetClock(func() time.Time { return testTOTPTime })
+	return store
+}
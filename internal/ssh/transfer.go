@@ -0,0 +1,466 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+// Transfer directions in the audit log, as seen from the client
+const (
+	directionUpload   = "upload"
+	directionDownload = "download"
+)
+
+// transferLog writes the audit lines of the files copied in one session
+type transferLog struct {
+	user     string
+	device   string
+	protocol string
+}
+
+// fileTransfer is a file being copied. The hash is only reported when the
+// data passed through in order, which is how scp and sftp clients copy.
+type fileTransfer struct {
+	name      string
+	direction string
+	size      int64
+	next      uint64
+	hash      hash.Hash
+	inOrder   bool
+}
+
+func newFileTransfer(name, direction string) *fileTransfer {
+	return &fileTransfer{name: name, direction: direction, hash: sha256.New(), inOrder: true}
+}
+
+// add records data copied at offset in direction
+func (f *fileTransfer) add(direction string, offset uint64, data []byte) {
+	if f.direction == "" {
+		f.direction = direction
+	}
+	if direction != f.direction || offset != f.next {
+		f.inOrder = false
+	}
+	f.size += int64(len(data))
+	f.next = offset + uint64(len(data))
+	if f.inOrder {
+		f.hash.Write(data)
+	}
+}
+
+// file logs a copied file. complete is false when the session ended before
+// the transfer did.
+func (l *transferLog) file(f *fileTransfer, complete bool) {
+	sum := ""
+	if f.inOrder && complete {
+		sum = hex.EncodeToString(f.hash.Sum(nil))
+	}
+	logger.Log.WithFields(map[string]interface{}{
+		"user":      l.user,
+		"device":    l.device,
+		"protocol":  l.protocol,
+		"file":      f.name,
+		"direction": f.direction,
+		"bytes":     f.size,
+		"sha256":    sum,
+		"complete":  complete,
+	}).Info("Bastion file transfer")
+}
+
+// operation logs a change to the device's file system other than a copy
+func (l *transferLog) operation(op string, paths ...string) {
+	logger.Log.WithFields(map[string]interface{}{
+		"user":      l.user,
+		"device":    l.device,
+		"protocol":  l.protocol,
+		"operation": op,
+		"file":      strings.Join(paths, " -> "),
+	}).Info("Bastion file operation")
+}
+
+// scpAudit follows the scp protocol sent by the copying side to log the
+// files of an "scp -t" (upload) or "scp -f" (download) command
+type scpAudit struct {
+	log       *transferLog
+	direction string
+	dirs      []string
+	line      []byte
+	current   *fileTransfer
+	remaining int64
+	// skipAck is set after a file's data, which the sender ends with a
+	// status byte
+	skipAck bool
+	failed  bool
+}
+
+// maxSCPLine bounds the control lines of the scp protocol
+const maxSCPLine = 4096
+
+// newSCPAudit returns an audit for command if it is the remote end of an
+// scp copy, or nil
+func newSCPAudit(log *transferLog, command string) *scpAudit {
+	fields := strings.Fields(command)
+	if len(fields) == 0 || path.Base(fields[0]) != "scp" {
+		return nil
+	}
+	direction := ""
+	for _, field := range fields[1:] {
+		if field == "--" || !strings.HasPrefix(field, "-") {
+			break
+		}
+		switch {
+		case strings.ContainsRune(field, 't'):
+			direction = directionUpload
+		case strings.ContainsRune(field, 'f'):
+			direction = directionDownload
+		}
+	}
+	if direction == "" {
+		return nil
+	}
+	log.protocol = "scp"
+	return &scpAudit{log: log, direction: direction}
+}
+
+// tap returns stdin and stdout of the device session with the stream from
+// the sending side passed through the audit
+func (a *scpAudit) tap(stdin io.Reader, stdout io.Writer) (io.Reader, io.Writer) {
+	if a.direction == directionUpload {
+		return io.TeeReader(stdin, a), stdout
+	}
+	return stdin, io.MultiWriter(stdout, a)
+}
+
+// Write parses the sending side of the protocol. It never fails, so that a
+// stream the audit does not understand is still copied.
+func (a *scpAudit) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && !a.failed {
+		switch {
+		case a.remaining > 0:
+			chunk := p
+			if int64(len(chunk)) > a.remaining {
+				chunk = chunk[:a.remaining]
+			}
+			a.current.add(a.direction, a.current.next, chunk)
+			a.remaining -= int64(len(chunk))
+			p = p[len(chunk):]
+			if a.remaining == 0 {
+				a.skipAck = true
+			}
+
+		case a.skipAck:
+			a.skipAck = false
+			a.log.file(a.current, p[0] == 0)
+			a.current = nil
+			p = p[1:]
+
+		default:
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				a.line = append(a.line, p...)
+				if len(a.line) > maxSCPLine {
+					a.failed = true
+				}
+				return n, nil
+			}
+			a.line = append(a.line, p[:i]...)
+			p = p[i+1:]
+			a.control(string(a.line))
+			a.line = a.line[:0]
+		}
+	}
+	return n, nil
+}
+
+// control handles a control line: C (file), D (directory), E (end of
+// directory), T (times) or a warning or error message
+func (a *scpAudit) control(line string) {
+	if line == "" {
+		a.failed = true
+		return
+	}
+	switch line[0] {
+	case 'C', 'D':
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			a.failed = true
+			return
+		}
+		if line[0] == 'D' {
+			a.dirs = append(a.dirs, fields[2])
+			return
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			a.failed = true
+			return
+		}
+		a.current = newFileTransfer(path.Join(append(a.dirs, fields[2])...), a.direction)
+		a.remaining = size
+		if size == 0 {
+			a.skipAck = true
+		}
+	case 'E':
+		if len(a.dirs) > 0 {
+			a.dirs = a.dirs[:len(a.dirs)-1]
+		}
+	case 'T', 1, 2:
+	default:
+		a.failed = true
+	}
+}
+
+// finish logs a file cut off by the end of the session
+func (a *scpAudit) finish() {
+	if a.current != nil {
+		a.log.file(a.current, false)
+	}
+}
+
+// SFTP packet types (draft-ietf-secsh-filexfer-02, version 3)
+const (
+	sftpOpen      = 3
+	sftpClose     = 4
+	sftpRead      = 5
+	sftpWrite     = 6
+	sftpRemove    = 13
+	sftpMkdir     = 14
+	sftpRmdir     = 15
+	sftpRename    = 18
+	sftpHandle    = 102
+	sftpData      = 103
+	sftpFlagWrite = 0x02
+	// maxSFTPPacket is above the 256 KiB that OpenSSH accepts
+	maxSFTPPacket = 1 << 20
+)
+
+// sftpAudit follows the requests and replies of an sftp session to log the
+// files read and written and the files removed or renamed
+type sftpAudit struct {
+	log *transferLog
+
+	mu sync.Mutex
+	// opens are the paths of open requests waiting for a handle
+	opens map[uint32]sftpPendingOpen
+	// reads are the read requests waiting for data
+	reads map[uint32]sftpPendingRead
+	files map[string]*fileTransfer
+}
+
+type sftpPendingOpen struct {
+	path  string
+	write bool
+}
+
+type sftpPendingRead struct {
+	handle string
+	offset uint64
+}
+
+func newSFTPAudit(log *transferLog) *sftpAudit {
+	log.protocol = "sftp"
+	return &sftpAudit{
+		log:   log,
+		opens: make(map[uint32]sftpPendingOpen),
+		reads: make(map[uint32]sftpPendingRead),
+		files: make(map[string]*fileTransfer),
+	}
+}
+
+// tap returns stdin and stdout of the device session with both directions
+// passed through the audit
+func (a *sftpAudit) tap(stdin io.Reader, stdout io.Writer) (io.Reader, io.Writer) {
+	requests := &sftpStream{handle: a.request}
+	replies := &sftpStream{handle: a.reply}
+	return io.TeeReader(stdin, requests), io.MultiWriter(stdout, replies)
+}
+
+// request handles a packet from the client
+func (a *sftpAudit) request(packet *sftpPacket) {
+	kind := packet.byte()
+	id := packet.uint32()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch kind {
+	case sftpOpen:
+		name := packet.string()
+		flags := packet.uint32()
+		if packet.ok {
+			a.opens[id] = sftpPendingOpen{path: name, write: flags&sftpFlagWrite != 0}
+		}
+	case sftpRead:
+		handle := packet.string()
+		offset := packet.uint64()
+		if packet.ok {
+			a.reads[id] = sftpPendingRead{handle: handle, offset: offset}
+		}
+	case sftpWrite:
+		handle := packet.string()
+		offset := packet.uint64()
+		data := packet.string()
+		if f := a.files[handle]; f != nil && packet.ok {
+			f.add(directionUpload, offset, []byte(data))
+		}
+	case sftpClose:
+		handle := packet.string()
+		if f := a.files[handle]; f != nil {
+			if f.direction != "" {
+				a.log.file(f, true)
+			}
+			delete(a.files, handle)
+		}
+	case sftpRemove:
+		if name := packet.string(); packet.ok {
+			a.log.operation("remove", name)
+		}
+	case sftpMkdir:
+		if name := packet.string(); packet.ok {
+			a.log.operation("mkdir", name)
+		}
+	case sftpRmdir:
+		if name := packet.string(); packet.ok {
+			a.log.operation("rmdir", name)
+		}
+	case sftpRename:
+		from := packet.string()
+		to := packet.string()
+		if packet.ok {
+			a.log.operation("rename", from, to)
+		}
+	}
+}
+
+// reply handles a packet from the device
+func (a *sftpAudit) reply(packet *sftpPacket) {
+	kind := packet.byte()
+	id := packet.uint32()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch kind {
+	case sftpHandle:
+		handle := packet.string()
+		if open, ok := a.opens[id]; ok && packet.ok {
+			f := newFileTransfer(open.path, "")
+			if open.write {
+				f.direction = directionUpload
+			}
+			a.files[handle] = f
+		}
+	case sftpData:
+		data := packet.string()
+		if read, ok := a.reads[id]; ok && packet.ok {
+			if f := a.files[read.handle]; f != nil {
+				f.add(directionDownload, read.offset, []byte(data))
+			}
+		}
+	}
+	delete(a.opens, id)
+	delete(a.reads, id)
+}
+
+// finish logs the files still open when the session ended
+func (a *sftpAudit) finish() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for handle, f := range a.files {
+		if f.direction != "" {
+			a.log.file(f, false)
+		}
+		delete(a.files, handle)
+	}
+}
+
+// sftpStream splits one direction of an sftp session into packets. Like
+// scpAudit it never fails and stops parsing on a packet it cannot frame.
+type sftpStream struct {
+	handle func(*sftpPacket)
+	buf    []byte
+	failed bool
+}
+
+func (s *sftpStream) Write(p []byte) (int, error) {
+	if s.failed {
+		return len(p), nil
+	}
+	s.buf = append(s.buf, p...)
+	rest := s.buf
+	for len(rest) >= 4 {
+		length := binary.BigEndian.Uint32(rest)
+		if length == 0 || length > maxSFTPPacket {
+			s.failed = true
+			s.buf = nil
+			return len(p), nil
+		}
+		if uint32(len(rest)-4) < length {
+			break
+		}
+		s.handle(&sftpPacket{data: rest[4 : 4+length], ok: true})
+		rest = rest[4+length:]
+	}
+	s.buf = append(s.buf[:0], rest...)
+	return len(p), nil
+}
+
+// sftpPacket decodes the fields of a packet. ok turns false once a field
+// runs past the end of the packet.
+type sftpPacket struct {
+	data []byte
+	ok   bool
+}
+
+func (p *sftpPacket) byte() byte {
+	if len(p.data) < 1 {
+		p.ok = false
+		return 0
+	}
+	b := p.data[0]
+	p.data = p.data[1:]
+	return b
+}
+
+func (p *sftpPacket) uint32() uint32 {
+	if len(p.data) < 4 {
+		p.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint32(p.data)
+	p.data = p.data[4:]
+	return v
+}
+
+func (p *sftpPacket) uint64() uint64 {
+	if len(p.data) < 8 {
+		p.ok = false
+		return 0
+	}
+	v := binary.BigEndian.Uint64(p.data)
+	p.data = p.data[8:]
+	return v
+}
+
+func (p *sftpPacket) string() string {
+	length := p.uint32()
+	if !p.ok || uint32(len(p.data)) < length {
+		p.ok = false
+		return ""
+	}
+	s := string(p.data[:length])
+	p.data = p.data[length:]
+	return s
+}
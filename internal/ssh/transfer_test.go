@@ -0,0 +1,322 @@
+package ssh
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+func TestSCPAudit(t *testing.T) {
+	hook := captureLogs(t)
+
+	audit := newSCPAudit(&transferLog{user: "alice", device: "router1"}, "scp -r -p -t -- /tmp")
+	if audit == nil {
+		t.Fatal("scp -t command not recognised")
+	}
+	stream := "T1700000000 0 1700000000 0\nD0755 0 configs\nC0644 5 a.txt\nhello\x00C0644 0 empty\n\x00E\nC0644 10 b.bin\nabc"
+	// Feed the stream in small chunks to split lines and data
+	for i := 0; i < len(stream); i += 3 {
+		_, _ = audit.Write([]byte(stream[i:min(i+3, len(stream))]))
+	}
+	audit.finish()
+
+	want := []transferEntry{
+		{"configs/a.txt", directionUpload, 5, sha256Hex("hello"), true},
+		{"configs/empty", directionUpload, 0, sha256Hex(""), true},
+		{"b.bin", directionUpload, 3, "", false},
+	}
+	assertTransfers(t, hook, "scp", want)
+
+	for _, command := range []string{"show version", "scp file host:", "scp -v"} {
+		if newSCPAudit(&transferLog{}, command) != nil {
+			t.Errorf("%q is not the remote end of an scp copy", command)
+		}
+	}
+}
+
+func TestSFTPPassthrough(t *testing.T) {
+	hook := captureLogs(t)
+	devicePort := startTestExecDevice(t, "secret")
+	_, address := startTestBastionWithConfig(t, testTransferConfig(devicePort))
+
+	client, err := sftp.NewClient(dialTestBastionAs(t, address, "admin%router1.test.local"))
+	if err != nil {
+		t.Fatalf("sftp.NewClient failed: %v", err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "image.bin")
+	content := strings.Repeat("firmware ", 10000)
+
+	f, err := client.Create(remote)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	_ = f.Close()
+
+	f, err = client.Open(remote)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil || string(data) != content {
+		t.Fatalf("download = %d bytes, %v, want the uploaded file", len(data), err)
+	}
+	if err := client.Remove(remote); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	client.Close()
+
+	assertTransfers(t, hook, "sftp", []transferEntry{
+		{remote, directionUpload, int64(len(content)), sha256Hex(content), true},
+		{remote, directionDownload, int64(len(content)), sha256Hex(content), true},
+	})
+	var removed bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Bastion file operation" && entry.Data["operation"] == "remove" && entry.Data["file"] == remote {
+			removed = true
+		}
+	}
+	if !removed {
+		t.Error("remove not logged")
+	}
+}
+
+func TestSFTPWithoutTarget(t *testing.T) {
+	devicePort := startTestExecDevice(t, "secret")
+	_, address := startTestBastionWithConfig(t, testTransferConfig(devicePort))
+
+	if _, err := sftp.NewClient(dialTestBastion(t, address)); err == nil {
+		t.Error("sftp without a device in the login name should be refused")
+	}
+}
+
+func TestSFTPExitStatus(t *testing.T) {
+	devicePort := startTestExecDevice(t, "secret")
+	cfg := testTransferConfig(devicePort)
+	cfg.Settings.Credentials["default"] = config.CredentialSettings{Username: "nosftp", Password: "secret"}
+	_, address := startTestBastionWithConfig(t, cfg)
+
+	// *ssh.Session cannot wait for the exit status of a subsystem, so the
+	// session is opened as a raw channel
+	channel, requests, err := dialTestBastionAs(t, address, "nosftp%router1.test.local").OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer channel.Close()
+
+	status := make(chan uint32, 1)
+	go func() {
+		for req := range requests {
+			var payload struct{ Status uint32 }
+			if req.Type == "exit-status" && ssh.Unmarshal(req.Payload, &payload) == nil {
+				status <- payload.Status
+			}
+		}
+	}()
+	if ok, err := channel.SendRequest("subsystem", true, ssh.Marshal(struct{ Name string }{"sftp"})); err != nil || !ok {
+		t.Fatalf("subsystem request = %v, %v", ok, err)
+	}
+	stderr, _ := io.ReadAll(channel.Stderr())
+	if !strings.Contains(string(stderr), "sftp-server: not found") {
+		t.Errorf("stderr = %q, want the device's error", stderr)
+	}
+	select {
+	case got := <-status:
+		if got != 127 {
+			t.Errorf("exit status = %d, want 127", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no exit status")
+	}
+}
+
+func TestDirectTCPIP(t *testing.T) {
+	hook := captureLogs(t)
+	devicePort := startTestExecDevice(t, "secret")
+	_, address := startTestBastionWithConfig(t, testTransferConfig(devicePort))
+	client := dialTestBastion(t, address)
+
+	for _, target := range []string{"router1", "router1.test.local", "127.0.0.1"} {
+		conn, err := client.Dial("tcp", net.JoinHostPort(target, strconv.Itoa(devicePort)))
+		if err != nil {
+			t.Errorf("forward to %s failed: %v", target, err)
+			continue
+		}
+		banner, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || !strings.HasPrefix(banner, "SSH-2.0-") {
+			t.Errorf("forward to %s read %q, %v, want the device's SSH banner", target, banner, err)
+		}
+		conn.Close()
+	}
+
+	// Only the SSH and NETCONF ports of inventory devices are reachable
+	for _, target := range []string{
+		net.JoinHostPort("router1", "1"),
+		net.JoinHostPort("127.0.0.2", strconv.Itoa(devicePort)),
+		net.JoinHostPort("example.com", "22"),
+	} {
+		if conn, err := client.Dial("tcp", target); err == nil {
+			conn.Close()
+			t.Errorf("forward to %s should be refused", target)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var opened, closed, refused int
+		for _, entry := range hook.AllEntries() {
+			switch entry.Message {
+			case "Bastion forward opened":
+				opened++
+			case "Bastion forward closed":
+				closed++
+			case "Refused bastion forward":
+				refused++
+			}
+		}
+		if opened == 3 && closed == 3 && refused == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("logged %d opened, %d closed and %d refused forwards, want 3 each", opened, closed, refused)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSCPPassthrough(t *testing.T) {
+	hook := captureLogs(t)
+	devicePort := startTestExecDevice(t, "secret")
+	_, address := startTestBastionWithConfig(t, testTransferConfig(devicePort))
+
+	session, err := dialTestBastionAs(t, address, "router1+admin").NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	dir := t.TempDir()
+	session.Stdin = strings.NewReader("C0644 5 startup.cfg\nhello\x00")
+	if err := session.Run("scp -t " + dir); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "startup.cfg"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("copied file = %q, %v, want hello", data, err)
+	}
+	assertTransfers(t, hook, "scp", []transferEntry{
+		{"startup.cfg", directionUpload, 5, sha256Hex("hello"), true},
+	})
+}
+
+// transferEntry is the expected audit line of a copied file
+type transferEntry struct {
+	file      string
+	direction string
+	bytes     int64
+	sha256    string
+	complete  bool
+}
+
+func assertTransfers(t *testing.T, hook *logtest.Hook, protocol string, want []transferEntry) {
+	t.Helper()
+
+	var got []transferEntry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message != "Bastion file transfer" || entry.Data["protocol"] != protocol {
+			continue
+		}
+		got = append(got, transferEntry{
+			file:      entry.Data["file"].(string),
+			direction: entry.Data["direction"].(string),
+			bytes:     entry.Data["bytes"].(int64),
+			sha256:    entry.Data["sha256"].(string),
+			complete:  entry.Data["complete"].(bool),
+		})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("transfers = %+v, want %+v", got, want)
+	}
+}
+
+// captureLogs records the log entries written during a test
+func captureLogs(t *testing.T) *logtest.Hook {
+	t.Helper()
+
+	hooks := logger.Log.ReplaceHooks(make(logrus.LevelHooks))
+	hook := logtest.NewLocal(logger.Log)
+	t.Cleanup(func() { logger.Log.ReplaceHooks(hooks) })
+	return hook
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func testTransferConfig(devicePort int) *config.Config {
+	return &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: devicePort},
+		},
+		Settings: config.Settings{
+			DomainSuffix: "test.local",
+			Credentials: map[string]config.CredentialSettings{
+				"default": {Username: "admin", Password: "secret"},
+			},
+		},
+	}
+}
+
+// receiveTestSCP is the sink side of scp for the test device, writing the
+// files it receives to dir
+func receiveTestSCP(channel ssh.Channel, dir string) uint32 {
+	reader := bufio.NewReader(channel)
+	_, _ = channel.Write([]byte{0})
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0
+		}
+		fields := strings.SplitN(strings.TrimSuffix(line[1:], "\n"), " ", 3)
+		if line[0] != 'C' || len(fields) != 3 {
+			return 1
+		}
+		size, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 1
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return 1
+		}
+		if err := os.WriteFile(filepath.Join(dir, fields[2]), data[:size], 0o644); err != nil {
+			return 1
+		}
+		_, _ = channel.Write([]byte{0})
+	}
+}
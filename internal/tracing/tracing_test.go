@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/tracing_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+func TestLogHook(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{})
+	log.AddHook(LogHook{})
+
+	ctx, span := Start(context.Background(), "test")
+	log.WithContext(ctx).Info("inside span")
+	End(span, errors.New("boom"))
+
+	log.Info("outside span")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d", len(lines))
+	}
+	traceID := span.SpanContext().TraceID().String()
+	if !strings.Contains(lines[0], `"trace_id":"`+traceID+`"`) {
+		t.Errorf("Expected trace_id in %s", lines[0])
+	}
+	if strings.Contains(lines[1], "trace_id") {
+		t.Errorf("Unexpected trace_id in %s", lines[1])
+	}
+
+	ended := recorder.Ended()
+	if len(ended) != 1 || ended[0].Status().Description != "boom" {
+		t.Errorf("Expected ended span with error status, got %+v", ended)
+	}
+}
+
+func TestInitFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Init(Options{Exporter: ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	_, span := Start(context.Background(), "exported")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"exported"`) {
+		t.Errorf("Span not written to trace file: %s", data)
+	}
+}
+
+func TestInitInvalid(t *testing.T) {
+	if _, err := Init(Options{Exporter: "zipkin"}); err == nil {
+		t.Error("Expected error for unknown exporter")
+	}
+	if _, err := Init(Options{Exporter: ExporterFile}); err == nil {
+		t.Error("Expected error for file exporter without a file")
+	}
+}
@@ -0,0 +1,311 @@
+package webterm
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+	sshbastion "github.com/safabayar/gateway/internal/ssh"
+)
+
+//go:embed static
+var static embed.FS
+
+// authTimeout limits how long a new WebSocket may wait before sending credentials
+const authTimeout = 60 * time.Second
+
+// Authenticator authenticates gateway users by the API token in the
+// authorization metadata of ctx, and returns the context their device logins
+// use and their name. The gateway API implements it.
+type Authenticator interface {
+	Authenticate(ctx context.Context) (context.Context, string, error)
+}
+
+// Server serves the browser terminal and bridges its WebSocket to the same
+// PTY proxy used by the SSH bastion. Terminals are opened by gateway users
+// with an API token.
+type Server struct {
+	config        *config.Config
+	authenticator Authenticator
+	upgrader      websocket.Upgrader
+	sessions      map[*terminalConn]struct{}
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	mu            sync.Mutex
+}
+
+// NewServer creates a new web terminal server whose users are authenticated
+// by authenticator
+func NewServer(cfg *config.Config, authenticator Authenticator) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		config:        cfg,
+		authenticator: authenticator,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
+		sessions: make(map[*terminalConn]struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Register adds the web terminal routes to mux.
+// The page and its terminal emulator are embedded, so the browser loads no
+// third-party code. The device list is read from the REST API at /v1/devices.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /terminal/", s.handleIndex)
+	mux.Handle("GET /terminal/static/", http.StripPrefix("/terminal/", http.FileServerFS(static)))
+	mux.HandleFunc("GET /terminal/ws", s.handleWebSocket)
+}
+
+// clientMessage is a control message sent by the browser as a text frame.
+// Terminal input is sent as binary frames.
+type clientMessage struct {
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Token is the gateway user's API token, TOTPCode the code of users
+	// with a second factor
+	Token    string `json:"token,omitempty"`
+	TOTPCode string `json:"totp_code,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Cols     int    `json:"cols"`
+	Rows     int    `json:"rows"`
+}
+
+// Client message types
+const (
+	messageAuth   = "auth"
+	messageResize = "resize"
+)
+
+// handleIndex serves the terminal page
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	data, err := static.ReadFile("static/index.html")
+	if err != nil {
+		http.Error(w, "terminal page not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(data)
+}
+
+// handleWebSocket serves GET /terminal/ws?device=<fqdn>.
+// The first message must be an auth message carrying the gateway user's
+// token, the device credentials and the initial terminal size.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	fqdn := r.URL.Query().Get("device")
+	device, deviceName, err := s.config.GetDeviceByFQDN(fqdn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Log.WithError(err).Warn("Failed to upgrade web terminal connection")
+		return
+	}
+	defer ws.Close()
+
+	_ = ws.SetReadDeadline(time.Now().Add(authTimeout))
+	var auth clientMessage
+	if err := ws.ReadJSON(&auth); err != nil || auth.Type != messageAuth {
+		s.closeWithError(ws, "expected auth message")
+		return
+	}
+	_ = ws.SetReadDeadline(time.Time{})
+
+	if auth.Token == "" {
+		s.closeWithError(ws, "a gateway user token is required")
+		return
+	}
+	userCtx, gatewayUser, err := s.authenticator.Authenticate(tokenContext(r, auth))
+	if err != nil {
+		logger.Log.WithError(err).WithField("remote", r.RemoteAddr).Warn("Refused web terminal session")
+		s.closeWithError(ws, status.Convert(err).Message())
+		return
+	}
+
+	logger.Log.WithFields(map[string]interface{}{
+		"device":       deviceName,
+		"username":     auth.Username,
+		"gateway_user": gatewayUser,
+		"remote":       r.RemoteAddr,
+	}).Info("Web terminal session started")
+
+	// The device session ends when the browser disconnects or on shutdown
+	ctx, cancel := context.WithCancel(userCtx)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+
+	conn := newTerminalConn(ws, cancel)
+	defer close(conn.done)
+
+	s.mu.Lock()
+	s.sessions[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	_, _ = conn.Write([]byte(fmt.Sprintf("Connecting to %s (%s)...\r\n", deviceName, device.Hostname)))
+
+	size := sshbastion.WindowSize{Columns: auth.Cols, Rows: auth.Rows}
+	if err := sshbastion.ProxyShell(ctx, conn, device, auth.Username, auth.Password, auth.Term, size, conn.resize); err != nil {
+		logger.Log.WithError(err).Warnf("Web terminal session to %s failed", deviceName)
+		_, _ = conn.Write([]byte(fmt.Sprintf("\r\nError: %s\r\n", err)))
+	} else {
+		_, _ = conn.Write([]byte("\r\n\r\nConnection closed.\r\n"))
+	}
+
+	logger.Log.Infof("Web terminal session to %s ended", deviceName)
+	s.closeWithError(ws, "")
+}
+
+// tokenContext returns the context of r with the token and TOTP code of msg,
+// as the HTTP API passes its headers to the gateway API
+func tokenContext(r *http.Request, msg clientMessage) context.Context {
+	ctx := r.Context()
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addrPort)})
+	}
+	md := metadata.Pairs("authorization", "Bearer "+msg.Token)
+	if msg.TOTPCode != "" {
+		md.Set("x-totp-code", msg.TOTPCode)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// Shutdown notifies open terminals and waits for them to end until ctx
+// expires, then closes the remaining WebSockets
+func (s *Server) Shutdown(ctx context.Context) error {
+	message := "\r\n*** The gateway is shutting down, this session will be closed soon ***\r\n"
+	if deadline, ok := ctx.Deadline(); ok {
+		message = fmt.Sprintf("\r\n*** The gateway is shutting down, this session will be closed in %s ***\r\n", time.Until(deadline).Round(time.Second))
+	}
+
+	s.mu.Lock()
+	sessions := make([]*terminalConn, 0, len(s.sessions))
+	for conn := range s.sessions {
+		sessions = append(sessions, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range sessions {
+		_, _ = conn.Write([]byte(message))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		s.mu.Lock()
+		for conn := range s.sessions {
+			_ = conn.ws.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// closeWithError sends a close frame with an optional reason
+func (s *Server) closeWithError(ws *websocket.Conn, reason string) {
+	code := websocket.CloseNormalClosure
+	if reason != "" {
+		code = websocket.ClosePolicyViolation
+	}
+	_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// terminalConn adapts a WebSocket to the io.ReadWriter expected by the PTY proxy.
+// Binary frames carry terminal data, text frames carry resize messages.
+type terminalConn struct {
+	ws      *websocket.Conn
+	pending []byte
+	resize  chan sshbastion.WindowSize
+	done    chan struct{}
+	closed  func()
+	writeMu sync.Mutex
+}
+
+func newTerminalConn(ws *websocket.Conn, closed func()) *terminalConn {
+	return &terminalConn{
+		ws:     ws,
+		resize: make(chan sshbastion.WindowSize),
+		done:   make(chan struct{}),
+		closed: closed,
+	}
+}
+
+// Read returns terminal input, handling control messages in between
+func (c *terminalConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		messageType, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.closed()
+			return 0, io.EOF
+		}
+
+		if messageType == websocket.BinaryMessage {
+			c.pending = data
+			continue
+		}
+
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type != messageResize {
+			continue
+		}
+		select {
+		case c.resize <- sshbastion.WindowSize{Columns: msg.Cols, Rows: msg.Rows}:
+		case <-c.done:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends terminal output as a binary frame.
+// Stdout and stderr are copied concurrently, so writes are serialized.
+func (c *terminalConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
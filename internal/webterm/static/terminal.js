@@ -0,0 +1,818 @@
+// Terminal is a small VT100/xterm emulator for the gateway web terminal.
+// It is served from the gateway itself so the page loads no third-party
+// code. It covers what device CLIs use: cursor movement, erase, scroll
+// regions, SGR colours (16, 256 and true colour), the alternate screen,
+// application cursor keys and bracketed paste.
+(function () {
+  'use strict';
+
+  const SCROLLBACK = 1000;
+
+  // xterm's 256 colour palette
+  const PALETTE = [
+    '#000000', '#cd3131', '#0dbc79', '#e5e510', '#2472c8', '#bc3fbc', '#11a8cd', '#e5e5e5',
+    '#666666', '#f14c4c', '#23d18b', '#f5f543', '#3b8eea', '#d670d6', '#29b8db', '#ffffff',
+  ];
+  const LEVELS = [0, 95, 135, 175, 215, 255];
+  for (let i = 0; i < 216; i++) {
+    PALETTE.push(rgb(LEVELS[Math.floor(i / 36)], LEVELS[Math.floor(i / 6) % 6], LEVELS[i % 6]));
+  }
+  for (let i = 0; i < 24; i++) {
+    PALETTE.push(rgb(8 + i * 10, 8 + i * 10, 8 + i * 10));
+  }
+
+  function rgb(r, g, b) {
+    return '#' + [r, g, b].map(v => v.toString(16).padStart(2, '0')).join('');
+  }
+
+  const DEFAULT_ATTR = Object.freeze({ fg: null, bg: null, bold: false, dim: false, underline: false, inverse: false });
+
+  function blankCell(attr) {
+    return { ch: ' ', attr: attr || DEFAULT_ATTR };
+  }
+
+  function blankLine(cols, attr) {
+    const line = new Array(cols);
+    for (let i = 0; i < cols; i++) {
+      line[i] = blankCell(attr);
+    }
+    return line;
+  }
+
+  // Keys sent for special keys, normal and application cursor mode
+  const KEYS = {
+    ArrowUp: ['\x1b[A', '\x1bOA'],
+    ArrowDown: ['\x1b[B', '\x1bOB'],
+    ArrowRight: ['\x1b[C', '\x1bOC'],
+    ArrowLeft: ['\x1b[D', '\x1bOD'],
+    Home: ['\x1b[H', '\x1bOH'],
+    End: ['\x1b[F', '\x1bOF'],
+    Insert: ['\x1b[2~'],
+    Delete: ['\x1b[3~'],
+    PageUp: ['\x1b[5~'],
+    PageDown: ['\x1b[6~'],
+    Enter: ['\r'],
+    Backspace: ['\x7f'],
+    Tab: ['\t'],
+    Escape: ['\x1b'],
+    F1: ['\x1bOP'], F2: ['\x1bOQ'], F3: ['\x1bOR'], F4: ['\x1bOS'],
+    F5: ['\x1b[15~'], F6: ['\x1b[17~'], F7: ['\x1b[18~'], F8: ['\x1b[19~'],
+    F9: ['\x1b[20~'], F10: ['\x1b[21~'], F11: ['\x1b[23~'], F12: ['\x1b[24~'],
+  };
+
+  class Terminal {
+    constructor(options) {
+      this.options = Object.assign({ cols: 80, rows: 24, cursorBlink: false }, options);
+      this.cols = this.options.cols;
+      this.rows = this.options.rows;
+      this.dataListeners = [];
+      this.resizeListeners = [];
+      this.decoder = new TextDecoder();
+      this.element = null;
+      this.reset();
+    }
+
+    // onData registers a listener for input typed or pasted by the user
+    onData(listener) {
+      this.dataListeners.push(listener);
+    }
+
+    // onResize registers a listener for size changes made by fit
+    onResize(listener) {
+      this.resizeListeners.push(listener);
+    }
+
+    // reset clears both screens and restores the initial modes
+    reset() {
+      this.normal = { lines: this.emptyScreen(), scrollback: [] };
+      this.alternate = null;
+      this.screen = this.normal;
+      this.x = 0;
+      this.y = 0;
+      this.attr = DEFAULT_ATTR;
+      this.saved = null;
+      this.top = 0;
+      this.bottom = this.rows - 1;
+      this.wrapPending = false;
+      this.autowrap = true;
+      this.insertMode = false;
+      this.cursorVisible = true;
+      this.applicationCursor = false;
+      this.bracketedPaste = false;
+      this.state = 'ground';
+      this.params = '';
+      this.viewOffset = 0;
+      this.decoder = new TextDecoder();
+      this.scheduleRender();
+    }
+
+    emptyScreen() {
+      const lines = [];
+      for (let i = 0; i < this.rows; i++) {
+        lines.push(blankLine(this.cols));
+      }
+      return lines;
+    }
+
+    // open attaches the terminal to a container element
+    open(parent) {
+      this.element = document.createElement('div');
+      this.element.className = 'terminal';
+      this.element.tabIndex = 0;
+      if (this.options.cursorBlink) {
+        this.element.classList.add('cursor-blink');
+      }
+      this.viewport = document.createElement('pre');
+      this.element.appendChild(this.viewport);
+      parent.appendChild(this.element);
+
+      this.measure = document.createElement('span');
+      this.measure.className = 'terminal-measure';
+      this.measure.textContent = 'W'.repeat(10);
+      this.element.appendChild(this.measure);
+
+      this.element.addEventListener('keydown', event => this.handleKey(event));
+      this.element.addEventListener('paste', event => this.handlePaste(event));
+      this.element.addEventListener('wheel', event => this.handleWheel(event), { passive: false });
+      this.element.addEventListener('focus', () => this.scheduleRender());
+      this.element.addEventListener('blur', () => this.scheduleRender());
+      this.scheduleRender();
+    }
+
+    focus() {
+      if (this.element) {
+        this.element.focus();
+      }
+    }
+
+    // fit resizes the terminal to fill its container
+    fit() {
+      if (!this.element) {
+        return;
+      }
+      const box = this.measure.getBoundingClientRect();
+      const width = box.width / 10;
+      const height = box.height;
+      if (!width || !height) {
+        return;
+      }
+      const cols = Math.max(2, Math.floor(this.element.clientWidth / width));
+      const rows = Math.max(1, Math.floor(this.element.clientHeight / height));
+      this.resize(cols, rows);
+    }
+
+    resize(cols, rows) {
+      if (cols === this.cols && rows === this.rows) {
+        return;
+      }
+      for (const screen of [this.normal, this.alternate]) {
+        if (!screen) {
+          continue;
+        }
+        // Shrinking pushes the top lines into the scrollback, growing
+        // pulls them back, like xterm
+        while (screen.lines.length > rows) {
+          if (this.y > 0 && screen === this.screen) {
+            this.y--;
+          }
+          const line = screen.lines.shift();
+          if (screen === this.normal) {
+            this.pushScrollback(line);
+          }
+        }
+        while (screen.lines.length < rows) {
+          if (screen === this.normal && screen.scrollback.length > 0) {
+            screen.lines.unshift(screen.scrollback.pop());
+            if (screen === this.screen) {
+              this.y++;
+            }
+          } else {
+            screen.lines.push(blankLine(cols));
+          }
+        }
+        for (const line of screen.lines.concat(screen.scrollback || [])) {
+          while (line.length < cols) {
+            line.push(blankCell());
+          }
+          line.length = cols;
+        }
+      }
+      this.cols = cols;
+      this.rows = rows;
+      this.top = 0;
+      this.bottom = rows - 1;
+      this.x = Math.min(this.x, cols - 1);
+      this.y = Math.min(this.y, rows - 1);
+      this.wrapPending = false;
+      this.scheduleRender();
+      for (const listener of this.resizeListeners) {
+        listener({ cols, rows });
+      }
+    }
+
+    // write feeds output from the device, as a string or UTF-8 bytes
+    write(data) {
+      const text = typeof data === 'string' ? data : this.decoder.decode(data, { stream: true });
+      for (const ch of text) {
+        this.consume(ch);
+      }
+      this.viewOffset = 0;
+      this.scheduleRender();
+    }
+
+    emit(data) {
+      for (const listener of this.dataListeners) {
+        listener(data);
+      }
+    }
+
+    consume(ch) {
+      const code = ch.codePointAt(0);
+      switch (this.state) {
+        case 'escape':
+          this.escape(ch);
+          return;
+        case 'csi':
+          if (code >= 0x40 && code <= 0x7e) {
+            this.state = 'ground';
+            this.csi(ch);
+          } else if (code >= 0x20) {
+            this.params += ch;
+          } else {
+            this.control(code);
+          }
+          return;
+        case 'osc':
+          // Titles and other OSC strings end with BEL or ST and are ignored
+          if (code === 0x07) {
+            this.state = 'ground';
+          } else if (code === 0x1b) {
+            this.state = 'osc-escape';
+          }
+          return;
+        case 'osc-escape':
+          this.state = ch === '\\' ? 'ground' : 'osc';
+          return;
+        case 'charset':
+          this.state = 'ground';
+          return;
+      }
+      if (code < 0x20 || code === 0x7f) {
+        this.control(code);
+      } else {
+        this.print(ch);
+      }
+    }
+
+    control(code) {
+      switch (code) {
+        case 0x08:
+          if (this.x > 0) {
+            this.x--;
+          }
+          this.wrapPending = false;
+          break;
+        case 0x09:
+          this.x = Math.min(this.cols - 1, (Math.floor(this.x / 8) + 1) * 8);
+          this.wrapPending = false;
+          break;
+        case 0x0a:
+        case 0x0b:
+        case 0x0c:
+          this.lineFeed();
+          break;
+        case 0x0d:
+          this.x = 0;
+          this.wrapPending = false;
+          break;
+        case 0x1b:
+          this.state = 'escape';
+          break;
+      }
+    }
+
+    print(ch) {
+      if (this.wrapPending) {
+        this.x = 0;
+        this.lineFeed();
+      }
+      const line = this.screen.lines[this.y];
+      if (this.insertMode) {
+        line.splice(this.x, 0, blankCell());
+        line.length = this.cols;
+      }
+      line[this.x] = { ch, attr: this.attr };
+      if (this.x === this.cols - 1) {
+        this.wrapPending = this.autowrap;
+      } else {
+        this.x++;
+      }
+    }
+
+    lineFeed() {
+      this.wrapPending = false;
+      if (this.y === this.bottom) {
+        this.scrollUp(1);
+      } else if (this.y < this.rows - 1) {
+        this.y++;
+      }
+    }
+
+    reverseIndex() {
+      if (this.y === this.top) {
+        this.scrollDown(1);
+      } else if (this.y > 0) {
+        this.y--;
+      }
+    }
+
+    pushScrollback(line) {
+      const scrollback = this.normal.scrollback;
+      scrollback.push(line);
+      if (scrollback.length > SCROLLBACK) {
+        scrollback.shift();
+      }
+    }
+
+    scrollUp(count) {
+      const lines = this.screen.lines;
+      for (let i = 0; i < count; i++) {
+        const line = lines.splice(this.top, 1)[0];
+        if (this.screen === this.normal && this.top === 0) {
+          this.pushScrollback(line);
+        }
+        lines.splice(this.bottom, 0, blankLine(this.cols, this.eraseAttr()));
+      }
+    }
+
+    scrollDown(count) {
+      const lines = this.screen.lines;
+      for (let i = 0; i < count; i++) {
+        lines.splice(this.bottom, 1);
+        lines.splice(this.top, 0, blankLine(this.cols, this.eraseAttr()));
+      }
+    }
+
+    // eraseAttr keeps the background colour for erased cells, as xterm does
+    eraseAttr() {
+      return this.attr.bg === null ? DEFAULT_ATTR : Object.assign({}, DEFAULT_ATTR, { bg: this.attr.bg });
+    }
+
+    erase(y, from, to) {
+      const line = this.screen.lines[y];
+      for (let x = from; x < to; x++) {
+        line[x] = blankCell(this.eraseAttr());
+      }
+    }
+
+    saveCursor() {
+      this.saved = { x: this.x, y: this.y, attr: this.attr };
+    }
+
+    restoreCursor() {
+      const saved = this.saved || { x: 0, y: 0, attr: DEFAULT_ATTR };
+      this.x = Math.min(saved.x, this.cols - 1);
+      this.y = Math.min(saved.y, this.rows - 1);
+      this.attr = saved.attr;
+      this.wrapPending = false;
+    }
+
+    escape(ch) {
+      this.state = 'ground';
+      switch (ch) {
+        case '[':
+          this.state = 'csi';
+          this.params = '';
+          break;
+        case ']':
+          this.state = 'osc';
+          break;
+        case '(':
+        case ')':
+        case '*':
+        case '+':
+          this.state = 'charset';
+          break;
+        case '7':
+          this.saveCursor();
+          break;
+        case '8':
+          this.restoreCursor();
+          break;
+        case 'D':
+          this.lineFeed();
+          break;
+        case 'E':
+          this.x = 0;
+          this.lineFeed();
+          break;
+        case 'M':
+          this.reverseIndex();
+          break;
+        case 'c':
+          this.reset();
+          break;
+      }
+    }
+
+    csi(final) {
+      const privateMode = this.params.startsWith('?');
+      const raw = privateMode ? this.params.slice(1) : this.params;
+      if (/^[<=>]/.test(raw)) {
+        return;
+      }
+      const params = raw === '' ? [] : raw.split(';').map(p => parseInt(p, 10) || 0);
+      const n = params[0] || 1;
+
+      switch (final) {
+        case 'A':
+          this.moveTo(this.x, Math.max(this.y - n, this.y >= this.top ? this.top : 0));
+          break;
+        case 'B':
+          this.moveTo(this.x, Math.min(this.y + n, this.y <= this.bottom ? this.bottom : this.rows - 1));
+          break;
+        case 'C':
+          this.moveTo(this.x + n, this.y);
+          break;
+        case 'D':
+          this.moveTo(this.x - n, this.y);
+          break;
+        case 'E':
+          this.moveTo(0, this.y + n);
+          break;
+        case 'F':
+          this.moveTo(0, this.y - n);
+          break;
+        case 'G':
+        case '`':
+          this.moveTo(n - 1, this.y);
+          break;
+        case 'd':
+          this.moveTo(this.x, n - 1);
+          break;
+        case 'H':
+        case 'f':
+          this.moveTo((params[1] || 1) - 1, n - 1);
+          break;
+        case 'J':
+          this.eraseDisplay(params[0] || 0);
+          break;
+        case 'K':
+          this.eraseLine(params[0] || 0);
+          break;
+        case 'L':
+          if (this.y >= this.top && this.y <= this.bottom) {
+            const top = this.top;
+            this.top = this.y;
+            this.scrollDown(Math.min(n, this.bottom - this.y + 1));
+            this.top = top;
+            this.x = 0;
+          }
+          break;
+        case 'M':
+          if (this.y >= this.top && this.y <= this.bottom) {
+            const top = this.top;
+            const screen = this.screen;
+            this.top = this.y;
+            // Deleted lines never go to the scrollback
+            this.screen = { lines: screen.lines };
+            this.scrollUp(Math.min(n, this.bottom - this.y + 1));
+            this.screen = screen;
+            this.top = top;
+            this.x = 0;
+          }
+          break;
+        case 'P': {
+          const line = this.screen.lines[this.y];
+          line.splice(this.x, Math.min(n, this.cols - this.x));
+          while (line.length < this.cols) {
+            line.push(blankCell(this.eraseAttr()));
+          }
+          break;
+        }
+        case '@': {
+          const line = this.screen.lines[this.y];
+          for (let i = 0; i < Math.min(n, this.cols - this.x); i++) {
+            line.splice(this.x, 0, blankCell(this.eraseAttr()));
+          }
+          line.length = this.cols;
+          break;
+        }
+        case 'X':
+          this.erase(this.y, this.x, Math.min(this.cols, this.x + n));
+          break;
+        case 'S':
+          this.scrollUp(n);
+          break;
+        case 'T':
+          this.scrollDown(n);
+          break;
+        case 'm':
+          this.sgr(params.length ? params : [0]);
+          break;
+        case 'r': {
+          const top = (params[0] || 1) - 1;
+          const bottom = (params[1] || this.rows) - 1;
+          if (top < bottom && bottom < this.rows) {
+            this.top = top;
+            this.bottom = bottom;
+            this.moveTo(0, 0);
+          }
+          break;
+        }
+        case 's':
+          this.saveCursor();
+          break;
+        case 'u':
+          this.restoreCursor();
+          break;
+        case 'h':
+        case 'l':
+          for (const mode of params) {
+            this.setMode(privateMode, mode, final === 'h');
+          }
+          break;
+        case 'n':
+          if (params[0] === 5) {
+            this.emit('\x1b[0n');
+          } else if (params[0] === 6) {
+            this.emit('\x1b[' + (this.y + 1) + ';' + (this.x + 1) + 'R');
+          }
+          break;
+        case 'c':
+          if (!privateMode) {
+            this.emit('\x1b[?1;2c');
+          }
+          break;
+      }
+    }
+
+    moveTo(x, y) {
+      this.x = Math.max(0, Math.min(this.cols - 1, x));
+      this.y = Math.max(0, Math.min(this.rows - 1, y));
+      this.wrapPending = false;
+    }
+
+    eraseDisplay(mode) {
+      switch (mode) {
+        case 0:
+          this.erase(this.y, this.x, this.cols);
+          for (let y = this.y + 1; y < this.rows; y++) {
+            this.erase(y, 0, this.cols);
+          }
+          break;
+        case 1:
+          this.erase(this.y, 0, this.x + 1);
+          for (let y = 0; y < this.y; y++) {
+            this.erase(y, 0, this.cols);
+          }
+          break;
+        case 2:
+          for (let y = 0; y < this.rows; y++) {
+            this.erase(y, 0, this.cols);
+          }
+          break;
+        case 3:
+          this.normal.scrollback = [];
+          break;
+      }
+    }
+
+    eraseLine(mode) {
+      switch (mode) {
+        case 0:
+          this.erase(this.y, this.x, this.cols);
+          break;
+        case 1:
+          this.erase(this.y, 0, this.x + 1);
+          break;
+        case 2:
+          this.erase(this.y, 0, this.cols);
+          break;
+      }
+    }
+
+    setMode(privateMode, mode, on) {
+      if (!privateMode) {
+        if (mode === 4) {
+          this.insertMode = on;
+        }
+        return;
+      }
+      switch (mode) {
+        case 1:
+          this.applicationCursor = on;
+          break;
+        case 7:
+          this.autowrap = on;
+          break;
+        case 25:
+          this.cursorVisible = on;
+          break;
+        case 47:
+        case 1047:
+        case 1049:
+          if (on && this.screen === this.normal) {
+            if (mode === 1049) {
+              this.saveCursor();
+            }
+            this.alternate = { lines: this.emptyScreen() };
+            this.screen = this.alternate;
+          } else if (!on && this.screen !== this.normal) {
+            this.screen = this.normal;
+            this.alternate = null;
+            if (mode === 1049) {
+              this.restoreCursor();
+            }
+          }
+          break;
+        case 2004:
+          this.bracketedPaste = on;
+          break;
+      }
+    }
+
+    sgr(params) {
+      const attr = Object.assign({}, this.attr);
+      for (let i = 0; i < params.length; i++) {
+        const p = params[i];
+        if (p === 0) {
+          Object.assign(attr, DEFAULT_ATTR);
+        } else if (p === 1) {
+          attr.bold = true;
+        } else if (p === 2) {
+          attr.dim = true;
+        } else if (p === 4) {
+          attr.underline = true;
+        } else if (p === 7) {
+          attr.inverse = true;
+        } else if (p === 22) {
+          attr.bold = false;
+          attr.dim = false;
+        } else if (p === 24) {
+          attr.underline = false;
+        } else if (p === 27) {
+          attr.inverse = false;
+        } else if (p >= 30 && p <= 37) {
+          attr.fg = PALETTE[p - 30];
+        } else if (p >= 40 && p <= 47) {
+          attr.bg = PALETTE[p - 40];
+        } else if (p >= 90 && p <= 97) {
+          attr.fg = PALETTE[p - 90 + 8];
+        } else if (p >= 100 && p <= 107) {
+          attr.bg = PALETTE[p - 100 + 8];
+        } else if (p === 39) {
+          attr.fg = null;
+        } else if (p === 49) {
+          attr.bg = null;
+        } else if (p === 38 || p === 48) {
+          let color = null;
+          if (params[i + 1] === 5) {
+            color = PALETTE[params[i + 2]] || null;
+            i += 2;
+          } else if (params[i + 1] === 2) {
+            color = rgb(params[i + 2] & 255, params[i + 3] & 255, params[i + 4] & 255);
+            i += 4;
+          }
+          attr[p === 38 ? 'fg' : 'bg'] = color;
+        }
+      }
+      this.attr = Object.freeze(attr);
+    }
+
+    handleKey(event) {
+      // Leave copy and paste shortcuts to the browser
+      if ((event.ctrlKey || event.metaKey) && event.shiftKey && (event.key === 'C' || event.key === 'V')) {
+        return;
+      }
+      if (event.metaKey) {
+        return;
+      }
+      let data = null;
+      const keys = KEYS[event.key];
+      if (keys) {
+        data = this.applicationCursor && keys[1] ? keys[1] : keys[0];
+        if (event.key === 'Tab' && event.shiftKey) {
+          data = '\x1b[Z';
+        }
+      } else if (event.ctrlKey && !event.getModifierState('AltGraph') && event.key.length === 1) {
+        const code = event.key.toUpperCase().charCodeAt(0);
+        if (code >= 0x40 && code <= 0x5f) {
+          data = String.fromCharCode(code - 0x40);
+        } else if (event.key === ' ') {
+          data = '\x00';
+        }
+      } else if (event.key.length === 1 || [...event.key].length === 1) {
+        data = event.key;
+      }
+      if (data === null) {
+        return;
+      }
+      if (event.altKey && !event.getModifierState('AltGraph')) {
+        data = '\x1b' + data;
+      }
+      event.preventDefault();
+      this.viewOffset = 0;
+      this.scheduleRender();
+      this.emit(data);
+    }
+
+    handlePaste(event) {
+      event.preventDefault();
+      const text = event.clipboardData.getData('text/plain').replace(/\r?\n/g, '\r');
+      this.emit(this.bracketedPaste ? '\x1b[200~' + text + '\x1b[201~' : text);
+    }
+
+    handleWheel(event) {
+      if (this.screen !== this.normal) {
+        return;
+      }
+      event.preventDefault();
+      const step = event.deltaY < 0 ? 3 : -3;
+      this.viewOffset = Math.max(0, Math.min(this.normal.scrollback.length, this.viewOffset + step));
+      this.scheduleRender();
+    }
+
+    scheduleRender() {
+      if (!this.element || this.renderPending) {
+        return;
+      }
+      this.renderPending = true;
+      requestAnimationFrame(() => {
+        this.renderPending = false;
+        this.render();
+      });
+    }
+
+    render() {
+      const scrollback = this.screen === this.normal ? this.normal.scrollback : [];
+      const start = scrollback.length - this.viewOffset;
+      const all = scrollback.concat(this.screen.lines);
+      const showCursor = this.cursorVisible && this.viewOffset === 0 && document.activeElement === this.element;
+      const html = [];
+      for (let row = 0; row < this.rows; row++) {
+        const line = all[start + row];
+        const cursorX = showCursor && row === this.y ? this.x : -1;
+        html.push(line ? renderLine(line, cursorX) : '');
+      }
+      this.viewport.innerHTML = html.join('\n');
+    }
+  }
+
+  function renderLine(line, cursorX) {
+    let html = '';
+    let run = '';
+    let style = null;
+    for (let x = 0; x < line.length; x++) {
+      const cell = line[x];
+      const cellStyle = styleOf(cell.attr, x === cursorX);
+      if (cellStyle !== style) {
+        html += wrap(run, style);
+        run = '';
+        style = cellStyle;
+      }
+      run += cell.ch;
+    }
+    return html + wrap(run, style);
+  }
+
+  function styleOf(attr, cursor) {
+    let fg = attr.fg;
+    let bg = attr.bg;
+    if (attr.inverse !== cursor) {
+      [fg, bg] = [bg || 'var(--terminal-bg)', fg || 'var(--terminal-fg)'];
+    }
+    let style = '';
+    if (fg) {
+      style += 'color:' + fg + ';';
+    }
+    if (bg) {
+      style += 'background:' + bg + ';';
+    }
+    if (attr.bold) {
+      style += 'font-weight:bold;';
+    }
+    if (attr.dim) {
+      style += 'opacity:0.6;';
+    }
+    if (attr.underline) {
+      style += 'text-decoration:underline;';
+    }
+    return (cursor ? '!' : '') + style;
+  }
+
+  function wrap(text, style) {
+    if (!text) {
+      return '';
+    }
+    const escaped = text.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+    if (!style) {
+      return escaped;
+    }
+    const cursor = style.startsWith('!');
+    const css = cursor ? style.slice(1) : style;
+    return '<span' + (cursor ? ' class="cursor"' : '') + (css ? ' style="' + css + '"' : '') + '>' + escaped + '</span>';
+  }
+
+  window.Terminal = Terminal;
+})();
@@ -0,0 +1,346 @@
+package webterm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/safabayar/gateway/internal/config"
+	"github.com/safabayar/gateway/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Initialize logger for tests
+	logger.InitLogger("/tmp/webterm_test.log", "debug")
+	os.Exit(m.Run())
+}
+
+func TestIndex(t *testing.T) {
+	server := newTestServer(t, 22)
+
+	resp, err := http.Get(server.URL + "/terminal/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "/terminal/static/terminal.js") {
+		t.Errorf("Unexpected index response: %d %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), "https://") {
+		t.Error("Index page should not load remote assets")
+	}
+
+	for _, asset := range []string{"terminal.js", "terminal.css"} {
+		resp, err := http.Get(server.URL + "/terminal/static/" + asset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d", asset, resp.StatusCode)
+		}
+	}
+}
+
+func TestWebSocket_UnknownDevice(t *testing.T) {
+	server := newTestServer(t, 22)
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(server, "missing.test.local"), nil)
+	if err == nil {
+		t.Fatal("Expected dial to fail for unknown device")
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", resp)
+	}
+}
+
+func TestWebSocket_Session(t *testing.T) {
+	port := startTestPtyServer(t, "secret")
+	server := newTestServer(t, port)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL(server, "router1.test.local"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(clientMessage{Type: messageAuth, Token: testToken, Username: "admin", Password: "secret", Cols: 90, Rows: 30}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, ws, "pty 90x30")
+
+	if err := ws.WriteJSON(clientMessage{Type: messageResize, Cols: 120, Rows: 40}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, ws, "resize 120x40")
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, ws, "echo:hello")
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("exit")); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, ws, "Connection closed.")
+}
+
+func TestWebSocket_AuthFailure(t *testing.T) {
+	port := startTestPtyServer(t, "secret")
+	server := newTestServer(t, port)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL(server, "router1"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(clientMessage{Type: messageAuth, Token: testToken, Username: "admin", Password: "wrong"}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, ws, "failed to connect to device")
+}
+
+func TestWebSocket_Token(t *testing.T) {
+	port := startTestPtyServer(t, "secret")
+	server := newTestServer(t, port)
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"no token", "", "a gateway user token is required"},
+		{"invalid token", "wrong", "invalid API token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, _, err := websocket.DefaultDialer.Dial(wsURL(server, "router1"), nil)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer ws.Close()
+
+			if err := ws.WriteJSON(clientMessage{Type: messageAuth, Token: tt.token, Username: "admin", Password: "secret"}); err != nil {
+				t.Fatal(err)
+			}
+			_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = ws.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Text != tt.want {
+				t.Errorf("got %v, want close with %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	port := startTestPtyServer(t, "secret")
+	terminal, server := newTestTerminal(t, port)
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL(server, "router1"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(clientMessage{Type: messageAuth, Token: testToken, Username: "admin", Password: "secret", Cols: 80, Rows: 24}); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, ws, "pty 80x24")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- terminal.Shutdown(ctx) }()
+
+	readUntil(t, ws, "The gateway is shutting down")
+
+	select {
+	case err := <-shutdownErr:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the deadline")
+	}
+}
+
+func newTestServer(t *testing.T, sshPort int) *httptest.Server {
+	t.Helper()
+
+	_, server := newTestTerminal(t, sshPort)
+	return server
+}
+
+func newTestTerminal(t *testing.T, sshPort int) (*Server, *httptest.Server) {
+	t.Helper()
+
+	cfg := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"router1": {Hostname: "127.0.0.1", SSHPort: sshPort},
+		},
+		Settings: config.Settings{DomainSuffix: "test.local"},
+	}
+
+	terminal := NewServer(cfg, testAuthenticator{})
+	mux := http.NewServeMux()
+	terminal.Register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return terminal, server
+}
+
+// testToken is the API token testAuthenticator accepts
+const testToken = "alice-token"
+
+// testAuthenticator accepts testToken for the gateway user alice
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(ctx context.Context) (context.Context, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) == 0 || values[0] != "Bearer "+testToken {
+		return nil, "", status.Error(codes.Unauthenticated, "invalid API token")
+	}
+	return ctx, "alice", nil
+}
+
+func wsURL(server *httptest.Server, device string) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/terminal/ws?device=" + device
+}
+
+// readUntil reads terminal output until it contains want
+func readUntil(t *testing.T, ws *websocket.Conn, want string) {
+	t.Helper()
+
+	var output strings.Builder
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !strings.Contains(output.String(), want) {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("Waiting for %q, got %q: %v", want, output.String(), err)
+		}
+		output.Write(data)
+	}
+}
+
+// startTestPtyServer starts an SSH server that reports PTY sizes and echoes input
+func startTestPtyServer(t *testing.T, password string) int {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestPtyConn(conn, serverConfig)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serveTestPtyConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				switch req.Type {
+				case "pty-req":
+					var pty struct {
+						Term                 string
+						Columns, Rows, Width uint32
+						Height               uint32
+						Modes                string
+					}
+					_ = ssh.Unmarshal(req.Payload, &pty)
+					_ = req.Reply(true, nil)
+					_, _ = fmt.Fprintf(channel, "pty %dx%d\r\n", pty.Columns, pty.Rows)
+				case "window-change":
+					var size struct{ Columns, Rows, Width, Height uint32 }
+					_ = ssh.Unmarshal(req.Payload, &size)
+					_, _ = fmt.Fprintf(channel, "resize %dx%d\r\n", size.Columns, size.Rows)
+				case "shell":
+					_ = req.Reply(true, nil)
+					go echoShell(channel)
+				default:
+					if req.WantReply {
+						_ = req.Reply(false, nil)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// echoShell echoes input until it reads "exit"
+func echoShell(channel ssh.Channel) {
+	defer channel.Close()
+	buf := make([]byte, 1024)
+	for {
+		n, err := channel.Read(buf)
+		if err != nil {
+			return
+		}
+		input := string(buf[:n])
+		if input == "exit" {
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		}
+		_, _ = fmt.Fprintf(channel, "echo:%s\r\n", input)
+	}
+}
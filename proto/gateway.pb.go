@@ -20,6 +20,82 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+// Classification of command execution failures
+type ErrorCategory int32
+
+const (
+	// No error
+	ErrorCategory_ERROR_CATEGORY_UNSPECIFIED ErrorCategory = 0
+	// The device could not be reached
+	ErrorCategory_ERROR_CATEGORY_CONNECT_FAILED ErrorCategory = 1
+	// The device rejected the supplied credentials
+	ErrorCategory_ERROR_CATEGORY_AUTH_FAILED ErrorCategory = 2
+	// The device did not answer in time
+	ErrorCategory_ERROR_CATEGORY_TIMEOUT ErrorCategory = 3
+	// The command ran but the device reported a failure
+	ErrorCategory_ERROR_CATEGORY_REMOTE_ERROR ErrorCategory = 4
+)
+
+var ErrorCategory_name = map[int32]string{
+	0: "ERROR_CATEGORY_UNSPECIFIED",
+	1: "ERROR_CATEGORY_CONNECT_FAILED",
+	2: "ERROR_CATEGORY_AUTH_FAILED",
+	3: "ERROR_CATEGORY_TIMEOUT",
+	4: "ERROR_CATEGORY_REMOTE_ERROR",
+}
+
+var ErrorCategory_value = map[string]int32{
+	"ERROR_CATEGORY_UNSPECIFIED":    0,
+	"ERROR_CATEGORY_CONNECT_FAILED": 1,
+	"ERROR_CATEGORY_AUTH_FAILED":    2,
+	"ERROR_CATEGORY_TIMEOUT":        3,
+	"ERROR_CATEGORY_REMOTE_ERROR":   4,
+}
+
+func (x ErrorCategory) String() string {
+	return proto.EnumName(ErrorCategory_name, int32(x))
+}
+
+func (ErrorCategory) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{0}
+}
+
+// How a gateway user proves who they are for a certificate request
+type CertificateAuthMethod int32
+
+const (
+	// No method, IssueUserCertificate with a password needs no request
+	CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_UNSPECIFIED CertificateAuthMethod = 0
+	// Signature of the challenge with a key bound to the user
+	CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_KEY CertificateAuthMethod = 1
+	// OAuth device flow with the configured identity provider
+	CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_OIDC CertificateAuthMethod = 2
+	// Approval by one of the approvers of user_ca
+	CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_APPROVAL CertificateAuthMethod = 3
+)
+
+var CertificateAuthMethod_name = map[int32]string{
+	0: "CERTIFICATE_AUTH_METHOD_UNSPECIFIED",
+	1: "CERTIFICATE_AUTH_METHOD_KEY",
+	2: "CERTIFICATE_AUTH_METHOD_OIDC",
+	3: "CERTIFICATE_AUTH_METHOD_APPROVAL",
+}
+
+var CertificateAuthMethod_value = map[string]int32{
+	"CERTIFICATE_AUTH_METHOD_UNSPECIFIED": 0,
+	"CERTIFICATE_AUTH_METHOD_KEY":         1,
+	"CERTIFICATE_AUTH_METHOD_OIDC":        2,
+	"CERTIFICATE_AUTH_METHOD_APPROVAL":    3,
+}
+
+func (x CertificateAuthMethod) String() string {
+	return proto.EnumName(CertificateAuthMethod_name, int32(x))
+}
+
+func (CertificateAuthMethod) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{1}
+}
+
 // Request message for command execution
 type CommandRequest struct {
 	// FQDN of the target device (e.g., router1.myCustomer.safabayar.net)
@@ -31,7 +107,9 @@ type CommandRequest struct {
 	// Command to execute
 	Command string `protobuf:"bytes,4,opt,name=command,proto3" json:"command,omitempty"`
 	// Protocol to use (ssh, telnet, netconf)
-	Protocol             string   `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Protocol string `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	// Parse the output with the template for the device platform and command
+	Parse                bool     `protobuf:"varint,6,opt,name=parse,proto3" json:"parse,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -97,16 +175,35 @@ func (m *CommandRequest) GetProtocol() string {
 	return ""
 }
 
+func (m *CommandRequest) GetParse() bool {
+	if m != nil {
+		return m.Parse
+	}
+	return false
+}
+
 // Response message for command execution
 type CommandResponse struct {
-	// Command output
+	// Command output (same as stdout, kept for existing clients)
 	Output string `protobuf:"bytes,1,opt,name=output,proto3" json:"output,omitempty"`
 	// Error message if any
 	Error string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
-	// Exit code
+	// Exit status reported by the device, -1 if none was reported
 	ExitCode int32 `protobuf:"varint,3,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
 	// Session ID for tracking
-	SessionId            string   `protobuf:"bytes,4,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	SessionId string `protobuf:"bytes,4,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Standard output of the command
+	Stdout string `protobuf:"bytes,5,opt,name=stdout,proto3" json:"stdout,omitempty"`
+	// Standard error of the command (SSH only)
+	Stderr string `protobuf:"bytes,6,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	// Category of the failure when error is set
+	ErrorCategory ErrorCategory `protobuf:"varint,7,opt,name=error_category,json=errorCategory,proto3,enum=gateway.ErrorCategory" json:"error_category,omitempty"`
+	// Time spent talking to the device in milliseconds
+	BackendDurationMs int64 `protobuf:"varint,8,opt,name=backend_duration_ms,json=backendDurationMs,proto3" json:"backend_duration_ms,omitempty"`
+	// Parsed output as a JSON array of objects (when parse was requested)
+	ParsedOutput string `protobuf:"bytes,9,opt,name=parsed_output,json=parsedOutput,proto3" json:"parsed_output,omitempty"`
+	// Reason the output could not be parsed (when parse was requested)
+	ParseError           string   `protobuf:"bytes,10,opt,name=parse_error,json=parseError,proto3" json:"parse_error,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -165,9 +262,1792 @@ func (m *CommandResponse) GetSessionId() string {
 	return ""
 }
 
+func (m *CommandResponse) GetStdout() string {
+	if m != nil {
+		return m.Stdout
+	}
+	return ""
+}
+
+func (m *CommandResponse) GetStderr() string {
+	if m != nil {
+		return m.Stderr
+	}
+	return ""
+}
+
+func (m *CommandResponse) GetErrorCategory() ErrorCategory {
+	if m != nil {
+		return m.ErrorCategory
+	}
+	return ErrorCategory_ERROR_CATEGORY_UNSPECIFIED
+}
+
+func (m *CommandResponse) GetBackendDurationMs() int64 {
+	if m != nil {
+		return m.BackendDurationMs
+	}
+	return 0
+}
+
+func (m *CommandResponse) GetParsedOutput() string {
+	if m != nil {
+		return m.ParsedOutput
+	}
+	return ""
+}
+
+func (m *CommandResponse) GetParseError() string {
+	if m != nil {
+		return m.ParseError
+	}
+	return ""
+}
+
+// Request message for template testing
+type ParseRequest struct {
+	// Platform used to select a stored template (e.g., nokia_srl)
+	Platform string `protobuf:"bytes,1,opt,name=platform,proto3" json:"platform,omitempty"`
+	// Command used to select a stored template (e.g., show version)
+	Command string `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	// Inline template text, used instead of a stored template when set
+	Template string `protobuf:"bytes,3,opt,name=template,proto3" json:"template,omitempty"`
+	// Raw command output to parse
+	Text                 string   `protobuf:"bytes,4,opt,name=text,proto3" json:"text,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ParseRequest) Reset()         { *m = ParseRequest{} }
+func (m *ParseRequest) String() string { return proto.CompactTextString(m) }
+func (*ParseRequest) ProtoMessage()    {}
+func (*ParseRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{2}
+}
+
+func (m *ParseRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ParseRequest.Unmarshal(m, b)
+}
+func (m *ParseRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ParseRequest.Marshal(b, m, deterministic)
+}
+func (m *ParseRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ParseRequest.Merge(m, src)
+}
+func (m *ParseRequest) XXX_Size() int {
+	return xxx_messageInfo_ParseRequest.Size(m)
+}
+func (m *ParseRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ParseRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ParseRequest proto.InternalMessageInfo
+
+func (m *ParseRequest) GetPlatform() string {
+	if m != nil {
+		return m.Platform
+	}
+	return ""
+}
+
+func (m *ParseRequest) GetCommand() string {
+	if m != nil {
+		return m.Command
+	}
+	return ""
+}
+
+func (m *ParseRequest) GetTemplate() string {
+	if m != nil {
+		return m.Template
+	}
+	return ""
+}
+
+func (m *ParseRequest) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+// Response message for template testing
+type ParseResponse struct {
+	// Parsed output as a JSON array of objects
+	ParsedOutput string `protobuf:"bytes,1,opt,name=parsed_output,json=parsedOutput,proto3" json:"parsed_output,omitempty"`
+	// Value names defined by the template, in declaration order
+	Header []string `protobuf:"bytes,2,rep,name=header,proto3" json:"header,omitempty"`
+	// Name of the stored template that was used, empty for inline templates
+	TemplateName         string   `protobuf:"bytes,3,opt,name=template_name,json=templateName,proto3" json:"template_name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ParseResponse) Reset()         { *m = ParseResponse{} }
+func (m *ParseResponse) String() string { return proto.CompactTextString(m) }
+func (*ParseResponse) ProtoMessage()    {}
+func (*ParseResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{3}
+}
+
+func (m *ParseResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ParseResponse.Unmarshal(m, b)
+}
+func (m *ParseResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ParseResponse.Marshal(b, m, deterministic)
+}
+func (m *ParseResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ParseResponse.Merge(m, src)
+}
+func (m *ParseResponse) XXX_Size() int {
+	return xxx_messageInfo_ParseResponse.Size(m)
+}
+func (m *ParseResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ParseResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ParseResponse proto.InternalMessageInfo
+
+func (m *ParseResponse) GetParsedOutput() string {
+	if m != nil {
+		return m.ParsedOutput
+	}
+	return ""
+}
+
+func (m *ParseResponse) GetHeader() []string {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
+func (m *ParseResponse) GetTemplateName() string {
+	if m != nil {
+		return m.TemplateName
+	}
+	return ""
+}
+
+// Request message for listing devices
+type ListDevicesRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListDevicesRequest) Reset()         { *m = ListDevicesRequest{} }
+func (m *ListDevicesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListDevicesRequest) ProtoMessage()    {}
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{4}
+}
+
+func (m *ListDevicesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListDevicesRequest.Unmarshal(m, b)
+}
+func (m *ListDevicesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListDevicesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListDevicesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListDevicesRequest.Merge(m, src)
+}
+func (m *ListDevicesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListDevicesRequest.Size(m)
+}
+func (m *ListDevicesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListDevicesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListDevicesRequest proto.InternalMessageInfo
+
+// Device reachable through the gateway
+type Device struct {
+	// Device name from the inventory (e.g., srl1)
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// FQDN used to address the device (e.g., srl1.safabayar.net)
+	Fqdn string `protobuf:"bytes,2,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	// Backend hostname or IP address
+	Hostname string `protobuf:"bytes,3,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	// Free-form description
+	Description string `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	// Physical or logical location
+	Location string `protobuf:"bytes,5,opt,name=location,proto3" json:"location,omitempty"`
+	// Platform used to select output templates
+	Platform string `protobuf:"bytes,6,opt,name=platform,proto3" json:"platform,omitempty"`
+	// Protocols with a configured port (ssh, telnet, netconf, gnmi)
+	Protocols            []string `protobuf:"bytes,7,rep,name=protocols,proto3" json:"protocols,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Device) Reset()         { *m = Device{} }
+func (m *Device) String() string { return proto.CompactTextString(m) }
+func (*Device) ProtoMessage()    {}
+func (*Device) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{5}
+}
+
+func (m *Device) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Device.Unmarshal(m, b)
+}
+func (m *Device) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Device.Marshal(b, m, deterministic)
+}
+func (m *Device) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Device.Merge(m, src)
+}
+func (m *Device) XXX_Size() int {
+	return xxx_messageInfo_Device.Size(m)
+}
+func (m *Device) XXX_DiscardUnknown() {
+	xxx_messageInfo_Device.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Device proto.InternalMessageInfo
+
+func (m *Device) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Device) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+func (m *Device) GetHostname() string {
+	if m != nil {
+		return m.Hostname
+	}
+	return ""
+}
+
+func (m *Device) GetDescription() string {
+	if m != nil {
+		return m.Description
+	}
+	return ""
+}
+
+func (m *Device) GetLocation() string {
+	if m != nil {
+		return m.Location
+	}
+	return ""
+}
+
+func (m *Device) GetPlatform() string {
+	if m != nil {
+		return m.Platform
+	}
+	return ""
+}
+
+func (m *Device) GetProtocols() []string {
+	if m != nil {
+		return m.Protocols
+	}
+	return nil
+}
+
+// Response message for listing devices
+type ListDevicesResponse struct {
+	// Devices sorted by name
+	Devices              []*Device `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *ListDevicesResponse) Reset()         { *m = ListDevicesResponse{} }
+func (m *ListDevicesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListDevicesResponse) ProtoMessage()    {}
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{6}
+}
+
+func (m *ListDevicesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListDevicesResponse.Unmarshal(m, b)
+}
+func (m *ListDevicesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListDevicesResponse.Marshal(b, m, deterministic)
+}
+func (m *ListDevicesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListDevicesResponse.Merge(m, src)
+}
+func (m *ListDevicesResponse) XXX_Size() int {
+	return xxx_messageInfo_ListDevicesResponse.Size(m)
+}
+func (m *ListDevicesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListDevicesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListDevicesResponse proto.InternalMessageInfo
+
+func (m *ListDevicesResponse) GetDevices() []*Device {
+	if m != nil {
+		return m.Devices
+	}
+	return nil
+}
+
+// Request message for NETCONF operations
+type NetconfRequest struct {
+	// FQDN of the target device
+	Fqdn string `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	// Username for authentication
+	Username string `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	// Password for authentication
+	Password string `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	// Operations run in order over one NETCONF session. Execution stops at the
+	// first failed operation; uncommitted candidate changes made by the request
+	// are then discarded and the locks it took are released.
+	Operations           []*NetconfOperation `protobuf:"bytes,4,rep,name=operations,proto3" json:"operations,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *NetconfRequest) Reset()         { *m = NetconfRequest{} }
+func (m *NetconfRequest) String() string { return proto.CompactTextString(m) }
+func (*NetconfRequest) ProtoMessage()    {}
+func (*NetconfRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{7}
+}
+
+func (m *NetconfRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfRequest.Unmarshal(m, b)
+}
+func (m *NetconfRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfRequest.Marshal(b, m, deterministic)
+}
+func (m *NetconfRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfRequest.Merge(m, src)
+}
+func (m *NetconfRequest) XXX_Size() int {
+	return xxx_messageInfo_NetconfRequest.Size(m)
+}
+func (m *NetconfRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfRequest proto.InternalMessageInfo
+
+func (m *NetconfRequest) GetFqdn() string {
+	if m != nil {
+		return m.Fqdn
+	}
+	return ""
+}
+
+func (m *NetconfRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *NetconfRequest) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *NetconfRequest) GetOperations() []*NetconfOperation {
+	if m != nil {
+		return m.Operations
+	}
+	return nil
+}
+
+// A single NETCONF operation
+type NetconfOperation struct {
+	// Types that are valid to be assigned to Operation:
+	//	*NetconfOperation_Get
+	//	*NetconfOperation_GetConfig
+	//	*NetconfOperation_EditConfig
+	//	*NetconfOperation_Lock
+	//	*NetconfOperation_Unlock
+	//	*NetconfOperation_Commit
+	//	*NetconfOperation_CancelCommit
+	//	*NetconfOperation_Validate
+	//	*NetconfOperation_DiscardChanges
+	Operation            isNetconfOperation_Operation `protobuf_oneof:"operation"`
+	XXX_NoUnkeyedLiteral struct{}                     `json:"-"`
+	XXX_unrecognized     []byte                       `json:"-"`
+	XXX_sizecache        int32                        `json:"-"`
+}
+
+func (m *NetconfOperation) Reset()         { *m = NetconfOperation{} }
+func (m *NetconfOperation) String() string { return proto.CompactTextString(m) }
+func (*NetconfOperation) ProtoMessage()    {}
+func (*NetconfOperation) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{8}
+}
+
+func (m *NetconfOperation) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfOperation.Unmarshal(m, b)
+}
+func (m *NetconfOperation) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfOperation.Marshal(b, m, deterministic)
+}
+func (m *NetconfOperation) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfOperation.Merge(m, src)
+}
+func (m *NetconfOperation) XXX_Size() int {
+	return xxx_messageInfo_NetconfOperation.Size(m)
+}
+func (m *NetconfOperation) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfOperation.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfOperation proto.InternalMessageInfo
+
+type isNetconfOperation_Operation interface {
+	isNetconfOperation_Operation()
+}
+
+type NetconfOperation_Get struct {
+	Get *NetconfGet `protobuf:"bytes,1,opt,name=get,proto3,oneof"`
+}
+
+type NetconfOperation_GetConfig struct {
+	GetConfig *NetconfGetConfig `protobuf:"bytes,2,opt,name=get_config,json=getConfig,proto3,oneof"`
+}
+
+type NetconfOperation_EditConfig struct {
+	EditConfig *NetconfEditConfig `protobuf:"bytes,3,opt,name=edit_config,json=editConfig,proto3,oneof"`
+}
+
+type NetconfOperation_Lock struct {
+	Lock *NetconfLock `protobuf:"bytes,4,opt,name=lock,proto3,oneof"`
+}
+
+type NetconfOperation_Unlock struct {
+	Unlock *NetconfLock `protobuf:"bytes,5,opt,name=unlock,proto3,oneof"`
+}
+
+type NetconfOperation_Commit struct {
+	Commit *NetconfCommit `protobuf:"bytes,6,opt,name=commit,proto3,oneof"`
+}
+
+type NetconfOperation_CancelCommit struct {
+	CancelCommit *NetconfCancelCommit `protobuf:"bytes,7,opt,name=cancel_commit,json=cancelCommit,proto3,oneof"`
+}
+
+type NetconfOperation_Validate struct {
+	Validate *NetconfValidate `protobuf:"bytes,8,opt,name=validate,proto3,oneof"`
+}
+
+type NetconfOperation_DiscardChanges struct {
+	DiscardChanges *NetconfDiscardChanges `protobuf:"bytes,9,opt,name=discard_changes,json=discardChanges,proto3,oneof"`
+}
+
+func (*NetconfOperation_Get) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_GetConfig) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_EditConfig) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_Lock) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_Unlock) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_Commit) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_CancelCommit) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_Validate) isNetconfOperation_Operation() {}
+
+func (*NetconfOperation_DiscardChanges) isNetconfOperation_Operation() {}
+
+func (m *NetconfOperation) GetOperation() isNetconfOperation_Operation {
+	if m != nil {
+		return m.Operation
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetGet() *NetconfGet {
+	if x, ok := m.GetOperation().(*NetconfOperation_Get); ok {
+		return x.Get
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetGetConfig() *NetconfGetConfig {
+	if x, ok := m.GetOperation().(*NetconfOperation_GetConfig); ok {
+		return x.GetConfig
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetEditConfig() *NetconfEditConfig {
+	if x, ok := m.GetOperation().(*NetconfOperation_EditConfig); ok {
+		return x.EditConfig
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetLock() *NetconfLock {
+	if x, ok := m.GetOperation().(*NetconfOperation_Lock); ok {
+		return x.Lock
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetUnlock() *NetconfLock {
+	if x, ok := m.GetOperation().(*NetconfOperation_Unlock); ok {
+		return x.Unlock
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetCommit() *NetconfCommit {
+	if x, ok := m.GetOperation().(*NetconfOperation_Commit); ok {
+		return x.Commit
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetCancelCommit() *NetconfCancelCommit {
+	if x, ok := m.GetOperation().(*NetconfOperation_CancelCommit); ok {
+		return x.CancelCommit
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetValidate() *NetconfValidate {
+	if x, ok := m.GetOperation().(*NetconfOperation_Validate); ok {
+		return x.Validate
+	}
+	return nil
+}
+
+func (m *NetconfOperation) GetDiscardChanges() *NetconfDiscardChanges {
+	if x, ok := m.GetOperation().(*NetconfOperation_DiscardChanges); ok {
+		return x.DiscardChanges
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*NetconfOperation) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*NetconfOperation_Get)(nil),
+		(*NetconfOperation_GetConfig)(nil),
+		(*NetconfOperation_EditConfig)(nil),
+		(*NetconfOperation_Lock)(nil),
+		(*NetconfOperation_Unlock)(nil),
+		(*NetconfOperation_Commit)(nil),
+		(*NetconfOperation_CancelCommit)(nil),
+		(*NetconfOperation_Validate)(nil),
+		(*NetconfOperation_DiscardChanges)(nil),
+	}
+}
+
+// Filter for get and get-config, at most one field may be set
+type NetconfFilter struct {
+	// Subtree filter, the XML content of the filter element
+	Subtree string `protobuf:"bytes,1,opt,name=subtree,proto3" json:"subtree,omitempty"`
+	// XPath expression, requires the :xpath capability
+	Xpath                string   `protobuf:"bytes,2,opt,name=xpath,proto3" json:"xpath,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfFilter) Reset()         { *m = NetconfFilter{} }
+func (m *NetconfFilter) String() string { return proto.CompactTextString(m) }
+func (*NetconfFilter) ProtoMessage()    {}
+func (*NetconfFilter) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{9}
+}
+
+func (m *NetconfFilter) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfFilter.Unmarshal(m, b)
+}
+func (m *NetconfFilter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfFilter.Marshal(b, m, deterministic)
+}
+func (m *NetconfFilter) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfFilter.Merge(m, src)
+}
+func (m *NetconfFilter) XXX_Size() int {
+	return xxx_messageInfo_NetconfFilter.Size(m)
+}
+func (m *NetconfFilter) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfFilter.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfFilter proto.InternalMessageInfo
+
+func (m *NetconfFilter) GetSubtree() string {
+	if m != nil {
+		return m.Subtree
+	}
+	return ""
+}
+
+func (m *NetconfFilter) GetXpath() string {
+	if m != nil {
+		return m.Xpath
+	}
+	return ""
+}
+
+// Retrieve running configuration and state data
+type NetconfGet struct {
+	Filter               *NetconfFilter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *NetconfGet) Reset()         { *m = NetconfGet{} }
+func (m *NetconfGet) String() string { return proto.CompactTextString(m) }
+func (*NetconfGet) ProtoMessage()    {}
+func (*NetconfGet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{10}
+}
+
+func (m *NetconfGet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfGet.Unmarshal(m, b)
+}
+func (m *NetconfGet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfGet.Marshal(b, m, deterministic)
+}
+func (m *NetconfGet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfGet.Merge(m, src)
+}
+func (m *NetconfGet) XXX_Size() int {
+	return xxx_messageInfo_NetconfGet.Size(m)
+}
+func (m *NetconfGet) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfGet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfGet proto.InternalMessageInfo
+
+func (m *NetconfGet) GetFilter() *NetconfFilter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+// Retrieve configuration data from a datastore
+type NetconfGetConfig struct {
+	// Source datastore: running (default), candidate or startup
+	Source               string         `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Filter               *NetconfFilter `protobuf:"bytes,2,opt,name=filter,proto3" json:"filter,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *NetconfGetConfig) Reset()         { *m = NetconfGetConfig{} }
+func (m *NetconfGetConfig) String() string { return proto.CompactTextString(m) }
+func (*NetconfGetConfig) ProtoMessage()    {}
+func (*NetconfGetConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{11}
+}
+
+func (m *NetconfGetConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfGetConfig.Unmarshal(m, b)
+}
+func (m *NetconfGetConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfGetConfig.Marshal(b, m, deterministic)
+}
+func (m *NetconfGetConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfGetConfig.Merge(m, src)
+}
+func (m *NetconfGetConfig) XXX_Size() int {
+	return xxx_messageInfo_NetconfGetConfig.Size(m)
+}
+func (m *NetconfGetConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfGetConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfGetConfig proto.InternalMessageInfo
+
+func (m *NetconfGetConfig) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *NetconfGetConfig) GetFilter() *NetconfFilter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+// Load configuration into a datastore
+type NetconfEditConfig struct {
+	// Target datastore: running (default), candidate or startup
+	Target string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	// Default operation: merge, replace or none (device default is merge)
+	DefaultOperation string `protobuf:"bytes,2,opt,name=default_operation,json=defaultOperation,proto3" json:"default_operation,omitempty"`
+	// Test option: test-then-set, set or test-only
+	TestOption string `protobuf:"bytes,3,opt,name=test_option,json=testOption,proto3" json:"test_option,omitempty"`
+	// Error option: stop-on-error, continue-on-error or rollback-on-error
+	ErrorOption string `protobuf:"bytes,4,opt,name=error_option,json=errorOption,proto3" json:"error_option,omitempty"`
+	// Configuration XML, with or without the enclosing config element
+	Config               string   `protobuf:"bytes,5,opt,name=config,proto3" json:"config,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfEditConfig) Reset()         { *m = NetconfEditConfig{} }
+func (m *NetconfEditConfig) String() string { return proto.CompactTextString(m) }
+func (*NetconfEditConfig) ProtoMessage()    {}
+func (*NetconfEditConfig) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{12}
+}
+
+func (m *NetconfEditConfig) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfEditConfig.Unmarshal(m, b)
+}
+func (m *NetconfEditConfig) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfEditConfig.Marshal(b, m, deterministic)
+}
+func (m *NetconfEditConfig) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfEditConfig.Merge(m, src)
+}
+func (m *NetconfEditConfig) XXX_Size() int {
+	return xxx_messageInfo_NetconfEditConfig.Size(m)
+}
+func (m *NetconfEditConfig) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfEditConfig.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfEditConfig proto.InternalMessageInfo
+
+func (m *NetconfEditConfig) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+func (m *NetconfEditConfig) GetDefaultOperation() string {
+	if m != nil {
+		return m.DefaultOperation
+	}
+	return ""
+}
+
+func (m *NetconfEditConfig) GetTestOption() string {
+	if m != nil {
+		return m.TestOption
+	}
+	return ""
+}
+
+func (m *NetconfEditConfig) GetErrorOption() string {
+	if m != nil {
+		return m.ErrorOption
+	}
+	return ""
+}
+
+func (m *NetconfEditConfig) GetConfig() string {
+	if m != nil {
+		return m.Config
+	}
+	return ""
+}
+
+// Lock or unlock a datastore
+type NetconfLock struct {
+	// Datastore: running (default), candidate or startup
+	Target               string   `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfLock) Reset()         { *m = NetconfLock{} }
+func (m *NetconfLock) String() string { return proto.CompactTextString(m) }
+func (*NetconfLock) ProtoMessage()    {}
+func (*NetconfLock) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{13}
+}
+
+func (m *NetconfLock) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfLock.Unmarshal(m, b)
+}
+func (m *NetconfLock) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfLock.Marshal(b, m, deterministic)
+}
+func (m *NetconfLock) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfLock.Merge(m, src)
+}
+func (m *NetconfLock) XXX_Size() int {
+	return xxx_messageInfo_NetconfLock.Size(m)
+}
+func (m *NetconfLock) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfLock.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfLock proto.InternalMessageInfo
+
+func (m *NetconfLock) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+// Commit the candidate configuration
+type NetconfCommit struct {
+	// Make this a confirmed commit, reverted unless confirmed in time.
+	// Without persist, the commit is also reverted when the session closes at
+	// the end of the request unless a later commit in the request confirms it.
+	Confirmed bool `protobuf:"varint,1,opt,name=confirmed,proto3" json:"confirmed,omitempty"`
+	// Seconds before an unconfirmed commit is reverted (device default is 600)
+	ConfirmTimeout uint32 `protobuf:"varint,2,opt,name=confirm_timeout,json=confirmTimeout,proto3" json:"confirm_timeout,omitempty"`
+	// Token that lets a later session confirm or cancel the confirmed commit
+	Persist string `protobuf:"bytes,3,opt,name=persist,proto3" json:"persist,omitempty"`
+	// Confirm a confirmed commit made with this persist token
+	PersistId            string   `protobuf:"bytes,4,opt,name=persist_id,json=persistId,proto3" json:"persist_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfCommit) Reset()         { *m = NetconfCommit{} }
+func (m *NetconfCommit) String() string { return proto.CompactTextString(m) }
+func (*NetconfCommit) ProtoMessage()    {}
+func (*NetconfCommit) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{14}
+}
+
+func (m *NetconfCommit) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfCommit.Unmarshal(m, b)
+}
+func (m *NetconfCommit) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfCommit.Marshal(b, m, deterministic)
+}
+func (m *NetconfCommit) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfCommit.Merge(m, src)
+}
+func (m *NetconfCommit) XXX_Size() int {
+	return xxx_messageInfo_NetconfCommit.Size(m)
+}
+func (m *NetconfCommit) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfCommit.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfCommit proto.InternalMessageInfo
+
+func (m *NetconfCommit) GetConfirmed() bool {
+	if m != nil {
+		return m.Confirmed
+	}
+	return false
+}
+
+func (m *NetconfCommit) GetConfirmTimeout() uint32 {
+	if m != nil {
+		return m.ConfirmTimeout
+	}
+	return 0
+}
+
+func (m *NetconfCommit) GetPersist() string {
+	if m != nil {
+		return m.Persist
+	}
+	return ""
+}
+
+func (m *NetconfCommit) GetPersistId() string {
+	if m != nil {
+		return m.PersistId
+	}
+	return ""
+}
+
+// Cancel an ongoing confirmed commit
+type NetconfCancelCommit struct {
+	// Persist token of a confirmed commit made by another session
+	PersistId            string   `protobuf:"bytes,1,opt,name=persist_id,json=persistId,proto3" json:"persist_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfCancelCommit) Reset()         { *m = NetconfCancelCommit{} }
+func (m *NetconfCancelCommit) String() string { return proto.CompactTextString(m) }
+func (*NetconfCancelCommit) ProtoMessage()    {}
+func (*NetconfCancelCommit) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{15}
+}
+
+func (m *NetconfCancelCommit) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfCancelCommit.Unmarshal(m, b)
+}
+func (m *NetconfCancelCommit) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfCancelCommit.Marshal(b, m, deterministic)
+}
+func (m *NetconfCancelCommit) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfCancelCommit.Merge(m, src)
+}
+func (m *NetconfCancelCommit) XXX_Size() int {
+	return xxx_messageInfo_NetconfCancelCommit.Size(m)
+}
+func (m *NetconfCancelCommit) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfCancelCommit.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfCancelCommit proto.InternalMessageInfo
+
+func (m *NetconfCancelCommit) GetPersistId() string {
+	if m != nil {
+		return m.PersistId
+	}
+	return ""
+}
+
+// Validate the contents of a datastore
+type NetconfValidate struct {
+	// Source datastore: candidate (default), running or startup
+	Source               string   `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfValidate) Reset()         { *m = NetconfValidate{} }
+func (m *NetconfValidate) String() string { return proto.CompactTextString(m) }
+func (*NetconfValidate) ProtoMessage()    {}
+func (*NetconfValidate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{16}
+}
+
+func (m *NetconfValidate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfValidate.Unmarshal(m, b)
+}
+func (m *NetconfValidate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfValidate.Marshal(b, m, deterministic)
+}
+func (m *NetconfValidate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfValidate.Merge(m, src)
+}
+func (m *NetconfValidate) XXX_Size() int {
+	return xxx_messageInfo_NetconfValidate.Size(m)
+}
+func (m *NetconfValidate) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfValidate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfValidate proto.InternalMessageInfo
+
+func (m *NetconfValidate) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+// Revert the candidate configuration to the running configuration
+type NetconfDiscardChanges struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfDiscardChanges) Reset()         { *m = NetconfDiscardChanges{} }
+func (m *NetconfDiscardChanges) String() string { return proto.CompactTextString(m) }
+func (*NetconfDiscardChanges) ProtoMessage()    {}
+func (*NetconfDiscardChanges) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{17}
+}
+
+func (m *NetconfDiscardChanges) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfDiscardChanges.Unmarshal(m, b)
+}
+func (m *NetconfDiscardChanges) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfDiscardChanges.Marshal(b, m, deterministic)
+}
+func (m *NetconfDiscardChanges) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfDiscardChanges.Merge(m, src)
+}
+func (m *NetconfDiscardChanges) XXX_Size() int {
+	return xxx_messageInfo_NetconfDiscardChanges.Size(m)
+}
+func (m *NetconfDiscardChanges) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfDiscardChanges.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfDiscardChanges proto.InternalMessageInfo
+
+// An rpc-error reported by the device
+type NetconfError struct {
+	Type     string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Tag      string `protobuf:"bytes,2,opt,name=tag,proto3" json:"tag,omitempty"`
+	Severity string `protobuf:"bytes,3,opt,name=severity,proto3" json:"severity,omitempty"`
+	AppTag   string `protobuf:"bytes,4,opt,name=app_tag,json=appTag,proto3" json:"app_tag,omitempty"`
+	Path     string `protobuf:"bytes,5,opt,name=path,proto3" json:"path,omitempty"`
+	Message  string `protobuf:"bytes,6,opt,name=message,proto3" json:"message,omitempty"`
+	// Raw XML content of error-info
+	Info                 string   `protobuf:"bytes,7,opt,name=info,proto3" json:"info,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfError) Reset()         { *m = NetconfError{} }
+func (m *NetconfError) String() string { return proto.CompactTextString(m) }
+func (*NetconfError) ProtoMessage()    {}
+func (*NetconfError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{18}
+}
+
+func (m *NetconfError) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfError.Unmarshal(m, b)
+}
+func (m *NetconfError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfError.Marshal(b, m, deterministic)
+}
+func (m *NetconfError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfError.Merge(m, src)
+}
+func (m *NetconfError) XXX_Size() int {
+	return xxx_messageInfo_NetconfError.Size(m)
+}
+func (m *NetconfError) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfError proto.InternalMessageInfo
+
+func (m *NetconfError) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *NetconfError) GetTag() string {
+	if m != nil {
+		return m.Tag
+	}
+	return ""
+}
+
+func (m *NetconfError) GetSeverity() string {
+	if m != nil {
+		return m.Severity
+	}
+	return ""
+}
+
+func (m *NetconfError) GetAppTag() string {
+	if m != nil {
+		return m.AppTag
+	}
+	return ""
+}
+
+func (m *NetconfError) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *NetconfError) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *NetconfError) GetInfo() string {
+	if m != nil {
+		return m.Info
+	}
+	return ""
+}
+
+// Result of one NETCONF operation
+type NetconfResult struct {
+	// Operation name (e.g., get-config)
+	Operation string `protobuf:"bytes,1,opt,name=operation,proto3" json:"operation,omitempty"`
+	// Content of the data element for get and get-config
+	Data string `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// The device answered with ok
+	Ok bool `protobuf:"varint,3,opt,name=ok,proto3" json:"ok,omitempty"`
+	// Errors and warnings reported by the device
+	Errors []*NetconfError `protobuf:"bytes,4,rep,name=errors,proto3" json:"errors,omitempty"`
+	// Time spent on the operation in milliseconds
+	DurationMs           int64    `protobuf:"varint,5,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfResult) Reset()         { *m = NetconfResult{} }
+func (m *NetconfResult) String() string { return proto.CompactTextString(m) }
+func (*NetconfResult) ProtoMessage()    {}
+func (*NetconfResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{19}
+}
+
+func (m *NetconfResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfResult.Unmarshal(m, b)
+}
+func (m *NetconfResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfResult.Marshal(b, m, deterministic)
+}
+func (m *NetconfResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfResult.Merge(m, src)
+}
+func (m *NetconfResult) XXX_Size() int {
+	return xxx_messageInfo_NetconfResult.Size(m)
+}
+func (m *NetconfResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfResult proto.InternalMessageInfo
+
+func (m *NetconfResult) GetOperation() string {
+	if m != nil {
+		return m.Operation
+	}
+	return ""
+}
+
+func (m *NetconfResult) GetData() string {
+	if m != nil {
+		return m.Data
+	}
+	return ""
+}
+
+func (m *NetconfResult) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+func (m *NetconfResult) GetErrors() []*NetconfError {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+func (m *NetconfResult) GetDurationMs() int64 {
+	if m != nil {
+		return m.DurationMs
+	}
+	return 0
+}
+
+// Response message for NETCONF operations
+type NetconfResponse struct {
+	// Results of the operations that were run, in request order, followed
+	// after a failure by the discard-changes and unlock operations of the
+	// rollback
+	Results []*NetconfResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	// Error message if an operation failed
+	Error string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	// Category of the failure when error is set
+	ErrorCategory ErrorCategory `protobuf:"varint,3,opt,name=error_category,json=errorCategory,proto3,enum=gateway.ErrorCategory" json:"error_category,omitempty"`
+	// NETCONF session-id assigned by the device
+	SessionId uint64 `protobuf:"varint,4,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Time spent talking to the device in milliseconds
+	BackendDurationMs    int64    `protobuf:"varint,5,opt,name=backend_duration_ms,json=backendDurationMs,proto3" json:"backend_duration_ms,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NetconfResponse) Reset()         { *m = NetconfResponse{} }
+func (m *NetconfResponse) String() string { return proto.CompactTextString(m) }
+func (*NetconfResponse) ProtoMessage()    {}
+func (*NetconfResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{20}
+}
+
+func (m *NetconfResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NetconfResponse.Unmarshal(m, b)
+}
+func (m *NetconfResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NetconfResponse.Marshal(b, m, deterministic)
+}
+func (m *NetconfResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NetconfResponse.Merge(m, src)
+}
+func (m *NetconfResponse) XXX_Size() int {
+	return xxx_messageInfo_NetconfResponse.Size(m)
+}
+func (m *NetconfResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_NetconfResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NetconfResponse proto.InternalMessageInfo
+
+func (m *NetconfResponse) GetResults() []*NetconfResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+func (m *NetconfResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *NetconfResponse) GetErrorCategory() ErrorCategory {
+	if m != nil {
+		return m.ErrorCategory
+	}
+	return ErrorCategory_ERROR_CATEGORY_UNSPECIFIED
+}
+
+func (m *NetconfResponse) GetSessionId() uint64 {
+	if m != nil {
+		return m.SessionId
+	}
+	return 0
+}
+
+func (m *NetconfResponse) GetBackendDurationMs() int64 {
+	if m != nil {
+		return m.BackendDurationMs
+	}
+	return 0
+}
+
+// Request message for a bastion user certificate
+type UserCertificateRequest struct {
+	// Gateway user from settings.users
+	Username string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	// Password of the gateway user
+	Password string `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	// TOTP code, required when the user has a second factor
+	TotpCode string `protobuf:"bytes,3,opt,name=totp_code,json=totpCode,proto3" json:"totp_code,omitempty"`
+	// Public key to sign in authorized_keys format
+	PublicKey string `protobuf:"bytes,4,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// Request from BeginUserCertificate, instead of the password
+	RequestId string `protobuf:"bytes,5,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Armored SSH signature of the challenge, for the key method
+	Signature            string   `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UserCertificateRequest) Reset()         { *m = UserCertificateRequest{} }
+func (m *UserCertificateRequest) String() string { return proto.CompactTextString(m) }
+func (*UserCertificateRequest) ProtoMessage()    {}
+func (*UserCertificateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{21}
+}
+
+func (m *UserCertificateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UserCertificateRequest.Unmarshal(m, b)
+}
+func (m *UserCertificateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UserCertificateRequest.Marshal(b, m, deterministic)
+}
+func (m *UserCertificateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UserCertificateRequest.Merge(m, src)
+}
+func (m *UserCertificateRequest) XXX_Size() int {
+	return xxx_messageInfo_UserCertificateRequest.Size(m)
+}
+func (m *UserCertificateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UserCertificateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UserCertificateRequest proto.InternalMessageInfo
+
+func (m *UserCertificateRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *UserCertificateRequest) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *UserCertificateRequest) GetTotpCode() string {
+	if m != nil {
+		return m.TotpCode
+	}
+	return ""
+}
+
+func (m *UserCertificateRequest) GetPublicKey() string {
+	if m != nil {
+		return m.PublicKey
+	}
+	return ""
+}
+
+func (m *UserCertificateRequest) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *UserCertificateRequest) GetSignature() string {
+	if m != nil {
+		return m.Signature
+	}
+	return ""
+}
+
+// Response message for a bastion user certificate
+type UserCertificateResponse struct {
+	// OpenSSH certificate in authorized_keys format, e.g. for id_ed25519-cert.pub
+	Certificate string `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	// Login names the certificate is valid for
+	Principals []string `protobuf:"bytes,2,rep,name=principals,proto3" json:"principals,omitempty"`
+	// Validity period as Unix time
+	ValidAfter  int64 `protobuf:"varint,3,opt,name=valid_after,json=validAfter,proto3" json:"valid_after,omitempty"`
+	ValidBefore int64 `protobuf:"varint,4,opt,name=valid_before,json=validBefore,proto3" json:"valid_before,omitempty"`
+	// Serial number and key ID, as logged by the bastion
+	Serial               uint64   `protobuf:"varint,5,opt,name=serial,proto3" json:"serial,omitempty"`
+	KeyId                string   `protobuf:"bytes,6,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UserCertificateResponse) Reset()         { *m = UserCertificateResponse{} }
+func (m *UserCertificateResponse) String() string { return proto.CompactTextString(m) }
+func (*UserCertificateResponse) ProtoMessage()    {}
+func (*UserCertificateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{22}
+}
+
+func (m *UserCertificateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UserCertificateResponse.Unmarshal(m, b)
+}
+func (m *UserCertificateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UserCertificateResponse.Marshal(b, m, deterministic)
+}
+func (m *UserCertificateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UserCertificateResponse.Merge(m, src)
+}
+func (m *UserCertificateResponse) XXX_Size() int {
+	return xxx_messageInfo_UserCertificateResponse.Size(m)
+}
+func (m *UserCertificateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_UserCertificateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UserCertificateResponse proto.InternalMessageInfo
+
+func (m *UserCertificateResponse) GetCertificate() string {
+	if m != nil {
+		return m.Certificate
+	}
+	return ""
+}
+
+func (m *UserCertificateResponse) GetPrincipals() []string {
+	if m != nil {
+		return m.Principals
+	}
+	return nil
+}
+
+func (m *UserCertificateResponse) GetValidAfter() int64 {
+	if m != nil {
+		return m.ValidAfter
+	}
+	return 0
+}
+
+func (m *UserCertificateResponse) GetValidBefore() int64 {
+	if m != nil {
+		return m.ValidBefore
+	}
+	return 0
+}
+
+func (m *UserCertificateResponse) GetSerial() uint64 {
+	if m != nil {
+		return m.Serial
+	}
+	return 0
+}
+
+func (m *UserCertificateResponse) GetKeyId() string {
+	if m != nil {
+		return m.KeyId
+	}
+	return ""
+}
+
+// Request message to start a certificate request
+type BeginUserCertificateRequest struct {
+	// Gateway user from settings.users
+	Username string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	// Public key to sign in authorized_keys format
+	PublicKey string `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// How the user proves who they are
+	Method               CertificateAuthMethod `protobuf:"varint,3,opt,name=method,proto3,enum=gateway.CertificateAuthMethod" json:"method,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}              `json:"-"`
+	XXX_unrecognized     []byte                `json:"-"`
+	XXX_sizecache        int32                 `json:"-"`
+}
+
+func (m *BeginUserCertificateRequest) Reset()         { *m = BeginUserCertificateRequest{} }
+func (m *BeginUserCertificateRequest) String() string { return proto.CompactTextString(m) }
+func (*BeginUserCertificateRequest) ProtoMessage()    {}
+func (*BeginUserCertificateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{23}
+}
+
+func (m *BeginUserCertificateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BeginUserCertificateRequest.Unmarshal(m, b)
+}
+func (m *BeginUserCertificateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BeginUserCertificateRequest.Marshal(b, m, deterministic)
+}
+func (m *BeginUserCertificateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BeginUserCertificateRequest.Merge(m, src)
+}
+func (m *BeginUserCertificateRequest) XXX_Size() int {
+	return xxx_messageInfo_BeginUserCertificateRequest.Size(m)
+}
+func (m *BeginUserCertificateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BeginUserCertificateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BeginUserCertificateRequest proto.InternalMessageInfo
+
+func (m *BeginUserCertificateRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateRequest) GetPublicKey() string {
+	if m != nil {
+		return m.PublicKey
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateRequest) GetMethod() CertificateAuthMethod {
+	if m != nil {
+		return m.Method
+	}
+	return CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_UNSPECIFIED
+}
+
+// Response message of a started certificate request
+type BeginUserCertificateResponse struct {
+	// Request to pass to IssueUserCertificate once the user is verified
+	RequestId string                `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Method    CertificateAuthMethod `protobuf:"varint,2,opt,name=method,proto3,enum=gateway.CertificateAuthMethod" json:"method,omitempty"`
+	// Challenge to sign with ssh-keygen -Y sign -n <signature_namespace>, for the key method
+	Challenge          string `protobuf:"bytes,3,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	SignatureNamespace string `protobuf:"bytes,4,opt,name=signature_namespace,json=signatureNamespace,proto3" json:"signature_namespace,omitempty"`
+	// Page where the user enters user_code, for the OIDC method
+	VerificationUri         string `protobuf:"bytes,5,opt,name=verification_uri,json=verificationUri,proto3" json:"verification_uri,omitempty"`
+	VerificationUriComplete string `protobuf:"bytes,6,opt,name=verification_uri_complete,json=verificationUriComplete,proto3" json:"verification_uri_complete,omitempty"`
+	UserCode                string `protobuf:"bytes,7,opt,name=user_code,json=userCode,proto3" json:"user_code,omitempty"`
+	// Expiry of the request as Unix time
+	ExpiresAt int64 `protobuf:"varint,8,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// Seconds to wait between IssueUserCertificate calls while the request is pending
+	Interval             int32    `protobuf:"varint,9,opt,name=interval,proto3" json:"interval,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BeginUserCertificateResponse) Reset()         { *m = BeginUserCertificateResponse{} }
+func (m *BeginUserCertificateResponse) String() string { return proto.CompactTextString(m) }
+func (*BeginUserCertificateResponse) ProtoMessage()    {}
+func (*BeginUserCertificateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{24}
+}
+
+func (m *BeginUserCertificateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BeginUserCertificateResponse.Unmarshal(m, b)
+}
+func (m *BeginUserCertificateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BeginUserCertificateResponse.Marshal(b, m, deterministic)
+}
+func (m *BeginUserCertificateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BeginUserCertificateResponse.Merge(m, src)
+}
+func (m *BeginUserCertificateResponse) XXX_Size() int {
+	return xxx_messageInfo_BeginUserCertificateResponse.Size(m)
+}
+func (m *BeginUserCertificateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BeginUserCertificateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BeginUserCertificateResponse proto.InternalMessageInfo
+
+func (m *BeginUserCertificateResponse) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateResponse) GetMethod() CertificateAuthMethod {
+	if m != nil {
+		return m.Method
+	}
+	return CertificateAuthMethod_CERTIFICATE_AUTH_METHOD_UNSPECIFIED
+}
+
+func (m *BeginUserCertificateResponse) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateResponse) GetSignatureNamespace() string {
+	if m != nil {
+		return m.SignatureNamespace
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateResponse) GetVerificationUri() string {
+	if m != nil {
+		return m.VerificationUri
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateResponse) GetVerificationUriComplete() string {
+	if m != nil {
+		return m.VerificationUriComplete
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateResponse) GetUserCode() string {
+	if m != nil {
+		return m.UserCode
+	}
+	return ""
+}
+
+func (m *BeginUserCertificateResponse) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
+func (m *BeginUserCertificateResponse) GetInterval() int32 {
+	if m != nil {
+		return m.Interval
+	}
+	return 0
+}
+
+// Request message to approve or deny a certificate request
+type ApproveUserCertificateRequest struct {
+	// Request from BeginUserCertificate with the approval method
+	RequestId string `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Approver from user_ca.approvers, with their password and TOTP code
+	Approver string `protobuf:"bytes,2,opt,name=approver,proto3" json:"approver,omitempty"`
+	Password string `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	TotpCode string `protobuf:"bytes,4,opt,name=totp_code,json=totpCode,proto3" json:"totp_code,omitempty"`
+	// Deny the request instead of approving it
+	Deny                 bool     `protobuf:"varint,5,opt,name=deny,proto3" json:"deny,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ApproveUserCertificateRequest) Reset()         { *m = ApproveUserCertificateRequest{} }
+func (m *ApproveUserCertificateRequest) String() string { return proto.CompactTextString(m) }
+func (*ApproveUserCertificateRequest) ProtoMessage()    {}
+func (*ApproveUserCertificateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{25}
+}
+
+func (m *ApproveUserCertificateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ApproveUserCertificateRequest.Unmarshal(m, b)
+}
+func (m *ApproveUserCertificateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ApproveUserCertificateRequest.Marshal(b, m, deterministic)
+}
+func (m *ApproveUserCertificateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ApproveUserCertificateRequest.Merge(m, src)
+}
+func (m *ApproveUserCertificateRequest) XXX_Size() int {
+	return xxx_messageInfo_ApproveUserCertificateRequest.Size(m)
+}
+func (m *ApproveUserCertificateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ApproveUserCertificateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ApproveUserCertificateRequest proto.InternalMessageInfo
+
+func (m *ApproveUserCertificateRequest) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateRequest) GetApprover() string {
+	if m != nil {
+		return m.Approver
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateRequest) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateRequest) GetTotpCode() string {
+	if m != nil {
+		return m.TotpCode
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateRequest) GetDeny() bool {
+	if m != nil {
+		return m.Deny
+	}
+	return false
+}
+
+// Response message of an approval
+type ApproveUserCertificateResponse struct {
+	RequestId string `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Gateway user and SHA256 fingerprint of the key the request is for
+	Username             string   `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Fingerprint          string   `protobuf:"bytes,3,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	Approved             bool     `protobuf:"varint,4,opt,name=approved,proto3" json:"approved,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ApproveUserCertificateResponse) Reset()         { *m = ApproveUserCertificateResponse{} }
+func (m *ApproveUserCertificateResponse) String() string { return proto.CompactTextString(m) }
+func (*ApproveUserCertificateResponse) ProtoMessage()    {}
+func (*ApproveUserCertificateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_85acbde2a6adc437, []int{26}
+}
+
+func (m *ApproveUserCertificateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ApproveUserCertificateResponse.Unmarshal(m, b)
+}
+func (m *ApproveUserCertificateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ApproveUserCertificateResponse.Marshal(b, m, deterministic)
+}
+func (m *ApproveUserCertificateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ApproveUserCertificateResponse.Merge(m, src)
+}
+func (m *ApproveUserCertificateResponse) XXX_Size() int {
+	return xxx_messageInfo_ApproveUserCertificateResponse.Size(m)
+}
+func (m *ApproveUserCertificateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ApproveUserCertificateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ApproveUserCertificateResponse proto.InternalMessageInfo
+
+func (m *ApproveUserCertificateResponse) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateResponse) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateResponse) GetFingerprint() string {
+	if m != nil {
+		return m.Fingerprint
+	}
+	return ""
+}
+
+func (m *ApproveUserCertificateResponse) GetApproved() bool {
+	if m != nil {
+		return m.Approved
+	}
+	return false
+}
 func init() {
+	proto.RegisterEnum("gateway.ErrorCategory", ErrorCategory_name, ErrorCategory_value)
+	proto.RegisterEnum("gateway.CertificateAuthMethod", CertificateAuthMethod_name, CertificateAuthMethod_value)
 	proto.RegisterType((*CommandRequest)(nil), "gateway.CommandRequest")
 	proto.RegisterType((*CommandResponse)(nil), "gateway.CommandResponse")
+	proto.RegisterType((*ParseRequest)(nil), "gateway.ParseRequest")
+	proto.RegisterType((*ParseResponse)(nil), "gateway.ParseResponse")
+	proto.RegisterType((*ListDevicesRequest)(nil), "gateway.ListDevicesRequest")
+	proto.RegisterType((*Device)(nil), "gateway.Device")
+	proto.RegisterType((*ListDevicesResponse)(nil), "gateway.ListDevicesResponse")
+	proto.RegisterType((*NetconfRequest)(nil), "gateway.NetconfRequest")
+	proto.RegisterType((*NetconfOperation)(nil), "gateway.NetconfOperation")
+	proto.RegisterType((*NetconfFilter)(nil), "gateway.NetconfFilter")
+	proto.RegisterType((*NetconfGet)(nil), "gateway.NetconfGet")
+	proto.RegisterType((*NetconfGetConfig)(nil), "gateway.NetconfGetConfig")
+	proto.RegisterType((*NetconfEditConfig)(nil), "gateway.NetconfEditConfig")
+	proto.RegisterType((*NetconfLock)(nil), "gateway.NetconfLock")
+	proto.RegisterType((*NetconfCommit)(nil), "gateway.NetconfCommit")
+	proto.RegisterType((*NetconfCancelCommit)(nil), "gateway.NetconfCancelCommit")
+	proto.RegisterType((*NetconfValidate)(nil), "gateway.NetconfValidate")
+	proto.RegisterType((*NetconfDiscardChanges)(nil), "gateway.NetconfDiscardChanges")
+	proto.RegisterType((*NetconfError)(nil), "gateway.NetconfError")
+	proto.RegisterType((*NetconfResult)(nil), "gateway.NetconfResult")
+	proto.RegisterType((*NetconfResponse)(nil), "gateway.NetconfResponse")
+	proto.RegisterType((*UserCertificateRequest)(nil), "gateway.UserCertificateRequest")
+	proto.RegisterType((*UserCertificateResponse)(nil), "gateway.UserCertificateResponse")
+	proto.RegisterType((*BeginUserCertificateRequest)(nil), "gateway.BeginUserCertificateRequest")
+	proto.RegisterType((*BeginUserCertificateResponse)(nil), "gateway.BeginUserCertificateResponse")
+	proto.RegisterType((*ApproveUserCertificateRequest)(nil), "gateway.ApproveUserCertificateRequest")
+	proto.RegisterType((*ApproveUserCertificateResponse)(nil), "gateway.ApproveUserCertificateResponse")
 }
 
 func init() {
@@ -175,24 +2055,129 @@ func init() {
 }
 
 var fileDescriptor_85acbde2a6adc437 = []byte{
-	// 292 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x91, 0xcd, 0x4a, 0xf3, 0x40,
-	0x14, 0x86, 0x99, 0xef, 0xeb, 0xef, 0x01, 0x2b, 0x8c, 0xa2, 0x43, 0x45, 0x90, 0xe0, 0xa2, 0xab,
-	0x44, 0xf4, 0x0e, 0x0c, 0x2a, 0x6e, 0xe3, 0xce, 0x4d, 0x99, 0x64, 0x4e, 0x6b, 0xc0, 0xe4, 0xa4,
-	0xf3, 0x43, 0x5b, 0xbc, 0x0b, 0x17, 0x5e, 0xaf, 0x64, 0x32, 0x29, 0x88, 0x3b, 0x57, 0xc9, 0x73,
-	0x5e, 0xce, 0xe1, 0xe1, 0x1d, 0x38, 0x69, 0x34, 0x59, 0x4a, 0xd6, 0xd2, 0xe2, 0x56, 0xee, 0x63,
-	0x4f, 0x7c, 0x1c, 0x30, 0xfa, 0x64, 0x30, 0x4b, 0xa9, 0xaa, 0x64, 0xad, 0x32, 0xdc, 0x38, 0x34,
-	0x96, 0x73, 0x18, 0xac, 0x36, 0xaa, 0x16, 0xec, 0x8a, 0x2d, 0xa6, 0x99, 0xff, 0xe7, 0x73, 0x98,
-	0x38, 0x83, 0xba, 0x96, 0x15, 0x8a, 0x7f, 0x7e, 0x7e, 0xe0, 0x36, 0x6b, 0xa4, 0x31, 0x5b, 0xd2,
-	0x4a, 0xfc, 0xef, 0xb2, 0x9e, 0xb9, 0x80, 0x71, 0xd1, 0x5d, 0x17, 0x03, 0x1f, 0xf5, 0xe8, 0xb7,
-	0x5a, 0x95, 0x82, 0xde, 0xc5, 0x30, 0x6c, 0x05, 0x8e, 0x3e, 0xe0, 0xf8, 0xe0, 0x64, 0x1a, 0xaa,
-	0x0d, 0xf2, 0x33, 0x18, 0x91, 0xb3, 0x8d, 0xb3, 0x41, 0x2b, 0x10, 0x3f, 0x85, 0x21, 0x6a, 0x4d,
-	0x3a, 0x58, 0x75, 0xc0, 0x2f, 0x60, 0x8a, 0xbb, 0xd2, 0x2e, 0x0b, 0x52, 0xe8, 0x9d, 0x86, 0xd9,
-	0xa4, 0x1d, 0xa4, 0xa4, 0x90, 0x5f, 0x02, 0x18, 0x34, 0xa6, 0xa4, 0x7a, 0x59, 0xf6, 0x5a, 0xd3,
-	0x30, 0x79, 0x56, 0xb7, 0x5f, 0x0c, 0xc6, 0x4f, 0x5d, 0x3b, 0x3c, 0x85, 0xd9, 0xc3, 0x0e, 0x0b,
-	0x67, 0x31, 0xf8, 0xf0, 0xf3, 0xb8, 0x2f, 0xf2, 0x67, 0x6b, 0x73, 0xf1, 0x3b, 0x08, 0xea, 0x8f,
-	0x70, 0xf4, 0x62, 0x35, 0xca, 0xea, 0xef, 0x37, 0x16, 0xec, 0x86, 0xdd, 0x5f, 0xbf, 0x46, 0xeb,
-	0xd2, 0xbe, 0xb9, 0x3c, 0x2e, 0xa8, 0x4a, 0x8c, 0x5c, 0xc9, 0x5c, 0xee, 0xa5, 0xee, 0x5f, 0x36,
-	0xf1, 0xf5, 0xe5, 0x23, 0xff, 0xb9, 0xfb, 0x0e, 0x00, 0x00, 0xff, 0xff, 0x4b, 0xb2, 0x34, 0xae,
-	0xf7, 0x01, 0x00, 0x00,
+	// 1973 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x38, 0xcb, 0x6f, 0x1b, 0xc7,
+	0xf9, 0x5a, 0x52, 0xe2, 0xe3, 0xa3, 0x1e, 0xf4, 0x48, 0x96, 0x18, 0x4a, 0xb6, 0x98, 0x8d, 0xf3,
+	0xb3, 0xec, 0x1f, 0x2a, 0x1b, 0x6a, 0x11, 0xa0, 0x41, 0x82, 0x56, 0xa6, 0x28, 0x8b, 0x88, 0x25,
+	0x1a, 0x1b, 0x2a, 0x80, 0x73, 0x59, 0x8c, 0x76, 0x87, 0xd4, 0x42, 0xe4, 0xee, 0x7a, 0x66, 0xa8,
+	0x88, 0xe7, 0xde, 0xdb, 0xde, 0x8a, 0x1c, 0x7b, 0x6a, 0x8b, 0x1e, 0x7a, 0xe9, 0x9f, 0xd0, 0x4b,
+	0x2f, 0xfd, 0x1b, 0x7a, 0xeb, 0xbf, 0x51, 0xcc, 0x6b, 0xb9, 0x5c, 0x92, 0x72, 0x12, 0xa0, 0x27,
+	0xce, 0xf7, 0x9a, 0xf9, 0xde, 0xdf, 0xc7, 0x85, 0xcd, 0x98, 0x46, 0x3c, 0x7a, 0xd1, 0xc7, 0x9c,
+	0x7c, 0x87, 0xc7, 0x87, 0x12, 0x42, 0x45, 0x0d, 0xda, 0x7f, 0xb6, 0x60, 0xbd, 0x19, 0x0d, 0x87,
+	0x38, 0xf4, 0x1d, 0xf2, 0x7e, 0x44, 0x18, 0x47, 0x08, 0x96, 0x7b, 0xef, 0xfd, 0xb0, 0x66, 0x35,
+	0xac, 0x83, 0xb2, 0x23, 0xcf, 0xa8, 0x0e, 0xa5, 0x11, 0x23, 0x34, 0xc4, 0x43, 0x52, 0xcb, 0x49,
+	0x7c, 0x02, 0x0b, 0x5a, 0x8c, 0x19, 0xfb, 0x2e, 0xa2, 0x7e, 0x2d, 0xaf, 0x68, 0x06, 0x46, 0x35,
+	0x28, 0x7a, 0xea, 0xf6, 0xda, 0xb2, 0x24, 0x19, 0x50, 0x4a, 0x09, 0x55, 0xbc, 0x68, 0x50, 0x5b,
+	0xd1, 0x52, 0x1a, 0x46, 0x5b, 0xb0, 0x12, 0x63, 0xca, 0x48, 0xad, 0xd0, 0xb0, 0x0e, 0x4a, 0x8e,
+	0x02, 0xec, 0x7f, 0xe7, 0x60, 0x23, 0x51, 0x95, 0xc5, 0x51, 0xc8, 0x08, 0xda, 0x86, 0x42, 0x34,
+	0xe2, 0xf1, 0x88, 0x6b, 0x6d, 0x35, 0x24, 0x6e, 0x20, 0x94, 0x46, 0x54, 0x2b, 0xab, 0x00, 0xb4,
+	0x0b, 0x65, 0x72, 0x17, 0x70, 0xd7, 0x8b, 0x7c, 0x22, 0x55, 0x5d, 0x71, 0x4a, 0x02, 0xd1, 0x8c,
+	0x7c, 0x82, 0x1e, 0x01, 0x30, 0xc2, 0x58, 0x10, 0x85, 0x6e, 0x60, 0xb4, 0x2d, 0x6b, 0x4c, 0xdb,
+	0x17, 0x2f, 0x31, 0xee, 0x47, 0x23, 0xae, 0xb5, 0xd5, 0x90, 0xc6, 0x13, 0x4a, 0x6b, 0x85, 0x04,
+	0x4f, 0x28, 0x45, 0x5f, 0xc2, 0xba, 0x7c, 0xd4, 0xf5, 0x30, 0x27, 0xfd, 0x88, 0x8e, 0x6b, 0xc5,
+	0x86, 0x75, 0xb0, 0x7e, 0xb4, 0x7d, 0x68, 0x22, 0xd1, 0x12, 0xe4, 0xa6, 0xa6, 0x3a, 0x6b, 0x24,
+	0x0d, 0xa2, 0x43, 0xd8, 0xbc, 0xc2, 0xde, 0x0d, 0x09, 0x7d, 0xd7, 0x1f, 0x51, 0xcc, 0x85, 0x5a,
+	0x43, 0x56, 0x2b, 0x35, 0xac, 0x83, 0xbc, 0xf3, 0x40, 0x93, 0x4e, 0x34, 0xe5, 0x9c, 0xa1, 0x4f,
+	0x60, 0x4d, 0x7a, 0xc9, 0x77, 0xb5, 0x3f, 0xca, 0x52, 0x9b, 0x55, 0x85, 0xec, 0x28, 0xaf, 0xec,
+	0x43, 0x45, 0xc2, 0xae, 0xf2, 0x0d, 0x48, 0x16, 0x90, 0x28, 0xa9, 0x8c, 0xcd, 0x61, 0xf5, 0xad,
+	0x80, 0x4c, 0x2a, 0x88, 0x20, 0x0d, 0x30, 0xef, 0x45, 0x74, 0xa8, 0x1d, 0x9c, 0xc0, 0xe9, 0xd0,
+	0xe6, 0x66, 0x42, 0xcb, 0xc9, 0x50, 0x30, 0x12, 0x93, 0x10, 0x06, 0x16, 0xc9, 0xc5, 0xc9, 0x1d,
+	0xd7, 0xfe, 0x95, 0x67, 0xfb, 0x3d, 0xac, 0xe9, 0x57, 0x75, 0x54, 0x67, 0x8c, 0xb1, 0xe6, 0x18,
+	0xb3, 0x0d, 0x85, 0x6b, 0x82, 0x7d, 0x22, 0x62, 0x9c, 0x17, 0x8e, 0x57, 0x90, 0x10, 0x36, 0xaf,
+	0xb9, 0x32, 0x5f, 0x95, 0x0a, 0xab, 0x06, 0x79, 0x81, 0x87, 0xc4, 0xde, 0x02, 0xf4, 0x26, 0x60,
+	0xfc, 0x84, 0xdc, 0x06, 0x1e, 0x61, 0xda, 0x5c, 0xfb, 0x1f, 0x16, 0x14, 0x14, 0x4a, 0xe8, 0x29,
+	0x85, 0x75, 0x11, 0x88, 0x73, 0x52, 0x18, 0xb9, 0xe9, 0xc2, 0xb8, 0x8e, 0x18, 0x4f, 0x3d, 0x94,
+	0xc0, 0xa8, 0x01, 0x15, 0x9f, 0x30, 0x8f, 0x06, 0xb1, 0x08, 0x92, 0x36, 0x39, 0x8d, 0x12, 0xd2,
+	0x83, 0xc8, 0x93, 0x31, 0x34, 0x45, 0x60, 0xe0, 0x29, 0xdf, 0x17, 0x32, 0xbe, 0xdf, 0x83, 0xb2,
+	0x29, 0x16, 0x56, 0x2b, 0x4a, 0xf3, 0x27, 0x08, 0xfb, 0xd7, 0xb0, 0x39, 0x65, 0x9c, 0xf6, 0xea,
+	0x33, 0x28, 0xfa, 0x0a, 0x55, 0xb3, 0x1a, 0xf9, 0x83, 0xca, 0xd1, 0x46, 0x92, 0x8a, 0x8a, 0xd5,
+	0x31, 0x74, 0xfb, 0x0f, 0x16, 0xac, 0x5f, 0x10, 0xee, 0x45, 0x61, 0xef, 0x7f, 0xd1, 0x15, 0x7e,
+	0x09, 0x10, 0xc5, 0x44, 0xe5, 0x2e, 0xab, 0x2d, 0x4b, 0x65, 0x3e, 0x4a, 0x94, 0xd1, 0x0f, 0x77,
+	0x0c, 0x87, 0x93, 0x62, 0xb6, 0xbf, 0x5f, 0x86, 0x6a, 0x96, 0x01, 0x3d, 0x85, 0x7c, 0x9f, 0xa8,
+	0x2c, 0xa9, 0x1c, 0x6d, 0x66, 0x2f, 0x7a, 0x4d, 0xf8, 0xd9, 0x92, 0x23, 0x38, 0xd0, 0xe7, 0x00,
+	0x7d, 0x22, 0xea, 0x3f, 0xec, 0x05, 0x7d, 0xa9, 0xf2, 0x9c, 0x87, 0x5f, 0x13, 0xde, 0x94, 0x0c,
+	0x67, 0x4b, 0x4e, 0xb9, 0x6f, 0x00, 0xf4, 0x25, 0x54, 0x88, 0x1f, 0x24, 0xc2, 0x79, 0x29, 0x5c,
+	0xcf, 0x0a, 0xb7, 0xfc, 0x60, 0x22, 0x0d, 0x24, 0x81, 0xd0, 0x73, 0x58, 0x1e, 0x44, 0xde, 0x8d,
+	0xcc, 0x82, 0xca, 0xd1, 0x56, 0x56, 0xee, 0x4d, 0xe4, 0xdd, 0x9c, 0x2d, 0x39, 0x92, 0x07, 0x1d,
+	0x42, 0x61, 0x14, 0x4a, 0xee, 0x95, 0x7b, 0xb9, 0x35, 0x17, 0x7a, 0x09, 0x05, 0x51, 0x7b, 0x01,
+	0x97, 0x89, 0x52, 0x39, 0xda, 0xce, 0xf2, 0x37, 0x25, 0x55, 0x48, 0x28, 0x3e, 0xd4, 0x84, 0x35,
+	0x0f, 0x87, 0x1e, 0x19, 0xb8, 0x5a, 0xb0, 0x28, 0x05, 0xf7, 0x66, 0x04, 0x25, 0x53, 0x22, 0xbe,
+	0xea, 0xa5, 0x60, 0xf4, 0x19, 0x94, 0x6e, 0xf1, 0x20, 0xf0, 0x45, 0x9d, 0x97, 0xa4, 0x7c, 0x2d,
+	0x2b, 0xff, 0x8d, 0xa6, 0x9f, 0x2d, 0x39, 0x09, 0x2f, 0x6a, 0xc3, 0x86, 0x1f, 0x30, 0x0f, 0x53,
+	0xdf, 0xf5, 0xae, 0x71, 0xd8, 0x27, 0x4c, 0x76, 0xab, 0xca, 0xd1, 0xe3, 0xac, 0xf8, 0x89, 0x62,
+	0x6b, 0x2a, 0xae, 0xb3, 0x25, 0x67, 0xdd, 0x9f, 0xc2, 0xbc, 0xaa, 0x40, 0x39, 0x49, 0x0e, 0xfb,
+	0x57, 0xb0, 0xa6, 0xe5, 0x4e, 0x83, 0x01, 0x27, 0x54, 0xb4, 0x28, 0x36, 0xba, 0xe2, 0x94, 0x98,
+	0x3a, 0x36, 0xa0, 0x98, 0x0f, 0x77, 0x31, 0xe6, 0xd7, 0x66, 0x3e, 0x48, 0xc0, 0xfe, 0x02, 0x60,
+	0x92, 0x03, 0x22, 0x0a, 0x3d, 0x79, 0x4f, 0xcd, 0x9a, 0xef, 0x55, 0xf5, 0x8a, 0xa3, 0xb9, 0xec,
+	0x6f, 0xa1, 0x9a, 0xcd, 0x20, 0x39, 0x1d, 0xa2, 0x11, 0xf5, 0x8c, 0x02, 0x1a, 0x4a, 0xdd, 0x9d,
+	0xfb, 0x41, 0x77, 0xff, 0xdd, 0x82, 0x07, 0x33, 0x19, 0x26, 0x6e, 0xe7, 0x98, 0x9a, 0xd4, 0x2f,
+	0x3b, 0x1a, 0x42, 0xff, 0x0f, 0x0f, 0x7c, 0xd2, 0xc3, 0xa3, 0x01, 0x77, 0x13, 0xef, 0x68, 0x4b,
+	0xab, 0x9a, 0x30, 0x29, 0x9e, 0x7d, 0xa8, 0x70, 0xc2, 0x04, 0xa7, 0x64, 0x53, 0xb5, 0x0a, 0x02,
+	0xd5, 0x91, 0x18, 0xf4, 0x31, 0xac, 0xaa, 0x49, 0x16, 0x4d, 0xf5, 0x31, 0x89, 0xd3, 0x2c, 0xdb,
+	0x22, 0x01, 0x65, 0x59, 0xe8, 0xe1, 0xa8, 0x20, 0xfb, 0x53, 0xa8, 0xa4, 0x32, 0x76, 0x91, 0xbe,
+	0xf6, 0xef, 0xac, 0x24, 0x72, 0x3a, 0xb5, 0xf6, 0xa0, 0x2c, 0xaf, 0xa0, 0x43, 0xe2, 0x4b, 0xe6,
+	0x92, 0x33, 0x41, 0xa0, 0xa7, 0xb0, 0xa1, 0x01, 0x97, 0x07, 0x43, 0x22, 0x86, 0xb2, 0xb0, 0x6e,
+	0xcd, 0x59, 0xd7, 0xe8, 0xae, 0xc2, 0x8a, 0x04, 0x88, 0x09, 0x65, 0x01, 0xe3, 0xda, 0x2e, 0x03,
+	0x8a, 0x69, 0xaf, 0x8f, 0xa9, 0x69, 0xaf, 0x31, 0x6d, 0xdf, 0xfe, 0x05, 0x6c, 0xce, 0xa9, 0x80,
+	0x8c, 0x94, 0x95, 0x95, 0x7a, 0x06, 0x1b, 0x99, 0xbc, 0x5f, 0x94, 0x00, 0xf6, 0x0e, 0x3c, 0x9c,
+	0x9b, 0xe3, 0xf6, 0x5f, 0x2d, 0x58, 0x35, 0x91, 0x96, 0x4b, 0x8b, 0x98, 0x98, 0xe3, 0x38, 0x99,
+	0x44, 0xe2, 0x8c, 0xaa, 0x90, 0xe7, 0xb8, 0xaf, 0x43, 0x2a, 0x8e, 0xa2, 0xdd, 0x32, 0x72, 0x4b,
+	0x68, 0xc0, 0xc7, 0xa6, 0xdd, 0x1a, 0x18, 0xed, 0x40, 0x11, 0xc7, 0xb1, 0x2b, 0x24, 0x94, 0xa1,
+	0x05, 0x1c, 0xc7, 0x5d, 0xdc, 0x17, 0x57, 0xcb, 0x22, 0x50, 0x41, 0x93, 0x67, 0xe1, 0xb2, 0x21,
+	0x61, 0x0c, 0xf7, 0x89, 0x9e, 0x3a, 0x06, 0x14, 0xdc, 0x41, 0xd8, 0x8b, 0x64, 0xab, 0x28, 0x3b,
+	0xf2, 0x6c, 0xff, 0x71, 0x12, 0x39, 0x87, 0xb0, 0xd1, 0x40, 0x46, 0x6e, 0x92, 0x73, 0xda, 0x43,
+	0x09, 0x42, 0xdc, 0xe1, 0x63, 0x8e, 0xcd, 0x08, 0x15, 0x67, 0xb4, 0x0e, 0xb9, 0xe8, 0x46, 0x2a,
+	0x5d, 0x72, 0x72, 0xd1, 0x0d, 0xfa, 0x19, 0x14, 0x64, 0x6e, 0x99, 0xc9, 0xf0, 0x70, 0xa6, 0xc7,
+	0x0a, 0xaa, 0xa3, 0x99, 0x44, 0xfe, 0xa6, 0x37, 0xa4, 0x15, 0xb9, 0x21, 0x81, 0x9f, 0xac, 0x46,
+	0xf6, 0x7f, 0xac, 0x24, 0x2c, 0xc9, 0x2c, 0x7c, 0x09, 0x45, 0x2a, 0xf5, 0x35, 0xb3, 0x70, 0xa6,
+	0x00, 0x95, 0x39, 0x8e, 0x61, 0x5b, 0xb0, 0x51, 0xce, 0x6e, 0x79, 0xf9, 0x1f, 0xb3, 0xe5, 0xcd,
+	0xee, 0x9c, 0xcb, 0xe9, 0x9d, 0x73, 0xc1, 0x12, 0xb8, 0xb2, 0x60, 0x09, 0xb4, 0xff, 0x69, 0xc1,
+	0xf6, 0x25, 0x23, 0xb4, 0x49, 0x28, 0x0f, 0x7a, 0x81, 0xd0, 0x29, 0xb5, 0xc9, 0x25, 0xa3, 0xda,
+	0xba, 0x67, 0x54, 0xe7, 0x32, 0xa3, 0x7a, 0x17, 0xca, 0x3c, 0xe2, 0xf1, 0x64, 0x65, 0x16, 0xcb,
+	0x5c, 0xc4, 0x63, 0xb3, 0x32, 0xc7, 0xa3, 0xab, 0x41, 0xe0, 0xb9, 0x37, 0x64, 0x9c, 0x14, 0x91,
+	0xc4, 0x7c, 0x45, 0xa4, 0x75, 0x54, 0x3d, 0x2f, 0xac, 0x53, 0x49, 0x56, 0xd6, 0x98, 0xb6, 0x2f,
+	0x32, 0x85, 0x05, 0xfd, 0x10, 0xf3, 0x11, 0x35, 0xb9, 0x36, 0x41, 0xd8, 0xff, 0xb2, 0x60, 0x67,
+	0xc6, 0x16, 0x1d, 0xbd, 0x06, 0x54, 0xbc, 0x09, 0x5a, 0xdb, 0x93, 0x46, 0xa1, 0xc7, 0x00, 0x31,
+	0x0d, 0x42, 0x2f, 0x88, 0xf1, 0x80, 0xe9, 0x05, 0x31, 0x85, 0x11, 0x49, 0x23, 0xc7, 0x91, 0x8b,
+	0x7b, 0xa2, 0x09, 0xe7, 0x55, 0xd2, 0x48, 0xd4, 0xb1, 0xc0, 0x88, 0xa6, 0xa7, 0x18, 0xae, 0x48,
+	0x2f, 0xa2, 0x44, 0x1a, 0x97, 0x77, 0x94, 0xd0, 0x2b, 0x89, 0x92, 0xa5, 0x4d, 0x68, 0x80, 0xd5,
+	0xff, 0x97, 0x65, 0x47, 0x43, 0xe8, 0x21, 0x14, 0x6e, 0xc8, 0x58, 0x98, 0xac, 0x8c, 0x5a, 0xb9,
+	0x21, 0xe3, 0xb6, 0x6f, 0xff, 0xde, 0x82, 0xdd, 0x57, 0xa4, 0x1f, 0x84, 0x3f, 0x21, 0x42, 0xd3,
+	0x8e, 0xce, 0x65, 0x1d, 0xfd, 0x19, 0x14, 0x86, 0x84, 0x5f, 0x47, 0xbe, 0xce, 0xbe, 0xc9, 0x1c,
+	0x4d, 0xbd, 0x73, 0x3c, 0xe2, 0xd7, 0xe7, 0x92, 0xcb, 0xd1, 0xdc, 0xf6, 0x6f, 0xf2, 0xb0, 0x37,
+	0x5f, 0x25, 0xed, 0xe8, 0xe9, 0x08, 0x5a, 0xd9, 0x08, 0x4e, 0xde, 0xcd, 0xfd, 0x98, 0x77, 0x65,
+	0x77, 0xbf, 0xc6, 0x83, 0x01, 0x09, 0xfb, 0x26, 0xa9, 0x26, 0x08, 0xf4, 0x02, 0x36, 0x93, 0x34,
+	0x90, 0x1b, 0x3c, 0x8b, 0xb1, 0x47, 0x74, 0x7a, 0xa1, 0x84, 0x74, 0x61, 0x28, 0xe8, 0x19, 0x54,
+	0x45, 0xa7, 0x93, 0xcf, 0x89, 0x12, 0x19, 0xd1, 0x40, 0x67, 0xdb, 0x46, 0x1a, 0x7f, 0x49, 0x03,
+	0xf4, 0x39, 0x7c, 0x94, 0x65, 0x15, 0x1b, 0x50, 0x3c, 0x20, 0xdc, 0xe4, 0xe0, 0x4e, 0x46, 0xa6,
+	0xa9, 0xc9, 0xa2, 0x14, 0x44, 0x40, 0x54, 0x29, 0x14, 0x27, 0x11, 0x32, 0xa5, 0x40, 0xee, 0xe2,
+	0x80, 0x12, 0xe6, 0x62, 0xae, 0xff, 0xa6, 0x95, 0x35, 0xe6, 0x58, 0x06, 0x37, 0x08, 0x39, 0xa1,
+	0xb7, 0x78, 0x20, 0x77, 0x9d, 0x15, 0x27, 0x81, 0xed, 0x3f, 0x59, 0xf0, 0xe8, 0x38, 0x8e, 0x69,
+	0x74, 0x4b, 0x16, 0xa4, 0xc6, 0x07, 0xc2, 0x50, 0x87, 0x12, 0x56, 0xf2, 0xa6, 0x3b, 0x25, 0xf0,
+	0xbd, 0x6b, 0xf8, 0x54, 0x6d, 0x2f, 0x67, 0x6a, 0x5b, 0x74, 0x6a, 0x12, 0x8e, 0xa5, 0x23, 0x4b,
+	0x8e, 0x3c, 0xdb, 0xdf, 0x5b, 0xf0, 0x78, 0x91, 0xa6, 0x3f, 0x2c, 0x63, 0xee, 0xfb, 0xc7, 0xd0,
+	0x80, 0x4a, 0x2f, 0x08, 0xfb, 0x84, 0x8a, 0x3a, 0x35, 0x03, 0x3b, 0x8d, 0x4a, 0x19, 0xaa, 0x9a,
+	0x65, 0x29, 0x31, 0xd4, 0x7f, 0xfe, 0x37, 0x0b, 0xd6, 0xa6, 0x7a, 0x2d, 0x7a, 0x0c, 0xf5, 0x96,
+	0xe3, 0x74, 0x1c, 0xb7, 0x79, 0xdc, 0x6d, 0xbd, 0xee, 0x38, 0xef, 0xdc, 0xcb, 0x8b, 0xaf, 0xdf,
+	0xb6, 0x9a, 0xed, 0xd3, 0x76, 0xeb, 0xa4, 0xba, 0x84, 0x3e, 0x86, 0x47, 0x19, 0x7a, 0xb3, 0x73,
+	0x71, 0xd1, 0x6a, 0x76, 0xdd, 0xd3, 0xe3, 0xf6, 0x9b, 0xd6, 0x49, 0xd5, 0x9a, 0x73, 0xc5, 0xf1,
+	0x65, 0xf7, 0xcc, 0xd0, 0x73, 0xa8, 0x0e, 0xdb, 0x19, 0x7a, 0xb7, 0x7d, 0xde, 0xea, 0x5c, 0x76,
+	0xab, 0x79, 0xb4, 0x0f, 0xbb, 0x19, 0x9a, 0xd3, 0x3a, 0xef, 0x74, 0x5b, 0xae, 0xc4, 0x56, 0x97,
+	0x9f, 0xff, 0xc5, 0x82, 0x87, 0x73, 0xeb, 0x04, 0x3d, 0x85, 0x4f, 0x9a, 0x2d, 0xa7, 0xdb, 0x3e,
+	0x6d, 0x0b, 0x59, 0xf5, 0xe6, 0x79, 0xab, 0x7b, 0xd6, 0x39, 0xc9, 0x98, 0xb0, 0x0f, 0xbb, 0x8b,
+	0x18, 0xbf, 0x6a, 0xbd, 0xab, 0x5a, 0xa8, 0x01, 0x7b, 0x8b, 0x18, 0x3a, 0xed, 0x93, 0x66, 0x35,
+	0x87, 0x9e, 0x40, 0x63, 0x11, 0xc7, 0xf1, 0xdb, 0xb7, 0x4e, 0xe7, 0x9b, 0xe3, 0x37, 0xd5, 0xfc,
+	0xd1, 0x6f, 0x57, 0xa0, 0xf8, 0x5a, 0xd5, 0x36, 0x6a, 0xc2, 0x7a, 0xeb, 0x8e, 0x78, 0x23, 0x4e,
+	0xf4, 0xd7, 0x18, 0xb4, 0x33, 0xa9, 0xfb, 0xa9, 0x4f, 0x49, 0xf5, 0xda, 0x2c, 0x41, 0xe7, 0xc9,
+	0x29, 0xac, 0x7d, 0xcd, 0x29, 0xc1, 0xc3, 0x9f, 0x7e, 0xc7, 0x81, 0xf5, 0xd2, 0x42, 0x5f, 0x40,
+	0x45, 0x7e, 0x3b, 0xd0, 0x1f, 0x05, 0x26, 0xbb, 0x42, 0xfa, 0x3b, 0x46, 0x7d, 0x3b, 0x8b, 0xd6,
+	0x5a, 0x9c, 0x41, 0x25, 0xf5, 0x4f, 0x19, 0xed, 0x26, 0x6c, 0xb3, 0x1f, 0x07, 0xea, 0x7b, 0xf3,
+	0x89, 0xfa, 0xa6, 0x89, 0x53, 0xf4, 0xfe, 0x90, 0x32, 0x68, 0xfa, 0x9f, 0x74, 0xbd, 0x36, 0x4b,
+	0xd0, 0x97, 0xbc, 0x83, 0xad, 0x36, 0x63, 0xa3, 0x6c, 0x71, 0xa1, 0xfd, 0x44, 0x62, 0x7e, 0x83,
+	0xa8, 0x37, 0x16, 0x33, 0xe8, 0xab, 0x3d, 0xd8, 0x9a, 0xd7, 0xe9, 0xd1, 0x93, 0x44, 0xf2, 0x9e,
+	0xd9, 0x54, 0xff, 0xf4, 0x03, 0x5c, 0xfa, 0x91, 0x00, 0xb6, 0xe7, 0xb7, 0x07, 0xf4, 0x7f, 0xc9,
+	0x05, 0xf7, 0x76, 0xba, 0xfa, 0xd3, 0x0f, 0xf2, 0xa9, 0xa7, 0x5e, 0x3d, 0xf9, 0xd6, 0xee, 0x07,
+	0xfc, 0x7a, 0x74, 0x75, 0xe8, 0x45, 0xc3, 0x17, 0x0c, 0xf7, 0xf0, 0x15, 0x1e, 0x63, 0x6a, 0x3e,
+	0x73, 0xbe, 0x90, 0x5f, 0x43, 0xae, 0x0a, 0xf2, 0xe7, 0xe7, 0xff, 0x1d, 0x00, 0x62, 0xb3, 0x1e,
+	0x1e, 0x04, 0x15, 0x00, 0x00,
 }
@@ -19,8 +19,14 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Gateway_ExecuteCommand_FullMethodName = "/gateway.Gateway/ExecuteCommand"
-	Gateway_StreamCommand_FullMethodName  = "/gateway.Gateway/StreamCommand"
+	Gateway_ExecuteCommand_FullMethodName         = "/gateway.Gateway/ExecuteCommand"
+	Gateway_StreamCommand_FullMethodName          = "/gateway.Gateway/StreamCommand"
+	Gateway_ParseOutput_FullMethodName            = "/gateway.Gateway/ParseOutput"
+	Gateway_ListDevices_FullMethodName            = "/gateway.Gateway/ListDevices"
+	Gateway_ExecuteNetconf_FullMethodName         = "/gateway.Gateway/ExecuteNetconf"
+	Gateway_IssueUserCertificate_FullMethodName   = "/gateway.Gateway/IssueUserCertificate"
+	Gateway_BeginUserCertificate_FullMethodName   = "/gateway.Gateway/BeginUserCertificate"
+	Gateway_ApproveUserCertificate_FullMethodName = "/gateway.Gateway/ApproveUserCertificate"
 )
 
 // GatewayClient is the client API for Gateway service.
@@ -33,6 +39,18 @@ type GatewayClient interface {
 	ExecuteCommand(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*CommandResponse, error)
 	// Stream command execution for interactive sessions
 	StreamCommand(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CommandRequest, CommandResponse], error)
+	// Parse sample output with a stored or inline template
+	ParseOutput(ctx context.Context, in *ParseRequest, opts ...grpc.CallOption) (*ParseResponse, error)
+	// List devices reachable through the gateway
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// Run typed NETCONF operations in order over a single NETCONF session
+	ExecuteNetconf(ctx context.Context, in *NetconfRequest, opts ...grpc.CallOption) (*NetconfResponse, error)
+	// Sign an SSH public key of a gateway user for logins to the bastion
+	IssueUserCertificate(ctx context.Context, in *UserCertificateRequest, opts ...grpc.CallOption) (*UserCertificateResponse, error)
+	// Start a certificate request verified by key, OIDC or approval
+	BeginUserCertificate(ctx context.Context, in *BeginUserCertificateRequest, opts ...grpc.CallOption) (*BeginUserCertificateResponse, error)
+	// Approve or deny a certificate request as an approver
+	ApproveUserCertificate(ctx context.Context, in *ApproveUserCertificateRequest, opts ...grpc.CallOption) (*ApproveUserCertificateResponse, error)
 }
 
 type gatewayClient struct {
@@ -66,6 +84,66 @@ func (c *gatewayClient) StreamCommand(ctx context.Context, opts ...grpc.CallOpti
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Gateway_StreamCommandClient = grpc.BidiStreamingClient[CommandRequest, CommandResponse]
 
+func (c *gatewayClient) ParseOutput(ctx context.Context, in *ParseRequest, opts ...grpc.CallOption) (*ParseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ParseResponse)
+	err := c.cc.Invoke(ctx, Gateway_ParseOutput_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, Gateway_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) ExecuteNetconf(ctx context.Context, in *NetconfRequest, opts ...grpc.CallOption) (*NetconfResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NetconfResponse)
+	err := c.cc.Invoke(ctx, Gateway_ExecuteNetconf_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) IssueUserCertificate(ctx context.Context, in *UserCertificateRequest, opts ...grpc.CallOption) (*UserCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UserCertificateResponse)
+	err := c.cc.Invoke(ctx, Gateway_IssueUserCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) BeginUserCertificate(ctx context.Context, in *BeginUserCertificateRequest, opts ...grpc.CallOption) (*BeginUserCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BeginUserCertificateResponse)
+	err := c.cc.Invoke(ctx, Gateway_BeginUserCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatewayClient) ApproveUserCertificate(ctx context.Context, in *ApproveUserCertificateRequest, opts ...grpc.CallOption) (*ApproveUserCertificateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ApproveUserCertificateResponse)
+	err := c.cc.Invoke(ctx, Gateway_ApproveUserCertificate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GatewayServer is the server API for Gateway service.
 // All implementations must embed UnimplementedGatewayServer
 // for forward compatibility.
@@ -76,6 +154,18 @@ type GatewayServer interface {
 	ExecuteCommand(context.Context, *CommandRequest) (*CommandResponse, error)
 	// Stream command execution for interactive sessions
 	StreamCommand(grpc.BidiStreamingServer[CommandRequest, CommandResponse]) error
+	// Parse sample output with a stored or inline template
+	ParseOutput(context.Context, *ParseRequest) (*ParseResponse, error)
+	// List devices reachable through the gateway
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// Run typed NETCONF operations in order over a single NETCONF session
+	ExecuteNetconf(context.Context, *NetconfRequest) (*NetconfResponse, error)
+	// Sign an SSH public key of a gateway user for logins to the bastion
+	IssueUserCertificate(context.Context, *UserCertificateRequest) (*UserCertificateResponse, error)
+	// Start a certificate request verified by key, OIDC or approval
+	BeginUserCertificate(context.Context, *BeginUserCertificateRequest) (*BeginUserCertificateResponse, error)
+	// Approve or deny a certificate request as an approver
+	ApproveUserCertificate(context.Context, *ApproveUserCertificateRequest) (*ApproveUserCertificateResponse, error)
 	mustEmbedUnimplementedGatewayServer()
 }
 
@@ -92,6 +182,24 @@ func (UnimplementedGatewayServer) ExecuteCommand(context.Context, *CommandReques
 func (UnimplementedGatewayServer) StreamCommand(grpc.BidiStreamingServer[CommandRequest, CommandResponse]) error {
 	return status.Error(codes.Unimplemented, "method StreamCommand not implemented")
 }
+func (UnimplementedGatewayServer) ParseOutput(context.Context, *ParseRequest) (*ParseResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ParseOutput not implemented")
+}
+func (UnimplementedGatewayServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedGatewayServer) ExecuteNetconf(context.Context, *NetconfRequest) (*NetconfResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ExecuteNetconf not implemented")
+}
+func (UnimplementedGatewayServer) IssueUserCertificate(context.Context, *UserCertificateRequest) (*UserCertificateResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method IssueUserCertificate not implemented")
+}
+func (UnimplementedGatewayServer) BeginUserCertificate(context.Context, *BeginUserCertificateRequest) (*BeginUserCertificateResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BeginUserCertificate not implemented")
+}
+func (UnimplementedGatewayServer) ApproveUserCertificate(context.Context, *ApproveUserCertificateRequest) (*ApproveUserCertificateResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ApproveUserCertificate not implemented")
+}
 func (UnimplementedGatewayServer) mustEmbedUnimplementedGatewayServer() {}
 func (UnimplementedGatewayServer) testEmbeddedByValue()                 {}
 
@@ -138,6 +246,114 @@ func _Gateway_StreamCommand_Handler(srv interface{}, stream grpc.ServerStream) e
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Gateway_StreamCommandServer = grpc.BidiStreamingServer[CommandRequest, CommandResponse]
 
+func _Gateway_ParseOutput_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ParseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).ParseOutput(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_ParseOutput_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).ParseOutput(ctx, req.(*ParseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_ExecuteNetconf_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NetconfRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).ExecuteNetconf(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_ExecuteNetconf_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).ExecuteNetconf(ctx, req.(*NetconfRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_IssueUserCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).IssueUserCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_IssueUserCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).IssueUserCertificate(ctx, req.(*UserCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_BeginUserCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginUserCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).BeginUserCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_BeginUserCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).BeginUserCertificate(ctx, req.(*BeginUserCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gateway_ApproveUserCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApproveUserCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatewayServer).ApproveUserCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gateway_ApproveUserCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatewayServer).ApproveUserCertificate(ctx, req.(*ApproveUserCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Gateway_ServiceDesc is the grpc.ServiceDesc for Gateway service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -149,6 +365,30 @@ var Gateway_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ExecuteCommand",
 			Handler:    _Gateway_ExecuteCommand_Handler,
 		},
+		{
+			MethodName: "ParseOutput",
+			Handler:    _Gateway_ParseOutput_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _Gateway_ListDevices_Handler,
+		},
+		{
+			MethodName: "ExecuteNetconf",
+			Handler:    _Gateway_ExecuteNetconf_Handler,
+		},
+		{
+			MethodName: "IssueUserCertificate",
+			Handler:    _Gateway_IssueUserCertificate_Handler,
+		},
+		{
+			MethodName: "BeginUserCertificate",
+			Handler:    _Gateway_BeginUserCertificate_Handler,
+		},
+		{
+			MethodName: "ApproveUserCertificate",
+			Handler:    _Gateway_ApproveUserCertificate_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{